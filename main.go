@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"regexp"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/urfave/cli/v2"
@@ -13,8 +15,12 @@ import (
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/bigquery"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/clickhouse"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/duckdb"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/mysql"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/postgres"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/spanner"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/sqlite"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/sqlserver"
 )
 
 func main() {
@@ -34,6 +40,24 @@ func main() {
 	}
 }
 
+// rewriteRules collects --dump-rewrite flags. Unlike string slice flags, values are not split
+// on commas, which are common in regular expressions. Environment variables may contain
+// one rule per line.
+type rewriteRules []string
+
+func (r *rewriteRules) Set(value string) error {
+	for _, rule := range strings.Split(value, "\n") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			*r = append(*r, rule)
+		}
+	}
+	return nil
+}
+
+func (r *rewriteRules) String() string {
+	return strings.Join(*r, "\n")
+}
+
 // NewApp creates a new command line app
 func NewApp() *cli.App {
 	app := cli.NewApp()
@@ -70,7 +94,23 @@ func NewApp() *cli.App {
 			Aliases: []string{"d"},
 			EnvVars: []string{"DBMATE_MIGRATIONS_DIR"},
 			Value:   cli.NewStringSlice(defaultDB.MigrationsDir[0]),
-			Usage:   "specify the directory containing migration files",
+			Usage:   "specify the directory containing migration files, optionally as namespace=dir",
+		},
+		&cli.StringFlag{
+			Name:    "namespace",
+			EnvVars: []string{"DBMATE_NAMESPACE"},
+			Usage:   "limit actions to the migrations directories with this namespace (or path)",
+		},
+		&cli.BoolFlag{
+			Name:    "namespace-tables",
+			EnvVars: []string{"DBMATE_NAMESPACE_TABLES"},
+			Usage:   "record each namespace in its own migrations table",
+		},
+		&cli.StringFlag{
+			Name:    "version-scheme",
+			EnvVars: []string{"DBMATE_VERSION_SCHEME"},
+			Value:   "timestamp",
+			Usage:   "specify how migrations are versioned (timestamp, sequential, flyway, or pattern:<template>)",
 		},
 		&cli.StringFlag{
 			Name:    "migrations-table",
@@ -85,11 +125,67 @@ func NewApp() *cli.App {
 			Value:   defaultDB.SchemaFile,
 			Usage:   "specify the schema file location",
 		},
+		&cli.StringFlag{
+			Name:    "schema-dir",
+			EnvVars: []string{"DBMATE_SCHEMA_DIR"},
+			Usage:   "write the schema as one file per object in this directory, instead of the schema file",
+		},
+		&cli.StringFlag{
+			Name:    "dump-method",
+			EnvVars: []string{"DBMATE_DUMP_METHOD"},
+			Usage:   "how to dump the schema: tool (e.g. pg_dump), native (query the database directly), or auto",
+		},
+		&cli.StringSliceFlag{
+			Name:    "dump-exclude-table",
+			EnvVars: []string{"DBMATE_DUMP_EXCLUDE_TABLE"},
+			Usage:   "leave tables matching this pattern (e.g. 'audit_*') out of the schema file",
+		},
+		&cli.StringSliceFlag{
+			Name:    "dump-include-schema",
+			EnvVars: []string{"DBMATE_DUMP_INCLUDE_SCHEMA"},
+			Usage:   "only include this schema in the schema file",
+		},
+		&cli.StringSliceFlag{
+			Name:    "dump-skip",
+			EnvVars: []string{"DBMATE_DUMP_SKIP"},
+			Usage:   "leave this object type (functions, views, triggers, indexes, sequences, types, extensions, events, partitions) out of the schema file",
+		},
+		&cli.BoolFlag{
+			Name:    "dump-no-migrations-data",
+			EnvVars: []string{"DBMATE_DUMP_NO_MIGRATIONS_DATA"},
+			Usage:   "don't include the list of applied migrations in the schema file",
+		},
+		&cli.StringSliceFlag{
+			Name:    "dump-data-table",
+			EnvVars: []string{"DBMATE_DUMP_DATA_TABLE"},
+			Usage:   "include the rows of this table (e.g. reference data) in the schema file",
+		},
+		&cli.StringSliceFlag{
+			Name:    "dump-normalize",
+			EnvVars: []string{"DBMATE_DUMP_NORMALIZE"},
+			Usage:   "normalize the schema file with this rule (version-comments, definers, owners, tablespaces, sort, whitespace, or all)",
+		},
+		&cli.GenericFlag{
+			Name:    "dump-rewrite",
+			EnvVars: []string{"DBMATE_DUMP_REWRITE"},
+			Usage:   "rewrite the schema file with this regular expression (e.g. 's/ COLLATE \\w+//')",
+			Value:   &rewriteRules{},
+		},
 		&cli.BoolFlag{
 			Name:    "no-dump-schema",
 			EnvVars: []string{"DBMATE_NO_DUMP_SCHEMA"},
 			Usage:   "don't update the schema file on migrate/rollback",
 		},
+		&cli.StringFlag{
+			Name:    "init-sql",
+			EnvVars: []string{"DBMATE_INIT_SQL"},
+			Usage:   "run this SQL on every database connection (e.g. 'SET ROLE migrator') (postgres, mysql)",
+		},
+		&cli.BoolFlag{
+			Name:    "no-lock",
+			EnvVars: []string{"DBMATE_NO_LOCK"},
+			Usage:   "don't lock the database while migrations run (e.g. behind a transaction pooler)",
+		},
 		&cli.BoolFlag{
 			Name:    "wait",
 			EnvVars: []string{"DBMATE_WAIT"},
@@ -108,7 +204,33 @@ func NewApp() *cli.App {
 			Name:    "new",
 			Aliases: []string{"n"},
 			Usage:   "Generate a new migration file",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "namespace or directory to create the migration in (defaults to the first migrations directory)",
+				},
+				&cli.StringFlag{
+					Name:    "template",
+					EnvVars: []string{"DBMATE_MIGRATION_TEMPLATE"},
+					Usage:   "template file (or name of a .tmpl file in the migrations directory) for the new migration",
+				},
+				&cli.BoolFlag{
+					Name:  "sql",
+					Usage: "prefill the up block with SQL read from stdin",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if dir := c.String("dir"); dir != "" {
+					db.Namespace = dir
+				}
+				db.MigrationTemplate = c.String("template")
+				if c.Bool("sql") {
+					sql, err := io.ReadAll(os.Stdin)
+					if err != nil {
+						return err
+					}
+					db.MigrationSQL = string(sql)
+				}
 				name := c.Args().First()
 				return db.NewMigration(name)
 			}),
@@ -188,13 +310,60 @@ func NewApp() *cli.App {
 				return db.Rollback()
 			}),
 		},
+		{
+			Name:  "verify",
+			Usage: "Apply, roll back, and re-apply each migration on a scratch database to check that rollbacks restore the schema",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "print the result of each statement execution",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.Verbose = c.Bool("verbose")
+				return db.Verify()
+			}),
+		},
+		{
+			Name:  "plan-schema",
+			Usage: "Generate a new migration which changes the database to match the desired schema",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "desired-schema-dir",
+					EnvVars: []string{"DBMATE_DESIRED_SCHEMA_DIR"},
+					Value:   defaultDB.DesiredSchemaDir,
+					Usage:   "directory containing .sql files which describe the desired schema",
+				},
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "namespace or directory to create the migration in (defaults to the first migrations directory)",
+				},
+				&cli.StringFlag{
+					Name:    "template",
+					EnvVars: []string{"DBMATE_MIGRATION_TEMPLATE"},
+					Usage:   "template file (or name of a .tmpl file in the migrations directory) for the new migration",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.DesiredSchemaDir = c.String("desired-schema-dir")
+				if dir := c.String("dir"); dir != "" {
+					db.Namespace = dir
+				}
+				db.MigrationTemplate = c.String("template")
+				name := c.Args().First()
+				return db.PlanSchema(name)
+			}),
+		},
 		{
 			Name:  "status",
 			Usage: "List applied and pending migrations",
 			Flags: []cli.Flag{
 				&cli.BoolFlag{
-					Name:  "exit-code",
-					Usage: "return 1 if there are pending migrations",
+					Name: "exit-code",
+					Usage: "return 1 if there are pending migrations, 4 if any are out of order, " +
+						"or 5 if applied migrations are missing their file",
 				},
 				&cli.BoolFlag{
 					Name:  "quiet",
@@ -209,13 +378,13 @@ func NewApp() *cli.App {
 					setExitCode = true
 				}
 
-				pending, err := db.Status(quiet)
+				counts, err := db.StatusSummary(quiet)
 				if err != nil {
 					return err
 				}
 
-				if pending > 0 && setExitCode {
-					return cli.Exit("", 1)
+				if setExitCode {
+					return statusExitCode(counts)
 				}
 
 				return nil
@@ -237,7 +406,22 @@ func NewApp() *cli.App {
 		{
 			Name:  "load",
 			Usage: "Load schema file to the database",
-			Action: action(func(db *dbmate.DB, _ *cli.Context) error {
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "transaction",
+					EnvVars: []string{"DBMATE_LOAD_TRANSACTION"},
+					Usage:   "load the schema in a single transaction",
+				},
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "print the result of each statement execution",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.LoadTransaction = c.Bool("transaction")
+				db.Verbose = c.Bool("verbose")
 				return db.LoadSchema()
 			}),
 		},
@@ -253,6 +437,27 @@ func NewApp() *cli.App {
 	return app
 }
 
+// status exit codes, from least to most severe
+const (
+	exitCodePending           = 1
+	exitCodePendingOutOfOrder = 4
+	exitCodeMissingFile       = 5
+)
+
+// statusExitCode returns an exit error for the most severe migration state found by status
+func statusExitCode(counts dbmate.StatusCounts) error {
+	switch {
+	case counts.MissingFile > 0:
+		return cli.Exit("", exitCodeMissingFile)
+	case counts.PendingOutOfOrder > 0:
+		return cli.Exit("", exitCodePendingOutOfOrder)
+	case counts.Pending > 0:
+		return cli.Exit("", exitCodePending)
+	}
+
+	return nil
+}
+
 // load environment variables from file(s)
 func loadEnvFiles(args []string) error {
 	var envFiles []string
@@ -316,9 +521,28 @@ func configureDB(c *cli.Context) (*dbmate.DB, error) {
 	db := dbmate.New(u)
 	db.DriverName = c.String("driver")
 	db.AutoDumpSchema = !c.Bool("no-dump-schema")
+	db.NoLock = c.Bool("no-lock")
+	db.InitSQL = c.String("init-sql")
 	db.MigrationsDir = c.StringSlice("migrations-dir")
 	db.MigrationsTableName = c.String("migrations-table")
+	db.Namespace = c.String("namespace")
+	db.NamespaceTables = c.Bool("namespace-tables")
 	db.SchemaFile = c.String("schema-file")
+	db.SchemaDir = c.String("schema-dir")
+	db.DumpMethod = c.String("dump-method")
+	db.DumpOptions = dbmate.DumpOptions{
+		ExcludeTables:    c.StringSlice("dump-exclude-table"),
+		IncludeSchemas:   c.StringSlice("dump-include-schema"),
+		Skip:             c.StringSlice("dump-skip"),
+		NoMigrationsData: c.Bool("dump-no-migrations-data"),
+		DataTables:       c.StringSlice("dump-data-table"),
+		Normalize:        c.StringSlice("dump-normalize"),
+		Rewrite:          *c.Generic("dump-rewrite").(*rewriteRules),
+	}
+	db.VersionScheme, err = dbmate.GetVersionScheme(c.String("version-scheme"))
+	if err != nil {
+		return nil, err
+	}
 	db.WaitBefore = c.Bool("wait")
 	waitTimeout := c.Duration("wait-timeout")
 	if waitTimeout != 0 {
@@ -62,6 +62,24 @@ func TestRedactLogString(t *testing.T) {
 	}
 }
 
+func TestStatusExitCode(t *testing.T) {
+	exitCode := func(counts dbmate.StatusCounts) int {
+		err := statusExitCode(counts)
+		if err == nil {
+			return 0
+		}
+
+		var exitErr cli.ExitCoder
+		require.ErrorAs(t, err, &exitErr)
+		return exitErr.ExitCode()
+	}
+
+	require.Equal(t, 0, exitCode(dbmate.StatusCounts{Applied: 2}))
+	require.Equal(t, 1, exitCode(dbmate.StatusCounts{Applied: 2, Pending: 1}))
+	require.Equal(t, 4, exitCode(dbmate.StatusCounts{Pending: 1, PendingOutOfOrder: 1}))
+	require.Equal(t, 5, exitCode(dbmate.StatusCounts{PendingOutOfOrder: 1, MissingFile: 1}))
+}
+
 func TestLoadEnvFiles(t *testing.T) {
 	setup := func(t *testing.T) {
 		env := os.Environ()
@@ -212,3 +230,41 @@ func TestConfigureDB_Driver(t *testing.T) {
 		require.Equal(t, "clickhouse", configuredDB.DriverName)
 	})
 }
+
+func TestConfigureDB_DumpRewrite(t *testing.T) {
+	configure := func(t *testing.T, args ...string) *dbmate.DB {
+		var configuredDB *dbmate.DB
+
+		app := NewApp()
+		app.Commands = []*cli.Command{
+			{
+				Name: "test-config",
+				Action: func(c *cli.Context) error {
+					var err error
+					configuredDB, err = configureDB(c)
+					return err
+				},
+			},
+		}
+
+		err := app.Run(append(append([]string{"dbmate"}, args...), "test-config"))
+		require.NoError(t, err)
+		return configuredDB
+	}
+
+	t.Run("default is empty", func(t *testing.T) {
+		db := configure(t)
+		require.Empty(t, db.DumpOptions.Rewrite)
+	})
+
+	t.Run("flags are not split on commas", func(t *testing.T) {
+		db := configure(t, "--dump-rewrite", "s/a{1,2}/b/", "--dump-rewrite", "s/c/d/")
+		require.Equal(t, []string{"s/a{1,2}/b/", "s/c/d/"}, db.DumpOptions.Rewrite)
+	})
+
+	t.Run("env variable contains one rule per line", func(t *testing.T) {
+		t.Setenv("DBMATE_DUMP_REWRITE", "s/a{1,2}/b/\ns/c/d/\n")
+		db := configure(t)
+		require.Equal(t, []string{"s/a{1,2}/b/", "s/c/d/"}, db.DumpOptions.Rewrite)
+	})
+}
@@ -1,7 +1,7 @@
 package dbmate
 
 import (
-	"database/sql"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
@@ -19,16 +20,25 @@ import (
 
 // Error codes
 var (
-	ErrNoMigrationFiles      = errors.New("no migration files found")
-	ErrInvalidURL            = errors.New("invalid url, have you set your --url flag or DATABASE_URL environment variable?")
-	ErrNoRollback            = errors.New("can't rollback: no migrations have been applied")
-	ErrCantConnect           = errors.New("unable to connect to database")
-	ErrUnsupportedDriver     = errors.New("unsupported driver")
-	ErrNoMigrationName       = errors.New("please specify a name for the new migration")
-	ErrMigrationAlreadyExist = errors.New("file already exists")
-	ErrMigrationDirNotFound  = errors.New("could not find migrations directory")
-	ErrMigrationNotFound     = errors.New("can't find migration file")
-	ErrCreateDirectory       = errors.New("unable to create directory")
+	ErrNoMigrationFiles        = errors.New("no migration files found")
+	ErrInvalidURL              = errors.New("invalid url, have you set your --url flag or DATABASE_URL environment variable?")
+	ErrNoRollback              = errors.New("can't rollback: no migrations have been applied")
+	ErrCantConnect             = errors.New("unable to connect to database")
+	ErrUnsupportedDriver       = errors.New("unsupported driver")
+	ErrNoMigrationName         = errors.New("please specify a name for the new migration")
+	ErrMigrationAlreadyExist   = errors.New("file already exists")
+	ErrMigrationDirNotFound    = errors.New("could not find migrations directory")
+	ErrMigrationNotFound       = errors.New("can't find migration file")
+	ErrCreateDirectory         = errors.New("unable to create directory")
+	ErrVerifyRollback          = errors.New("unable to roll back migration")
+	ErrVerifySchemaMismatch    = errors.New("rolling back migration did not restore the original schema")
+	ErrVerifyUnsupportedDriver = errors.New("driver does not support scratch databases for verification")
+	ErrDependencyCycle         = errors.New("migration dependencies contain a cycle")
+	ErrDependencyNotApplied    = errors.New("migration depends on a migration which has not been applied")
+	ErrNamespaceNotFound       = errors.New("could not find migrations namespace")
+	ErrLockFailed              = errors.New("unable to lock database")
+	ErrUnsupportedOption       = errors.New("migration option is not supported by this driver")
+	ErrNonTransactional        = errors.New("migration contains statements which cannot run in a transaction")
 )
 
 // migrationFileRegexp pattern for valid migration files
@@ -42,20 +52,50 @@ type DB struct {
 	DatabaseURL *url.URL
 	// DriverName used to force specific driver (overrides deriving from url scheme)
 	DriverName string
-	// FS specifies the filesystem, or nil for OS filesystem
+	// FS specifies the filesystem used for all file operations, or nil for OS filesystem.
+	// Operations which write files require FS to implement WriteFS.
 	FS fs.FS
 	// Log is the interface to write stdout
 	Log io.Writer
-	// MigrationsDir specifies the directory or directories to find migration files
+	// MigrationsDir specifies the directory or directories to find migration files.
+	// Each directory may be assigned a namespace using the form "namespace=path".
 	MigrationsDir []string
 	// MigrationsTableName specifies the database table to record migrations in
 	MigrationsTableName string
+	// MigrationTemplate specifies the template used by NewMigration, either as a path
+	// or as the name of a .tmpl file in the migrations directory
+	MigrationTemplate string
+	// MigrationSQL is used by NewMigration to prefill the up block
+	MigrationSQL string
+	// DesiredSchemaDir specifies the directory containing the desired schema used by PlanSchema
+	DesiredSchemaDir string
+	// VersionScheme determines how migration versions are parsed, ordered, and generated
+	VersionScheme VersionScheme
+	// Namespace limits actions to the migrations directories with this namespace or path
+	Namespace string
+	// NamespaceTables records each namespace in its own table, named after MigrationsTableName
+	NamespaceTables bool
 	// SchemaFile specifies the location for schema.sql file
 	SchemaFile string
+	// SchemaDir writes the schema as one file per object in this directory, along with a
+	// manifest listing the load order (see SchemaManifest). It replaces SchemaFile if set.
+	SchemaDir string
+	// DumpMethod selects how the schema is dumped, for drivers which support more than one
+	// (DumpMethodTool, DumpMethodNative, or DumpMethodAuto). Empty uses the driver default.
+	DumpMethod string
+	// DumpOptions filter the objects included in the schema file
+	DumpOptions DumpOptions
+	// LoadTransaction runs all statements in LoadSchema in a single transaction
+	LoadTransaction bool
 	// Fail if migrations would be applied out of order
 	Strict bool
+	// NoLock disables the lock held while migrations run, for drivers which implement Locker
+	NoLock bool
 	// Verbose prints the result of each statement execution
 	Verbose bool
+	// InitSQL is executed on every new database connection (e.g. "SET ROLE migrator"), for
+	// drivers which support it
+	InitSQL string
 	// WaitBefore will wait for database to become available before running any actions
 	WaitBefore bool
 	// WaitInterval specifies length of time between connection attempts
@@ -64,12 +104,43 @@ type DB struct {
 	WaitTimeout time.Duration
 	// Additional arguments for the subcommand being invoked e.g. pg_dump/mysqldump
 	Args []string
+
+	// reportNotices is set while migrations and schema files run, so that notices raised by
+	// dbmate's own statements are not printed
+	reportNotices bool
 }
 
+// MigrationState classifies a migration in status results
+type MigrationState string
+
+// Migration states
+const (
+	// StateApplied is an applied migration
+	StateApplied MigrationState = "applied"
+	// StatePending is a migration which has not been applied yet
+	StatePending MigrationState = "pending"
+	// StatePendingOutOfOrder is a pending migration with a lower version than an applied migration
+	StatePendingOutOfOrder MigrationState = "pending-out-of-order"
+	// StateMissingFile is a version recorded in the migrations table without a migration file
+	StateMissingFile MigrationState = "missing-file"
+)
+
 // StatusResult represents an available migration status
 type StatusResult struct {
-	Filename string
-	Applied  bool
+	Filename     string
+	Applied      bool
+	Version      string
+	Namespace    string
+	State        MigrationState
+	Dependencies []string
+}
+
+// StatusCounts holds the number of migrations in each state
+type StatusCounts struct {
+	Applied           int
+	Pending           int
+	PendingOutOfOrder int
+	MissingFile       int
 }
 
 // New initializes a new dbmate database
@@ -81,6 +152,8 @@ func New(databaseURL *url.URL) *DB {
 		Log:                 os.Stdout,
 		MigrationsDir:       []string{"./db/migrations"},
 		MigrationsTableName: "schema_migrations",
+		VersionScheme:       TimestampScheme{},
+		DesiredSchemaDir:    "./db/schema",
 		SchemaFile:          "./db/schema.sql",
 		Strict:              false,
 		Verbose:             false,
@@ -91,38 +164,164 @@ func New(databaseURL *url.URL) *DB {
 	}
 }
 
-// Driver initializes the appropriate database driver
+// Driver initializes the appropriate database driver. It returns ErrNotSQLDriver for
+// drivers which do not use database/sql (see SessionDriver).
 func (db *DB) Driver() (Driver, error) {
 	if db.DatabaseURL == nil || db.DatabaseURL.Scheme == "" {
 		return nil, ErrInvalidURL
 	}
 
-	driverName := db.DatabaseURL.Scheme
+	drv, err := db.driver(db.MigrationsTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.WaitBefore {
+		if err := db.wait(drv); err != nil {
+			return nil, err
+		}
+	}
+
+	return drv, nil
+}
+
+// SessionDriver initializes the appropriate database driver, adapting drivers which use
+// database/sql to SessionDriver
+func (db *DB) SessionDriver() (SessionDriver, error) {
+	if db.DatabaseURL == nil || db.DatabaseURL.Scheme == "" {
+		return nil, ErrInvalidURL
+	}
+
+	var drv SessionDriver
+	if sessionDriverFunc := sessionDrivers[db.driverName()]; sessionDriverFunc != nil {
+		if err := db.DumpOptions.Validate(); err != nil {
+			return nil, err
+		}
+		drv = sessionDriverFunc(db.driverConfig(db.DatabaseURL, db.MigrationsTableName))
+	} else {
+		sqlDrv, err := db.driver(db.MigrationsTableName)
+		if err != nil {
+			return nil, err
+		}
+		drv = &sqlSessionDriver{Driver: sqlDrv, tableDriver: db.driver}
+	}
+
+	if reporter, ok := capability[NoticeReporter](drv); ok {
+		reporter.SetNoticeHandler(db.printNotice)
+	}
+
+	if db.WaitBefore {
+		if err := db.wait(drv); err != nil {
+			return nil, err
+		}
+	}
+
+	return drv, nil
+}
+
+// driver initializes the database driver for a given migrations table
+func (db *DB) driver(migrationsTableName string) (Driver, error) {
+	return db.driverForURL(db.DatabaseURL, migrationsTableName)
+}
+
+// driverForURL initializes the configured driver for another database URL
+func (db *DB) driverForURL(u *url.URL, migrationsTableName string) (Driver, error) {
+	driverName := u.Scheme
 	if db.DriverName != "" {
 		driverName = db.DriverName
 	}
 	driverFunc := drivers[driverName]
+	if driverFunc == nil && sessionDrivers[driverName] != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotSQLDriver, driverName)
+	}
 	if driverFunc == nil {
 		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDriver, driverName)
 	}
+	if err := db.DumpOptions.Validate(); err != nil {
+		return nil, err
+	}
+
+	return driverFunc(db.driverConfig(u, migrationsTableName)), nil
+}
 
-	config := DriverConfig{
-		DatabaseURL:         db.DatabaseURL,
+// driverConfig returns the configuration passed to driver constructors
+func (db *DB) driverConfig(u *url.URL, migrationsTableName string) DriverConfig {
+	return DriverConfig{
+		DatabaseURL:         u,
 		Log:                 db.Log,
-		MigrationsTableName: db.MigrationsTableName,
+		MigrationsTableName: migrationsTableName,
+		InitSQL:             db.InitSQL,
+		DumpMethod:          db.DumpMethod,
+		DumpOptions:         db.DumpOptions,
+	}
+}
+
+// printNotice prints a notice raised by a migration or schema file
+func (db *DB) printNotice(notice string) {
+	if db.reportNotices {
+		fmt.Fprintf(db.Log, "%s\n", notice)
 	}
-	drv := driverFunc(config)
+}
 
-	if db.WaitBefore {
-		if err := db.wait(drv); err != nil {
-			return nil, err
-		}
+// printServerVersion prints the version of the database server in verbose mode, if the
+// driver is a ServerVersioner
+func (db *DB) printServerVersion(drv SessionDriver) {
+	versioner, ok := capability[ServerVersioner](drv)
+	if !ok || !db.Verbose {
+		return
 	}
 
-	return drv, nil
+	version, err := versioner.ServerVersion()
+	if err != nil {
+		fmt.Fprintf(db.Log, "Server version: unknown (%s)\n", err)
+		return
+	}
+	fmt.Fprintf(db.Log, "Server version: %s\n", version)
+}
+
+// noLock is returned by DB.lock when the database is not locked
+type noLock struct{}
+
+func (noLock) Close() error { return nil }
+
+// lock locks the database while migrations run, if the driver is a Locker. Closing the
+// returned io.Closer releases the lock.
+func (db *DB) lock(drv SessionDriver) (io.Closer, error) {
+	locker, ok := capability[Locker](drv)
+	if !ok || db.NoLock {
+		return noLock{}, nil
+	}
+
+	lock, err := locker.Lock()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return noLock{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLockFailed, err)
+	}
+
+	return lock, nil
+}
+
+// transactionalDDL returns false if the driver reports that schema changes made in a
+// transaction are not rolled back with it
+func transactionalDDL(drv SessionDriver) bool {
+	if reporter, ok := capability[TransactionalDDL](drv); ok {
+		return reporter.TransactionalDDL()
+	}
+
+	return true
+}
+
+// warnTransaction warns when a migration section asks for a transaction which will not roll
+// back its schema changes
+func (db *DB) warnTransaction(drv SessionDriver, migration Migration, options ParsedMigrationOptions) {
+	if requestsTransaction(options) && !transactionalDDL(drv) {
+		fmt.Fprintf(db.Log, "Warning: %s requests transaction:true, but %s does not roll back schema changes in transactions\n",
+			migration.displayName(), db.driverName())
+	}
 }
 
-func (db *DB) wait(drv Driver) error {
+func (db *DB) wait(drv interface{ Ping() error }) error {
 	// attempt connection to database server
 	err := drv.Ping()
 	if err == nil {
@@ -152,7 +351,7 @@ func (db *DB) wait(drv Driver) error {
 // Wait blocks until the database server is available. It does not verify that
 // the specified database exists, only that the host is ready to accept connections.
 func (db *DB) Wait() error {
-	drv, err := db.Driver()
+	drv, err := db.SessionDriver()
 	if err != nil {
 		return err
 	}
@@ -163,7 +362,7 @@ func (db *DB) Wait() error {
 
 // CreateAndMigrate creates the database (if necessary) and runs migrations
 func (db *DB) CreateAndMigrate() error {
-	drv, err := db.Driver()
+	drv, err := db.SessionDriver()
 	if err != nil {
 		return err
 	}
@@ -184,7 +383,7 @@ func (db *DB) CreateAndMigrate() error {
 
 // Create creates the current database
 func (db *DB) Create() error {
-	drv, err := db.Driver()
+	drv, err := db.SessionDriver()
 	if err != nil {
 		return err
 	}
@@ -194,7 +393,7 @@ func (db *DB) Create() error {
 
 // Drop drops the current database (if it exists)
 func (db *DB) Drop() error {
-	drv, err := db.Driver()
+	drv, err := db.SessionDriver()
 	if err != nil {
 		return err
 	}
@@ -204,158 +403,377 @@ func (db *DB) Drop() error {
 
 // DumpSchema writes the current database schema to a file
 func (db *DB) DumpSchema() error {
-	drv, err := db.Driver()
+	target := db.SchemaFile
+	if db.SchemaDir != "" {
+		target = db.SchemaDir
+	}
+	wfs, err := db.writeFS("writing schema file", target)
+	if err != nil {
+		return err
+	}
+
+	drv, err := db.SessionDriver()
+	if err != nil {
+		return err
+	}
+
+	session, err := db.openSessionForMigration(drv)
 	if err != nil {
 		return err
 	}
+	defer dbutil.MustClose(session)
 
-	sqlDB, err := db.openDatabaseForMigration(drv)
+	schema, err := session.DumpSchema(db.Args...)
 	if err != nil {
 		return err
 	}
-	defer dbutil.MustClose(sqlDB)
 
-	schema, err := drv.DumpSchema(sqlDB, db.Args...)
+	if !db.DumpOptions.NoMigrationsData {
+		namespaceMigrations, err := db.namespaceMigrationsDump(session)
+		if err != nil {
+			return err
+		}
+		schema = append(schema, namespaceMigrations...)
+	}
+
+	normalizers, err := db.DumpOptions.Normalizers()
 	if err != nil {
 		return err
 	}
+	schema, err = dbutil.NormalizeSchema(schema, normalizers...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(db.Log, "Writing: %s\n", target)
 
-	fmt.Fprintf(db.Log, "Writing: %s\n", db.SchemaFile)
+	if db.SchemaDir != "" {
+		return db.writeSchemaDir(wfs, schema)
+	}
 
 	// ensure schema directory exists
-	if err = ensureDir(filepath.Dir(db.SchemaFile)); err != nil {
+	if err = ensureDir(wfs, filepath.Dir(db.SchemaFile)); err != nil {
 		return err
 	}
 
 	// write schema to file
-	return os.WriteFile(db.SchemaFile, schema, 0o644)
+	return wfs.WriteFile(fsPath(db.SchemaFile), schema, 0o644)
 }
 
-// LoadSchema loads schema file to the current database
+// loadProgressInterval is how often LoadSchema reports the number of statements executed
+var loadProgressInterval = 5 * time.Second
+
+// LoadSchema loads schema file to the current database. The file is executed one statement
+// at a time, so that large files do not need to be held in memory, and errors include the
+// line number of the failing statement.
 func (db *DB) LoadSchema() error {
-	drv, err := db.Driver()
+	drv, err := db.SessionDriver()
 	if err != nil {
 		return err
 	}
 
-	sqlDB, err := drv.Open()
-	if err != nil {
-		return err
+	files := []string{db.SchemaFile}
+	if db.SchemaDir != "" {
+		fmt.Fprintf(db.Log, "Reading: %s\n", db.SchemaDir)
+		names, err := db.readSchemaManifest()
+		if err != nil {
+			return err
+		}
+		files = make([]string, len(names))
+		for i, name := range names {
+			files[i] = filepath.Join(db.SchemaDir, filepath.FromSlash(name))
+		}
+	} else {
+		fmt.Fprintf(db.Log, "Reading: %s\n", db.SchemaFile)
 	}
-	defer dbutil.MustClose(sqlDB)
 
-	_, err = os.Stat(db.SchemaFile)
-	if err != nil {
-		return err
+	db.printServerVersion(drv)
+
+	return db.loadSchema(drv, files)
+}
+
+// loadSchema executes schema files with the driver's SchemaLoader, or statement by statement
+func (db *DB) loadSchema(drv SessionDriver, files []string) error {
+	if loader, ok := capability[SchemaLoader](drv); ok {
+		return db.loadSchemaFiles(loader, files)
 	}
 
-	fmt.Fprintf(db.Log, "Reading: %s\n", db.SchemaFile)
+	if db.LoadTransaction && !transactionalDDL(drv) {
+		fmt.Fprintf(db.Log, "Warning: %s does not roll back schema changes in transactions\n", db.driverName())
+	}
 
-	bytes, err := os.ReadFile(db.SchemaFile)
+	session, err := drv.OpenSession()
 	if err != nil {
 		return err
 	}
-
-	// Strip psql meta-commands (e.g., \restrict, \unrestrict) that cannot be
-	// executed directly against the database server.
-	bytes, err = dbutil.StripPsqlMetaCommands(bytes)
+	defer dbutil.MustClose(session)
+
+	// schema files set session variables (such as search_path), so every statement must
+	// run on the same connection, which Session.Run guarantees
+	loader := &schemaLoader{db: db, syntax: statementSyntax(drv), start: time.Now()}
+	loader.lastProgress = loader.start
+	db.reportNotices = true
+	defer func() { db.reportNotices = false }()
+	err = session.Run(db.LoadTransaction, func(ex Executor) error {
+		loader.ex = ex
+		return loader.loadFiles(files)
+	})
 	if err != nil {
 		return err
 	}
 
-	result, err := sqlDB.Exec(string(bytes))
+	fmt.Fprintf(db.Log, "Loaded: %d statements in %s\n", loader.count, time.Since(loader.start))
+
+	return nil
+}
+
+// loadSchemaFiles loads each schema file with the driver's SchemaLoader
+func (db *DB) loadSchemaFiles(loader SchemaLoader, files []string) error {
+	if db.LoadTransaction {
+		fmt.Fprintf(db.Log, "Warning: %s loads schema files itself, and does not support --transaction\n", db.driverName())
+	}
+
+	start := time.Now()
+	db.reportNotices = true
+	defer func() { db.reportNotices = false }()
+	for _, file := range files {
+		if err := db.loadSchemaFile(loader, file); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(db.Log, "Loaded: %d files in %s\n", len(files), time.Since(start))
+
+	return nil
+}
+
+// loadSchemaFile loads a schema file with the driver's SchemaLoader
+func (db *DB) loadSchemaFile(loader SchemaLoader, file string) error {
+	f, err := db.fs().Open(fsPath(file))
 	if err != nil {
 		return err
-	} else if db.Verbose {
-		db.printVerbose(result)
+	}
+	defer dbutil.MustClose(f)
+
+	if err := loader.LoadSchema(f); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
 	}
 
 	return nil
 }
 
-// ensureDir creates a directory if it does not already exist
-func ensureDir(dir string) error {
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return fmt.Errorf("%w `%s`", ErrCreateDirectory, dir)
+// schemaLoader executes schema files statement by statement for LoadSchema
+type schemaLoader struct {
+	db           *DB
+	syntax       dbutil.StatementSyntax
+	ex           Executor
+	count        int
+	start        time.Time
+	lastProgress time.Time
+}
+
+// loadFiles executes each file in order
+func (l *schemaLoader) loadFiles(files []string) error {
+	for _, file := range files {
+		if err := l.loadFile(file); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-const migrationTemplate = "-- migrate:up\n\n\n-- migrate:down\n\n"
+// loadFile executes each statement in a schema file
+func (l *schemaLoader) loadFile(file string) error {
+	f, err := l.db.fs().Open(fsPath(file))
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(f)
+
+	scanner := dbutil.NewStatementScanner(f, l.syntax)
+	for scanner.Scan() {
+		stmt := scanner.Statement()
+		result, err := l.ex.Exec(stmt.SQL)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", file, stmt.Line, err)
+		} else if l.db.Verbose {
+			l.db.printVerbose(result)
+		}
+
+		l.count++
+		if time.Since(l.lastProgress) >= loadProgressInterval {
+			l.lastProgress = time.Now()
+			fmt.Fprintf(l.db.Log, "Loading: %d statements (%s line %d)\n", l.count, file, stmt.Line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	return nil
+}
 
 // NewMigration creates a new migration file
 func (db *DB) NewMigration(name string) error {
-	// new migration name
-	timestamp := time.Now().UTC().Format("20060102150405")
+	return db.newMigration(name, db.MigrationSQL, "")
+}
+
+// newMigration creates a new migration file with the given up and down blocks
+func (db *DB) newMigration(name, upSQL, downSQL string) error {
 	if name == "" {
 		return ErrNoMigrationName
 	}
-	name = fmt.Sprintf("%s_%s.sql", timestamp, name)
+
+	// use the first directory in the selected namespace
+	dirs, err := db.selectedMigrationsDirs()
+	if err != nil {
+		return err
+	}
+	dir := dirs[0].Path
+
+	// new migration name
+	now := time.Now().UTC()
+	existing, err := db.existingVersions(dirs[0])
+	if err != nil {
+		return err
+	}
+	scheme := db.versionScheme()
+	version := scheme.Next(existing, now)
+	fileName := scheme.FileName(version, name)
+
+	path := filepath.Join(dir, fileName)
+	wfs, err := db.writeFS("creating migration", path)
+	if err != nil {
+		return err
+	}
+
+	contents, err := db.renderMigrationTemplate(dir, MigrationTemplateData{
+		Name:      name,
+		Version:   version,
+		Timestamp: now,
+		Author:    currentAuthor(),
+		Driver:    db.driverName(),
+		SQL:       strings.TrimRight(upSQL, " \t\r\n"),
+		DownSQL:   strings.TrimRight(downSQL, " \t\r\n"),
+	})
+	if err != nil {
+		return err
+	}
 
 	// create migrations dir if missing
-	if err := ensureDir(db.MigrationsDir[0]); err != nil {
+	if err := ensureDir(wfs, dir); err != nil {
 		return err
 	}
 
 	// check file does not already exist
-	path := filepath.Join(db.MigrationsDir[0], name)
 	fmt.Fprintf(db.Log, "Creating migration: %s\n", path)
 
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
+	if _, err := fs.Stat(wfs, fsPath(path)); !errors.Is(err, fs.ErrNotExist) {
 		return ErrMigrationAlreadyExist
 	}
 
 	// write new migration
-	file, err := os.Create(path)
-	if err != nil {
-		return err
+	return wfs.WriteFile(fsPath(path), contents, 0o644)
+}
+
+// existingVersions lists the versions of all migration files sharing a version space with dir
+func (db *DB) existingVersions(dir migrationsDir) ([]string, error) {
+	scheme := db.versionScheme()
+	table := db.namespaceTableName(dir.Namespace)
+
+	versions := []string{}
+	for _, other := range db.migrationsDirs() {
+		if db.namespaceTableName(other.Namespace) != table {
+			continue
+		}
+
+		files, err := db.readMigrationsDir(other.Path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if version, ok := scheme.Parse(file.Name()); ok && !file.IsDir() {
+				versions = append(versions, version)
+			}
+		}
 	}
 
-	defer dbutil.MustClose(file)
-	_, err = file.WriteString(migrationTemplate)
-	return err
+	return versions, nil
 }
 
-func doTransaction(sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
-	tx, err := sqlDB.Begin()
-	if err != nil {
-		return err
+// versionScheme returns the configured version scheme, defaulting to timestamps
+func (db *DB) versionScheme() VersionScheme {
+	if db.VersionScheme == nil {
+		return TimestampScheme{}
 	}
 
-	if err := txFunc(tx); err != nil {
-		if err1 := tx.Rollback(); err1 != nil {
-			return err1
-		}
+	return db.VersionScheme
+}
 
-		return err
+// statementSyntax returns the syntax used to split schema files and migrations into
+// statements, which is standard SQL unless the driver is a StatementSplitter
+func statementSyntax(drv SessionDriver) dbutil.StatementSyntax {
+	if splitter, ok := drv.(StatementSplitter); ok {
+		return splitter.StatementSyntax()
 	}
 
-	return tx.Commit()
+	return dbutil.StatementSyntax{}
 }
 
-func (db *DB) openDatabaseForMigration(drv Driver) (*sql.DB, error) {
-	sqlDB, err := drv.Open()
+// openSessionForMigration opens a session, and creates the migrations table (along with
+// separate tables for namespaces)
+func (db *DB) openSessionForMigration(drv SessionDriver) (Session, error) {
+	session, err := drv.OpenSession()
 	if err != nil {
 		return nil, err
 	}
 
-	if err := drv.CreateMigrationsTable(sqlDB); err != nil {
-		dbutil.MustClose(sqlDB)
+	if err := db.createMigrationsTables(session); err != nil {
+		dbutil.MustClose(session)
 		return nil, err
 	}
 
-	return sqlDB, nil
+	return session, nil
+}
+
+// createMigrationsTables creates the migrations table of every namespace, if it does not exist
+func (db *DB) createMigrationsTables(session Session) error {
+	for _, table := range db.namespaceTableNames() {
+		if err := session.CreateMigrationsTable(table); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Migrate migrates database to the latest version
 func (db *DB) Migrate() error {
-	drv, err := db.Driver()
+	drv, err := db.SessionDriver()
+	if err != nil {
+		return err
+	}
+
+	// hold the lock while reading applied migrations, so that they can't change before
+	// pending migrations are applied
+	lock, err := db.lock(drv)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(lock)
+
+	session, err := drv.OpenSession()
 	if err != nil {
 		return err
 	}
+	defer dbutil.MustClose(session)
 
-	migrations, err := db.FindMigrations()
+	migrations, appliedByTable, err := db.findSessionMigrations(session)
 	if err != nil {
 		return err
 	}
@@ -364,70 +782,80 @@ func (db *DB) Migrate() error {
 		return ErrNoMigrationFiles
 	}
 
-	highestAppliedMigrationVersion := ""
+	// each migrations table has its own version space
+	scheme := db.versionScheme()
+	highestAppliedMigrationVersion := map[string]string{}
 	pendingMigrations := []Migration{}
 	for _, migration := range migrations {
 		if migration.Applied {
-			if db.Strict && highestAppliedMigrationVersion <= migration.Version {
-				highestAppliedMigrationVersion = migration.Version
+			table := db.namespaceTableName(migration.Namespace)
+			if db.Strict && scheme.Compare(highestAppliedMigrationVersion[table], migration.Version) <= 0 {
+				highestAppliedMigrationVersion[table] = migration.Version
 			}
 		} else {
 			pendingMigrations = append(pendingMigrations, migration)
 		}
 	}
 
-	if len(pendingMigrations) > 0 && db.Strict && pendingMigrations[0].Version <= highestAppliedMigrationVersion {
-		return fmt.Errorf(
-			"migration `%s` is out of order with already applied migrations, the version number has to be higher than the applied migration `%s` in --strict mode",
-			pendingMigrations[0].Version,
-			highestAppliedMigrationVersion,
-		)
-	}
-
-	sqlDB, err := db.openDatabaseForMigration(drv)
-	if err != nil {
-		return err
+	if db.Strict {
+		// dependencies may place a lower version after a higher one, so check every pending migration
+		for _, migration := range pendingMigrations {
+			highest := highestAppliedMigrationVersion[db.namespaceTableName(migration.Namespace)]
+			if highest != "" && scheme.Compare(migration.Version, highest) <= 0 {
+				return fmt.Errorf(
+					"migration `%s` is out of order with already applied migrations, the version number has to be higher than the applied migration `%s` in --strict mode",
+					migration.Version,
+					highest,
+				)
+			}
+		}
 	}
-	defer dbutil.MustClose(sqlDB)
 
+	// refuse to apply migrations whose dependencies will not have been applied
+	pendingByTable := map[string]map[string]bool{}
 	for _, migration := range pendingMigrations {
-		fmt.Fprintf(db.Log, "Applying: %s\n", migration.FileName)
-
-		start := time.Now()
-
-		parsed, err := migration.Parse()
-		if err != nil {
-			return err
-		}
-
-		for _, migrationSection := range parsed {
-			execMigration := func(tx dbutil.Transaction) error {
-				// run actual migration
-				result, err := tx.Exec(migrationSection.Up)
-				if err != nil {
-					return drv.QueryError(migrationSection.Up, err)
-				} else if db.Verbose {
-					db.printVerbose(result)
-				}
-
-				// record migration
-				return drv.InsertMigration(tx, migration.Version)
+		for _, dependency := range migration.Dependencies {
+			table, version, err := db.dependencyTable(migration.Namespace, dependency)
+			if err != nil {
+				return err
 			}
-
-			if migrationSection.UpOptions.Transaction() {
-				// begin transaction
-				err = doTransaction(sqlDB, execMigration)
-			} else {
-				// run outside of transaction
-				err = execMigration(sqlDB)
+			_, applied := lookupVersion(scheme, appliedByTable[table], version)
+			_, pending := lookupVersion(scheme, pendingByTable[table], version)
+			if !applied && !pending {
+				return fmt.Errorf("%w: `%s` depends on `%s`", ErrDependencyNotApplied, migration.displayName(), dependency)
 			}
+		}
 
-			elapsed := time.Since(start)
-			fmt.Fprintf(db.Log, "Applied: %s in %s\n", migration.FileName, elapsed)
+		table := db.namespaceTableName(migration.Namespace)
+		if pendingByTable[table] == nil {
+			pendingByTable[table] = map[string]bool{}
+		}
+		pendingByTable[table][migration.Version] = true
+	}
 
+	// fail before applying anything if a pending migration has statements which can't run
+	// as written
+	if _, ok := capability[NonTransactionalDetector](drv); ok {
+		for _, migration := range pendingMigrations {
+			parsed, err := migration.Parse()
 			if err != nil {
 				return err
 			}
+			if _, err := db.planBlocks(drv, migration, parsed, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := db.createMigrationsTables(session); err != nil {
+		return err
+	}
+
+	db.printServerVersion(drv)
+
+	for _, migration := range pendingMigrations {
+		if err := db.applyMigration(drv, session, migration); err != nil {
+			return err
 		}
 	}
 
@@ -439,63 +867,206 @@ func (db *DB) Migrate() error {
 	return nil
 }
 
-func (db *DB) printVerbose(result sql.Result) {
-	lastInsertID, err := result.LastInsertId()
-	if err == nil {
-		fmt.Fprintf(db.Log, "Last insert ID: %d\n", lastInsertID)
+// execMigrationSQL runs the SQL of a migration section. If the driver has a batch separator
+// (such as GO for SQL Server), each batch is executed separately.
+func (db *DB) execMigrationSQL(drv SessionDriver, ex Executor, query string) error {
+	db.reportNotices = true
+	defer func() { db.reportNotices = false }()
+
+	syntax := statementSyntax(drv)
+	if syntax.BatchSeparator == "" {
+		result, err := ex.Exec(query)
+		if err != nil {
+			return err
+		} else if db.Verbose {
+			db.printVerbose(result)
+		}
+
+		return nil
 	}
-	rowsAffected, err := result.RowsAffected()
-	if err == nil {
-		fmt.Fprintf(db.Log, "Rows affected: %d\n", rowsAffected)
+
+	scanner := dbutil.NewStatementScanner(strings.NewReader(query), syntax)
+	for scanner.Scan() {
+		batch := scanner.Statement()
+		result, err := ex.Exec(batch.SQL)
+		if err != nil {
+			return fmt.Errorf("batch at line %d: %w", batch.Line, err)
+		} else if db.Verbose {
+			db.printVerbose(result)
+		}
 	}
+
+	return scanner.Err()
 }
 
-func (db *DB) readMigrationsDir(dir string) ([]fs.DirEntry, error) {
-	path := path.Clean(dir)
+// sectionSettings holds the SQL which switches to the role and search path requested by a
+// migration section, and which switches back afterwards
+type sectionSettings struct {
+	set   string
+	reset string
+}
 
-	// We use nil instead of os.DirFS() because DirFS cannot support both relative and absolute
-	// directory paths - it must be anchored at either "." or "/", which we do not know in advance.
-	// See: https://github.com/amacneil/dbmate/issues/403
-	if db.FS == nil {
-		return os.ReadDir(path)
+// sessionSettings returns the SQL for the role and search_path options of a migration section
+func (db *DB) sessionSettings(drv SessionDriver, options ParsedMigrationOptions) (sectionSettings, error) {
+	if options.Role() == "" && options.SearchPath() == "" {
+		return sectionSettings{}, nil
 	}
 
-	return fs.ReadDir(db.FS, path)
-}
+	settings, ok := capability[SessionSettings](drv)
+	if !ok {
+		return sectionSettings{}, fmt.Errorf("%w: role and search_path (%s)", ErrUnsupportedOption, db.driverName())
+	}
 
-// FindMigrations lists all available migrations
-func (db *DB) FindMigrations() ([]Migration, error) {
-	drv, err := db.Driver()
+	set, reset, err := settings.SessionSettingsSQL(options.Role(), options.SearchPath())
 	if err != nil {
-		return nil, err
+		return sectionSettings{}, fmt.Errorf("%w: %w", ErrUnsupportedOption, err)
+	}
+
+	return sectionSettings{set: set, reset: reset}, nil
+}
+
+// execMigrationSection runs the SQL of a migration section with its role and search path.
+// The settings are switched back even if the section fails, since a section which does not
+// run in a transaction leaves them on the connection.
+func (db *DB) execMigrationSection(drv SessionDriver, ex Executor, settings sectionSettings, query string) error {
+	if settings.set != "" {
+		if _, err := ex.Exec(settings.set); err != nil {
+			return err
+		}
+	}
+
+	err := db.execMigrationSQL(drv, ex, query)
+	if settings.reset != "" {
+		if _, resetErr := ex.Exec(settings.reset); err == nil {
+			err = resetErr
+		}
 	}
 
-	sqlDB, err := drv.Open()
+	return err
+}
+
+// applyMigration runs the up block of each section of a migration and records it
+func (db *DB) applyMigration(drv SessionDriver, session Session, migration Migration) error {
+	fmt.Fprintf(db.Log, "Applying: %s\n", migration.displayName())
+
+	start := time.Now()
+	table := db.namespaceTableName(migration.Namespace)
+
+	parsed, err := migration.Parse()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer dbutil.MustClose(sqlDB)
 
-	// find applied migrations
-	appliedMigrations := map[string]bool{}
-	migrationsTableExists, err := drv.MigrationsTableExists(sqlDB)
+	plans, err := db.planBlocks(drv, migration, parsed, false)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if migrationsTableExists {
-		appliedMigrations, err = drv.SelectMigrations(sqlDB, -1)
+	for i, migrationSection := range parsed {
+		db.warnTransaction(drv, migration, migrationSection.UpOptions)
+		settings, err := db.sessionSettings(drv, migrationSection.UpOptions)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if plans[i].note != "" {
+			fmt.Fprintln(db.Log, plans[i].note)
+		}
+
+		// run in a transaction, unless the section opts out of it (or can't run in one)
+		err = session.Run(plans[i].transaction, func(ex Executor) error {
+			// run actual migration
+			if err := db.execMigrationSection(drv, ex, settings, migrationSection.Up); err != nil {
+				return err
+			}
+
+			// record migration
+			return ex.InsertMigration(table, migration.Version)
+		})
+
+		elapsed := time.Since(start)
+		fmt.Fprintf(db.Log, "Applied: %s in %s\n", migration.displayName(), elapsed)
+
+		if err != nil {
+			return err
 		}
 	}
 
+	return nil
+}
+
+func (db *DB) printVerbose(result Result) {
+	if result == nil {
+		return
+	}
+	lastInsertID, err := result.LastInsertId()
+	if err == nil {
+		fmt.Fprintf(db.Log, "Last insert ID: %d\n", lastInsertID)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err == nil {
+		fmt.Fprintf(db.Log, "Rows affected: %d\n", rowsAffected)
+	}
+}
+
+func (db *DB) readMigrationsDir(dir string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(db.fs(), fsPath(dir))
+}
+
+// FindMigrations lists all available migrations
+func (db *DB) FindMigrations() ([]Migration, error) {
+	migrations, _, err := db.findMigrations()
+	return migrations, err
+}
+
+// findMigrations lists all available migrations, along with the versions
+// recorded in each migrations table (which may include versions that have
+// no corresponding migration file)
+func (db *DB) findMigrations() ([]Migration, map[string]map[string]bool, error) {
+	drv, err := db.SessionDriver()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := drv.OpenSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer dbutil.MustClose(session)
+
+	return db.findSessionMigrations(session)
+}
+
+// findSessionMigrations is findMigrations for a session which is already open
+func (db *DB) findSessionMigrations(session Session) ([]Migration, map[string]map[string]bool, error) {
+	// find applied migrations in each migrations table
+	appliedByTable := map[string]map[string]bool{}
+	for _, table := range db.namespaceTableNames() {
+		migrationsTableExists, err := session.MigrationsTableExists(table)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		appliedByTable[table] = map[string]bool{}
+		if migrationsTableExists {
+			appliedByTable[table], err = session.SelectMigrations(table, -1)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	dirs, err := db.selectedMigrationsDirs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scheme := db.versionScheme()
 	migrations := []Migration{}
-	for _, dir := range db.MigrationsDir {
+	for _, dir := range dirs {
 		// find filesystem migrations
-		files, err := db.readMigrationsDir(dir)
+		files, err := db.readMigrationsDir(dir.Path)
 		if err != nil {
-			return nil, fmt.Errorf("%w `%s`", ErrMigrationDirNotFound, dir)
+			return nil, nil, fmt.Errorf("%w `%s`", ErrMigrationDirNotFound, dir.Path)
 		}
 
 		for _, file := range files {
@@ -503,51 +1074,159 @@ func (db *DB) FindMigrations() ([]Migration, error) {
 				continue
 			}
 
-			matches := migrationFileRegexp.FindStringSubmatch(file.Name())
-			if len(matches) < 2 {
+			version, ok := scheme.Parse(file.Name())
+			if !ok {
 				continue
 			}
 
 			migration := Migration{
-				Applied:  false,
-				FileName: matches[0],
-				FilePath: path.Join(dir, matches[0]),
-				FS:       db.FS,
-				Version:  matches[1],
+				Applied:   false,
+				FileName:  file.Name(),
+				FilePath:  path.Join(dir.Path, file.Name()),
+				FS:        db.FS,
+				Version:   version,
+				Namespace: dir.Namespace,
 			}
-			if ok := appliedMigrations[migration.Version]; ok {
+			if ok := appliedByTable[db.namespaceTableName(dir.Namespace)][migration.Version]; ok {
 				migration.Applied = true
 			}
 
+			contents, err := migration.readFile()
+			if err != nil {
+				return nil, nil, err
+			}
+			migration.Dependencies = parseMigrationDependencies(contents, scheme)
+
 			migrations = append(migrations, migration)
 		}
 	}
 
+	// versions are looked up as written, so each table must not contain equal versions
+	// which are written differently
+	versionsByTable := map[string][]string{}
+	for table, applied := range appliedByTable {
+		for version := range applied {
+			versionsByTable[table] = append(versionsByTable[table], version)
+		}
+	}
+	for _, migration := range migrations {
+		table := db.namespaceTableName(migration.Namespace)
+		versionsByTable[table] = append(versionsByTable[table], migration.Version)
+	}
+	for _, versions := range versionsByTable {
+		if err := checkAmbiguousVersions(scheme, versions); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	sort.Slice(
 		migrations, func(i, j int) bool {
+			if c := scheme.Compare(migrations[i].Version, migrations[j].Version); c != 0 {
+				return c < 0
+			}
 			return migrations[i].FileName < migrations[j].FileName
 		},
 	)
 
-	return migrations, nil
+	migrations, err = db.sortMigrationDependencies(migrations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return migrations, appliedByTable, nil
+}
+
+// sortMigrationDependencies reorders migrations (which must already be sorted by version)
+// so that every migration follows the migrations it depends on. Migrations without
+// dependencies between them keep their relative order. Dependencies on versions which
+// are not present on disk are ignored here, and checked before migrating instead.
+func (db *DB) sortMigrationDependencies(migrations []Migration) ([]Migration, error) {
+	scheme := db.versionScheme()
+	indexes := map[string]map[string][]int{}
+	for i, migration := range migrations {
+		table := db.namespaceTableName(migration.Namespace)
+		if indexes[table] == nil {
+			indexes[table] = map[string][]int{}
+		}
+		indexes[table][migration.Version] = append(indexes[table][migration.Version], i)
+	}
+
+	// count unresolved dependencies of each migration, and record the reverse edges
+	inDegree := make([]int, len(migrations))
+	dependents := make([][]int, len(migrations))
+	for i, migration := range migrations {
+		for _, dependency := range migration.Dependencies {
+			table, version, err := db.dependencyTable(migration.Namespace, dependency)
+			if err != nil {
+				return nil, fmt.Errorf("%w: `%s` depends on `%s`", err, migration.displayName(), dependency)
+			}
+			matches, _ := lookupVersion(scheme, indexes[table], version)
+			for _, j := range matches {
+				if j == i {
+					continue
+				}
+				inDegree[i]++
+				dependents[j] = append(dependents[j], i)
+			}
+		}
+	}
+
+	sorted := make([]Migration, 0, len(migrations))
+	done := make([]bool, len(migrations))
+	for len(sorted) < len(migrations) {
+		// pick the first migration (by version) whose dependencies are all satisfied
+		next := -1
+		for i := range migrations {
+			if !done[i] && inDegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			cycle := []string{}
+			for i, migration := range migrations {
+				if !done[i] {
+					cycle = append(cycle, migration.displayName())
+				}
+			}
+			return nil, fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(cycle, ", "))
+		}
+
+		done[next] = true
+		sorted = append(sorted, migrations[next])
+		for _, i := range dependents[next] {
+			inDegree[i]--
+		}
+	}
+
+	return sorted, nil
 }
 
 // Rollback rolls back the most recent migration
 func (db *DB) Rollback() error {
-	drv, err := db.Driver()
+	drv, err := db.SessionDriver()
 	if err != nil {
 		return err
 	}
 
-	sqlDB, err := db.openDatabaseForMigration(drv)
+	lock, err := db.lock(drv)
 	if err != nil {
 		return err
 	}
-	defer dbutil.MustClose(sqlDB)
+	defer dbutil.MustClose(lock)
+
+	session, err := db.openSessionForMigration(drv)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(session)
+
+	db.printServerVersion(drv)
 
 	// find last applied migration
 	var latest *Migration
-	migrations, err := db.FindMigrations()
+	migrations, _, err := db.findSessionMigrations(session)
 	if err != nil {
 		return err
 	}
@@ -562,81 +1241,443 @@ func (db *DB) Rollback() error {
 		return ErrNoRollback
 	}
 
-	fmt.Fprintf(db.Log, "Rolling back: %s\n", latest.FileName)
+	if err := db.rollbackMigration(drv, session, *latest); err != nil {
+		return err
+	}
+
+	// automatically update schema file, silence errors
+	if db.AutoDumpSchema {
+		_ = db.DumpSchema()
+	}
+
+	return nil
+}
+
+// rollbackMigration runs the down block of each section of a migration and removes its record
+func (db *DB) rollbackMigration(drv SessionDriver, session Session, migration Migration) error {
+	fmt.Fprintf(db.Log, "Rolling back: %s\n", migration.displayName())
 
 	start := time.Now()
+	table := db.namespaceTableName(migration.Namespace)
 
-	parsedSections, err := latest.Parse()
+	parsedSections, err := migration.Parse()
 	if err != nil {
 		return err
 	}
 
-	for _, migrationSection := range parsedSections {
-		execMigration := func(tx dbutil.Transaction) error {
+	plans, err := db.planBlocks(drv, migration, parsedSections, true)
+	if err != nil {
+		return err
+	}
+
+	for i, migrationSection := range parsedSections {
+		db.warnTransaction(drv, migration, migrationSection.DownOptions)
+		settings, err := db.sessionSettings(drv, migrationSection.DownOptions)
+		if err != nil {
+			return err
+		}
+		if plans[i].note != "" {
+			fmt.Fprintln(db.Log, plans[i].note)
+		}
+
+		// run in a transaction, unless the section opts out of it (or can't run in one)
+		err = session.Run(plans[i].transaction, func(ex Executor) error {
 			// rollback migration
-			result, err := tx.Exec(migrationSection.Down)
-			if err != nil {
-				return drv.QueryError(migrationSection.Down, err)
-			} else if db.Verbose {
-				db.printVerbose(result)
+			if err := db.execMigrationSection(drv, ex, settings, migrationSection.Down); err != nil {
+				return err
 			}
 
 			// remove migration record
-			return drv.DeleteMigration(tx, latest.Version)
-		}
-
-		if migrationSection.DownOptions.Transaction() {
-			// begin transaction
-			err = doTransaction(sqlDB, execMigration)
-		} else {
-			// run outside of transaction
-			err = execMigration(sqlDB)
-		}
+			return ex.DeleteMigration(table, migration.Version)
+		})
 
 		elapsed := time.Since(start)
-		fmt.Fprintf(db.Log, "Rolled back: %s in %s\n", latest.FileName, elapsed)
+		fmt.Fprintf(db.Log, "Rolled back: %s in %s\n", migration.displayName(), elapsed)
 
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// Verify checks that every migration can be cleanly rolled back. The migrations are applied to
+// a new scratch database on the same server, which is dropped afterwards, so the configured
+// database is not changed. Each migration is applied, rolled back, and then applied again, and
+// the schema dumped before the migration is compared with the schema dumped after rolling it
+// back. Every migration which fails verification is reported.
+func (db *DB) Verify() error {
+	drv, err := db.Driver()
+	if errors.Is(err, ErrNotSQLDriver) {
+		return fmt.Errorf("%w: %s", ErrVerifyUnsupportedDriver, db.driverName())
+	} else if err != nil {
+		return err
+	}
+
+	scratcher, ok := drv.(ScratchDatabaser)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrVerifyUnsupportedDriver, db.driverName())
+	}
 
-		// automatically update schema file, silence errors
-		if db.AutoDumpSchema {
-			_ = db.DumpSchema()
+	scratchDB, drop, err := db.createScratchDatabase(scratcher, db.Log)
+	if err != nil {
+		return err
+	}
+	defer drop()
+
+	return scratchDB.verifyMigrations()
+}
+
+// verifyMigrations applies, rolls back, and re-applies each migration in turn
+func (db *DB) verifyMigrations() error {
+	drv, err := db.SessionDriver()
+	if err != nil {
+		return err
+	}
+
+	session, err := drv.OpenSession()
+	if err != nil {
+		return err
+	}
+	defer func() { dbutil.MustClose(session) }()
+
+	migrations, _, err := db.findSessionMigrations(session)
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) == 0 {
+		return ErrNoMigrationFiles
+	}
+
+	if err := db.createMigrationsTables(session); err != nil {
+		return err
+	}
+
+	db.printServerVersion(drv)
+
+	failures := []error{}
+	for i, migration := range migrations {
+		err := db.verifyMigration(drv, session, migration)
+		if err == nil {
+			fmt.Fprintf(db.Log, "Verified: %s\n", migration.displayName())
+			continue
+		}
+		if !errors.Is(err, ErrVerifyRollback) && !errors.Is(err, ErrVerifySchemaMismatch) {
+			return errors.Join(append(failures, err)...)
+		}
+		failures = append(failures, err)
+
+		// the failed rollback leaves the scratch database in an unknown state, so recreate it
+		// with the migrations up to this one before verifying the rest
+		dbutil.MustClose(session)
+		session, err = db.resetScratchDatabase(drv, migrations[:i+1])
+		if err != nil {
+			return errors.Join(append(failures, err)...)
 		}
 	}
 
-	return nil
+	return errors.Join(failures...)
 }
 
-// Status shows the status of all migrations
+// verifyMigration applies a migration, rolls it back, and compares the schema with the schema
+// before the migration, before applying it again
+func (db *DB) verifyMigration(drv SessionDriver, session Session, migration Migration) error {
+	before, err := session.DumpSchema(db.Args...)
+	if err != nil {
+		return err
+	}
+
+	if err := db.applyMigration(drv, session, migration); err != nil {
+		return err
+	}
+
+	if err := db.rollbackMigration(drv, session, migration); err != nil {
+		return fmt.Errorf("%w `%s`: %w", ErrVerifyRollback, migration.FileName, err)
+	}
+
+	after, err := session.DumpSchema(db.Args...)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(before, after) {
+		return fmt.Errorf("%w `%s`:\n%s", ErrVerifySchemaMismatch, migration.FileName, schemaDiff(before, after))
+	}
+
+	// re-apply migration so that subsequent migrations can build on it
+	return db.applyMigration(drv, session, migration)
+}
+
+// resetScratchDatabase recreates the scratch database and applies the given migrations to it,
+// returning a new session
+func (db *DB) resetScratchDatabase(drv SessionDriver, migrations []Migration) (Session, error) {
+	fmt.Fprintf(db.Log, "Recreating scratch database\n")
+
+	if err := drv.DropDatabase(); err != nil {
+		return nil, err
+	}
+	if err := drv.CreateDatabase(); err != nil {
+		return nil, err
+	}
+
+	session, err := drv.OpenSession()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.createMigrationsTables(session); err != nil {
+		dbutil.MustClose(session)
+		return nil, err
+	}
+
+	for _, migration := range migrations {
+		if err := db.applyMigration(drv, session, migration); err != nil {
+			dbutil.MustClose(session)
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+// schemaDiff lists the lines which only appear in one of two schema dumps,
+// prefixed with "-" if the line is missing after rollback or "+" if it was added
+func schemaDiff(before, after []byte) string {
+	beforeLines := strings.Split(string(before), "\n")
+	afterLines := strings.Split(string(after), "\n")
+
+	count := map[string]int{}
+	for _, line := range beforeLines {
+		count[line]++
+	}
+	for _, line := range afterLines {
+		count[line]--
+	}
+
+	var out strings.Builder
+	for _, line := range beforeLines {
+		if count[line] > 0 {
+			fmt.Fprintf(&out, "- %s\n", line)
+			count[line]--
+		}
+	}
+	for _, line := range afterLines {
+		if count[line] < 0 {
+			fmt.Fprintf(&out, "+ %s\n", line)
+			count[line]++
+		}
+	}
+
+	return out.String()
+}
+
+// Status shows the status of all migrations, and returns the number of pending migrations
 func (db *DB) Status(quiet bool) (int, error) {
-	results, err := db.FindMigrations()
+	counts, err := db.StatusSummary(quiet)
 	if err != nil {
 		return -1, err
 	}
 
-	var totalApplied int
-	var line string
+	return counts.Pending + counts.PendingOutOfOrder, nil
+}
+
+// StatusSummary shows the status of all migrations, and returns the number of migrations in each state
+func (db *DB) StatusSummary(quiet bool) (StatusCounts, error) {
+	var counts StatusCounts
+
+	results, err := db.StatusResults()
+	if err != nil {
+		return counts, err
+	}
 
+	// dependencies are resolved in the migrations table of each namespace
+	fileNames := map[string]map[string]string{}
+	appliedVersions := map[string]map[string]bool{}
 	for _, res := range results {
+		table := db.namespaceTableName(res.Namespace)
+		if fileNames[table] == nil {
+			fileNames[table] = map[string]string{}
+			appliedVersions[table] = map[string]bool{}
+		}
+		if res.Filename != "" {
+			fileNames[table][res.Version] = statusDisplayName(res)
+		}
 		if res.Applied {
-			line = fmt.Sprintf("[X] %s", res.FileName)
-			totalApplied++
-		} else {
-			line = fmt.Sprintf("[ ] %s", res.FileName)
+			appliedVersions[table][res.Version] = true
+		}
+	}
+
+	var line string
+
+	for _, res := range results {
+		switch res.State {
+		case StateApplied:
+			line = fmt.Sprintf("[X] %s", statusDisplayName(res))
+			counts.Applied++
+		case StatePending:
+			line = fmt.Sprintf("[ ] %s", statusDisplayName(res))
+			counts.Pending++
+		case StatePendingOutOfOrder:
+			line = fmt.Sprintf("[ ] %s (out of order)", statusDisplayName(res))
+			counts.PendingOutOfOrder++
+		case StateMissingFile:
+			line = fmt.Sprintf("[?] %s (applied, file not found)", statusDisplayName(res))
+			counts.MissingFile++
 		}
 		if !quiet {
 			fmt.Fprintln(db.Log, line)
+			for _, dependency := range res.Dependencies {
+				table, version, err := db.dependencyTable(res.Namespace, dependency)
+				if err != nil {
+					return counts, err
+				}
+				fmt.Fprintf(db.Log, "    depends on: %s\n",
+					dependencyName(db.versionScheme(), dependency, fileNames[table], appliedVersions[table], version))
+			}
 		}
 	}
 
-	totalPending := len(results) - totalApplied
 	if !quiet {
 		fmt.Fprintln(db.Log)
-		fmt.Fprintf(db.Log, "Applied: %d\n", totalApplied)
-		fmt.Fprintf(db.Log, "Pending: %d\n", totalPending)
+		fmt.Fprintf(db.Log, "Applied: %d\n", counts.Applied)
+		fmt.Fprintf(db.Log, "Pending: %d\n", counts.Pending+counts.PendingOutOfOrder)
+		if counts.PendingOutOfOrder > 0 {
+			fmt.Fprintf(db.Log, "Pending out of order: %d\n", counts.PendingOutOfOrder)
+		}
+		if counts.MissingFile > 0 {
+			fmt.Fprintf(db.Log, "Applied with missing file: %d\n", counts.MissingFile)
+		}
+	}
+
+	return counts, nil
+}
+
+// StatusResults lists all migrations classified by state. Versions which are recorded
+// in the migrations table but have no migration file are listed last.
+func (db *DB) StatusResults() ([]StatusResult, error) {
+	migrations, appliedByTable, err := db.findMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	// versions on disk are needed for every namespace sharing a migrations table
+	allMigrations := migrations
+	if db.Namespace != "" {
+		unscoped := *db
+		unscoped.Namespace = ""
+		allMigrations, _, err = unscoped.findMigrations()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	highestApplied := map[string]string{}
+	onDisk := map[string]map[string]bool{}
+	for _, migration := range allMigrations {
+		table := db.namespaceTableName(migration.Namespace)
+		if onDisk[table] == nil {
+			onDisk[table] = map[string]bool{}
+		}
+		onDisk[table][migration.Version] = true
+	}
+	scheme := db.versionScheme()
+	for _, migration := range migrations {
+		table := db.namespaceTableName(migration.Namespace)
+		if migration.Applied && (highestApplied[table] == "" || scheme.Compare(highestApplied[table], migration.Version) < 0) {
+			highestApplied[table] = migration.Version
+		}
+	}
+
+	results := []StatusResult{}
+	for _, migration := range migrations {
+		result := StatusResult{
+			Filename:     migration.FileName,
+			Applied:      migration.Applied,
+			Version:      migration.Version,
+			Namespace:    migration.Namespace,
+			State:        StatePending,
+			Dependencies: migration.Dependencies,
+		}
+
+		if migration.Applied {
+			result.State = StateApplied
+		} else if highest := highestApplied[db.namespaceTableName(migration.Namespace)]; highest != "" && scheme.Compare(migration.Version, highest) < 0 {
+			result.State = StatePendingOutOfOrder
+		}
+
+		results = append(results, result)
+	}
+
+	// find applied versions without a migration file in the selected namespaces
+	dirs, err := db.selectedMigrationsDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		table := db.namespaceTableName(dir.Namespace)
+		if seen[table] {
+			continue
+		}
+		seen[table] = true
+
+		versions := []string{}
+		for version := range appliedByTable[table] {
+			if !onDisk[table][version] {
+				versions = append(versions, version)
+			}
+		}
+		sortVersions(db.versionScheme(), versions)
+
+		// versions in the default table may belong to any namespace sharing it
+		namespace := ""
+		if table != db.MigrationsTableName {
+			namespace = dir.Namespace
+		}
+
+		for _, version := range versions {
+			results = append(results, StatusResult{
+				Applied:   true,
+				Version:   version,
+				Namespace: namespace,
+				State:     StateMissingFile,
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// statusDisplayName returns the file name (or version, if there is no file) of a status
+// result, prefixed with its namespace if it has one
+func statusDisplayName(res StatusResult) string {
+	name := res.Filename
+	if name == "" {
+		name = res.Version
+	}
+
+	if res.Namespace == "" {
+		return name
+	}
+
+	return res.Namespace + "/" + name
+}
+
+// dependencyName describes a dependency for status output, given the file names and applied
+// versions of the migrations table it refers to
+func dependencyName(scheme VersionScheme, dependency string, fileNames map[string]string,
+	appliedVersions map[string]bool, version string,
+) string {
+	if fileName, ok := lookupVersion(scheme, fileNames, version); ok {
+		return fileName
+	}
+
+	if _, ok := lookupVersion(scheme, appliedVersions, version); ok {
+		return dependency + " (applied, file not found)"
 	}
 
-	return totalPending, nil
+	return dependency + " (not found)"
 }
@@ -1,6 +1,7 @@
 package dbmate_test
 
 import (
+	"io/fs"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -15,7 +16,7 @@ import (
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/mysql"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/postgres"
-	_ "github.com/amacneil/dbmate/v2/pkg/driver/sqlite"
+	"github.com/amacneil/dbmate/v2/pkg/driver/sqlite"
 
 	"github.com/stretchr/testify/require"
 	"github.com/zenizh/go-capturer"
@@ -65,6 +66,7 @@ func TestNew(t *testing.T) {
 	require.Equal(t, []string{"./db/migrations"}, db.MigrationsDir)
 	require.Equal(t, "schema_migrations", db.MigrationsTableName)
 	require.Equal(t, "./db/schema.sql", db.SchemaFile)
+	require.Equal(t, "./db/schema", db.DesiredSchemaDir)
 	require.False(t, db.WaitBefore)
 	require.Equal(t, time.Second, db.WaitInterval)
 	require.Equal(t, 60*time.Second, db.WaitTimeout)
@@ -133,7 +135,7 @@ func TestWait(t *testing.T) {
 
 		err := db.Wait()
 		require.Error(t, err)
-		require.Contains(t, err.Error(), "unable to connect to database: unable to open database file:")
+		require.Contains(t, err.Error(), "unable to connect to database: "+sqliteOpenError)
 	})
 }
 
@@ -168,6 +170,49 @@ func TestDumpSchema(t *testing.T) {
 	require.Contains(t, string(schema), "-- Dbmate schema migrations")
 }
 
+func TestDumpSchemaOptions(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	db.SchemaFile = filepath.Join(t.TempDir(), "schema.sql")
+	db.DumpOptions = dbmate.DumpOptions{
+		ExcludeTables:    []string{"post*"},
+		NoMigrationsData: true,
+	}
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+
+	// dump schema
+	err = db.DumpSchema()
+	require.NoError(t, err)
+
+	schema, err := os.ReadFile(db.SchemaFile)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "CREATE TABLE users")
+	require.NotContains(t, string(schema), "posts")
+	require.NotContains(t, string(schema), "-- Dbmate schema migrations")
+
+	// normalization rules apply to the whole schema file
+	db.DumpOptions = dbmate.DumpOptions{
+		Normalize: []string{"whitespace"},
+		Rewrite:   []string{`s/^CREATE TABLE (\w+)/CREATE TABLE IF NOT EXISTS $1/`},
+	}
+	err = db.DumpSchema()
+	require.NoError(t, err)
+
+	schema, err = os.ReadFile(db.SchemaFile)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "CREATE TABLE IF NOT EXISTS users")
+	require.Contains(t, string(schema), "-- Dbmate schema migrations")
+	require.NotContains(t, string(schema), "\n\n\n")
+
+	// invalid options are reported before connecting
+	db.DumpOptions = dbmate.DumpOptions{Skip: []string{"tables"}}
+	err = db.DumpSchema()
+	require.ErrorIs(t, err, dbmate.ErrUnsupportedDumpSkip)
+}
+
 // TestDumpSchemaExtraArgs test that extra arguments are received by DumpSchema by passing undefined arguments
 func TestDumpSchemaExtraArgs(t *testing.T) {
 	t.Run("MySQL", func(t *testing.T) {
@@ -313,13 +358,87 @@ func TestLoadSchema(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestLoadSchemaStatements(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	drv, err := db.Driver()
+	require.NoError(t, err)
+
+	db.SchemaFile = filepath.Join(t.TempDir(), "schema.sql")
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.Create()
+	require.NoError(t, err)
+
+	sqlDB, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(sqlDB)
+
+	countTables := func() int {
+		var count int
+		err := sqlDB.QueryRow("select count(*) from sqlite_master where type = 'table'").Scan(&count)
+		require.NoError(t, err)
+		return count
+	}
+
+	schema := `-- comment
+CREATE TABLE users (id integer, name text default 'a;b');
+
+CREATE TRIGGER users_insert AFTER INSERT ON users
+BEGIN
+  UPDATE users SET name = 'x' WHERE id = NEW.id;
+END;
+
+CREATE TABLE posts (
+  id integer,
+  missing_type
+  ,
+);
+`
+	err = os.WriteFile(db.SchemaFile, []byte(schema), 0o644)
+	require.NoError(t, err)
+
+	t.Run("error line", func(t *testing.T) {
+		err := db.LoadSchema()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), db.SchemaFile+" line 9: ")
+		// statements before the error have been executed
+		require.Equal(t, 1, countTables())
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		_, err := sqlDB.Exec("drop table users")
+		require.NoError(t, err)
+
+		db.LoadTransaction = true
+		defer func() { db.LoadTransaction = false }()
+
+		err = db.LoadSchema()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), db.SchemaFile+" line 9: ")
+		require.Equal(t, 0, countTables())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		err := os.WriteFile(db.SchemaFile, []byte(strings.Replace(schema, "missing_type\n  ,", "title text", 1)), 0o644)
+		require.NoError(t, err)
+
+		var log strings.Builder
+		db.Log = &log
+
+		err = db.LoadSchema()
+		require.NoError(t, err)
+		require.Equal(t, 2, countTables())
+		require.Contains(t, log.String(), "Loaded: 3 statements in ")
+	})
+}
+
 func checkWaitCalled(t *testing.T, db *dbmate.DB, command func() error) {
 	oldDatabaseURL := db.DatabaseURL
 	db.DatabaseURL = sqliteBrokenTestURL(t)
 
 	err := command()
 	require.Error(t, err)
-	require.Contains(t, err.Error(), "unable to connect to database: unable to open database file:")
+	require.Contains(t, err.Error(), "unable to connect to database: "+sqliteOpenError)
 
 	db.DatabaseURL = oldDatabaseURL
 }
@@ -808,6 +927,53 @@ func TestMigrateStrictOrder(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestMigrateNonTransactional(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	drv, err := db.Driver()
+	require.NoError(t, err)
+
+	var out strings.Builder
+	db.Log = &out
+
+	err = db.Drop()
+	require.NoError(t, err)
+
+	// a block which mixes VACUUM with other statements is rejected before anything is applied
+	migrations := fstest.MapFS{
+		"db/migrations/001_create_users.sql": {Data: []byte("-- migrate:up\ncreate table users (id integer);\n-- migrate:down\n")},
+		"db/migrations/002_vacuum.sql": {
+			Data: []byte("-- migrate:up\ninsert into users (id) values (1);\nvacuum;\n-- migrate:down\n"),
+		},
+	}
+	db.FS = migrations
+
+	err = db.Migrate()
+	require.ErrorIs(t, err, dbmate.ErrNonTransactional)
+	require.ErrorContains(t, err, "002_vacuum.sql up block mixes them with other statements "+
+		"(line 3: VACUUM cannot run in a transaction)")
+
+	sqlDB, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(sqlDB)
+
+	count := -1
+	err = sqlDB.QueryRow("select count(*) from sqlite_master where name = 'users'").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	// a block which only contains VACUUM runs without a transaction
+	migrations["db/migrations/002_vacuum.sql"] = &fstest.MapFile{Data: []byte("-- migrate:up\nvacuum;\n-- migrate:down\n")}
+
+	err = db.Migrate()
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Running 002_vacuum.sql up block without a transaction "+
+		"(line 2: VACUUM cannot run in a transaction)")
+
+	applied, err := drv.SelectMigrations(sqlDB, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"001": true, "002": true}, applied)
+}
+
 func TestMigrateQueryErrorMessage(t *testing.T) {
 	db := newTestDB(t, sqliteTestURL(t))
 
@@ -920,3 +1086,881 @@ func TestMigrationContents(t *testing.T) {
 		require.NoError(t, err)
 	})
 }
+
+func TestVerify(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+
+	t.Run("reversible migrations", func(t *testing.T) {
+		err := db.Drop()
+		require.NoError(t, err)
+
+		err = db.Verify()
+		require.NoError(t, err)
+
+		// the configured database is not created or migrated
+		_, err = os.Stat("dbmate_test.sqlite3")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("applied migrations", func(t *testing.T) {
+		err := db.Drop()
+		require.NoError(t, err)
+		err = db.CreateAndMigrate()
+		require.NoError(t, err)
+
+		// migrations which were applied to the configured database are verified as well
+		output := &strings.Builder{}
+		db := newTestDB(t, sqliteTestURL(t))
+		db.Log = output
+
+		err = db.Verify()
+		require.NoError(t, err)
+		require.Contains(t, output.String(), "Verified: 20151129054053_test_migration.sql")
+		require.Contains(t, output.String(), "Verified: 20200227231541_test_posts.sql")
+	})
+
+	t.Run("down block does not restore schema", func(t *testing.T) {
+		err := db.Drop()
+		require.NoError(t, err)
+
+		db.FS = fstest.MapFS{
+			"db/migrations/001_create_users.sql": {
+				Data: []byte("-- migrate:up\ncreate table users (id integer);\n-- migrate:down\ndrop table users;\n"),
+			},
+			"db/migrations/002_create_posts.sql": {
+				Data: []byte("-- migrate:up\ncreate table posts (id integer);\n-- migrate:down\n"),
+			},
+		}
+
+		err = db.Verify()
+		require.ErrorIs(t, err, dbmate.ErrVerifySchemaMismatch)
+		require.Contains(t, err.Error(), "`002_create_posts.sql`")
+		require.Contains(t, err.Error(), "+ CREATE TABLE posts (id integer);")
+	})
+
+	t.Run("down block fails", func(t *testing.T) {
+		err := db.Drop()
+		require.NoError(t, err)
+
+		db.FS = fstest.MapFS{
+			"db/migrations/001_create_users.sql": {
+				Data: []byte("-- migrate:up\ncreate table users (id integer);\n-- migrate:down\ndrop table not_users;\n"),
+			},
+		}
+
+		err = db.Verify()
+		require.ErrorIs(t, err, dbmate.ErrVerifyRollback)
+		require.Contains(t, err.Error(), "no such table: not_users")
+	})
+
+	t.Run("every failure is reported", func(t *testing.T) {
+		err := db.Drop()
+		require.NoError(t, err)
+
+		db.FS = fstest.MapFS{
+			"db/migrations/001_create_users.sql": {
+				Data: []byte("-- migrate:up\ncreate table users (id integer);\n-- migrate:down\n"),
+			},
+			"db/migrations/002_create_posts.sql": {
+				Data: []byte("-- migrate:up\ncreate table posts (user_id integer references users);\n-- migrate:down\ndrop table not_posts;\n"),
+			},
+			"db/migrations/003_create_tags.sql": {
+				Data: []byte("-- migrate:up\ncreate table tags (id integer);\n-- migrate:down\ndrop table tags;\n"),
+			},
+		}
+
+		err = db.Verify()
+		require.ErrorIs(t, err, dbmate.ErrVerifySchemaMismatch)
+		require.ErrorIs(t, err, dbmate.ErrVerifyRollback)
+		require.Contains(t, err.Error(), "`001_create_users.sql`")
+		require.Contains(t, err.Error(), "`002_create_posts.sql`: no such table: not_posts")
+		require.NotContains(t, err.Error(), "003_create_tags.sql")
+	})
+
+	t.Run("unsupported driver", func(t *testing.T) {
+		dbmate.RegisterDriver(func(config dbmate.DriverConfig) dbmate.Driver {
+			return noScratchDriver{Driver: sqlite.NewDriver(config)}
+		}, "sqlite-no-scratch")
+
+		db := newTestDB(t, sqliteTestURL(t))
+		db.DriverName = "sqlite-no-scratch"
+
+		err := db.Verify()
+		require.ErrorIs(t, err, dbmate.ErrVerifyUnsupportedDriver)
+		require.EqualError(t, err, "driver does not support scratch databases for verification: sqlite-no-scratch")
+	})
+}
+
+// noScratchDriver is a sqlite driver which cannot create scratch databases
+type noScratchDriver struct {
+	dbmate.Driver
+}
+
+func TestFindMigrationsDependencies(t *testing.T) {
+	emptyMigration := []byte("-- migrate:up\n-- migrate:down")
+	dependsOn := func(versions string) []byte {
+		return []byte("-- migrate:depends " + versions + "\n-- migrate:up\n-- migrate:down")
+	}
+
+	db := newTestDB(t, sqliteTestURL(t))
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.Create()
+	require.NoError(t, err)
+
+	t.Run("dependencies are ordered first", func(t *testing.T) {
+		db.FS = fstest.MapFS{
+			"db/migrations/001_a.sql": {Data: dependsOn("003")},
+			"db/migrations/002_b.sql": {Data: emptyMigration},
+			"db/migrations/003_c.sql": {Data: dependsOn("004_d.sql")},
+			"db/migrations/004_d.sql": {Data: emptyMigration},
+		}
+
+		migrations, err := db.FindMigrations()
+		require.NoError(t, err)
+
+		fileNames := []string{}
+		for _, migration := range migrations {
+			fileNames = append(fileNames, migration.FileName)
+		}
+		require.Equal(t, []string{"002_b.sql", "004_d.sql", "003_c.sql", "001_a.sql"}, fileNames)
+		require.Equal(t, []string{"004"}, migrations[2].Dependencies)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		db.FS = fstest.MapFS{
+			"db/migrations/001_a.sql": {Data: dependsOn("003")},
+			"db/migrations/002_b.sql": {Data: emptyMigration},
+			"db/migrations/003_c.sql": {Data: dependsOn("001")},
+		}
+
+		_, err := db.FindMigrations()
+		require.ErrorIs(t, err, dbmate.ErrDependencyCycle)
+		require.Contains(t, err.Error(), "001_a.sql, 003_c.sql")
+	})
+}
+
+func TestMigrateDependencies(t *testing.T) {
+	emptyMigration := []byte("-- migrate:up\n-- migrate:down")
+
+	db := newTestDB(t, sqliteTestURL(t))
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.Create()
+	require.NoError(t, err)
+
+	t.Run("missing dependency is refused", func(t *testing.T) {
+		db.FS = fstest.MapFS{
+			"db/migrations/002_b.sql": {Data: []byte("-- migrate:depends 001\n-- migrate:up\n-- migrate:down")},
+		}
+
+		err := db.Migrate()
+		require.ErrorIs(t, err, dbmate.ErrDependencyNotApplied)
+		require.Contains(t, err.Error(), "`002_b.sql` depends on `001`")
+
+		results, err := db.FindMigrations()
+		require.NoError(t, err)
+		require.False(t, results[0].Applied)
+	})
+
+	t.Run("dependencies in another directory", func(t *testing.T) {
+		db.FS = fstest.MapFS{
+			"db/auth/001_a.sql":    {Data: emptyMigration},
+			"db/billing/002_b.sql": {Data: []byte("-- migrate:depends 001\n-- migrate:up\n-- migrate:down")},
+		}
+
+		// the dependency is refused while its directory is excluded
+		db.MigrationsDir = []string{"db/billing"}
+		err := db.Migrate()
+		require.ErrorIs(t, err, dbmate.ErrDependencyNotApplied)
+
+		db.MigrationsDir = []string{"db/auth", "db/billing"}
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		// once the dependency has been applied, its directory is no longer required
+		db.MigrationsDir = []string{"db/billing"}
+		err = db.Rollback()
+		require.NoError(t, err)
+		err = db.Migrate()
+		require.NoError(t, err)
+	})
+
+	t.Run("dependencies in another namespace table", func(t *testing.T) {
+		err := db.Drop()
+		require.NoError(t, err)
+
+		db.NamespaceTables = true
+		db.MigrationsDir = []string{"auth=db/auth", "billing=db/billing"}
+		defer func() {
+			db.NamespaceTables = false
+			db.MigrationsDir = []string{"./db/migrations"}
+		}()
+
+		// the same version in another namespace does not satisfy the dependency
+		mapFS := fstest.MapFS{
+			"db/auth/001_a.sql":    {Data: emptyMigration},
+			"db/billing/002_b.sql": {Data: []byte("-- migrate:depends 001\n-- migrate:up\n-- migrate:down")},
+		}
+		db.FS = mapFS
+		err = db.Migrate()
+		require.ErrorIs(t, err, dbmate.ErrDependencyNotApplied)
+		require.Contains(t, err.Error(), "`billing/002_b.sql` depends on `001`")
+
+		// unless the namespace is named
+		mapFS["db/billing/002_b.sql"] = &fstest.MapFile{Data: []byte("-- migrate:depends auth:001_a.sql\n-- migrate:up\n-- migrate:down")}
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		results, err := db.FindMigrations()
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		require.Equal(t, "001_a.sql", results[0].FileName)
+		require.True(t, results[1].Applied)
+		require.Equal(t, []string{"auth:001"}, results[1].Dependencies)
+
+		// namespaces must exist
+		mapFS["db/billing/003_c.sql"] = &fstest.MapFile{Data: []byte("-- migrate:depends payments:001\n-- migrate:up\n-- migrate:down")}
+		err = db.Migrate()
+		require.ErrorIs(t, err, dbmate.ErrNamespaceNotFound)
+		require.EqualError(t, err, "could not find migrations namespace `payments`: `billing/003_c.sql` depends on `payments:001`")
+	})
+
+	t.Run("versions are compared with the version scheme", func(t *testing.T) {
+		err := db.Drop()
+		require.NoError(t, err)
+
+		db.VersionScheme = dbmate.SequentialScheme{Width: 4}
+		defer func() { db.VersionScheme = nil }()
+
+		mapFS := fstest.MapFS{
+			"db/migrations/0001_a.sql": {Data: emptyMigration},
+		}
+		db.FS = mapFS
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		// an applied dependency is matched regardless of zero padding
+		mapFS["db/migrations/0002_b.sql"] = &fstest.MapFile{Data: []byte("-- migrate:depends 1\n-- migrate:up\n-- migrate:down")}
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		// as is a pending one, which is ordered first
+		mapFS["db/migrations/0003_c.sql"] = &fstest.MapFile{Data: []byte("-- migrate:depends 04\n-- migrate:up\n-- migrate:down")}
+		mapFS["db/migrations/0004_d.sql"] = &fstest.MapFile{Data: emptyMigration}
+		results, err := db.FindMigrations()
+		require.NoError(t, err)
+		require.Equal(t, "0004_d.sql", results[2].FileName)
+		require.Equal(t, "0003_c.sql", results[3].FileName)
+
+		err = db.Migrate()
+		require.NoError(t, err)
+	})
+}
+
+func TestStatusDependencies(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.Create()
+	require.NoError(t, err)
+
+	db.FS = fstest.MapFS{
+		"db/migrations/001_a.sql": {Data: []byte("-- migrate:up\n-- migrate:down")},
+		"db/migrations/002_b.sql": {Data: []byte("-- migrate:depends 001 000\n-- migrate:up\n-- migrate:down")},
+	}
+
+	var out strings.Builder
+	db.Log = &out
+
+	pending, err := db.Status(false)
+	require.NoError(t, err)
+	require.Equal(t, 2, pending)
+	require.Contains(t, out.String(), `[ ] 001_a.sql
+[ ] 002_b.sql
+    depends on: 001_a.sql
+    depends on: 000 (not found)
+`)
+}
+
+func TestNamespaces(t *testing.T) {
+	emptyMigration := []byte("-- migrate:up\n-- migrate:down")
+
+	mapFS := fstest.MapFS{
+		"db/auth/001_create_users.sql":      {Data: emptyMigration},
+		"db/auth/003_create_sessions.sql":   {Data: emptyMigration},
+		"db/billing/002_create_plans.sql":   {Data: emptyMigration},
+		"db/billing/001_create_charges.sql": {Data: emptyMigration},
+	}
+
+	t.Run("shared migrations table", func(t *testing.T) {
+		db := newTestDB(t, sqliteTestURL(t))
+		db.FS = fstest.MapFS{
+			"db/auth/001_create_users.sql":    {Data: emptyMigration},
+			"db/auth/003_create_sessions.sql": {Data: emptyMigration},
+			"db/billing/002_create_plans.sql": {Data: emptyMigration},
+		}
+		db.MigrationsDir = []string{"auth=db/auth", "billing=db/billing"}
+
+		err := db.Drop()
+		require.NoError(t, err)
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		// rollback is limited to the selected namespace
+		db.Namespace = "billing"
+		err = db.Rollback()
+		require.NoError(t, err)
+
+		db.Namespace = ""
+		results, err := db.FindMigrations()
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		require.Equal(t, "auth", results[0].Namespace)
+		require.True(t, results[0].Applied)
+		require.Equal(t, "billing", results[1].Namespace)
+		require.False(t, results[1].Applied)
+		require.True(t, results[2].Applied)
+
+		// status is limited to the selected namespace
+		var out strings.Builder
+		db.Log = &out
+		db.Namespace = "auth"
+		pending, err := db.Status(false)
+		require.NoError(t, err)
+		require.Equal(t, 0, pending)
+		require.Contains(t, out.String(), "[X] auth/001_create_users.sql\n[X] auth/003_create_sessions.sql\n\n")
+
+		// namespaces can also be selected by path
+		db.Namespace = "./db/billing"
+		results, err = db.FindMigrations()
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+
+		db.Namespace = "shipping"
+		_, err = db.FindMigrations()
+		require.ErrorIs(t, err, dbmate.ErrNamespaceNotFound)
+	})
+
+	t.Run("separate migrations tables", func(t *testing.T) {
+		db := newTestDB(t, sqliteTestURL(t))
+		db.FS = dbmate.MapFS(mapFS)
+		db.MigrationsDir = []string{"auth=db/auth", "billing-v2=db/billing"}
+		db.NamespaceTables = true
+		drv, err := db.Driver()
+		require.NoError(t, err)
+
+		err = db.Drop()
+		require.NoError(t, err)
+
+		// version 001 exists in both namespaces
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		results, err := db.FindMigrations()
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+		for _, result := range results {
+			require.True(t, result.Applied)
+		}
+
+		sqlDB, err := drv.Open()
+		require.NoError(t, err)
+		defer dbutil.MustClose(sqlDB)
+
+		applied, err := drv.SelectMigrations(sqlDB, -1)
+		require.NoError(t, err)
+		require.Empty(t, applied)
+
+		var count int
+		err = sqlDB.QueryRow(`select count(*) from "schema_migrations_billing-v2"`).Scan(&count)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		// schema dump records every namespace table
+		err = db.DumpSchema()
+		require.NoError(t, err)
+		schema, err := fs.ReadFile(db.FS, "db/schema.sql")
+		require.NoError(t, err)
+		require.Contains(t, string(schema), "-- Dbmate schema migrations\n"+
+			"INSERT INTO \"schema_migrations_auth\" (version) VALUES\n  ('001'),\n  ('003');\n")
+		require.Contains(t, string(schema), "-- Dbmate schema migrations\n"+
+			"INSERT INTO \"schema_migrations_billing-v2\" (version) VALUES\n  ('001'),\n  ('002');\n")
+
+		// loading the schema restores the applied status of each namespace
+		err = db.Drop()
+		require.NoError(t, err)
+		err = db.LoadSchema()
+		require.NoError(t, err)
+
+		db.Namespace = "billing-v2"
+		pending, err := db.Status(true)
+		require.NoError(t, err)
+		require.Equal(t, 0, pending)
+	})
+}
+
+func TestNewMigrationNamespace(t *testing.T) {
+	dir := t.TempDir()
+
+	db := newTestDB(t, sqliteTestURL(t))
+	db.MigrationsDir = []string{filepath.Join(dir, "app"), "billing=" + filepath.Join(dir, "billing")}
+	db.Log = &strings.Builder{}
+
+	err := db.NewMigration("create_users")
+	require.NoError(t, err)
+
+	db.Namespace = "billing"
+	err = db.NewMigration("create_plans")
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "app", "*_create_users.sql"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	files, err = filepath.Glob(filepath.Join(dir, "billing", "*_create_plans.sql"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	db.Namespace = "shipping"
+	err = db.NewMigration("create_shipments")
+	require.ErrorIs(t, err, dbmate.ErrNamespaceNotFound)
+}
+
+func TestStatusResults(t *testing.T) {
+	emptyMigration := []byte("-- migrate:up\n-- migrate:down")
+
+	db := newTestDB(t, sqliteTestURL(t))
+	drv, err := db.Driver()
+	require.NoError(t, err)
+
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.Create()
+	require.NoError(t, err)
+
+	// apply 001 and 003, then record 004 without a migration file
+	db.FS = fstest.MapFS{
+		"db/migrations/001_a.sql": {Data: emptyMigration},
+		"db/migrations/003_c.sql": {Data: emptyMigration},
+	}
+	err = db.Migrate()
+	require.NoError(t, err)
+
+	sqlDB, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(sqlDB)
+	err = drv.InsertMigration(sqlDB, "004")
+	require.NoError(t, err)
+
+	db.FS = fstest.MapFS{
+		"db/migrations/001_a.sql": {Data: emptyMigration},
+		"db/migrations/002_b.sql": {Data: emptyMigration},
+		"db/migrations/003_c.sql": {Data: emptyMigration},
+		"db/migrations/005_e.sql": {Data: emptyMigration},
+	}
+
+	results, err := db.StatusResults()
+	require.NoError(t, err)
+
+	states := map[string]dbmate.MigrationState{}
+	for _, result := range results {
+		states[result.Version] = result.State
+	}
+	require.Equal(t, map[string]dbmate.MigrationState{
+		"001": dbmate.StateApplied,
+		"002": dbmate.StatePendingOutOfOrder,
+		"003": dbmate.StateApplied,
+		"004": dbmate.StateMissingFile,
+		"005": dbmate.StatePending,
+	}, states)
+	require.Equal(t, "004", results[len(results)-1].Version)
+	require.Empty(t, results[len(results)-1].Filename)
+
+	var out strings.Builder
+	db.Log = &out
+
+	counts, err := db.StatusSummary(false)
+	require.NoError(t, err)
+	require.Equal(t, dbmate.StatusCounts{Applied: 2, Pending: 1, PendingOutOfOrder: 1, MissingFile: 1}, counts)
+	require.Equal(t, `[X] 001_a.sql
+[ ] 002_b.sql (out of order)
+[X] 003_c.sql
+[ ] 005_e.sql
+[?] 004 (applied, file not found)
+
+Applied: 2
+Pending: 2
+Pending out of order: 1
+Applied with missing file: 1
+`, out.String())
+
+	pending, err := db.Status(true)
+	require.NoError(t, err)
+	require.Equal(t, 2, pending)
+}
+
+func TestNewMigrationTemplate(t *testing.T) {
+	readMigration := func(t *testing.T, dir, name string) string {
+		files, err := filepath.Glob(filepath.Join(dir, "*_"+name+".sql"))
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+
+		contents, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		return string(contents)
+	}
+
+	t.Run("default template", func(t *testing.T) {
+		dir := t.TempDir()
+		db := newTestDB(t, nil)
+		db.MigrationsDir = []string{dir}
+		db.Log = &strings.Builder{}
+
+		err := db.NewMigration("create_users")
+		require.NoError(t, err)
+		require.Equal(t, "-- migrate:up\n\n\n-- migrate:down\n\n", readMigration(t, dir, "create_users"))
+
+		db.MigrationSQL = "create table posts (id integer);\n"
+		err = db.NewMigration("create_posts")
+		require.NoError(t, err)
+		require.Equal(t, "-- migrate:up\ncreate table posts (id integer);\n\n-- migrate:down\n\n", readMigration(t, dir, "create_posts"))
+	})
+
+	t.Run("templates in migrations directory", func(t *testing.T) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "migration.tmpl"),
+			[]byte("-- {{.Name}} ({{.Driver}})\n-- migrate:up\n{{.SQL}}\n-- migrate:down\n"), 0o644)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, "migration.sqlite.tmpl"),
+			[]byte("-- {{.Version}} sqlite\n-- migrate:up\n-- migrate:down\n"), 0o644)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dir, "concurrent.tmpl"),
+			[]byte("-- migrate:up transaction:false\n{{.SQL}}\n-- migrate:down transaction:false\n"), 0o644)
+		require.NoError(t, err)
+
+		db := newTestDB(t, dbtest.MustParseURL(t, "postgres://localhost/app"))
+		db.MigrationsDir = []string{dir}
+		db.Log = &strings.Builder{}
+
+		// fall back to the generic template
+		err = db.NewMigration("create_users")
+		require.NoError(t, err)
+		require.Equal(t, "-- create_users (postgres)\n-- migrate:up\n\n-- migrate:down\n", readMigration(t, dir, "create_users"))
+
+		// per-driver template
+		db.DatabaseURL = sqliteTestURL(t)
+		err = db.NewMigration("create_posts")
+		require.NoError(t, err)
+		require.Regexp(t, `^-- \d{14} sqlite\n`, readMigration(t, dir, "create_posts"))
+
+		// template selected by name
+		db.MigrationTemplate = "concurrent"
+		db.MigrationSQL = "create index concurrently posts_id on posts (id);"
+		err = db.NewMigration("index_posts")
+		require.NoError(t, err)
+		require.Equal(t, "-- migrate:up transaction:false\ncreate index concurrently posts_id on posts (id);\n-- migrate:down transaction:false\n",
+			readMigration(t, dir, "index_posts"))
+
+		// template selected by path
+		db.MigrationTemplate = filepath.Join(dir, "migration.tmpl")
+		err = db.NewMigration("create_comments")
+		require.NoError(t, err)
+		require.Contains(t, readMigration(t, dir, "create_comments"), "-- create_comments (sqlite)\n")
+
+		db.MigrationTemplate = "missing"
+		err = db.NewMigration("create_tags")
+		require.ErrorIs(t, err, dbmate.ErrTemplateNotFound)
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "migration.tmpl"), []byte("{{.Unknown}}"), 0o644)
+		require.NoError(t, err)
+
+		db := newTestDB(t, nil)
+		db.MigrationsDir = []string{dir}
+		db.Log = &strings.Builder{}
+
+		err = db.NewMigration("create_users")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "can't evaluate field Unknown")
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+}
+
+func TestVersionSchemes(t *testing.T) {
+	emptyMigration := []byte("-- migrate:up\n-- migrate:down")
+
+	t.Run("sequential new migrations", func(t *testing.T) {
+		dir := t.TempDir()
+		db := newTestDB(t, nil)
+		db.MigrationsDir = []string{dir}
+		db.VersionScheme = dbmate.SequentialScheme{Width: 4}
+		db.Log = &strings.Builder{}
+
+		err := db.NewMigration("create_users")
+		require.NoError(t, err)
+		err = db.NewMigration("create_posts")
+		require.NoError(t, err)
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			filepath.Join(dir, "0001_create_users.sql"),
+			filepath.Join(dir, "0002_create_posts.sql"),
+		}, files)
+	})
+
+	t.Run("flyway ordering", func(t *testing.T) {
+		db := newTestDB(t, sqliteTestURL(t))
+		db.FS = fstest.MapFS{
+			"db/migrations/V1_9__create_users.sql":  {Data: emptyMigration},
+			"db/migrations/V1_10__create_posts.sql": {Data: emptyMigration},
+			"db/migrations/V2__create_tags.sql":     {Data: emptyMigration},
+			"db/migrations/001_ignored.sql":         {Data: emptyMigration},
+		}
+		db.VersionScheme = dbmate.FlywayScheme{}
+
+		err := db.Drop()
+		require.NoError(t, err)
+
+		migrations, err := db.FindMigrations()
+		require.NoError(t, err)
+		require.Len(t, migrations, 3)
+		require.Equal(t, "V1_9__create_users.sql", migrations[0].FileName)
+		require.Equal(t, "1.9", migrations[0].Version)
+		require.Equal(t, "V1_10__create_posts.sql", migrations[1].FileName)
+		require.Equal(t, "1.10", migrations[1].Version)
+		require.Equal(t, "V2__create_tags.sql", migrations[2].FileName)
+
+		// strict mode compares versions using the scheme
+		db.Strict = true
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		// rollback removes the highest version
+		err = db.Rollback()
+		require.NoError(t, err)
+
+		migrations, err = db.FindMigrations()
+		require.NoError(t, err)
+		require.True(t, migrations[1].Applied)
+		require.False(t, migrations[2].Applied)
+	})
+
+	t.Run("sequential status", func(t *testing.T) {
+		db := newTestDB(t, sqliteTestURL(t))
+		db.VersionScheme = dbmate.SequentialScheme{Width: 4}
+		drv, err := db.Driver()
+		require.NoError(t, err)
+
+		err = db.Drop()
+		require.NoError(t, err)
+		db.FS = fstest.MapFS{
+			"db/migrations/1_a.sql":  {Data: emptyMigration},
+			"db/migrations/9_b.sql":  {Data: emptyMigration},
+			"db/migrations/10_c.sql": {Data: emptyMigration},
+		}
+		err = db.Migrate()
+		require.NoError(t, err)
+
+		// versions without a migration file are listed in version order
+		db.FS = fstest.MapFS{
+			"db/migrations/1_a.sql": {Data: emptyMigration},
+		}
+		results, err := db.StatusResults()
+		require.NoError(t, err)
+		versions := []string{}
+		for _, result := range results {
+			versions = append(versions, result.Version)
+		}
+		require.Equal(t, []string{"1", "9", "10"}, versions)
+		require.Equal(t, dbmate.StateMissingFile, results[1].State)
+
+		// a migration file which was renamed with different zero padding is rejected
+		mapFS := fstest.MapFS{
+			"db/migrations/0001_a.sql": {Data: emptyMigration},
+		}
+		db.FS = mapFS
+		_, err = db.StatusResults()
+		require.ErrorIs(t, err, dbmate.ErrAmbiguousVersion)
+		require.EqualError(t, err, "ambiguous migration version: `0001` and `1` are the same version, "+
+			"rename the migration file to match the recorded version")
+
+		// as are two migration files with the same version
+		err = db.Drop()
+		require.NoError(t, err)
+		db.FS = fstest.MapFS{
+			"db/migrations/001_a.sql":  {Data: emptyMigration},
+			"db/migrations/0001_b.sql": {Data: emptyMigration},
+		}
+		err = db.Migrate()
+		require.ErrorIs(t, err, dbmate.ErrAmbiguousVersion)
+
+		sqlDB, err := drv.Open()
+		require.NoError(t, err)
+		defer dbutil.MustClose(sqlDB)
+		exists, err := drv.MigrationsTableExists(sqlDB)
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+}
+
+func TestPlanSchema(t *testing.T) {
+	migrationsDir := t.TempDir()
+	desiredDir := t.TempDir()
+
+	db := newTestDB(t, sqliteTestURL(t))
+	db.MigrationsDir = []string{migrationsDir}
+	db.DesiredSchemaDir = desiredDir
+	db.Log = &strings.Builder{}
+
+	err := os.WriteFile(filepath.Join(migrationsDir, "001_create_users.sql"), []byte(
+		"-- migrate:up\ncreate table users (id integer primary key, name text);\n"+
+			"-- migrate:down\ndrop table users;\n"), 0o644)
+	require.NoError(t, err)
+
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+
+	// desired schema may be split across multiple files
+	err = os.WriteFile(filepath.Join(desiredDir, "posts.sql"), []byte(
+		"create table posts (id integer primary key, user_id integer references users (id));\n"), 0o644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(desiredDir, "users.sql"), []byte(
+		"create table users (id integer primary key, name text, email text);\n"+
+			"create index users_email on users (email);\n"), 0o644)
+	require.NoError(t, err)
+
+	err = db.PlanSchema("sync_schema")
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(migrationsDir, "*_sync_schema.sql"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	contents, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Equal(t, "-- migrate:up\n"+
+		"CREATE TABLE posts (id integer primary key, user_id integer references users (id));\n"+
+		"ALTER TABLE \"users\" ADD COLUMN \"email\" TEXT;\n"+
+		"CREATE INDEX users_email on users (email);\n\n"+
+		"-- migrate:down\n"+
+		"DROP INDEX \"users_email\";\n"+
+		"ALTER TABLE \"users\" DROP COLUMN \"email\";\n"+
+		"DROP TABLE \"posts\";\n", string(contents))
+
+	// the planned migration reaches the desired schema
+	err = db.Migrate()
+	require.NoError(t, err)
+
+	// views are not planned, but the user is warned about them
+	err = os.WriteFile(filepath.Join(desiredDir, "views.sql"), []byte(
+		"create view named_users as select * from users where name is not null;\n"), 0o644)
+	require.NoError(t, err)
+
+	var out strings.Builder
+	db.Log = &out
+	err = db.PlanSchema("sync_again")
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Warning: changes to these objects in the desired schema are not planned: view named_users\n")
+	require.Contains(t, out.String(), "Schema is up to date\n")
+	files, err = filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	// and can be rolled back
+	err = db.Rollback()
+	require.NoError(t, err)
+
+	// changes which sqlite cannot make in place are left for the user
+	err = os.WriteFile(filepath.Join(desiredDir, "users.sql"), []byte(
+		"create table users (id integer primary key, name text not null default '');\n"), 0o644)
+	require.NoError(t, err)
+	err = os.Remove(filepath.Join(desiredDir, "posts.sql"))
+	require.NoError(t, err)
+
+	err = db.PlanSchema("change_name")
+	require.NoError(t, err)
+	files, err = filepath.Glob(filepath.Join(migrationsDir, "*_change_name.sql"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	contents, err = os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Contains(t, string(contents), "-- migrate:up\n-- TODO: users: change cannot be planned automatically: "+
+		"sqlite cannot alter column users.name\n")
+}
+
+func TestPlanSchemaErrors(t *testing.T) {
+	t.Run("missing desired schema", func(t *testing.T) {
+		db := newTestDB(t, sqliteTestURL(t))
+		db.DesiredSchemaDir = filepath.Join(t.TempDir(), "missing")
+
+		err := db.PlanSchema("sync_schema")
+		require.ErrorIs(t, err, dbmate.ErrNoDesiredSchema)
+	})
+
+	t.Run("invalid desired schema", func(t *testing.T) {
+		desiredDir := t.TempDir()
+		err := os.WriteFile(filepath.Join(desiredDir, "users.sql"), []byte(
+			"create table users (id integer);\n\ncreate tabel posts (id integer);\n"), 0o644)
+		require.NoError(t, err)
+
+		db := newTestDB(t, sqliteTestURL(t))
+		db.MigrationsDir = []string{t.TempDir()}
+		db.DesiredSchemaDir = desiredDir
+		db.Log = &strings.Builder{}
+
+		// files are loaded statement by statement, so errors include the line number
+		err = db.PlanSchema("sync_schema")
+		require.ErrorIs(t, err, dbmate.ErrDesiredSchemaLoad)
+		require.Contains(t, err.Error(), "users.sql line 3: ")
+	})
+
+	t.Run("existing scratch database", func(t *testing.T) {
+		desiredDir := t.TempDir()
+		err := os.WriteFile(filepath.Join(desiredDir, "users.sql"), []byte("create table users (id integer);\n"), 0o644)
+		require.NoError(t, err)
+
+		// the scratch database is the database being planned
+		dbmate.RegisterDriver(func(config dbmate.DriverConfig) dbmate.Driver {
+			return fixedScratchDriver{Driver: sqlite.NewDriver(config).(*sqlite.Driver), scratch: sqliteTestURL(t)}
+		}, "sqlite-fixed-scratch")
+
+		db := newTestDB(t, sqliteTestURL(t))
+		db.DriverName = "sqlite-fixed-scratch"
+		db.MigrationsDir = []string{t.TempDir()}
+		db.DesiredSchemaDir = desiredDir
+
+		err = db.Drop()
+		require.NoError(t, err)
+		err = db.Create()
+		require.NoError(t, err)
+
+		err = db.PlanSchema("sync_schema")
+		require.ErrorIs(t, err, dbmate.ErrScratchDatabaseExists)
+		require.EqualError(t, err, "scratch database already exists: sqlite:dbmate_test.sqlite3")
+
+		// the existing database is not dropped
+		_, err = os.Stat("dbmate_test.sqlite3")
+		require.NoError(t, err)
+	})
+}
+
+// fixedScratchDriver is a sqlite driver which always plans in the same scratch database
+type fixedScratchDriver struct {
+	*sqlite.Driver
+	scratch *url.URL
+}
+
+func (drv fixedScratchDriver) ScratchDatabaseURL() (*url.URL, error) {
+	return drv.scratch, nil
+}
@@ -2,6 +2,7 @@ package dbmate
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -25,13 +26,101 @@ type Driver interface {
 	QueryError(string, error) error
 }
 
+// StatementSplitter is implemented by drivers whose SQL dialect quotes strings or comments
+// differently from standard SQL, which LoadSchema needs to split schema files into statements.
+// Drivers with a BatchSeparator also use it to split migrations into batches.
+type StatementSplitter interface {
+	StatementSyntax() dbutil.StatementSyntax
+}
+
+// TransactionRunner is implemented by drivers which control how migrations run inside a
+// transaction, for example to retry transactions which fail with a serialization error.
+// Migrations for other drivers run between a single Begin and Commit.
+type TransactionRunner interface {
+	RunTransaction(*sql.DB, func(dbutil.Transaction) error) error
+}
+
+// Locker is implemented by drivers which can lock the database while migrations run, so that
+// concurrent dbmate processes do not apply the same migration twice. Lock blocks until the
+// lock is acquired, and closing the returned io.Closer releases it. Drivers which can only
+// lock some databases return errors.ErrUnsupported for the others.
+type Locker interface {
+	Lock() (io.Closer, error)
+}
+
+// TransactionalDDL is implemented by drivers to report whether schema changes made in a
+// transaction are rolled back along with it. Migrations still run in a transaction by
+// default, but DB warns when a migration explicitly asks for one and the driver returns false.
+type TransactionalDDL interface {
+	TransactionalDDL() bool
+}
+
+// SchemaLoader is implemented by drivers which load schema files themselves (for example to
+// apply schema changes in batches), rather than one statement at a time
+type SchemaLoader interface {
+	LoadSchema(io.Reader) error
+}
+
+// ServerVersioner is implemented by drivers which can report the version of the database
+// server, which DB prints in verbose mode
+type ServerVersioner interface {
+	ServerVersion() (string, error)
+}
+
+// NoticeReporter is implemented by drivers which receive messages from the database server
+// while statements run, such as RAISE NOTICE in PostgreSQL. DB prints the notices raised by
+// migrations and schema files to its log.
+type NoticeReporter interface {
+	SetNoticeHandler(func(notice string))
+}
+
+// MigrationsDumper is implemented by drivers which can dump the versions recorded in their
+// migrations table, in the same format as DumpSchema. DB uses it to add the migrations tables
+// of namespaces (see DB.NamespaceTables) to the schema file.
+type MigrationsDumper interface {
+	DumpMigrations(*sql.DB) ([]byte, error)
+}
+
+// NonTransactionalDetector is implemented by drivers which recognize statements that cannot
+// run in a transaction, such as CREATE INDEX CONCURRENTLY in PostgreSQL. NonTransactionalReason
+// returns why a statement cannot run in a transaction, or an empty string if it can.
+type NonTransactionalDetector interface {
+	NonTransactionalReason(statement string) string
+}
+
+// SessionSettings is implemented by drivers which support the role and search_path migration
+// options. SessionSettingsSQL returns the SQL which switches to a role and search path (either
+// of which may be empty) for a migration section, and the SQL which switches back afterwards.
+type SessionSettings interface {
+	SessionSettingsSQL(role, searchPath string) (set, reset string, err error)
+}
+
 // DriverConfig holds configuration passed to driver constructors
 type DriverConfig struct {
 	DatabaseURL         *url.URL
 	Log                 io.Writer
 	MigrationsTableName string
+	// InitSQL is executed on every connection returned by Open, for drivers which support it
+	InitSQL string
+	// DumpMethod selects how DumpSchema reads the schema (see DumpMethodTool, DumpMethodNative, DumpMethodAuto)
+	DumpMethod string
+	// DumpOptions filter the objects written by DumpSchema
+	DumpOptions DumpOptions
 }
 
+// Schema dump methods, for drivers which support more than one
+const (
+	// DumpMethodTool dumps the schema using the database's command line tool (e.g. pg_dump)
+	DumpMethodTool = "tool"
+	// DumpMethodNative dumps the schema by querying the database catalog, without external tools
+	DumpMethodNative = "native"
+	// DumpMethodAuto uses the command line tool if it is installed, and the native dumper otherwise
+	DumpMethodAuto = "auto"
+)
+
+// ErrUnsupportedDumpMethod is returned when a driver does not support the requested dump method
+var ErrUnsupportedDumpMethod = errors.New("unsupported dump method")
+
 // DriverFunc represents a driver constructor
 type DriverFunc func(DriverConfig) Driver
 
@@ -68,6 +157,11 @@ func (e *QueryError) Error() string {
 	return e.Err.Error()
 }
 
+// Unwrap returns the driver error
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
 var drivers = map[string]DriverFunc{}
 
 // RegisterDriver registers a driver constructor for a given URL scheme
@@ -0,0 +1,7 @@
+//go:build !cgo || sqlite_purego
+// +build !cgo sqlite_purego
+
+package dbmate_test
+
+// sqliteOpenError is the error returned by modernc.org/sqlite for a missing directory
+const sqliteOpenError = "unable to open database file (14)"
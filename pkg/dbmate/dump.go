@@ -0,0 +1,154 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrUnsupportedDumpSkip is returned when DumpOptions.Skip contains an unknown object type
+var ErrUnsupportedDumpSkip = errors.New("unsupported dump skip object type")
+
+// ErrUnsupportedNormalizeRule is returned when DumpOptions.Normalize contains an unknown rule
+var ErrUnsupportedNormalizeRule = errors.New("unsupported schema normalize rule")
+
+// NormalizeAll selects all built-in normalization rules in DumpOptions.Normalize
+const NormalizeAll = "all"
+
+// Object types which can be left out of schema dumps with DumpOptions.Skip
+const (
+	// DumpSkipFunctions skips functions, procedures, and aggregates
+	DumpSkipFunctions = "functions"
+	// DumpSkipViews skips views and materialized views
+	DumpSkipViews = "views"
+	// DumpSkipTriggers skips triggers
+	DumpSkipTriggers = "triggers"
+	// DumpSkipIndexes skips indexes which are created separately from their table
+	DumpSkipIndexes = "indexes"
+	// DumpSkipSequences skips sequences, along with column defaults which use them
+	DumpSkipSequences = "sequences"
+	// DumpSkipTypes skips user defined types and domains
+	DumpSkipTypes = "types"
+	// DumpSkipExtensions skips extensions
+	DumpSkipExtensions = "extensions"
+	// DumpSkipEvents skips scheduled events
+	DumpSkipEvents = "events"
+	// DumpSkipPartitions skips the partitions of partitioned tables
+	DumpSkipPartitions = "partitions"
+)
+
+var dumpSkipTypes = []string{
+	DumpSkipFunctions,
+	DumpSkipViews,
+	DumpSkipTriggers,
+	DumpSkipIndexes,
+	DumpSkipSequences,
+	DumpSkipTypes,
+	DumpSkipExtensions,
+	DumpSkipEvents,
+	DumpSkipPartitions,
+}
+
+// DumpOptions filter the objects written by Driver.DumpSchema, and select how the schema
+// file is normalized. Drivers ignore object types which their database does not have.
+type DumpOptions struct {
+	// ExcludeTables lists glob patterns (as in path.Match) of tables and views to leave out,
+	// along with their indexes, constraints, and triggers. Patterns containing a dot are
+	// matched against "schema.table".
+	ExcludeTables []string
+	// IncludeSchemas limits the dump to these schemas, for databases which support them
+	IncludeSchemas []string
+	// Skip lists object types to leave out (see DumpSkipFunctions etc.)
+	Skip []string
+	// NoMigrationsData leaves out the list of applied migrations
+	NoMigrationsData bool
+	// DataTables lists tables whose rows are included as sorted INSERT statements after
+	// the schema, such as lookup tables which are part of the schema
+	DataTables []string
+	// Normalize lists built-in rules (see dbutil.NormalizeRuleNames, or NormalizeAll)
+	// which are applied to the schema file, so that it does not depend on the dump tool
+	Normalize []string
+	// Rewrite lists regular expression replacements (see dbutil.RewriteRule) which are
+	// applied to the schema file after the built-in rules
+	Rewrite []string
+}
+
+// Validate returns an error if the options contain an invalid pattern or object type
+func (o DumpOptions) Validate() error {
+	for _, pattern := range o.ExcludeTables {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid dump exclude table pattern `%s`: %w", pattern, err)
+		}
+	}
+	for _, skip := range o.Skip {
+		if !slices.Contains(dumpSkipTypes, skip) {
+			return fmt.Errorf("%w: %s (expected one of: %s)", ErrUnsupportedDumpSkip, skip,
+				strings.Join(dumpSkipTypes, ", "))
+		}
+	}
+
+	_, err := o.Normalizers()
+	return err
+}
+
+// Normalizers returns the normalization rules selected by Normalize and Rewrite.
+// Built-in rules are always applied in the order of dbutil.NormalizeRuleNames.
+func (o DumpOptions) Normalizers() ([]dbutil.NormalizeFunc, error) {
+	for _, name := range o.Normalize {
+		if name != NormalizeAll && dbutil.NormalizeRule(name) == nil {
+			return nil, fmt.Errorf("%w: %s (expected one of: %s, %s)", ErrUnsupportedNormalizeRule, name,
+				NormalizeAll, strings.Join(dbutil.NormalizeRuleNames, ", "))
+		}
+	}
+
+	rules := []dbutil.NormalizeFunc{}
+	for _, name := range dbutil.NormalizeRuleNames {
+		if slices.Contains(o.Normalize, NormalizeAll) || slices.Contains(o.Normalize, name) {
+			rules = append(rules, dbutil.NormalizeRule(name))
+		}
+	}
+	for _, expr := range o.Rewrite {
+		rule, err := dbutil.RewriteRule(expr)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Filtered returns true if the options leave any objects out of the schema
+func (o DumpOptions) Filtered() bool {
+	return len(o.ExcludeTables) > 0 || len(o.IncludeSchemas) > 0 || len(o.Skip) > 0
+}
+
+// ExcludesTable returns true if a table or view matches one of the ExcludeTables patterns.
+// The schema may be empty for databases which do not support schemas.
+func (o DumpOptions) ExcludesTable(schema, table string) bool {
+	for _, pattern := range o.ExcludeTables {
+		name := table
+		if strings.Contains(pattern, ".") {
+			name = schema + "." + table
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IncludesSchema returns true if a schema should be dumped
+func (o DumpOptions) IncludesSchema(schema string) bool {
+	return len(o.IncludeSchemas) == 0 || slices.Contains(o.IncludeSchemas, schema)
+}
+
+// Skips returns true if an object type (see DumpSkipFunctions etc.) should be left out
+func (o DumpOptions) Skips(objectType string) bool {
+	return slices.Contains(o.Skip, objectType)
+}
@@ -0,0 +1,116 @@
+package dbmate_test
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpOptionsValidate(t *testing.T) {
+	require.NoError(t, dbmate.DumpOptions{}.Validate())
+	require.NoError(t, dbmate.DumpOptions{
+		ExcludeTables: []string{"audit_*", "public.tmp_?"},
+		Skip:          []string{dbmate.DumpSkipFunctions, dbmate.DumpSkipViews},
+	}.Validate())
+
+	err := dbmate.DumpOptions{Skip: []string{"function"}}.Validate()
+	require.ErrorIs(t, err, dbmate.ErrUnsupportedDumpSkip)
+	require.ErrorContains(t, err, "unsupported dump skip object type: function (expected one of: functions, views,")
+
+	err = dbmate.DumpOptions{ExcludeTables: []string{"audit_["}}.Validate()
+	require.EqualError(t, err, "invalid dump exclude table pattern `audit_[`: syntax error in pattern")
+}
+
+func TestDumpOptionsExcludesTable(t *testing.T) {
+	opts := dbmate.DumpOptions{ExcludeTables: []string{"audit_*", "archive.*"}}
+
+	require.True(t, opts.ExcludesTable("public", "audit_log"))
+	require.True(t, opts.ExcludesTable("", "audit_log"))
+	require.False(t, opts.ExcludesTable("public", "users_audit"))
+	require.True(t, opts.ExcludesTable("archive", "users"))
+	require.False(t, opts.ExcludesTable("public", "users"))
+	require.False(t, dbmate.DumpOptions{}.ExcludesTable("public", "users"))
+}
+
+func TestDumpOptionsFilters(t *testing.T) {
+	opts := dbmate.DumpOptions{}
+	require.False(t, opts.Filtered())
+	require.True(t, opts.IncludesSchema("public"))
+	require.False(t, opts.Skips(dbmate.DumpSkipViews))
+
+	opts = dbmate.DumpOptions{NoMigrationsData: true}
+	require.False(t, opts.Filtered())
+
+	opts = dbmate.DumpOptions{IncludeSchemas: []string{"app"}, Skip: []string{dbmate.DumpSkipViews}}
+	require.True(t, opts.Filtered())
+	require.True(t, opts.IncludesSchema("app"))
+	require.False(t, opts.IncludesSchema("public"))
+	require.True(t, opts.Skips(dbmate.DumpSkipViews))
+	require.False(t, opts.Skips(dbmate.DumpSkipFunctions))
+}
+
+func TestDumpOptionsNormalizers(t *testing.T) {
+	rules, err := dbmate.DumpOptions{}.Normalizers()
+	require.NoError(t, err)
+	require.Empty(t, rules)
+
+	rules, err = dbmate.DumpOptions{Normalize: []string{"whitespace", "definers"}, Rewrite: []string{"s/a/b/"}}.Normalizers()
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+
+	rules, err = dbmate.DumpOptions{Normalize: []string{dbmate.NormalizeAll}}.Normalizers()
+	require.NoError(t, err)
+	require.Len(t, rules, 6)
+
+	err = dbmate.DumpOptions{Normalize: []string{"comments"}}.Validate()
+	require.ErrorIs(t, err, dbmate.ErrUnsupportedNormalizeRule)
+	require.EqualError(t, err, "unsupported schema normalize rule: comments (expected one of: all, "+
+		"version-comments, definers, owners, tablespaces, sort, whitespace)")
+
+	err = dbmate.DumpOptions{Rewrite: []string{"s/a/b"}}.Validate()
+	require.ErrorIs(t, err, dbutil.ErrInvalidRewriteRule)
+}
+
+func TestDumpSchemaNamespaceTables(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	db.FS = dbmate.MapFS(fstest.MapFS{
+		"db/auth/001_create_users.sql":    {Data: []byte("-- migrate:up\ncreate table users (id integer);\n-- migrate:down\n")},
+		"db/billing/001_create_plans.sql": {Data: []byte("-- migrate:up\ncreate table plans (id integer);\n-- migrate:down\n")},
+	})
+	db.MigrationsDir = []string{"auth=db/auth", "billing=db/billing"}
+	db.NamespaceTables = true
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+
+	dump := func(opts dbmate.DumpOptions) string {
+		db.DumpOptions = opts
+		err := db.DumpSchema()
+		require.NoError(t, err)
+
+		schema, err := fs.ReadFile(db.FS, "db/schema.sql")
+		require.NoError(t, err)
+		return string(schema)
+	}
+
+	schema := dump(dbmate.DumpOptions{})
+	require.Contains(t, schema, `INSERT INTO "schema_migrations_auth" (version) VALUES`)
+	require.Contains(t, schema, `INSERT INTO "schema_migrations_billing" (version) VALUES`)
+
+	// excluded namespace tables are left out
+	schema = dump(dbmate.DumpOptions{ExcludeTables: []string{"schema_migrations_bill*"}})
+	require.Contains(t, schema, `INSERT INTO "schema_migrations_auth" (version) VALUES`)
+	require.NotContains(t, schema, "schema_migrations_billing")
+
+	// no namespace migrations are dumped without migrations data
+	schema = dump(dbmate.DumpOptions{NoMigrationsData: true})
+	require.Contains(t, schema, "CREATE TABLE users")
+	require.NotContains(t, schema, "INSERT INTO")
+}
@@ -0,0 +1,170 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing/fstest"
+	"time"
+)
+
+// ErrReadOnlyFS is returned when an operation needs to write to a filesystem which can only be read
+var ErrReadOnlyFS = errors.New("filesystem is read-only")
+
+// WriteFS is a filesystem which supports writing files as well as reading them.
+// DB.FS must implement WriteFS for NewMigration, PlanSchema, and DumpSchema to write files.
+type WriteFS interface {
+	fs.FS
+	// MkdirAll creates a directory, along with any necessary parents
+	MkdirAll(name string, perm fs.FileMode) error
+	// WriteFile writes data to a file, creating it if necessary
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// Remove removes a file
+	Remove(name string) error
+}
+
+// OSFS is a WriteFS backed by the operating system, which is used when DB.FS is nil.
+//
+// Unlike os.DirFS(), OSFS accepts both relative and absolute paths. DirFS must be anchored
+// at either "." or "/", which we do not know in advance.
+// See: https://github.com/amacneil/dbmate/issues/403
+type OSFS struct{}
+
+// Open opens a file
+func (OSFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+// ReadDir reads a directory
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// ReadFile reads a file
+func (OSFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// Stat returns information about a file
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// MkdirAll creates a directory, along with any necessary parents
+func (OSFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// WriteFile writes data to a file, creating it if necessary
+func (OSFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+// Remove removes a file
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// MapFS is an in-memory WriteFS, for example to run dbmate against generated files in tests
+type MapFS fstest.MapFS
+
+// Open opens a file
+func (m MapFS) Open(name string) (fs.File, error) {
+	return fstest.MapFS(m).Open(name)
+}
+
+// ReadDir reads a directory
+func (m MapFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fstest.MapFS(m).ReadDir(name)
+}
+
+// ReadFile reads a file
+func (m MapFS) ReadFile(name string) ([]byte, error) {
+	return fstest.MapFS(m).ReadFile(name)
+}
+
+// Stat returns information about a file
+func (m MapFS) Stat(name string) (fs.FileInfo, error) {
+	return fstest.MapFS(m).Stat(name)
+}
+
+// MkdirAll creates a directory, along with any necessary parents
+func (m MapFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		if file, ok := m[name]; ok && !file.Mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+		}
+		m[name] = &fstest.MapFile{Mode: fs.ModeDir | perm, ModTime: time.Now()}
+	}
+
+	return nil
+}
+
+// WriteFile writes data to a file, creating it if necessary
+func (m MapFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	if file, ok := m[name]; ok && file.Mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	m[name] = &fstest.MapFile{Data: append([]byte{}, data...), Mode: perm, ModTime: time.Now()}
+
+	return nil
+}
+
+// Remove removes a file
+func (m MapFS) Remove(name string) error {
+	if _, ok := m[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m, name)
+
+	return nil
+}
+
+// fs returns the filesystem used for all file operations
+func (db *DB) fs() fs.FS {
+	if db.FS == nil {
+		return OSFS{}
+	}
+
+	return db.FS
+}
+
+// writeFS returns the filesystem for an operation which writes a file,
+// or ErrReadOnlyFS if the filesystem does not support writing
+func (db *DB) writeFS(operation, name string) (WriteFS, error) {
+	if wfs, ok := db.fs().(WriteFS); ok {
+		return wfs, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s `%s` requires DB.FS to implement dbmate.WriteFS", ErrReadOnlyFS, operation, name)
+}
+
+// fsPath converts a file path to the slash separated form used by fs.FS.
+// Leading "./" elements are removed, so that relative paths are valid in an fs.FS.
+func fsPath(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// fileExists returns true if a file (not a directory) exists
+func (db *DB) fileExists(name string) bool {
+	info, err := fs.Stat(db.fs(), fsPath(name))
+	return err == nil && !info.IsDir()
+}
+
+// ensureDir creates a directory if it does not already exist
+func ensureDir(wfs WriteFS, dir string) error {
+	if err := wfs.MkdirAll(fsPath(dir), 0o755); err != nil {
+		return fmt.Errorf("%w `%s`", ErrCreateDirectory, dir)
+	}
+
+	return nil
+}
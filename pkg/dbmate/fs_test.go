@@ -0,0 +1,188 @@
+package dbmate_test
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapFS(t *testing.T) {
+	mapFS := dbmate.MapFS{}
+
+	err := mapFS.MkdirAll("db/migrations", 0o755)
+	require.NoError(t, err)
+	err = mapFS.WriteFile("db/migrations/001_create_users.sql", []byte("-- migrate:up\n"), 0o644)
+	require.NoError(t, err)
+
+	entries, err := fs.ReadDir(mapFS, "db/migrations")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "001_create_users.sql", entries[0].Name())
+
+	contents, err := fs.ReadFile(mapFS, "db/migrations/001_create_users.sql")
+	require.NoError(t, err)
+	require.Equal(t, "-- migrate:up\n", string(contents))
+
+	err = mapFS.Remove("db/migrations/001_create_users.sql")
+	require.NoError(t, err)
+	err = mapFS.Remove("db/migrations/001_create_users.sql")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// paths must be valid fs.FS paths
+	err = mapFS.WriteFile("/db/schema.sql", nil, 0o644)
+	require.ErrorIs(t, err, fs.ErrInvalid)
+	err = mapFS.WriteFile("db/migrations", nil, 0o644)
+	require.ErrorIs(t, err, fs.ErrInvalid)
+}
+
+func TestWriteFS(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	mapFS := dbmate.MapFS{}
+	db.FS = mapFS
+	db.Log = &strings.Builder{}
+
+	// new migrations are written to the filesystem
+	err := db.NewMigration("create_users")
+	require.NoError(t, err)
+	migrations, err := fs.Glob(mapFS, "db/migrations/*_create_users.sql")
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+
+	err = mapFS.WriteFile(migrations[0], []byte(
+		"-- migrate:up\ncreate table users (id integer);\n-- migrate:down\ndrop table users;\n"), 0o644)
+	require.NoError(t, err)
+
+	// as is the schema file
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+	err = db.DumpSchema()
+	require.NoError(t, err)
+
+	schema, err := fs.ReadFile(mapFS, "db/schema.sql")
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "CREATE TABLE users (id integer);")
+
+	// and the schema is loaded from the same filesystem
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.LoadSchema()
+	require.NoError(t, err)
+
+	results, err := db.FindMigrations()
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.True(t, results[0].Applied)
+}
+
+func TestSchemaDir(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	mapFS := dbmate.MapFS{
+		"db/migrations/001_create_users.sql": {
+			Data: []byte("-- migrate:up\ncreate table users (id integer);\n-- migrate:down\ndrop table users;\n"),
+		},
+		"db/migrations/002_create_posts.sql": {
+			Data: []byte("-- migrate:up\ncreate table posts (id integer);\ncreate index posts_id on posts (id);\n" +
+				"-- migrate:down\ndrop table posts;\n"),
+		},
+	}
+	db.FS = mapFS
+	db.Log = &strings.Builder{}
+	db.SchemaDir = "db/schema"
+	db.DumpMethod = dbmate.DumpMethodNative
+
+	// the schema is written as one file per object, with a manifest listing the load order
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+	err = db.DumpSchema()
+	require.NoError(t, err)
+
+	manifest, err := fs.ReadFile(mapFS, "db/schema/manifest.txt")
+	require.NoError(t, err)
+	require.Equal(t, "# Generated by dbmate. Schema files are loaded in this order.\n"+
+		"table/posts.sql\n"+
+		"table/schema_migrations.sql\n"+
+		"table/users.sql\n"+
+		"index/posts_id.sql\n"+
+		"dbmate_schema_migrations.sql\n", string(manifest))
+
+	table, err := fs.ReadFile(mapFS, "db/schema/table/users.sql")
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE users (id integer);\n", string(table))
+	_, err = fs.Stat(mapFS, "db/schema.sql")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// the schema is loaded from the same files
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.LoadSchema()
+	require.NoError(t, err)
+
+	results, err := db.FindMigrations()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.True(t, results[1].Applied)
+
+	// files for objects which no longer exist are removed
+	err = db.Rollback()
+	require.NoError(t, err)
+	err = db.DumpSchema()
+	require.NoError(t, err)
+
+	_, err = fs.Stat(mapFS, "db/schema/table/posts.sql")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fs.Stat(mapFS, "db/schema/index/posts_id.sql")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fs.Stat(mapFS, "db/schema/table/users.sql")
+	require.NoError(t, err)
+
+	// the manifest may only list files within the schema directory
+	err = mapFS.WriteFile("db/schema/manifest.txt", []byte("table/users.sql\n../schema.sql\n"), 0o644)
+	require.NoError(t, err)
+	err = db.LoadSchema()
+	require.ErrorIs(t, err, dbmate.ErrInvalidSchemaManifest)
+	require.EqualError(t, err, "invalid schema manifest `db/schema/manifest.txt` line 2: ../schema.sql")
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	db.FS = fstest.MapFS{
+		"db/migrations/001_create_users.sql": {
+			Data: []byte("-- migrate:up\ncreate table users (id integer);\n-- migrate:down\ndrop table users;\n"),
+		},
+		"db/schema.sql": {
+			Data: []byte("CREATE TABLE users (id integer);\n"),
+		},
+	}
+	db.Log = &strings.Builder{}
+
+	// reading works with any fs.FS
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.LoadSchema()
+	require.NoError(t, err)
+
+	// operations which write files report that they need a WriteFS
+	err = db.NewMigration("create_posts")
+	require.ErrorIs(t, err, dbmate.ErrReadOnlyFS)
+	require.Contains(t, err.Error(), "creating migration `db/migrations/")
+
+	err = db.DumpSchema()
+	require.ErrorIs(t, err, dbmate.ErrReadOnlyFS)
+	require.EqualError(t, err, "filesystem is read-only: writing schema file `./db/schema.sql` "+
+		"requires DB.FS to implement dbmate.WriteFS")
+
+	// migrations can still be applied, without updating the schema file
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+}
@@ -15,6 +15,10 @@ type Migration struct {
 	FilePath string
 	FS       fs.FS
 	Version  string
+	// Namespace of the migrations directory containing this migration
+	Namespace string
+	// Dependencies lists the versions this migration declares with '-- migrate:depends'
+	Dependencies []string
 }
 
 func (m *Migration) readFile() (string, error) {
@@ -48,6 +52,8 @@ type ParsedMigration struct {
 // ParsedMigrationOptions is an interface for accessing migration options
 type ParsedMigrationOptions interface {
 	Transaction() bool
+	Role() string
+	SearchPath() string
 }
 
 type migrationOptions map[string]string
@@ -58,14 +64,35 @@ func (m migrationOptions) Transaction() bool {
 	return m["transaction"] != "false"
 }
 
+// Role returns the role which this migration should run as, or an empty string to keep the
+// role of the connection
+func (m migrationOptions) Role() string {
+	return m["role"]
+}
+
+// SearchPath returns the schema search path which this migration should run with, or an
+// empty string to keep the search path of the connection
+func (m migrationOptions) SearchPath() string {
+	return m["search_path"]
+}
+
+// requestsTransaction returns true if the options explicitly set transaction:true
+func requestsTransaction(options ParsedMigrationOptions) bool {
+	m, ok := options.(migrationOptions)
+	return ok && m["transaction"] == "true"
+}
+
 var (
-	upRegExp              = regexp.MustCompile(`(?m)^--\s*migrate:up(\s*$|\s+\S+)`)
-	downRegExp            = regexp.MustCompile(`(?m)^--\s*migrate:down(\s*$|\s+\S+)`)
-	emptyLineRegExp       = regexp.MustCompile(`^\s*$`)
-	commentLineRegExp     = regexp.MustCompile(`^\s*--`)
-	whitespaceRegExp      = regexp.MustCompile(`\s+`)
-	optionSeparatorRegExp = regexp.MustCompile(`:`)
-	blockDirectiveRegExp  = regexp.MustCompile(`^--\s*migrate:(up|down)`)
+	upRegExp               = regexp.MustCompile(`(?m)^--\s*migrate:up(\s*$|\s+\S+)`)
+	downRegExp             = regexp.MustCompile(`(?m)^--\s*migrate:down(\s*$|\s+\S+)`)
+	emptyLineRegExp        = regexp.MustCompile(`^\s*$`)
+	commentLineRegExp      = regexp.MustCompile(`^\s*--`)
+	whitespaceRegExp       = regexp.MustCompile(`\s+`)
+	optionSeparatorRegExp  = regexp.MustCompile(`:`)
+	blockDirectiveRegExp   = regexp.MustCompile(`^--\s*migrate:(up|down)`)
+	dependsRegExp          = regexp.MustCompile(`(?m)^--\s*migrate:depends\s+(.*?)\s*$`)
+	dependsSeparatorRegExp = regexp.MustCompile(`[\s,]+`)
+	dependsNamespaceRegExp = regexp.MustCompile(`^([a-zA-Z0-9_-]+):(.+)$`)
 )
 
 // Error codes
@@ -95,6 +122,57 @@ func parseMigrationContents(contents string) ([]*ParsedMigration, error) {
 	return migrationSections, nil
 }
 
+// parseMigrationDependencies returns the versions referenced by any
+// '-- migrate:depends' directives in the header of a migration, i.e. before
+// the first '-- migrate:up' block. Each dependency may be given either as a
+// version or as a migration file name (with or without the .sql extension),
+// optionally prefixed with the namespace it belongs to.
+//
+// For example:
+//
+//	parseMigrationDependencies("-- migrate:depends 001 auth:002_create_users.sql\n-- migrate:up\n", TimestampScheme{})
+//	// []string{"001", "auth:002"}
+func parseMigrationDependencies(contents string, scheme VersionScheme) []string {
+	if upDirectiveStart, ok := getMatchPosition(contents, upRegExp); ok {
+		contents = contents[:upDirectiveStart]
+	}
+
+	var dependencies []string
+	for _, match := range dependsRegExp.FindAllStringSubmatch(contents, -1) {
+		for _, ref := range dependsSeparatorRegExp.Split(match[1], -1) {
+			if ref == "" {
+				continue
+			}
+
+			namespace, ref := splitDependency(ref)
+
+			// accept file names as well as bare versions
+			if version, ok := scheme.Parse(ref); ok {
+				ref = version
+			} else if version, ok := scheme.Parse(ref + ".sql"); ok {
+				ref = version
+			}
+
+			if namespace != "" {
+				ref = namespace + ":" + ref
+			}
+			dependencies = append(dependencies, ref)
+		}
+	}
+
+	return dependencies
+}
+
+// splitDependency splits a dependency of the form "namespace:version" into its namespace and
+// version. The namespace is empty if the dependency does not name one.
+func splitDependency(dependency string) (string, string) {
+	if matches := dependsNamespaceRegExp.FindStringSubmatch(dependency); matches != nil {
+		return matches[1], matches[2]
+	}
+
+	return "", dependency
+}
+
 // parseMigrationSection parses the string contents of a migration section.
 // It will return two Migration objects, the first representing the "up"
 // block and the second representing the "down" block. This function
@@ -53,6 +53,24 @@ drop table users;`
 		require.Equal(t, true, parsed.DownOptions.Transaction())
 	})
 
+	t.Run("support role and search_path options", func(t *testing.T) {
+		migration := `-- migrate:up role:owner search_path:billing,public
+create table invoices (id serial);
+-- migrate:down
+drop table invoices;`
+
+		parsedSections, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+		parsed := parsedSections[0]
+
+		require.Equal(t, "owner", parsed.UpOptions.Role())
+		require.Equal(t, "billing,public", parsed.UpOptions.SearchPath())
+		require.Equal(t, true, parsed.UpOptions.Transaction())
+
+		require.Equal(t, "", parsed.DownOptions.Role())
+		require.Equal(t, "", parsed.DownOptions.SearchPath())
+	})
+
 	t.Run("do not require space between '--' and 'migrate'", func(t *testing.T) {
 		migration := `
 --migrate:up
@@ -282,3 +300,51 @@ drop table statuses;
 		require.ErrorIs(t, err, ErrParseMultipleDown)
 	})
 }
+
+func TestParseMigrationDependencies(t *testing.T) {
+	t.Run("no dependencies", func(t *testing.T) {
+		migration := "-- migrate:up\ncreate table users (id serial);\n-- migrate:down\ndrop table users;\n"
+
+		require.Nil(t, parseMigrationDependencies(migration, TimestampScheme{}))
+	})
+
+	t.Run("versions and file names", func(t *testing.T) {
+		migration := `-- Adds the posts table
+-- migrate:depends 20240101120000
+--migrate:depends 002_create_users.sql, 003
+-- migrate:up
+create table posts (id serial);
+-- migrate:down
+drop table posts;
+`
+
+		require.Equal(t, []string{"20240101120000", "002", "003"}, parseMigrationDependencies(migration, TimestampScheme{}))
+	})
+
+	t.Run("flyway versions", func(t *testing.T) {
+		migration := "-- migrate:depends V1_2__create_users.sql V1_10__create_posts 2.0\n-- migrate:up\n-- migrate:down\n"
+
+		require.Equal(t, []string{"1.2", "1.10", "2.0"}, parseMigrationDependencies(migration, FlywayScheme{}))
+	})
+
+	t.Run("namespaced dependencies", func(t *testing.T) {
+		migration := "-- migrate:depends auth:002_create_users.sql billing:003 004\n-- migrate:up\n-- migrate:down\n"
+
+		require.Equal(t, []string{"auth:002", "billing:003", "004"}, parseMigrationDependencies(migration, TimestampScheme{}))
+	})
+
+	t.Run("ignore directives after the up block", func(t *testing.T) {
+		migration := `-- migrate:up
+-- migrate:depends 001
+create table posts (id serial);
+-- migrate:down
+drop table posts;
+`
+
+		require.Nil(t, parseMigrationDependencies(migration, TimestampScheme{}))
+
+		// the directive is treated as an ordinary comment by the parser
+		_, err := parseMigrationContents(migration)
+		require.NoError(t, err)
+	})
+}
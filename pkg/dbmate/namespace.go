@@ -0,0 +1,152 @@
+package dbmate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// namespacedDirRegexp matches migrations directories in the form "namespace=path"
+var namespacedDirRegexp = regexp.MustCompile(`^([a-zA-Z0-9_-]+)=(.+)$`)
+
+// migrationsDir is a migrations directory and the namespace it belongs to
+type migrationsDir struct {
+	Namespace string
+	Path      string
+}
+
+// parseMigrationsDir splits a migrations directory of the form "namespace=path".
+// Directories without a namespace belong to the default (empty) namespace.
+func parseMigrationsDir(dir string) migrationsDir {
+	if matches := namespacedDirRegexp.FindStringSubmatch(dir); matches != nil {
+		return migrationsDir{Namespace: matches[1], Path: matches[2]}
+	}
+
+	return migrationsDir{Path: dir}
+}
+
+// matches returns true if the directory is selected by a namespace name or directory path
+func (d migrationsDir) matches(selector string) bool {
+	return selector == d.Namespace || path.Clean(selector) == path.Clean(d.Path)
+}
+
+// migrationsDirs returns all configured migrations directories
+func (db *DB) migrationsDirs() []migrationsDir {
+	dirs := make([]migrationsDir, 0, len(db.MigrationsDir))
+	for _, dir := range db.MigrationsDir {
+		dirs = append(dirs, parseMigrationsDir(dir))
+	}
+
+	return dirs
+}
+
+// selectedMigrationsDirs returns the migrations directories selected by db.Namespace,
+// or all directories if no namespace is selected
+func (db *DB) selectedMigrationsDirs() ([]migrationsDir, error) {
+	dirs := db.migrationsDirs()
+	if db.Namespace == "" {
+		return dirs, nil
+	}
+
+	selected := []migrationsDir{}
+	for _, dir := range dirs {
+		if dir.matches(db.Namespace) {
+			selected = append(selected, dir)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w `%s`", ErrNamespaceNotFound, db.Namespace)
+	}
+
+	return selected, nil
+}
+
+// namespaceTableName returns the migrations table used to track a namespace
+func (db *DB) namespaceTableName(namespace string) string {
+	if !db.NamespaceTables || namespace == "" {
+		return db.MigrationsTableName
+	}
+
+	return db.MigrationsTableName + "_" + namespace
+}
+
+// namespaceTableNames returns the distinct migrations tables used by all namespaces
+func (db *DB) namespaceTableNames() []string {
+	tables := []string{db.MigrationsTableName}
+	seen := map[string]bool{db.MigrationsTableName: true}
+	for _, dir := range db.migrationsDirs() {
+		table := db.namespaceTableName(dir.Namespace)
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+
+	return tables
+}
+
+// dependencyTable returns the migrations table and version which a dependency of a migration in
+// a namespace refers to. Dependencies are resolved in the migration's own table, unless they name
+// another namespace in the form "namespace:version".
+func (db *DB) dependencyTable(namespace, dependency string) (string, string, error) {
+	dependencyNamespace, version := splitDependency(dependency)
+	if dependencyNamespace == "" {
+		return db.namespaceTableName(namespace), version, nil
+	}
+
+	for _, dir := range db.migrationsDirs() {
+		if dir.Namespace == dependencyNamespace {
+			return db.namespaceTableName(dependencyNamespace), version, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("%w `%s`", ErrNamespaceNotFound, dependencyNamespace)
+}
+
+// namespaceMigrationsDump dumps the versions recorded in each separate namespace table, so
+// that they are restored when loading the schema. Tables excluded by DumpOptions are skipped.
+func (db *DB) namespaceMigrationsDump(session Session) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, table := range db.namespaceTableNames()[1:] {
+		schema, name := "", table
+		if i := strings.LastIndex(table, "."); i >= 0 {
+			schema, name = table[:i], table[i+1:]
+		}
+		if db.DumpOptions.ExcludesTable(schema, name) {
+			continue
+		}
+
+		exists, err := session.MigrationsTableExists(table)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+
+		dumper, ok := session.(SessionMigrationsDumper)
+		if !ok {
+			return nil, fmt.Errorf("unable to dump migrations table %s: %w", table, errors.ErrUnsupported)
+		}
+		migrations, err := dumper.DumpMigrations(table)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dump migrations table %s: %w", table, err)
+		}
+		buf.Write(migrations)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// displayName returns the migration file name, prefixed with its namespace if it has one
+func (m *Migration) displayName() string {
+	if m.Namespace == "" {
+		return m.FileName
+	}
+
+	return m.Namespace + "/" + m.FileName
+}
@@ -0,0 +1,480 @@
+package dbmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Schema planning errors
+var (
+	ErrPlanUnsupportedDriver = errors.New("driver does not support schema planning")
+	ErrPlanUnsupportedChange = errors.New("change cannot be planned automatically")
+	ErrNoDesiredSchema       = errors.New("no desired schema files found")
+	ErrDesiredSchemaLoad     = errors.New("unable to load desired schema")
+	ErrScratchDatabaseExists = errors.New("scratch database already exists")
+)
+
+// Schema describes the tables in a database, as returned by SchemaPlanner.InspectSchema
+type Schema struct {
+	Tables []SchemaTable
+	// Unplanned lists other objects which the planner cannot compare, such as views, functions
+	// and triggers, e.g. "view active_users"
+	Unplanned []string
+}
+
+// SchemaTable describes a table and its indexes
+type SchemaTable struct {
+	// Name of the table
+	Name string
+	// Definition is a CREATE TABLE statement which recreates the table (without indexes)
+	Definition string
+	// Columns in table order
+	Columns []SchemaColumn
+	// Constraints such as primary keys, unique constraints, and foreign keys
+	Constraints []SchemaConstraint
+	// Indexes which are not part of a constraint
+	Indexes []SchemaIndex
+	// References lists the tables referenced by foreign keys
+	References []string
+}
+
+// SchemaColumn describes a table column
+type SchemaColumn struct {
+	// Name of the column
+	Name string
+	// Type of the column, e.g. "integer"
+	Type string
+	// NotNull is true if the column may not contain nulls
+	NotNull bool
+	// Default is the default expression, or empty if there is none
+	Default string
+	// Definition is the column definition used in CREATE TABLE and ADD COLUMN statements
+	Definition string
+}
+
+// SchemaConstraint describes a table constraint
+type SchemaConstraint struct {
+	// Name of the constraint
+	Name string
+	// Definition of the constraint, e.g. "PRIMARY KEY (id)"
+	Definition string
+}
+
+// SchemaIndex describes an index
+type SchemaIndex struct {
+	// Name of the index
+	Name string
+	// Definition is a CREATE INDEX statement which recreates the index
+	Definition string
+}
+
+// ScratchDatabaser is implemented by drivers which can create temporary databases, which are
+// used to plan schema changes and verify migrations without changing the configured database
+type ScratchDatabaser interface {
+	// ScratchDatabaseURL returns the URL of a temporary database on the same server. The name
+	// should be unique, since dbmate refuses to use a scratch database which already exists.
+	ScratchDatabaseURL() (*url.URL, error)
+}
+
+// SchemaPlanner is implemented by drivers which support planning migrations from a desired schema
+type SchemaPlanner interface {
+	// InspectSchema returns the tables in the current schema
+	InspectSchema(*sql.DB) (*Schema, error)
+	// ScratchDatabaser names the temporary database which the desired schema is loaded into
+	ScratchDatabaser
+	// AlterTableSQL returns the statements which change a table from one definition to another,
+	// excluding indexes. ErrPlanUnsupportedChange is returned if the change cannot be made in place.
+	AlterTableSQL(from, to SchemaTable) ([]string, error)
+}
+
+// planStep is a set of statements in the up block, and the statements which revert them
+type planStep struct {
+	up   []string
+	down []string
+}
+
+// PlanSchema compares the desired schema with the current database, and creates a new
+// migration containing the statements required to reach the desired schema
+func (db *DB) PlanSchema(name string) error {
+	if name == "" {
+		return ErrNoMigrationName
+	}
+
+	desired, err := db.readDesiredSchema()
+	if err != nil {
+		return err
+	}
+
+	drv, err := db.Driver()
+	if errors.Is(err, ErrNotSQLDriver) {
+		return fmt.Errorf("%w: %s", ErrPlanUnsupportedDriver, db.driverName())
+	} else if err != nil {
+		return err
+	}
+
+	planner, ok := drv.(SchemaPlanner)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrPlanUnsupportedDriver, db.driverName())
+	}
+
+	// warn if the plan would repeat changes from pending migrations
+	migrations, err := db.FindMigrations()
+	if err != nil && !errors.Is(err, ErrMigrationDirNotFound) {
+		return err
+	}
+	pending := 0
+	for _, migration := range migrations {
+		if !migration.Applied {
+			pending++
+		}
+	}
+	if pending > 0 {
+		fmt.Fprintf(db.Log, "Warning: %d pending migrations have not been applied to the current database\n", pending)
+	}
+
+	current, err := db.inspectSchema(drv, planner)
+	if err != nil {
+		return err
+	}
+
+	target, err := db.inspectDesiredSchema(planner, desired)
+	if err != nil {
+		return err
+	}
+	if len(target.Unplanned) > 0 {
+		fmt.Fprintf(db.Log, "Warning: changes to these objects in the desired schema are not planned: %s\n",
+			strings.Join(target.Unplanned, ", "))
+	}
+
+	up, down := planSchemaChanges(planner, current, target)
+	if len(up) == 0 {
+		fmt.Fprintln(db.Log, "Schema is up to date")
+		return nil
+	}
+
+	return db.newMigration(name, strings.Join(up, "\n"), strings.Join(down, "\n"))
+}
+
+// readDesiredSchema lists all .sql files in the desired schema directory, ordered by name
+func (db *DB) readDesiredSchema() ([]string, error) {
+	dir := path.Clean(db.DesiredSchemaDir)
+
+	files, err := fs.ReadDir(db.fs(), fsPath(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w `%s`", ErrNoDesiredSchema, dir)
+	} else if err != nil {
+		return nil, err
+	}
+
+	schema := []string{}
+	for _, file := range files {
+		if file.IsDir() || path.Ext(file.Name()) != ".sql" {
+			continue
+		}
+
+		schema = append(schema, path.Join(dir, file.Name()))
+	}
+
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("%w `%s`", ErrNoDesiredSchema, dir)
+	}
+
+	return schema, nil
+}
+
+// inspectSchema inspects a database, ignoring any dbmate migrations tables
+func (db *DB) inspectSchema(drv Driver, planner SchemaPlanner) (*Schema, error) {
+	sqlDB, err := drv.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(sqlDB)
+
+	return db.inspectSchemaDB(sqlDB, planner)
+}
+
+// inspectDesiredSchema loads the desired schema into a scratch database and inspects it
+func (db *DB) inspectDesiredSchema(planner SchemaPlanner, desired []string) (*Schema, error) {
+	// scratch database output is not interesting to the user
+	scratchDB, drop, err := db.createScratchDatabase(planner, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	defer drop()
+
+	// files are loaded like schema files, one statement at a time
+	sessionDrv, err := scratchDB.SessionDriver()
+	if err != nil {
+		return nil, err
+	}
+	if err := scratchDB.loadSchema(sessionDrv, desired); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDesiredSchemaLoad, err)
+	}
+
+	drv, err := scratchDB.driver(db.MigrationsTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch, ok := drv.(SchemaPlanner)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPlanUnsupportedDriver, db.driverName())
+	}
+
+	sqlDB, err := drv.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(sqlDB)
+
+	return scratchDB.inspectSchemaDB(sqlDB, scratch)
+}
+
+// createScratchDatabase creates a temporary database, and returns a copy of db which uses it and
+// writes its output to log. The returned function drops the scratch database again.
+func (db *DB) createScratchDatabase(drv ScratchDatabaser, log io.Writer) (*DB, func(), error) {
+	scratchURL, err := drv.ScratchDatabaseURL()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scratchDB := *db
+	scratchDB.DatabaseURL = scratchURL
+	scratchDB.Log = log
+	scratchDrv, err := scratchDB.driver(db.MigrationsTableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// never drop a database which dbmate did not create
+	exists, err := scratchDrv.DatabaseExists()
+	if err != nil {
+		return nil, nil, err
+	}
+	if exists {
+		return nil, nil, fmt.Errorf("%w: %s", ErrScratchDatabaseExists, scratchURL.Redacted())
+	}
+	if err := scratchDrv.CreateDatabase(); err != nil {
+		return nil, nil, err
+	}
+
+	drop := func() {
+		if err := scratchDrv.DropDatabase(); err != nil {
+			fmt.Fprintf(db.Log, "Warning: unable to drop scratch database: %s\n", err)
+		}
+	}
+
+	return &scratchDB, drop, nil
+}
+
+// inspectSchemaDB inspects an open database, ignoring any dbmate migrations tables
+func (db *DB) inspectSchemaDB(sqlDB *sql.DB, planner SchemaPlanner) (*Schema, error) {
+	schema, err := planner.InspectSchema(sqlDB)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := []SchemaTable{}
+	for _, table := range schema.Tables {
+		if !db.isMigrationsTable(table.Name) {
+			tables = append(tables, table)
+		}
+	}
+	schema.Tables = tables
+
+	return schema, nil
+}
+
+// isMigrationsTable returns true if a table is used to record migrations
+func (db *DB) isMigrationsTable(name string) bool {
+	for _, table := range db.namespaceTableNames() {
+		if name == table || strings.HasSuffix(table, "."+name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// planSchemaChanges returns the statements which change the current schema into the desired
+// schema, along with the statements which revert them
+func planSchemaChanges(planner SchemaPlanner, current, desired *Schema) ([]string, []string) {
+	currentTables := map[string]SchemaTable{}
+	for _, table := range current.Tables {
+		currentTables[table.Name] = table
+	}
+	desiredTables := map[string]SchemaTable{}
+	for _, table := range desired.Tables {
+		desiredTables[table.Name] = table
+	}
+
+	var dropIndexes, dropTables, createTables, alterTables, createIndexes []planStep
+
+	// tables are dropped in reverse dependency order, so that referencing tables are dropped first
+	removed := sortTablesByReferences(current.Tables)
+	for i := len(removed) - 1; i >= 0; i-- {
+		table := removed[i]
+		if _, ok := desiredTables[table.Name]; ok {
+			continue
+		}
+
+		dropTables = append(dropTables, planStep{
+			up:   []string{"DROP TABLE " + quoteIdentifier(table.Name) + ";"},
+			down: createTableStatements(table),
+		})
+	}
+
+	for _, table := range sortTablesByReferences(desired.Tables) {
+		from, ok := currentTables[table.Name]
+		if !ok {
+			createTables = append(createTables, planStep{
+				up:   createTableStatements(table),
+				down: []string{"DROP TABLE " + quoteIdentifier(table.Name) + ";"},
+			})
+			continue
+		}
+
+		if from.Definition != table.Definition {
+			up, upErr := planner.AlterTableSQL(from, table)
+			down, downErr := planner.AlterTableSQL(table, from)
+			if upErr != nil || downErr != nil {
+				alterTables = append(alterTables, planStep{
+					up:   []string{planErrorComment(table.Name, upErr, downErr)},
+					down: []string{planErrorComment(table.Name, downErr, upErr)},
+				})
+			} else if len(up) > 0 {
+				alterTables = append(alterTables, planStep{
+					up:   terminateStatements(up),
+					down: terminateStatements(down),
+				})
+			}
+		}
+
+		currentIndexes := map[string]SchemaIndex{}
+		for _, index := range from.Indexes {
+			currentIndexes[index.Name] = index
+		}
+		desiredIndexes := map[string]SchemaIndex{}
+		for _, index := range table.Indexes {
+			desiredIndexes[index.Name] = index
+		}
+
+		for _, index := range from.Indexes {
+			if desiredIndex, ok := desiredIndexes[index.Name]; !ok || desiredIndex.Definition != index.Definition {
+				dropIndexes = append(dropIndexes, planStep{
+					up:   []string{"DROP INDEX " + quoteIdentifier(index.Name) + ";"},
+					down: []string{index.Definition + ";"},
+				})
+			}
+		}
+		for _, index := range table.Indexes {
+			if currentIndex, ok := currentIndexes[index.Name]; !ok || currentIndex.Definition != index.Definition {
+				createIndexes = append(createIndexes, planStep{
+					up:   []string{index.Definition + ";"},
+					down: []string{"DROP INDEX " + quoteIdentifier(index.Name) + ";"},
+				})
+			}
+		}
+	}
+
+	steps := []planStep{}
+	steps = append(steps, dropIndexes...)
+	steps = append(steps, dropTables...)
+	steps = append(steps, createTables...)
+	steps = append(steps, alterTables...)
+	steps = append(steps, createIndexes...)
+
+	// the down block reverts each step in reverse order
+	up := []string{}
+	down := []string{}
+	for i, step := range steps {
+		up = append(up, step.up...)
+		down = append(down, steps[len(steps)-1-i].down...)
+	}
+
+	return up, down
+}
+
+// createTableStatements returns the statements which create a table and its indexes
+func createTableStatements(table SchemaTable) []string {
+	statements := []string{table.Definition + ";"}
+	for _, index := range table.Indexes {
+		statements = append(statements, index.Definition+";")
+	}
+
+	return statements
+}
+
+// terminateStatements adds a semicolon to each statement which is not a comment
+func terminateStatements(statements []string) []string {
+	out := make([]string, 0, len(statements))
+	for _, statement := range statements {
+		if !strings.HasPrefix(statement, "--") {
+			statement += ";"
+		}
+		out = append(out, statement)
+	}
+
+	return out
+}
+
+// planErrorComment explains why a table change must be written by hand
+func planErrorComment(table string, err, otherErr error) string {
+	if err == nil {
+		err = otherErr
+	}
+
+	return fmt.Sprintf("-- TODO: %s: %s", table, err)
+}
+
+// sortTablesByReferences orders tables by name, except that referenced tables come first
+func sortTablesByReferences(tables []SchemaTable) []SchemaTable {
+	sorted := make([]SchemaTable, len(tables))
+	copy(sorted, tables)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	byName := map[string]SchemaTable{}
+	for _, table := range sorted {
+		byName[table.Name] = table
+	}
+
+	out := make([]SchemaTable, 0, len(sorted))
+	visited := map[string]bool{}
+	var visit func(table SchemaTable)
+	visit = func(table SchemaTable) {
+		if visited[table.Name] {
+			return
+		}
+		visited[table.Name] = true
+
+		// cycles are broken by ignoring references to tables already visited
+		for _, ref := range table.References {
+			if refTable, ok := byName[ref]; ok {
+				visit(refTable)
+			}
+		}
+
+		out = append(out, table)
+	}
+	for _, table := range sorted {
+		visit(table)
+	}
+
+	return out
+}
+
+// quoteIdentifier quotes a table or index name using standard SQL double quotes
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,214 @@
+package dbmate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrInvalidSchemaManifest is returned when the manifest in DB.SchemaDir lists an invalid file
+var ErrInvalidSchemaManifest = errors.New("invalid schema manifest")
+
+// SchemaManifest is the file in DB.SchemaDir which lists the schema files in load order
+const SchemaManifest = "manifest.txt"
+
+// schemaManifestHeader is written at the start of the manifest
+const schemaManifestHeader = "# Generated by dbmate. Schema files are loaded in this order.\n"
+
+var (
+	// pgObjectRegexp matches pg_dump block headers
+	pgObjectRegexp = regexp.MustCompile(`^(?:Data for )?Name: (.*); Type: (.*?); Schema: (.*?);`)
+	// mysqlObjectRegexp matches mysqldump block headers
+	mysqlObjectRegexp = regexp.MustCompile("^(?:(Temporary view|Final view|View|Table) structure for (?:table|view)|" +
+		"Dumping (data) for table|(\\w+)) `(.+)`$")
+	// dataObjectRegexp matches the header of table data in ClickHouse schema dumps
+	dataObjectRegexp = regexp.MustCompile(`^Data for table (.+)$`)
+	// schemaStatementRegexp matches the start of each object in schema dumps without block
+	// headers (such as SQLite, ClickHouse, and SQL Server), and the list of applied migrations
+	schemaStatementRegexp = regexp.MustCompile(`(?im)^(?:CREATE (?:OR (?:REPLACE|ALTER) )?` +
+		`(?:(?:UNIQUE|TEMP|TEMPORARY|MATERIALIZED|VIRTUAL|EXTERNAL|SNAPSHOT|CLUSTERED|NONCLUSTERED) )*` +
+		`(TABLE|INDEX|VIEW|TRIGGER|FUNCTION|PROCEDURE|SCHEMA|DICTIONARY|SEQUENCE|TYPE) ` +
+		"(?:IF NOT EXISTS )?((?:\"(?:[^\"]|\"\")*\"|`(?:[^`]|``)*`|\\[(?:[^\\]]|\\]\\])*\\]|[^\\s(\"`\\[]+)+)|" +
+		"-- (Dbmate schema migrations.*)$|-- Data for table (.+)$)")
+	// schemaPathRegexp matches characters which are replaced in schema file names
+	schemaPathRegexp = regexp.MustCompile(`[^a-z0-9_.-]+`)
+)
+
+// schemaObject is part of a schema dump, which is written to its own file in DB.SchemaDir
+type schemaObject struct {
+	// Dir is the object type, or empty for text which is not part of an object
+	Dir  string
+	Name string
+	Text []byte
+}
+
+// splitSchemaObjects splits a schema dump into objects. Concatenating the text of each
+// object returns the original schema, apart from blank lines and comments between objects.
+func splitSchemaObjects(schema []byte) []schemaObject {
+	preamble, blocks := dbutil.SplitSchemaBlocks(schema)
+
+	objects := splitSchemaStatements("preamble", preamble)
+	for _, block := range blocks {
+		if match := pgObjectRegexp.FindStringSubmatch(block.Header); match != nil {
+			name := match[1]
+			if match[3] != "-" {
+				name = match[3] + "." + name
+			}
+			objects = append(objects, schemaObject{Dir: match[2], Name: name, Text: block.Text})
+		} else if match := mysqlObjectRegexp.FindStringSubmatch(block.Header); match != nil {
+			dir := match[1] + match[2] + match[3]
+			if dir == "Final view" {
+				// mysqldump creates a temporary table for each view, which is replaced by the
+				// final view once all tables exist
+				dir = "View"
+			}
+			objects = append(objects, schemaObject{Dir: dir, Name: match[4], Text: block.Text})
+		} else if match := dataObjectRegexp.FindStringSubmatch(block.Header); match != nil {
+			objects = append(objects, schemaObject{Dir: "data", Name: match[1], Text: block.Text})
+		} else {
+			objects = append(objects, splitSchemaStatements(block.Header, block.Text)...)
+		}
+	}
+
+	return objects
+}
+
+// splitSchemaStatements splits text without block headers on each CREATE statement
+func splitSchemaStatements(name string, text []byte) []schemaObject {
+	objects := []schemaObject{}
+	matches := schemaStatementRegexp.FindAllSubmatchIndex(text, -1)
+
+	// text before the first statement, which is left out if it only contains comments
+	start := len(text)
+	if len(matches) > 0 {
+		start = matches[0][0]
+	}
+	if !isSQLComment(text[:start]) {
+		objects = append(objects, schemaObject{Name: name, Text: text[:start]})
+	}
+
+	for i, match := range matches {
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+
+		object := schemaObject{Text: text[match[0]:end]}
+		if match[6] >= 0 {
+			object.Name = string(text[match[6]:match[7]])
+		} else if match[8] >= 0 {
+			object.Dir = "data"
+			object.Name = string(text[match[8]:match[9]])
+		} else {
+			object.Dir = string(text[match[2]:match[3]])
+			object.Name = strings.NewReplacer("`", "", `"`, "", "[", "", "]", "").Replace(string(text[match[4]:match[5]]))
+		}
+		objects = append(objects, object)
+	}
+
+	return objects
+}
+
+// isSQLComment returns true if text only contains blank lines and sql comments
+func isSQLComment(text []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) > 0 && !bytes.HasPrefix(line, []byte("--")) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// schemaObjectPath returns the file name for an object, relative to DB.SchemaDir
+func schemaObjectPath(object schemaObject) string {
+	name := strings.Trim(schemaPathRegexp.ReplaceAllString(strings.ToLower(object.Name), "_"), "_.")
+	if name == "" {
+		name = "object"
+	}
+	if object.Dir == "" {
+		return name + ".sql"
+	}
+
+	dir := strings.Trim(schemaPathRegexp.ReplaceAllString(strings.ToLower(object.Dir), "_"), "_.")
+	return dir + "/" + name + ".sql"
+}
+
+// writeSchemaDir writes a schema dump to DB.SchemaDir, with one file per object.
+// Files listed in the previous manifest which are no longer part of the schema are removed.
+func (db *DB) writeSchemaDir(wfs WriteFS, schema []byte) error {
+	previous, err := db.readSchemaManifest()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var manifest strings.Builder
+	manifest.WriteString(schemaManifestHeader)
+	written := map[string]bool{}
+	for _, object := range splitSchemaObjects(schema) {
+		name := schemaObjectPath(object)
+		// objects with the same name, such as overloaded functions, are numbered in order
+		for i := 2; written[name]; i++ {
+			name = fmt.Sprintf("%s_%d.sql", strings.TrimSuffix(schemaObjectPath(object), ".sql"), i)
+		}
+		written[name] = true
+		manifest.WriteString(name + "\n")
+
+		filePath := filepath.Join(db.SchemaDir, filepath.FromSlash(name))
+		if err := ensureDir(wfs, filepath.Dir(filePath)); err != nil {
+			return err
+		}
+		if err := wfs.WriteFile(fsPath(filePath), object.Text, 0o644); err != nil {
+			return err
+		}
+	}
+
+	err = wfs.WriteFile(fsPath(filepath.Join(db.SchemaDir, SchemaManifest)), []byte(manifest.String()), 0o644)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range previous {
+		if !written[name] {
+			err := wfs.Remove(fsPath(filepath.Join(db.SchemaDir, filepath.FromSlash(name))))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readSchemaManifest returns the files listed in the manifest in DB.SchemaDir, in load order
+func (db *DB) readSchemaManifest() ([]string, error) {
+	manifestPath := filepath.Join(db.SchemaDir, SchemaManifest)
+	contents, err := fs.ReadFile(db.fs(), fsPath(manifestPath))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !fs.ValidPath(line) || path.Base(line) == SchemaManifest {
+			return nil, fmt.Errorf("%w `%s` line %d: %s", ErrInvalidSchemaManifest, manifestPath, i+1, line)
+		}
+		files = append(files, line)
+	}
+
+	return files, nil
+}
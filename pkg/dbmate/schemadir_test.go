@@ -0,0 +1,111 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitSchemaObjects(t *testing.T) {
+	paths := func(objects []schemaObject) []string {
+		result := []string{}
+		for _, object := range objects {
+			result = append(result, schemaObjectPath(object))
+		}
+		return result
+	}
+
+	t.Run("pg_dump", func(t *testing.T) {
+		schema := "SET statement_timeout = 0;\n\n" +
+			"--\n-- Name: add(integer, integer); Type: FUNCTION; Schema: public; Owner: -\n--\n\nCREATE FUNCTION public.add();\n\n\n" +
+			"--\n-- Name: Users; Type: TABLE; Schema: public; Owner: -\n--\n\nCREATE TABLE public.\"Users\" ();\n\n\n" +
+			"--\n-- Name: Users Users_pkey; Type: CONSTRAINT; Schema: public; Owner: -\n--\n\nALTER TABLE public.\"Users\";\n\n\n" +
+			"--\n-- Name: pgcrypto; Type: EXTENSION; Schema: -; Owner: -\n--\n\nCREATE EXTENSION pgcrypto;\n\n\n" +
+			"--\n-- PostgreSQL database dump complete\n--\n\n\n" +
+			"--\n-- Data for Name: countries; Type: TABLE DATA; Schema: public; Owner: -\n--\n\nINSERT INTO public.countries;\n" +
+			"--\n-- Dbmate schema migrations\n--\n\nINSERT INTO public.schema_migrations (version) VALUES\n    ('1');\n"
+
+		objects := splitSchemaObjects([]byte(schema))
+		require.Equal(t, []string{
+			"preamble.sql",
+			"function/public.add_integer_integer.sql",
+			"table/public.users.sql",
+			"constraint/public.users_users_pkey.sql",
+			"extension/pgcrypto.sql",
+			"table_data/public.countries.sql",
+			"dbmate_schema_migrations.sql",
+		}, paths(objects))
+		require.Equal(t, "--\n-- Name: pgcrypto; Type: EXTENSION; Schema: -; Owner: -\n--\n\nCREATE EXTENSION pgcrypto;\n\n\n",
+			string(objects[4].Text))
+	})
+
+	t.Run("mysqldump", func(t *testing.T) {
+		schema := "/*!40101 SET NAMES utf8mb4 */;\n\n" +
+			"--\n-- Table structure for table `users`\n--\n\nCREATE TABLE `users` ();\n\n" +
+			"--\n-- Temporary view structure for view `user_ids`\n--\n\nCREATE VIEW `user_ids` AS SELECT 1;\n\n" +
+			"--\n-- Function `add`\n--\n\nCREATE FUNCTION `add`();\n\n" +
+			"--\n-- Final view structure for view `user_ids`\n--\n\nCREATE VIEW `user_ids` AS SELECT id FROM users;\n" +
+			"--\n-- Dumping data for table `countries`\n--\n\nINSERT INTO `countries`;\n"
+
+		require.Equal(t, []string{
+			"preamble.sql",
+			"table/users.sql",
+			"temporary_view/user_ids.sql",
+			"function/add.sql",
+			"view/user_ids.sql",
+			"data/countries.sql",
+		}, paths(splitSchemaObjects([]byte(schema))))
+	})
+
+	t.Run("statements", func(t *testing.T) {
+		schema := "CREATE TABLE users (id integer);\n" +
+			"CREATE UNIQUE INDEX users_id ON users (id);\n" +
+			"CREATE VIEW IF NOT EXISTS \"user ids\" AS SELECT id FROM users;\n" +
+			"-- Data for table countries\n" +
+			"INSERT INTO \"countries\" (code) VALUES\n  ('AU');\n" +
+			"-- Dbmate schema migrations\n" +
+			"INSERT INTO \"schema_migrations\" (version) VALUES\n  ('1');\n"
+
+		objects := splitSchemaObjects([]byte(schema))
+		require.Equal(t, []string{
+			"table/users.sql",
+			"index/users_id.sql",
+			"view/user_ids.sql",
+			"data/countries.sql",
+			"dbmate_schema_migrations.sql",
+		}, paths(objects))
+		require.Equal(t, "CREATE UNIQUE INDEX users_id ON users (id);\n", string(objects[1].Text))
+	})
+
+	t.Run("sql server", func(t *testing.T) {
+		schema := "CREATE SCHEMA [app];\nGO\n\n" +
+			"CREATE TABLE [app].[user ids] (\n    [id] int NOT NULL\n);\nGO\n\n" +
+			"CREATE NONCLUSTERED INDEX [ix_id] ON [app].[user ids] ([id]);\nGO\n\n" +
+			"create or alter procedure app.add_user as select 1\nGO\n\n" +
+			"-- Dbmate schema migrations\n" +
+			"INSERT INTO [dbo].[schema_migrations] (version) VALUES\n  (N'1');\nGO\n"
+
+		objects := splitSchemaObjects([]byte(schema))
+		require.Equal(t, []string{
+			"schema/app.sql",
+			"table/app.user_ids.sql",
+			"index/ix_id.sql",
+			"procedure/app.add_user.sql",
+			"dbmate_schema_migrations.sql",
+		}, paths(objects))
+		require.Equal(t, "CREATE NONCLUSTERED INDEX [ix_id] ON [app].[user ids] ([id]);\nGO\n\n", string(objects[2].Text))
+	})
+
+	t.Run("clickhouse", func(t *testing.T) {
+		schema := "\n--\n-- Database schema\n--\n\n" +
+			"CREATE DATABASE IF NOT EXISTS test_db;\n\n" +
+			"CREATE TABLE test_db.users (id Int32) ENGINE = MergeTree ORDER BY id;\n\n" +
+			"\n--\n-- Data for table countries\n--\n\nINSERT INTO test_db.countries (code) VALUES\n    ('AU');\n"
+
+		require.Equal(t, []string{
+			"database_schema.sql",
+			"table/test_db.users.sql",
+			"data/countries.sql",
+		}, paths(splitSchemaObjects([]byte(schema))))
+	})
+}
@@ -0,0 +1,331 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version scheme errors
+var (
+	ErrUnsupportedVersionScheme = errors.New("unsupported version scheme")
+	ErrAmbiguousVersion         = errors.New("ambiguous migration version")
+)
+
+// VersionScheme defines how migration versions are parsed from file names,
+// ordered, and generated for new migrations
+type VersionScheme interface {
+	// Parse returns the version of a migration file, or false if the file is not a migration
+	Parse(fileName string) (string, bool)
+	// Compare returns a negative number, zero, or a positive number if version a
+	// is lower than, equal to, or higher than version b
+	Compare(a, b string) int
+	// Next returns the version for a new migration, given the existing versions
+	Next(existing []string, now time.Time) string
+	// FileName returns the file name for a new migration
+	FileName(version, name string) string
+}
+
+var versionSchemes = map[string]VersionScheme{}
+
+// RegisterVersionScheme registers a version scheme under a name
+func RegisterVersionScheme(scheme VersionScheme, name string) {
+	versionSchemes[name] = scheme
+}
+
+// patternSchemePrefix selects a PatternScheme in GetVersionScheme, e.g. "pattern:R{version}__{name}.sql"
+const patternSchemePrefix = "pattern:"
+
+// GetVersionScheme returns the version scheme registered under a name, or a PatternScheme
+// if the name is a template prefixed with "pattern:"
+func GetVersionScheme(name string) (VersionScheme, error) {
+	if template, ok := strings.CutPrefix(name, patternSchemePrefix); ok {
+		return NewPatternScheme(template)
+	}
+
+	scheme := versionSchemes[name]
+	if scheme == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersionScheme, name)
+	}
+
+	return scheme, nil
+}
+
+func init() {
+	RegisterVersionScheme(TimestampScheme{}, "timestamp")
+	RegisterVersionScheme(SequentialScheme{Width: 4}, "sequential")
+	RegisterVersionScheme(FlywayScheme{}, "flyway")
+}
+
+// TimestampScheme is the default version scheme. New migrations are versioned with the
+// current UTC time (e.g. 20151127184807_name.sql), and versions are compared as strings.
+type TimestampScheme struct{}
+
+// Parse returns all leading digits of a migration file name as its version
+func (TimestampScheme) Parse(fileName string) (string, bool) {
+	matches := migrationFileRegexp.FindStringSubmatch(fileName)
+	if len(matches) < 2 {
+		return "", false
+	}
+
+	return matches[1], true
+}
+
+// Compare compares versions as strings
+func (TimestampScheme) Compare(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+// Next returns the current time as a version
+func (TimestampScheme) Next(_ []string, now time.Time) string {
+	return now.UTC().Format("20060102150405")
+}
+
+// FileName returns a file name in the format version_name.sql
+func (TimestampScheme) FileName(version, name string) string {
+	return fmt.Sprintf("%s_%s.sql", version, name)
+}
+
+// SequentialScheme versions migrations with zero-padded sequential integers (e.g. 0001_name.sql)
+type SequentialScheme struct {
+	// Width is the minimum number of digits in new versions
+	Width int
+}
+
+// Parse returns all leading digits of a migration file name as its version
+func (SequentialScheme) Parse(fileName string) (string, bool) {
+	return TimestampScheme{}.Parse(fileName)
+}
+
+// Compare compares versions numerically, ignoring zero padding
+func (SequentialScheme) Compare(a, b string) int {
+	return compareNumeric(a, b)
+}
+
+// Next returns the highest existing version plus one, padded to the width of the
+// highest existing version (or the scheme width, whichever is greater)
+func (s SequentialScheme) Next(existing []string, _ time.Time) string {
+	highest := ""
+	for _, version := range existing {
+		if highest == "" || compareNumeric(version, highest) > 0 {
+			highest = version
+		}
+	}
+
+	width := s.Width
+	if len(highest) > width {
+		width = len(highest)
+	}
+
+	next := 1
+	if highest != "" {
+		n, err := strconv.Atoi(highest)
+		if err == nil {
+			next = n + 1
+		}
+	}
+
+	return fmt.Sprintf("%0*d", width, next)
+}
+
+// FileName returns a file name in the format version_name.sql
+func (SequentialScheme) FileName(version, name string) string {
+	return fmt.Sprintf("%s_%s.sql", version, name)
+}
+
+// flywayFileRegexp matches Flyway style migration file names, e.g. V1_2_3__name.sql
+var flywayFileRegexp = regexp.MustCompile(`^V(\d+(?:[._]\d+)*)__.*\.sql$`)
+
+// FlywayScheme versions migrations in the style of Flyway (e.g. V1_2_3__name.sql).
+// Versions are recorded with dots (e.g. 1.2.3) and compared numerically part by part.
+type FlywayScheme struct{}
+
+// Parse returns the dotted version of a Flyway style migration file name
+func (FlywayScheme) Parse(fileName string) (string, bool) {
+	matches := flywayFileRegexp.FindStringSubmatch(fileName)
+	if len(matches) < 2 {
+		return "", false
+	}
+
+	return strings.ReplaceAll(matches[1], "_", "."), true
+}
+
+// Compare compares dotted versions numerically, part by part, so that 1.10 > 1.9
+func (FlywayScheme) Compare(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := "0", "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		if c := compareNumeric(aPart, bPart); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// Next increments the last part of the highest existing version, or returns 1
+func (s FlywayScheme) Next(existing []string, _ time.Time) string {
+	highest := ""
+	for _, version := range existing {
+		if highest == "" || s.Compare(version, highest) > 0 {
+			highest = version
+		}
+	}
+
+	if highest == "" {
+		return "1"
+	}
+
+	parts := strings.Split(highest, ".")
+	last, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return highest + ".1"
+	}
+	parts[len(parts)-1] = strconv.Itoa(last + 1)
+
+	return strings.Join(parts, ".")
+}
+
+// FileName returns a file name in the format Vversion__name.sql, with dots replaced by underscores
+func (FlywayScheme) FileName(version, name string) string {
+	return fmt.Sprintf("V%s__%s.sql", strings.ReplaceAll(version, ".", "_"), name)
+}
+
+// PatternScheme versions migrations with file names in a custom format, given as a template
+// containing {version} and {name} (e.g. R{version}__{name}.sql). Versions are numbers with
+// optional dotted parts (e.g. 12 or 1.2), which are compared numerically part by part.
+type PatternScheme struct {
+	template string
+	regexp   *regexp.Regexp
+}
+
+// NewPatternScheme returns a PatternScheme for a file name template, which must contain
+// {version} and {name} exactly once
+func NewPatternScheme(template string) (PatternScheme, error) {
+	if strings.Count(template, "{version}") != 1 || strings.Count(template, "{name}") != 1 {
+		return PatternScheme{}, fmt.Errorf("%w: %s%s (the pattern must contain {version} and {name} once)",
+			ErrUnsupportedVersionScheme, patternSchemePrefix, template)
+	}
+
+	pattern := regexp.QuoteMeta(template)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{version}"), `(\d+(?:\.\d+)*)`, 1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{name}"), `.*`, 1)
+
+	return PatternScheme{template: template, regexp: regexp.MustCompile("^" + pattern + "$")}, nil
+}
+
+// Parse returns the version of a file name which matches the template
+func (s PatternScheme) Parse(fileName string) (string, bool) {
+	if s.regexp == nil {
+		return "", false
+	}
+
+	matches := s.regexp.FindStringSubmatch(fileName)
+	if len(matches) < 2 {
+		return "", false
+	}
+
+	return matches[1], true
+}
+
+// Compare compares dotted versions numerically, part by part
+func (PatternScheme) Compare(a, b string) int {
+	return FlywayScheme{}.Compare(a, b)
+}
+
+// Next increments the last part of the highest existing version, keeping its zero padding,
+// or returns 1
+func (s PatternScheme) Next(existing []string, _ time.Time) string {
+	highest := ""
+	for _, version := range existing {
+		if highest == "" || s.Compare(version, highest) > 0 {
+			highest = version
+		}
+	}
+
+	if highest == "" {
+		return "1"
+	}
+
+	parts := strings.Split(highest, ".")
+	last := parts[len(parts)-1]
+	n, err := strconv.Atoi(last)
+	if err != nil {
+		return highest + ".1"
+	}
+	parts[len(parts)-1] = fmt.Sprintf("%0*d", len(last), n+1)
+
+	return strings.Join(parts, ".")
+}
+
+// FileName returns the template with the version and name filled in
+func (s PatternScheme) FileName(version, name string) string {
+	return strings.NewReplacer("{version}", version, "{name}", name).Replace(s.template)
+}
+
+// compareNumeric compares two strings of digits numerically, ignoring leading zeros
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+
+	return strings.Compare(a, b)
+}
+
+// sortVersions sorts versions in the order of a version scheme. Versions which the scheme
+// considers equal are sorted as strings.
+func sortVersions(scheme VersionScheme, versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		if c := scheme.Compare(versions[i], versions[j]); c != 0 {
+			return c < 0
+		}
+		return versions[i] < versions[j]
+	})
+}
+
+// lookupVersion returns the value recorded for a version, or for another version which is equal
+// to it under a version scheme (such as 0001 for 1 in the sequential scheme)
+func lookupVersion[T any](scheme VersionScheme, versions map[string]T, version string) (T, bool) {
+	if value, ok := versions[version]; ok {
+		return value, true
+	}
+
+	// ambiguous versions are rejected, so at most one version matches
+	for other, value := range versions {
+		if scheme.Compare(other, version) == 0 {
+			return value, true
+		}
+	}
+
+	var zero T
+	return zero, false
+}
+
+// checkAmbiguousVersions returns ErrAmbiguousVersion if two distinct versions are equal under
+// a version scheme (such as 001 and 0001 in the sequential scheme). Versions are recorded as
+// written, so the same migration would otherwise be reported as both applied and pending.
+func checkAmbiguousVersions(scheme VersionScheme, versions []string) error {
+	sorted := append([]string{}, versions...)
+	sortVersions(scheme, sorted)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] != sorted[i-1] && scheme.Compare(sorted[i], sorted[i-1]) == 0 {
+			return fmt.Errorf("%w: `%s` and `%s` are the same version, rename the migration file to match the recorded version",
+				ErrAmbiguousVersion, sorted[i-1], sorted[i])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package dbmate_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetVersionScheme(t *testing.T) {
+	scheme, err := dbmate.GetVersionScheme("sequential")
+	require.NoError(t, err)
+	require.Equal(t, dbmate.SequentialScheme{Width: 4}, scheme)
+
+	scheme, err = dbmate.GetVersionScheme("foo")
+	require.ErrorIs(t, err, dbmate.ErrUnsupportedVersionScheme)
+	require.EqualError(t, err, "unsupported version scheme: foo")
+	require.Nil(t, scheme)
+}
+
+func TestTimestampScheme(t *testing.T) {
+	scheme := dbmate.TimestampScheme{}
+
+	version, ok := scheme.Parse("20151127184807_create_users.sql")
+	require.True(t, ok)
+	require.Equal(t, "20151127184807", version)
+
+	_, ok = scheme.Parse("create_users.sql")
+	require.False(t, ok)
+	_, ok = scheme.Parse("20151127184807_create_users.txt")
+	require.False(t, ok)
+
+	require.Less(t, scheme.Compare("20151127184807", "20151127184808"), 0)
+	require.Equal(t, 0, scheme.Compare("001", "001"))
+
+	now := time.Date(2015, 11, 27, 18, 48, 7, 0, time.UTC)
+	require.Equal(t, "20151127184807", scheme.Next([]string{"99999999999999"}, now))
+	require.Equal(t, "20151127184807_create_users.sql", scheme.FileName("20151127184807", "create_users"))
+}
+
+func TestSequentialScheme(t *testing.T) {
+	scheme := dbmate.SequentialScheme{Width: 4}
+
+	version, ok := scheme.Parse("0012_create_users.sql")
+	require.True(t, ok)
+	require.Equal(t, "0012", version)
+
+	// versions are compared numerically
+	require.Less(t, scheme.Compare("9", "10"), 0)
+	require.Equal(t, 0, scheme.Compare("0012", "12"))
+	require.Greater(t, scheme.Compare("0100", "99"), 0)
+
+	require.Equal(t, "0001", scheme.Next(nil, time.Now()))
+	require.Equal(t, "0010", scheme.Next([]string{"0002", "0009", "0003"}, time.Now()))
+	require.Equal(t, "100000", scheme.Next([]string{"99999"}, time.Now()))
+	require.Equal(t, "0002_create_users.sql", scheme.FileName("0002", "create_users"))
+}
+
+func TestFlywayScheme(t *testing.T) {
+	scheme := dbmate.FlywayScheme{}
+
+	version, ok := scheme.Parse("V1_2_3__create_users.sql")
+	require.True(t, ok)
+	require.Equal(t, "1.2.3", version)
+
+	version, ok = scheme.Parse("V2.1__create_posts.sql")
+	require.True(t, ok)
+	require.Equal(t, "2.1", version)
+
+	_, ok = scheme.Parse("001_create_users.sql")
+	require.False(t, ok)
+	_, ok = scheme.Parse("V1_create_users.sql")
+	require.False(t, ok)
+
+	require.Less(t, scheme.Compare("1.9", "1.10"), 0)
+	require.Equal(t, 0, scheme.Compare("1.0", "1"))
+	require.Greater(t, scheme.Compare("2", "1.10.4"), 0)
+
+	require.Equal(t, "1", scheme.Next(nil, time.Now()))
+	require.Equal(t, "1.10", scheme.Next([]string{"1.2", "1.9", "1.1"}, time.Now()))
+	require.Equal(t, "V1_10__create_users.sql", scheme.FileName("1.10", "create_users"))
+}
+
+func TestPatternScheme(t *testing.T) {
+	scheme, err := dbmate.GetVersionScheme("pattern:R{version}__{name}.sql")
+	require.NoError(t, err)
+
+	version, ok := scheme.Parse("R12__create_users.sql")
+	require.True(t, ok)
+	require.Equal(t, "12", version)
+
+	version, ok = scheme.Parse("R1.2__create_posts.sql")
+	require.True(t, ok)
+	require.Equal(t, "1.2", version)
+
+	_, ok = scheme.Parse("12__create_users.sql")
+	require.False(t, ok)
+	_, ok = scheme.Parse("R12_create_users.sql")
+	require.False(t, ok)
+	_, ok = scheme.Parse("R12__create_users.sql.bak")
+	require.False(t, ok)
+
+	require.Less(t, scheme.Compare("9", "10"), 0)
+	require.Less(t, scheme.Compare("1.9", "1.10"), 0)
+
+	require.Equal(t, "1", scheme.Next(nil, time.Now()))
+	require.Equal(t, "0010", scheme.Next([]string{"0002", "0009"}, time.Now()))
+	require.Equal(t, "1.10", scheme.Next([]string{"1.2", "1.9"}, time.Now()))
+	require.Equal(t, "R0010__create_users.sql", scheme.FileName("0010", "create_users"))
+
+	_, err = dbmate.GetVersionScheme("pattern:R{version}.sql")
+	require.ErrorIs(t, err, dbmate.ErrUnsupportedVersionScheme)
+	require.EqualError(t, err, "unsupported version scheme: pattern:R{version}.sql "+
+		"(the pattern must contain {version} and {name} once)")
+}
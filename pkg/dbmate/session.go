@@ -0,0 +1,67 @@
+package dbmate
+
+import (
+	"errors"
+)
+
+// ErrNotSQLDriver is returned by DB.Driver when the driver does not use database/sql
+var ErrNotSQLDriver = errors.New("driver does not support database/sql")
+
+// SessionDriver provides top level database functions without depending on database/sql.
+// DB manages databases through a SessionDriver: drivers for stores which have their own
+// clients (such as CQL or document databases) implement it directly, and drivers which
+// implement Driver are adapted to it.
+type SessionDriver interface {
+	DatabaseExists() (bool, error)
+	CreateDatabase() error
+	DropDatabase() error
+	Ping() error
+	OpenSession() (Session, error)
+}
+
+// Session is an open connection to a database, which applies migrations and records the
+// migrations which have been applied. Migrations tables are named by the caller, since
+// namespaces may be recorded in separate tables.
+type Session interface {
+	Close() error
+	DumpSchema(...string) ([]byte, error)
+	MigrationsTableExists(table string) (bool, error)
+	CreateMigrationsTable(table string) error
+	SelectMigrations(table string, limit int) (map[string]bool, error)
+	// Run calls fn with an Executor which uses a single connection. If transaction is true,
+	// the changes made by fn are committed if it returns nil, and rolled back otherwise.
+	Run(transaction bool, fn func(Executor) error) error
+}
+
+// SessionMigrationsDumper is implemented by sessions which can dump the versions recorded in a
+// migrations table, in the same format as DumpSchema. Sessions of drivers which use database/sql
+// support it if the driver is a MigrationsDumper.
+type SessionMigrationsDumper interface {
+	DumpMigrations(table string) ([]byte, error)
+}
+
+// Executor runs migrations and records them, either inside or outside of a transaction
+type Executor interface {
+	// Exec runs a script, such as a migration section or a statement from a schema file.
+	// Errors are normalized in the same way as Driver.QueryError.
+	Exec(script string) (Result, error)
+	InsertMigration(table, version string) error
+	DeleteMigration(table, version string) error
+}
+
+// Result describes the effect of a script, for verbose output. It may be nil for drivers
+// which do not report results. sql.Result implements Result.
+type Result interface {
+	LastInsertId() (int64, error)
+	RowsAffected() (int64, error)
+}
+
+// SessionDriverFunc represents a session driver constructor
+type SessionDriverFunc func(DriverConfig) SessionDriver
+
+var sessionDrivers = map[string]SessionDriverFunc{}
+
+// RegisterSessionDriver registers a session driver constructor for a given URL scheme
+func RegisterSessionDriver(f SessionDriverFunc, scheme string) {
+	sessionDrivers[scheme] = f
+}
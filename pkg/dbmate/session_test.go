@@ -0,0 +1,457 @@
+package dbmate_test
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbtest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a database for memDriver, which records the scripts it has run
+type memStore struct {
+	exists  bool
+	scripts []string
+	tables  map[string]map[string]bool
+	// sessions counts the sessions which have been opened
+	sessions int
+}
+
+func (s *memStore) clone() *memStore {
+	clone := &memStore{
+		exists:   s.exists,
+		scripts:  slices.Clone(s.scripts),
+		tables:   map[string]map[string]bool{},
+		sessions: s.sessions,
+	}
+	for table, versions := range s.tables {
+		clone.tables[table] = maps.Clone(versions)
+	}
+
+	return clone
+}
+
+var testMemStore *memStore
+
+// testNoticeHandler is set by memCapableDriver, and called by scripts starting with "notice"
+var testNoticeHandler func(string)
+
+// memDriver is a SessionDriver which does not use database/sql
+type memDriver struct{}
+
+func init() {
+	dbmate.RegisterSessionDriver(func(dbmate.DriverConfig) dbmate.SessionDriver {
+		return memDriver{}
+	}, "memstore")
+}
+
+func (memDriver) DatabaseExists() (bool, error) { return testMemStore.exists, nil }
+func (memDriver) CreateDatabase() error         { testMemStore.exists = true; return nil }
+func (memDriver) DropDatabase() error           { testMemStore.exists = false; return nil }
+func (memDriver) Ping() error                   { return nil }
+
+func (memDriver) OpenSession() (dbmate.Session, error) {
+	if !testMemStore.exists {
+		return nil, errors.New("store does not exist")
+	}
+	testMemStore.sessions++
+
+	return memSession{}, nil
+}
+
+type memSession struct{}
+
+func (memSession) Close() error { return nil }
+
+func (memSession) DumpSchema(...string) ([]byte, error) {
+	return []byte(strings.Join(testMemStore.scripts, "\n") + "\n"), nil
+}
+
+func (memSession) MigrationsTableExists(table string) (bool, error) {
+	_, ok := testMemStore.tables[table]
+	return ok, nil
+}
+
+func (memSession) CreateMigrationsTable(table string) error {
+	if testMemStore.tables[table] == nil {
+		testMemStore.tables[table] = map[string]bool{}
+	}
+
+	return nil
+}
+
+func (memSession) SelectMigrations(table string, _ int) (map[string]bool, error) {
+	return maps.Clone(testMemStore.tables[table]), nil
+}
+
+// Run applies the changes made in a transaction only if fn succeeds
+func (memSession) Run(transaction bool, fn func(dbmate.Executor) error) error {
+	if !transaction {
+		return fn(memExecutor{store: testMemStore})
+	}
+
+	tx := testMemStore.clone()
+	if err := fn(memExecutor{store: tx}); err != nil {
+		return err
+	}
+	*testMemStore = *tx
+
+	return nil
+}
+
+type memExecutor struct {
+	store *memStore
+}
+
+func (e memExecutor) Exec(script string) (dbmate.Result, error) {
+	// migration sections start with their migrate directive
+	lines := slices.DeleteFunc(strings.Split(script, "\n"), func(line string) bool {
+		return strings.HasPrefix(line, "--")
+	})
+	script = strings.TrimSpace(strings.Join(lines, "\n"))
+	if strings.Contains(script, "fail") {
+		return nil, errors.New("script failed")
+	}
+	if notice, ok := strings.CutPrefix(script, "notice "); ok && testNoticeHandler != nil {
+		testNoticeHandler(notice)
+	}
+	e.store.scripts = append(e.store.scripts, script)
+
+	return nil, nil
+}
+
+func (e memExecutor) InsertMigration(table, version string) error {
+	e.store.tables[table][version] = true
+	return nil
+}
+
+func (e memExecutor) DeleteMigration(table, version string) error {
+	delete(e.store.tables[table], version)
+	return nil
+}
+
+func newMemStoreDB(t *testing.T, files fstest.MapFS) *dbmate.DB {
+	testMemStore = &memStore{tables: map[string]map[string]bool{}}
+
+	db := dbmate.New(dbtest.MustParseURL(t, "memstore://test"))
+	db.AutoDumpSchema = false
+	db.Log = &strings.Builder{}
+	db.FS = files
+
+	return db
+}
+
+func TestSessionDriverMigrate(t *testing.T) {
+	db := newMemStoreDB(t, fstest.MapFS{
+		"db/migrations/001_users.sql": {Data: []byte("-- migrate:up\ncreate users\n-- migrate:down\ndrop users\n")},
+		"db/migrations/002_posts.sql": {Data: []byte("-- migrate:up transaction:false\ncreate posts\n" +
+			"-- migrate:down transaction:false\ndrop posts\n")},
+	})
+
+	err := db.CreateAndMigrate()
+	require.NoError(t, err)
+	require.Equal(t, []string{"create users", "create posts"}, testMemStore.scripts)
+	require.Equal(t, map[string]bool{"001": true, "002": true}, testMemStore.tables["schema_migrations"])
+	require.Equal(t, 1, testMemStore.sessions)
+
+	results, err := db.StatusResults()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.True(t, results[0].Applied)
+	require.True(t, results[1].Applied)
+
+	// rollback finds the latest migration with the session it rolls back in
+	testMemStore.sessions = 0
+	err = db.Rollback()
+	require.NoError(t, err)
+	require.Equal(t, []string{"create users", "create posts", "drop posts"}, testMemStore.scripts)
+	require.Equal(t, map[string]bool{"001": true}, testMemStore.tables["schema_migrations"])
+	require.Equal(t, 1, testMemStore.sessions)
+}
+
+func TestSessionDriverTransaction(t *testing.T) {
+	db := newMemStoreDB(t, fstest.MapFS{
+		"db/migrations/001_users.sql": {Data: []byte("-- migrate:up\ncreate users\n-- migrate:down\n")},
+		"db/migrations/002_fail.sql":  {Data: []byte("-- migrate:up\nfail\n-- migrate:down\n")},
+	})
+
+	err := db.CreateAndMigrate()
+	require.EqualError(t, err, "script failed")
+
+	// the failed migration is not recorded
+	require.Equal(t, []string{"create users"}, testMemStore.scripts)
+	require.Equal(t, map[string]bool{"001": true}, testMemStore.tables["schema_migrations"])
+}
+
+func TestSessionDriverNamespaceTables(t *testing.T) {
+	db := newMemStoreDB(t, fstest.MapFS{
+		"db/migrations/001_users.sql":    {Data: []byte("-- migrate:up\ncreate users\n-- migrate:down\n")},
+		"db/billing/001_invoices.sql":    {Data: []byte("-- migrate:up\ncreate invoices\n-- migrate:down\n")},
+		"db/billing/002_invoice_ids.sql": {Data: []byte("-- migrate:up\ncreate invoice_ids\n-- migrate:down\n")},
+	})
+	db.MigrationsDir = []string{"./db/migrations", "billing=./db/billing"}
+	db.NamespaceTables = true
+
+	err := db.CreateAndMigrate()
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"001": true}, testMemStore.tables["schema_migrations"])
+	require.Equal(t, map[string]bool{"001": true, "002": true}, testMemStore.tables["schema_migrations_billing"])
+}
+
+func TestSessionDriverLoadSchema(t *testing.T) {
+	db := newMemStoreDB(t, fstest.MapFS{
+		"db/schema.sql": {Data: []byte("create users;\ncreate posts;\n")},
+	})
+	testMemStore.exists = true
+
+	err := db.LoadSchema()
+	require.NoError(t, err)
+	require.Equal(t, []string{"create users", "create posts"}, testMemStore.scripts)
+
+	db.FS = fstest.MapFS{
+		"db/schema.sql": {Data: []byte("create comments;\nfail;\n")},
+	}
+	db.LoadTransaction = true
+	err = db.LoadSchema()
+	require.EqualError(t, err, "./db/schema.sql line 2: script failed")
+	require.Equal(t, []string{"create users", "create posts"}, testMemStore.scripts)
+}
+
+func TestSessionDriverNotSQLDriver(t *testing.T) {
+	db := newMemStoreDB(t, fstest.MapFS{})
+
+	_, err := db.Driver()
+	require.ErrorIs(t, err, dbmate.ErrNotSQLDriver)
+
+	drv, err := db.SessionDriver()
+	require.NoError(t, err)
+	require.IsType(t, memDriver{}, drv)
+}
+
+func TestSQLSessionDriver(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	drv, err := db.SessionDriver()
+	require.NoError(t, err)
+
+	// database/sql drivers are adapted, and keep their optional interfaces
+	_, ok := drv.(dbmate.StatementSplitter)
+	require.True(t, ok)
+
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.Create()
+	require.NoError(t, err)
+
+	session, err := drv.OpenSession()
+	require.NoError(t, err)
+	defer func() { require.NoError(t, session.Close()) }()
+
+	err = session.CreateMigrationsTable("schema_migrations")
+	require.NoError(t, err)
+	exists, err := session.MigrationsTableExists("schema_migrations")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	err = session.Run(true, func(ex dbmate.Executor) error {
+		if _, err := ex.Exec("create table users (id integer)"); err != nil {
+			return err
+		}
+		return ex.InsertMigration("schema_migrations", "001")
+	})
+	require.NoError(t, err)
+
+	err = session.Run(true, func(ex dbmate.Executor) error {
+		if err := ex.InsertMigration("schema_migrations", "002"); err != nil {
+			return err
+		}
+		_, err := ex.Exec("select * from missing")
+		return err
+	})
+	var queryErr *dbmate.QueryError
+	require.ErrorAs(t, err, &queryErr)
+	require.Equal(t, "select * from missing", queryErr.Query)
+
+	migrations, err := session.SelectMigrations("schema_migrations", -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"001": true}, migrations)
+
+	// statements outside of a transaction share a connection
+	err = session.Run(false, func(ex dbmate.Executor) error {
+		if _, err := ex.Exec("create temp table scratch (id integer)"); err != nil {
+			return err
+		}
+		_, err := ex.Exec("insert into scratch values (1)")
+		return err
+	})
+	require.NoError(t, err)
+}
+
+// memCapableDriver is a memDriver which implements the optional driver interfaces
+type memCapableDriver struct {
+	memDriver
+	locks *[]string
+}
+
+func init() {
+	dbmate.RegisterSessionDriver(func(dbmate.DriverConfig) dbmate.SessionDriver {
+		return memCapableDriver{locks: &testLocks}
+	}, "memstore-capable")
+}
+
+// testLocks records when memCapableDriver locks and unlocks the store
+var testLocks []string
+
+type memLock struct {
+	locks *[]string
+}
+
+func (l memLock) Close() error {
+	*l.locks = append(*l.locks, "unlock")
+	return nil
+}
+
+func (drv memCapableDriver) Lock() (io.Closer, error) {
+	*drv.locks = append(*drv.locks, "lock")
+	return memLock{locks: drv.locks}, nil
+}
+
+// SessionSettingsSQL returns scripts which record the settings, and an error for an invalid role
+func (memCapableDriver) SessionSettingsSQL(role, searchPath string) (string, string, error) {
+	if role == "invalid" {
+		return "", "", errors.New("invalid role")
+	}
+
+	return "set " + role + " " + searchPath, "reset", nil
+}
+
+func (memCapableDriver) TransactionalDDL() bool           { return false }
+func (memCapableDriver) ServerVersion() (string, error)   { return "1.2.3", nil }
+func (memCapableDriver) SetNoticeHandler(fn func(string)) { testNoticeHandler = fn }
+
+// LoadSchema records the whole schema file as a single script
+func (memCapableDriver) LoadSchema(r io.Reader) error {
+	schema, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	testMemStore.scripts = append(testMemStore.scripts, strings.TrimSpace(string(schema)))
+
+	return nil
+}
+
+func newCapableMemStoreDB(t *testing.T, files fstest.MapFS) (*dbmate.DB, *strings.Builder) {
+	db := newMemStoreDB(t, files)
+	db.DatabaseURL = dbtest.MustParseURL(t, "memstore-capable://test")
+	log := &strings.Builder{}
+	db.Log = log
+	testLocks = nil
+	testNoticeHandler = nil
+
+	return db, log
+}
+
+func TestDriverCapabilitiesMigrate(t *testing.T) {
+	db, log := newCapableMemStoreDB(t, fstest.MapFS{
+		"db/migrations/001_users.sql": {Data: []byte("-- migrate:up transaction:true\ncreate users\n" +
+			"-- migrate:down\ndrop users\n")},
+		"db/migrations/002_notice.sql": {Data: []byte("-- migrate:up\nnotice hello\n-- migrate:down\n")},
+	})
+	db.Verbose = true
+
+	err := db.CreateAndMigrate()
+	require.NoError(t, err)
+	require.Equal(t, []string{"lock", "unlock"}, testLocks)
+	require.Contains(t, log.String(), "Server version: 1.2.3\n")
+	require.Contains(t, log.String(), "Warning: 001_users.sql requests transaction:true, "+
+		"but memstore-capable does not roll back schema changes in transactions\n")
+	require.Contains(t, log.String(), "Applying: 002_notice.sql\nhello\n")
+
+	// notices raised outside of migrations are not printed
+	testNoticeHandler("ignored")
+	require.NotContains(t, log.String(), "ignored")
+
+	err = db.Rollback()
+	require.NoError(t, err)
+	require.Equal(t, []string{"lock", "unlock", "lock", "unlock"}, testLocks)
+}
+
+func TestDriverCapabilitiesNoLock(t *testing.T) {
+	db, _ := newCapableMemStoreDB(t, fstest.MapFS{
+		"db/migrations/001_users.sql": {Data: []byte("-- migrate:up\ncreate users\n-- migrate:down\n")},
+	})
+	db.NoLock = true
+
+	err := db.CreateAndMigrate()
+	require.NoError(t, err)
+	require.Empty(t, testLocks)
+}
+
+func TestDriverCapabilitiesLoadSchema(t *testing.T) {
+	db, log := newCapableMemStoreDB(t, fstest.MapFS{
+		"db/schema.sql": {Data: []byte("create users;\ncreate posts;\n")},
+	})
+	testMemStore.exists = true
+	db.LoadTransaction = true
+
+	err := db.LoadSchema()
+	require.NoError(t, err)
+	require.Equal(t, []string{"create users;\ncreate posts;"}, testMemStore.scripts)
+	require.Contains(t, log.String(), "Warning: memstore-capable loads schema files itself, and does not support --transaction\n")
+	require.Contains(t, log.String(), "Loaded: 1 files in ")
+}
+
+func TestSQLSessionDriverCapabilities(t *testing.T) {
+	db := newTestDB(t, sqliteTestURL(t))
+	log := &strings.Builder{}
+	db.Log = log
+	db.Verbose = true
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+
+	// optional interfaces are discovered on database/sql drivers
+	require.Regexp(t, `Server version: 3\.\d+\.\d+\n`, log.String())
+}
+
+func TestSessionSettings(t *testing.T) {
+	db, _ := newCapableMemStoreDB(t, fstest.MapFS{
+		"db/migrations/001_users.sql": {Data: []byte("-- migrate:up role:owner search_path:app\ncreate users\n" +
+			"-- migrate:down role:owner\ndrop users\n")},
+		"db/migrations/002_fail.sql": {Data: []byte("-- migrate:up role:owner transaction:false\nfail\n-- migrate:down\n")},
+	})
+
+	err := db.CreateAndMigrate()
+	require.EqualError(t, err, "script failed")
+
+	// settings are switched back after a section fails
+	require.Equal(t, []string{"set owner app", "create users", "reset", "set owner", "reset"}, testMemStore.scripts)
+
+	db.FS = fstest.MapFS{
+		"db/migrations/001_users.sql": {Data: []byte("-- migrate:up role:invalid\ncreate users\n-- migrate:down\n")},
+	}
+	testMemStore = &memStore{exists: true, tables: map[string]map[string]bool{}}
+	err = db.Migrate()
+	require.ErrorIs(t, err, dbmate.ErrUnsupportedOption)
+	require.EqualError(t, err, "migration option is not supported by this driver: invalid role")
+	require.Empty(t, testMemStore.scripts)
+}
+
+func TestSessionSettingsUnsupported(t *testing.T) {
+	db := newMemStoreDB(t, fstest.MapFS{
+		"db/migrations/001_users.sql": {Data: []byte("-- migrate:up search_path:app\ncreate users\n-- migrate:down\n")},
+	})
+
+	err := db.CreateAndMigrate()
+	require.ErrorIs(t, err, dbmate.ErrUnsupportedOption)
+	require.EqualError(t, err, "migration option is not supported by this driver: role and search_path (memstore)")
+	require.Empty(t, testMemStore.scripts)
+}
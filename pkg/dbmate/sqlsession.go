@@ -0,0 +1,216 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// sqlSessionDriver adapts a Driver, which uses database/sql, to SessionDriver
+type sqlSessionDriver struct {
+	Driver
+	// tableDriver returns a driver which records migrations in another migrations table
+	tableDriver func(table string) (Driver, error)
+}
+
+// StatementSyntax returns the syntax of the adapted driver, or standard SQL
+func (drv *sqlSessionDriver) StatementSyntax() dbutil.StatementSyntax {
+	if splitter, ok := drv.Driver.(StatementSplitter); ok {
+		return splitter.StatementSyntax()
+	}
+
+	return dbutil.StatementSyntax{}
+}
+
+// capability returns the driver as the optional interface T, if it implements it. Drivers
+// which use database/sql are unwrapped from their adapter first.
+func capability[T any](drv SessionDriver) (T, bool) {
+	if sqlDrv, ok := drv.(*sqlSessionDriver); ok {
+		c, ok := sqlDrv.Driver.(T)
+		return c, ok
+	}
+
+	c, ok := drv.(T)
+	return c, ok
+}
+
+// OpenSession opens the database
+func (drv *sqlSessionDriver) OpenSession() (Session, error) {
+	sqlDB, err := drv.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlSession{drv: drv, db: sqlDB, drivers: map[string]Driver{}}, nil
+}
+
+func doTransaction(sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := txFunc(tx); err != nil {
+		if err1 := tx.Rollback(); err1 != nil {
+			return err1
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// runTransaction runs txFunc in a transaction, using the driver's TransactionRunner if it has one
+func runTransaction(drv Driver, sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
+	if runner, ok := drv.(TransactionRunner); ok {
+		return runner.RunTransaction(sqlDB, txFunc)
+	}
+
+	return doTransaction(sqlDB, txFunc)
+}
+
+// sqlSession is a Session for a database/sql database
+type sqlSession struct {
+	drv     *sqlSessionDriver
+	db      *sql.DB
+	drivers map[string]Driver
+}
+
+// driver returns the driver which records migrations in a migrations table
+func (s *sqlSession) driver(table string) (Driver, error) {
+	if drv, ok := s.drivers[table]; ok {
+		return drv, nil
+	}
+
+	drv, err := s.drv.tableDriver(table)
+	if err != nil {
+		return nil, err
+	}
+	s.drivers[table] = drv
+
+	return drv, nil
+}
+
+func (s *sqlSession) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlSession) DumpSchema(args ...string) ([]byte, error) {
+	return s.drv.DumpSchema(s.db, args...)
+}
+
+func (s *sqlSession) MigrationsTableExists(table string) (bool, error) {
+	drv, err := s.driver(table)
+	if err != nil {
+		return false, err
+	}
+
+	return drv.MigrationsTableExists(s.db)
+}
+
+func (s *sqlSession) CreateMigrationsTable(table string) error {
+	drv, err := s.driver(table)
+	if err != nil {
+		return err
+	}
+
+	return drv.CreateMigrationsTable(s.db)
+}
+
+func (s *sqlSession) SelectMigrations(table string, limit int) (map[string]bool, error) {
+	drv, err := s.driver(table)
+	if err != nil {
+		return nil, err
+	}
+
+	return drv.SelectMigrations(s.db, limit)
+}
+
+// DumpMigrations dumps the versions recorded in a migrations table, or returns
+// errors.ErrUnsupported if the driver is not a MigrationsDumper
+func (s *sqlSession) DumpMigrations(table string) ([]byte, error) {
+	drv, err := s.driver(table)
+	if err != nil {
+		return nil, err
+	}
+
+	dumper, ok := drv.(MigrationsDumper)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+
+	return dumper.DumpMigrations(s.db)
+}
+
+// Run calls fn in a transaction (using the driver's TransactionRunner if it has one), or
+// otherwise on a single connection, so that session settings apply to every statement
+func (s *sqlSession) Run(transaction bool, fn func(Executor) error) error {
+	if transaction {
+		return runTransaction(s.drv.Driver, s.db, func(tx dbutil.Transaction) error {
+			return fn(&sqlExecutor{session: s, tx: tx})
+		})
+	}
+
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(conn)
+
+	return fn(&sqlExecutor{session: s, tx: connTransaction{ctx: ctx, conn: conn}})
+}
+
+// sqlExecutor is an Executor for a database/sql transaction or connection
+type sqlExecutor struct {
+	session *sqlSession
+	tx      dbutil.Transaction
+}
+
+func (e *sqlExecutor) Exec(script string) (Result, error) {
+	result, err := e.tx.Exec(script)
+	if err != nil {
+		return nil, e.session.drv.QueryError(script, err)
+	}
+
+	return result, nil
+}
+
+func (e *sqlExecutor) InsertMigration(table, version string) error {
+	drv, err := e.session.driver(table)
+	if err != nil {
+		return err
+	}
+
+	return drv.InsertMigration(e.tx, version)
+}
+
+func (e *sqlExecutor) DeleteMigration(table, version string) error {
+	drv, err := e.session.driver(table)
+	if err != nil {
+		return err
+	}
+
+	return drv.DeleteMigration(e.tx, version)
+}
+
+// connTransaction runs queries on a single connection, outside of a transaction
+type connTransaction struct {
+	ctx  context.Context
+	conn *sql.Conn
+}
+
+func (c connTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c connTransaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c connTransaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
@@ -0,0 +1,133 @@
+package dbmate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ErrTemplateNotFound is returned when the requested migration template does not exist
+var ErrTemplateNotFound = errors.New("could not find migration template")
+
+// defaultMigrationTemplate is used when no template file is found
+const defaultMigrationTemplate = "-- migrate:up\n{{.SQL}}\n\n-- migrate:down\n{{.DownSQL}}\n"
+
+// MigrationTemplateData is passed to migration templates when creating a new migration
+type MigrationTemplateData struct {
+	// Name of the migration, e.g. "create_users_table"
+	Name string
+	// Version of the migration, e.g. "20151127184807"
+	Version string
+	// Timestamp the migration was created at (UTC)
+	Timestamp time.Time
+	// Author is the name of the current user
+	Author string
+	// Driver is the driver name, or empty if no database URL is configured
+	Driver string
+	// SQL to prefill the up block with
+	SQL string
+	// DownSQL to prefill the down block with
+	DownSQL string
+}
+
+// driverName returns the name of the configured driver, without initializing it
+func (db *DB) driverName() string {
+	if db.DriverName != "" {
+		return db.DriverName
+	}
+
+	if db.DatabaseURL != nil {
+		return db.DatabaseURL.Scheme
+	}
+
+	return ""
+}
+
+// findMigrationTemplate returns the path of the template to use for new migrations in dir,
+// or an empty string to use the default template. Templates are searched for in order:
+//
+//   - db.MigrationTemplate, either as a path or as a template name in dir (e.g. "concurrent"
+//     for "concurrent.tmpl")
+//   - migration.<driver>.tmpl in dir (e.g. "migration.postgres.tmpl")
+//   - migration.tmpl in dir
+func (db *DB) findMigrationTemplate(dir string) (string, error) {
+	if db.MigrationTemplate != "" {
+		candidates := []string{db.MigrationTemplate}
+		if !strings.ContainsAny(db.MigrationTemplate, `/\`) {
+			candidates = append(candidates, filepath.Join(dir, db.MigrationTemplate+".tmpl"))
+		}
+
+		for _, candidate := range candidates {
+			if db.fileExists(candidate) {
+				return candidate, nil
+			}
+		}
+
+		return "", fmt.Errorf("%w `%s`", ErrTemplateNotFound, db.MigrationTemplate)
+	}
+
+	candidates := []string{}
+	if driver := db.driverName(); driver != "" {
+		candidates = append(candidates, filepath.Join(dir, "migration."+driver+".tmpl"))
+	}
+	candidates = append(candidates, filepath.Join(dir, "migration.tmpl"))
+
+	for _, candidate := range candidates {
+		if db.fileExists(candidate) {
+			return candidate, nil
+		}
+	}
+
+	return "", nil
+}
+
+// renderMigrationTemplate renders the contents of a new migration in dir
+func (db *DB) renderMigrationTemplate(dir string, data MigrationTemplateData) ([]byte, error) {
+	templatePath, err := db.findMigrationTemplate(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	text := defaultMigrationTemplate
+	name := "migration"
+	if templatePath != "" {
+		contents, err := fs.ReadFile(db.fs(), fsPath(templatePath))
+		if err != nil {
+			return nil, err
+		}
+		text = string(contents)
+		name = filepath.Base(templatePath)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// currentAuthor returns the name of the current user, or an empty string if unknown
+func currentAuthor() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+
+	if u.Name != "" {
+		return u.Name
+	}
+
+	return u.Username
+}
@@ -0,0 +1,103 @@
+package dbmate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// blockPlan describes how a migration block runs
+type blockPlan struct {
+	// transaction is true if the block runs in a transaction
+	transaction bool
+	// note is printed before the block runs, if it is not empty
+	note string
+}
+
+// planBlocks decides whether each up (or down) block of a migration runs in a transaction.
+// Blocks run in a transaction unless they opt out with transaction:false, or the driver is a
+// NonTransactionalDetector which finds statements that cannot run in one.
+func (db *DB) planBlocks(drv SessionDriver, migration Migration, sections []*ParsedMigration, down bool) ([]blockPlan, error) {
+	detector, ok := capability[NonTransactionalDetector](drv)
+
+	var contents string
+	if ok {
+		var err error
+		if contents, err = migration.readFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	plans := make([]blockPlan, len(sections))
+	for i, section := range sections {
+		block, options, direction := section.Up, section.UpOptions, "up"
+		if down {
+			block, options, direction = section.Down, section.DownOptions, "down"
+		}
+
+		if !ok {
+			plans[i] = blockPlan{transaction: options.Transaction()}
+			continue
+		}
+
+		// report lines within the migration file, rather than the block
+		offset := 0
+		if start := strings.Index(contents, block); start > 0 {
+			offset = strings.Count(contents[:start], "\n")
+		}
+
+		name := fmt.Sprintf("%s %s block", migration.displayName(), direction)
+		plan, err := planBlock(detector, statementSyntax(drv), name, block, offset, options)
+		if err != nil {
+			return nil, err
+		}
+		plans[i] = plan
+	}
+
+	return plans, nil
+}
+
+// planBlock checks the statements in a migration block. A block whose statements all cannot
+// run in a transaction runs without one, unless it asks for a transaction. A block which
+// mixes them with other statements must opt out of the transaction explicitly, since the
+// other statements would otherwise silently lose the protection of the transaction.
+func planBlock(detector NonTransactionalDetector, syntax dbutil.StatementSyntax, name, block string, offset int,
+	options ParsedMigrationOptions,
+) (blockPlan, error) {
+	found := []string{}
+	others := 0
+
+	scanner := dbutil.NewStatementScanner(strings.NewReader(block), syntax)
+	for scanner.Scan() {
+		stmt := scanner.Statement()
+		if reason := detector.NonTransactionalReason(stmt.SQL); reason != "" {
+			found = append(found, fmt.Sprintf("line %d: %s", offset+stmt.Line, reason))
+		} else {
+			others++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return blockPlan{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if len(found) == 0 {
+		return blockPlan{transaction: options.Transaction()}, nil
+	}
+
+	details := strings.Join(found, "; ")
+	switch {
+	case !options.Transaction() && others > 0:
+		return blockPlan{note: fmt.Sprintf("Warning: %s mixes statements which cannot run in a transaction "+
+			"with other statements (%s)", name, details)}, nil
+	case !options.Transaction():
+		return blockPlan{}, nil
+	case others > 0:
+		return blockPlan{}, fmt.Errorf("%w: %s mixes them with other statements (%s), "+
+			"move them to their own section with transaction:false", ErrNonTransactional, name, details)
+	case requestsTransaction(options):
+		return blockPlan{}, fmt.Errorf("%w: %s requests transaction:true (%s)", ErrNonTransactional, name, details)
+	default:
+		return blockPlan{note: fmt.Sprintf("Running %s without a transaction (%s)", name, details)}, nil
+	}
+}
@@ -0,0 +1,74 @@
+package dbmate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+// concurrentlyDetector reports statements containing "concurrently"
+type concurrentlyDetector struct{}
+
+func (concurrentlyDetector) NonTransactionalReason(statement string) string {
+	if strings.Contains(strings.ToLower(statement), "concurrently") {
+		return "CONCURRENTLY cannot run in a transaction"
+	}
+
+	return ""
+}
+
+func TestPlanBlock(t *testing.T) {
+	plan := func(block string) (blockPlan, error) {
+		sections, err := parseMigrationContents(block + "\n-- migrate:down\n")
+		require.NoError(t, err)
+
+		return planBlock(concurrentlyDetector{}, dbutil.StatementSyntax{}, "001_test.sql up block",
+			sections[0].Up, 10, sections[0].UpOptions)
+	}
+
+	t.Run("transactional statements", func(t *testing.T) {
+		p, err := plan("-- migrate:up\ncreate table users (id int);\n")
+		require.NoError(t, err)
+		require.Equal(t, blockPlan{transaction: true}, p)
+
+		p, err = plan("-- migrate:up transaction:false\ncreate table users (id int);\n")
+		require.NoError(t, err)
+		require.Equal(t, blockPlan{transaction: false}, p)
+	})
+
+	t.Run("runs without a transaction", func(t *testing.T) {
+		p, err := plan("-- migrate:up\n-- comment\ncreate index concurrently users_email on users (email);\n")
+		require.NoError(t, err)
+		require.Equal(t, blockPlan{note: "Running 001_test.sql up block without a transaction " +
+			"(line 13: CONCURRENTLY cannot run in a transaction)"}, p)
+
+		p, err = plan("-- migrate:up transaction:false\ncreate index concurrently users_email on users (email);\n")
+		require.NoError(t, err)
+		require.Equal(t, blockPlan{}, p)
+	})
+
+	t.Run("requests a transaction", func(t *testing.T) {
+		_, err := plan("-- migrate:up transaction:true\ncreate index concurrently users_email on users (email);\n")
+		require.ErrorIs(t, err, ErrNonTransactional)
+		require.EqualError(t, err, "migration contains statements which cannot run in a transaction: "+
+			"001_test.sql up block requests transaction:true (line 12: CONCURRENTLY cannot run in a transaction)")
+	})
+
+	t.Run("mixed statements", func(t *testing.T) {
+		_, err := plan("-- migrate:up\ncreate table users (id int);\n" +
+			"create index concurrently users_email on users (email);\n")
+		require.ErrorIs(t, err, ErrNonTransactional)
+		require.EqualError(t, err, "migration contains statements which cannot run in a transaction: "+
+			"001_test.sql up block mixes them with other statements (line 13: CONCURRENTLY cannot run in a transaction), "+
+			"move them to their own section with transaction:false")
+
+		p, err := plan("-- migrate:up transaction:false\ncreate table users (id int);\n" +
+			"create index concurrently users_email on users (email);\n")
+		require.NoError(t, err)
+		require.Equal(t, blockPlan{note: "Warning: 001_test.sql up block mixes statements which cannot run " +
+			"in a transaction with other statements (line 13: CONCURRENTLY cannot run in a transaction)"}, p)
+	})
+}
@@ -0,0 +1,62 @@
+package dbutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// ErrInitSQL is returned when the SQL which initializes a connection fails
+var ErrInitSQL = errors.New("init sql failed")
+
+// WithInitSQL returns a connector which executes initSQL on every connection it opens, so that
+// session settings (such as the role or search path) apply to every connection in a pool.
+// The connector is returned unchanged if initSQL is empty.
+func WithInitSQL(connector driver.Connector, initSQL string) driver.Connector {
+	if initSQL == "" {
+		return connector
+	}
+
+	return &initSQLConnector{Connector: connector, initSQL: initSQL}
+}
+
+type initSQLConnector struct {
+	driver.Connector
+	initSQL string
+}
+
+// Connect opens a connection and executes the init SQL on it
+func (c *initSQLConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := execConn(ctx, conn, c.initSQL); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("%w: %w", ErrInitSQL, err)
+	}
+
+	return conn, nil
+}
+
+// execConn executes a query on a driver connection, preparing it if the driver does not
+// execute queries directly
+func execConn(ctx context.Context, conn driver.Conn, query string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, query, nil)
+		if !errors.Is(err, driver.ErrSkip) {
+			return err
+		}
+	}
+
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer MustClose(stmt)
+
+	_, err = stmt.Exec(nil) //nolint:staticcheck // the connection does not implement ExecerContext
+	return err
+}
@@ -0,0 +1,62 @@
+package dbutil_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dsnConnector opens connections with a driver and a data source name
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.driver }
+
+func sqliteConnector(t *testing.T) driver.Connector {
+	db, err := sql.Open(sqlDriverName, ":memory:")
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+
+	return dsnConnector{driver: db.Driver(), dsn: ":memory:"}
+}
+
+func TestWithInitSQL(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		connector := sqliteConnector(t)
+		require.Equal(t, connector, dbutil.WithInitSQL(connector, ""))
+	})
+
+	t.Run("every connection", func(t *testing.T) {
+		db := sql.OpenDB(dbutil.WithInitSQL(sqliteConnector(t), "create temp table init (id integer)"))
+		defer dbutil.MustClose(db)
+
+		// each in-memory connection is a separate database
+		ctx := context.Background()
+		for range 2 {
+			conn, err := db.Conn(ctx)
+			require.NoError(t, err)
+			defer dbutil.MustClose(conn)
+
+			var count int
+			err = conn.QueryRowContext(ctx, "select count(*) from init").Scan(&count)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		db := sql.OpenDB(dbutil.WithInitSQL(sqliteConnector(t), "bogus"))
+		defer dbutil.MustClose(db)
+
+		err := db.Ping()
+		require.ErrorIs(t, err, dbutil.ErrInitSQL)
+		require.Contains(t, err.Error(), "init sql failed: ")
+	})
+}
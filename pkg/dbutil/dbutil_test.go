@@ -7,7 +7,6 @@ import (
 	"github.com/amacneil/dbmate/v2/pkg/dbtest"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 
-	_ "github.com/mattn/go-sqlite3" // database/sql driver
 	"github.com/stretchr/testify/require"
 )
 
@@ -90,7 +89,7 @@ func TestStripPsqlMetaCommands(t *testing.T) {
 const sqliteMemoryDB = "file:dbutil.sqlite3?mode=memory&cache=shared"
 
 func TestQueryColumn(t *testing.T) {
-	db, err := sql.Open("sqlite3", sqliteMemoryDB)
+	db, err := sql.Open(sqlDriverName, sqliteMemoryDB)
 	require.NoError(t, err)
 
 	val, err := dbutil.QueryColumn(db, "select 'foo_' || val from (select ? as val union select ?)",
@@ -100,7 +99,7 @@ func TestQueryColumn(t *testing.T) {
 }
 
 func TestQueryValue(t *testing.T) {
-	db, err := sql.Open("sqlite3", sqliteMemoryDB)
+	db, err := sql.Open(sqlDriverName, sqliteMemoryDB)
 	require.NoError(t, err)
 
 	val, err := dbutil.QueryValue(db, "select $1 + $2", "5", 2)
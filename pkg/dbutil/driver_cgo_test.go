@@ -0,0 +1,11 @@
+//go:build cgo && !sqlite_purego
+// +build cgo,!sqlite_purego
+
+package dbutil_test
+
+import (
+	_ "github.com/mattn/go-sqlite3" // database/sql driver
+)
+
+// sqlDriverName is the database/sql driver registered by mattn/go-sqlite3
+const sqlDriverName = "sqlite3"
@@ -0,0 +1,11 @@
+//go:build !cgo || sqlite_purego
+// +build !cgo sqlite_purego
+
+package dbutil_test
+
+import (
+	_ "modernc.org/sqlite" // database/sql driver
+)
+
+// sqlDriverName is the database/sql driver registered by modernc.org/sqlite
+const sqlDriverName = "sqlite"
@@ -0,0 +1,265 @@
+package dbutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidRewriteRule is returned by RewriteRule for rules which cannot be parsed
+var ErrInvalidRewriteRule = errors.New("invalid rewrite rule")
+
+// NormalizeFunc rewrites a schema dump
+type NormalizeFunc func(data []byte) ([]byte, error)
+
+// NormalizeSchema applies each rule to a schema dump in order
+func NormalizeSchema(data []byte, rules ...NormalizeFunc) ([]byte, error) {
+	var err error
+	for _, rule := range rules {
+		if data, err = rule(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// NormalizeRuleNames lists the built-in normalization rules, in the order they are applied
+var NormalizeRuleNames = []string{
+	"version-comments",
+	"definers",
+	"owners",
+	"tablespaces",
+	"sort",
+	"whitespace",
+}
+
+// NormalizeRule returns a built-in normalization rule by name, or nil if it does not exist
+func NormalizeRule(name string) NormalizeFunc {
+	switch name {
+	case "version-comments":
+		return StripVersionComments
+	case "definers":
+		return StripDefiners
+	case "owners":
+		return StripOwners
+	case "tablespaces":
+		return StripTablespaces
+	case "sort":
+		return SortBlocks
+	case "whitespace":
+		return NormalizeWhitespace
+	}
+
+	return nil
+}
+
+// RewriteRule returns a rule which replaces each match of a regular expression, written
+// in the form "s/pattern/replacement/". Any character may be used instead of the slash,
+// and the replacement may refer to submatches using $1 or ${name}.
+func RewriteRule(expr string) (NormalizeFunc, error) {
+	if len(expr) < 2 || expr[0] != 's' {
+		return nil, fmt.Errorf("%w `%s`: expected s/pattern/replacement/", ErrInvalidRewriteRule, expr)
+	}
+
+	parts := strings.Split(expr[2:], expr[1:2])
+	if len(parts) != 3 || parts[2] != "" || parts[0] == "" {
+		return nil, fmt.Errorf("%w `%s`: expected s/pattern/replacement/", ErrInvalidRewriteRule, expr)
+	}
+
+	pattern, err := regexp.Compile("(?m)" + parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w `%s`: %s", ErrInvalidRewriteRule, expr, err)
+	}
+	replacement := []byte(parts[1])
+
+	return func(data []byte) ([]byte, error) {
+		return pattern.ReplaceAll(data, replacement), nil
+	}, nil
+}
+
+// versionCommentRegexp matches comments which contain client or server versions
+var versionCommentRegexp = regexp.MustCompile(`(?m)^-- (?:Dumped from database version|Dumped by pg_dump version|` +
+	`MySQL dump|MariaDB dump|Server version|Dump completed).*\n`)
+
+// StripVersionComments removes comments containing client or server versions, which
+// pg_dump and mysqldump write at the start and end of their output
+func StripVersionComments(data []byte) ([]byte, error) {
+	return versionCommentRegexp.ReplaceAll(data, nil), nil
+}
+
+// definerRegexp matches the DEFINER clause of MySQL views, routines, triggers, and events
+var definerRegexp = regexp.MustCompile(" DEFINER=(`(?:[^`]|``)*`|[^ @]+)@(`(?:[^`]|``)*`|[^ *]+)")
+
+// StripDefiners removes DEFINER clauses, which contain the user and host that created an object
+func StripDefiners(data []byte) ([]byte, error) {
+	return definerRegexp.ReplaceAll(data, nil), nil
+}
+
+var (
+	// ownerStatementRegexp matches ALTER ... OWNER TO statements
+	ownerStatementRegexp = regexp.MustCompile(`(?m)^ALTER .* OWNER TO .*;\n`)
+	// ownerCommentRegexp matches the owner in pg_dump object comments
+	ownerCommentRegexp = regexp.MustCompile(`(?m)^(-- Name: .*; Owner: ).+$`)
+)
+
+// StripOwners removes statements which set the owner of objects, and replaces the owner in
+// pg_dump object comments with "-", as if the dump was created with --no-owner
+func StripOwners(data []byte) ([]byte, error) {
+	data = ownerStatementRegexp.ReplaceAll(data, nil)
+	return ownerCommentRegexp.ReplaceAll(data, []byte("${1}-")), nil
+}
+
+var (
+	// tablespaceStatementRegexp matches statements which set the default tablespace
+	tablespaceStatementRegexp = regexp.MustCompile(`(?m)^SET default_tablespace = .*;\n`)
+	// tablespaceClauseRegexp matches TABLESPACE clauses, and MySQL comments containing them
+	tablespaceClauseRegexp = regexp.MustCompile(`(?: /\*!\d+)? TABLESPACE (?:"(?:[^"]|"")*"|` +
+		"`(?:[^`]|``)*`" + `|[A-Za-z_][\w$]*)(?:(?: STORAGE \w+)? \*/)?`)
+)
+
+// StripTablespaces removes TABLESPACE clauses and statements which set the default tablespace
+func StripTablespaces(data []byte) ([]byte, error) {
+	data = tablespaceStatementRegexp.ReplaceAll(data, nil)
+	return tablespaceClauseRegexp.ReplaceAll(data, nil), nil
+}
+
+// NormalizeWhitespace converts line endings to "\n", removes trailing whitespace from each
+// line, collapses consecutive blank lines, and removes blank lines from the start and end
+func NormalizeWhitespace(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+
+	blank := false
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimRightFunc(line, unicode.IsSpace)
+		if len(line) == 0 {
+			blank = out.Len() > 0
+			continue
+		}
+
+		if blank {
+			out.WriteString("\n")
+			blank = false
+		}
+		out.Write(line)
+		out.WriteString("\n")
+	}
+
+	return out.Bytes(), nil
+}
+
+var (
+	// blockHeaderRegexp matches the comment which starts each object in pg_dump and mysqldump
+	// output, and in dbmate schema files
+	blockHeaderRegexp = regexp.MustCompile(`(?m)^--\n-- (.+)\n--\n`)
+	// blockTypeRegexp matches the object type in pg_dump block headers
+	blockTypeRegexp = regexp.MustCompile(`; Type: ([^;]+);`)
+	// restoreSettingRegexp matches the statements at the end of mysqldump output which
+	// restore session variables, and which are not part of the last object
+	restoreSettingRegexp = regexp.MustCompile(`(?m)(?:^/\*!\d+ SET \w+ *= *@OLD_\w+ \*/;\n+)+\z`)
+)
+
+// unsortedBlockTypes lists the block types which may depend on other blocks of the same type,
+// and which are left in the order chosen by the dump tool (or in the case of table data,
+// the order chosen by the user)
+var unsortedBlockTypes = []string{"view", "type", "domain", "data"}
+
+// SchemaBlock is one object in a schema dump, starting with the comment header which
+// pg_dump, mysqldump, and dbmate write before each object
+type SchemaBlock struct {
+	// Header is the text of the comment, e.g. "Name: users; Type: TABLE; Schema: public; Owner: -"
+	Header string
+	// Type is the object type from the header, e.g. "TABLE" or "Table structure for table ",
+	// or empty if the header does not contain a type
+	Type string
+	// Text is the block including its header, up to the next header
+	Text []byte
+}
+
+// SplitSchemaBlocks splits a schema dump into the text before the first block header,
+// and the blocks which follow it
+func SplitSchemaBlocks(data []byte) ([]byte, []SchemaBlock) {
+	headers := blockHeaderRegexp.FindAllSubmatchIndex(data, -1)
+	if len(headers) == 0 {
+		return data, nil
+	}
+
+	blocks := make([]SchemaBlock, len(headers))
+	for i, header := range headers {
+		end := len(data)
+		if i+1 < len(headers) {
+			end = headers[i+1][0]
+		}
+
+		block := SchemaBlock{Header: string(data[header[2]:header[3]]), Text: data[header[0]:end]}
+		if match := blockTypeRegexp.FindStringSubmatch(block.Header); match != nil {
+			block.Type = match[1]
+		} else if prefix, _, ok := strings.Cut(block.Header, "`"); ok {
+			// mysqldump headers, e.g. "Table structure for table `users`"
+			block.Type = prefix
+		}
+		blocks[i] = block
+	}
+
+	return data[:headers[0][0]], blocks
+}
+
+// sortable returns true if the block may be reordered within blocks of the same type
+func (b SchemaBlock) sortable() bool {
+	if b.Type == "" || bytes.Contains(b.Text, []byte(" INHERITS (")) {
+		return false
+	}
+	lowerType := strings.ToLower(b.Type)
+	return !slices.ContainsFunc(unsortedBlockTypes, func(t string) bool {
+		return strings.Contains(lowerType, t)
+	})
+}
+
+// SortBlocks sorts consecutive objects of the same type by the comment which starts them,
+// so that the order does not depend on the version of the dump tool. Objects which may
+// depend on other objects of the same type (views, types, domains, inherited tables, and
+// table data) keep their original order.
+func SortBlocks(data []byte) ([]byte, error) {
+	preamble, blocks := SplitSchemaBlocks(data)
+	if len(blocks) == 0 {
+		return data, nil
+	}
+
+	trailers := make([][]byte, len(blocks))
+	for i := range blocks {
+		if loc := restoreSettingRegexp.FindIndex(blocks[i].Text); loc != nil {
+			blocks[i].Text, trailers[i] = blocks[i].Text[:loc[0]], blocks[i].Text[loc[0]:]
+		}
+	}
+
+	// sort runs of sortable blocks with the same type
+	for start := 0; start < len(blocks); {
+		end := start + 1
+		for end < len(blocks) && blocks[start].sortable() && blocks[end].sortable() &&
+			blocks[end].Type == blocks[start].Type {
+			end++
+		}
+		slices.SortStableFunc(blocks[start:end], func(a, b SchemaBlock) int {
+			return strings.Compare(a.Header, b.Header)
+		})
+		start = end
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(preamble)
+	for i, block := range blocks {
+		// blocks moved away from the end of the file may not end with a blank line
+		out.Write(block.Text)
+		if i+1 < len(blocks) && !bytes.HasSuffix(block.Text, []byte("\n\n")) {
+			out.WriteString("\n")
+		}
+		// trailers stay at the end of the file
+		out.Write(trailers[i])
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,174 @@
+package dbutil_test
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSchema(t *testing.T) {
+	appendB := func(data []byte) ([]byte, error) {
+		return []byte(string(data) + "b"), nil
+	}
+	out, err := dbutil.NormalizeSchema([]byte("a"), appendB, appendB)
+	require.NoError(t, err)
+	require.Equal(t, "abb", string(out))
+
+	for _, name := range dbutil.NormalizeRuleNames {
+		require.NotNil(t, dbutil.NormalizeRule(name), name)
+	}
+	require.Nil(t, dbutil.NormalizeRule("foo"))
+}
+
+func TestRewriteRule(t *testing.T) {
+	rule, err := dbutil.RewriteRule(`s/ COLLATE (\w+)/ -- $1/`)
+	require.NoError(t, err)
+	out, err := rule([]byte("name text COLLATE utf8mb4_bin,\nemail text COLLATE C\n"))
+	require.NoError(t, err)
+	require.Equal(t, "name text -- utf8mb4_bin,\nemail text -- C\n", string(out))
+
+	// any delimiter may be used, and patterns match each line
+	rule, err = dbutil.RewriteRule(`s|^SET search_path = .*;\n||`)
+	require.NoError(t, err)
+	out, err = rule([]byte("SET a = 1;\nSET search_path = public;\nSET b = 2;\n"))
+	require.NoError(t, err)
+	require.Equal(t, "SET a = 1;\nSET b = 2;\n", string(out))
+
+	for _, expr := range []string{"", "s", "/a/b/", "s/a/b", "s/a/b/c", "s//b/"} {
+		_, err = dbutil.RewriteRule(expr)
+		require.ErrorIs(t, err, dbutil.ErrInvalidRewriteRule, expr)
+	}
+
+	_, err = dbutil.RewriteRule("s/a(/b/")
+	require.EqualError(t, err, "invalid rewrite rule `s/a(/b/`: error parsing regexp: missing closing ): `(?m)a(`")
+}
+
+func TestStripVersionComments(t *testing.T) {
+	in := "--\n" +
+		"-- PostgreSQL database dump\n" +
+		"--\n\n" +
+		"-- Dumped from database version 16.4\n" +
+		"-- Dumped by pg_dump version 17.0\n\n" +
+		"-- MySQL dump 10.13  Distrib 8.0.39, for Linux (x86_64)\n" +
+		"-- Server version\t8.0.39\n" +
+		"SET x = 1;\n" +
+		"-- Dump completed on 2024-01-01 00:00:00\n"
+	out, err := dbutil.StripVersionComments([]byte(in))
+	require.NoError(t, err)
+	require.Equal(t, "--\n-- PostgreSQL database dump\n--\n\n\nSET x = 1;\n", string(out))
+}
+
+func TestStripDefiners(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `v` AS select 1 AS `1`",
+			"CREATE ALGORITHM=UNDEFINED SQL SECURITY DEFINER VIEW `v` AS select 1 AS `1`"},
+		{"CREATE DEFINER=`app``user`@`localhost` PROCEDURE `p`()\nBEGIN\nEND",
+			"CREATE PROCEDURE `p`()\nBEGIN\nEND"},
+		{"CREATE DEFINER=root@localhost TRIGGER `t` BEFORE INSERT ON `foo` FOR EACH ROW SET NEW.id = 1",
+			"CREATE TRIGGER `t` BEFORE INSERT ON `foo` FOR EACH ROW SET NEW.id = 1"},
+		// mysqldump wraps trigger definers in version comments
+		{"/*!50003 CREATE*/ /*!50017 DEFINER=root@localhost*/ /*!50003 TRIGGER `t`",
+			"/*!50003 CREATE*/ /*!50017*/ /*!50003 TRIGGER `t`"},
+		{"CREATE TABLE `definer` (`id` int)", "CREATE TABLE `definer` (`id` int)"},
+	}
+
+	for _, c := range cases {
+		out, err := dbutil.StripDefiners([]byte(c.input))
+		require.NoError(t, err)
+		require.Equal(t, c.expected, string(out))
+	}
+}
+
+func TestStripOwners(t *testing.T) {
+	in := "--\n" +
+		"-- Name: users; Type: TABLE; Schema: public; Owner: alice\n" +
+		"--\n\n" +
+		"CREATE TABLE public.users (id integer);\n\n" +
+		"ALTER TABLE public.users OWNER TO alice;\n" +
+		"ALTER TABLE public.users ADD CONSTRAINT users_pkey PRIMARY KEY (id);\n"
+	out, err := dbutil.StripOwners([]byte(in))
+	require.NoError(t, err)
+	require.Equal(t, "--\n"+
+		"-- Name: users; Type: TABLE; Schema: public; Owner: -\n"+
+		"--\n\n"+
+		"CREATE TABLE public.users (id integer);\n\n"+
+		"ALTER TABLE public.users ADD CONSTRAINT users_pkey PRIMARY KEY (id);\n", string(out))
+}
+
+func TestStripTablespaces(t *testing.T) {
+	in := "SET default_tablespace = '';\n" +
+		"SET default_tablespace = fast;\n" +
+		"CREATE INDEX users_email ON public.users USING btree (email) TABLESPACE fast;\n" +
+		"CREATE TABLE public.posts (id integer) TABLESPACE \"Slow Disk\";\n" +
+		"CREATE TABLE `t` (`id` int) /*!50100 TABLESPACE `ts1` */ ENGINE=InnoDB;\n"
+	out, err := dbutil.StripTablespaces([]byte(in))
+	require.NoError(t, err)
+	require.Equal(t, "CREATE INDEX users_email ON public.users USING btree (email);\n"+
+		"CREATE TABLE public.posts (id integer);\n"+
+		"CREATE TABLE `t` (`id` int) ENGINE=InnoDB;\n", string(out))
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	in := "\n\nSET x = 1;  \r\n\r\n\n\nCREATE TABLE t (\n\tid int\t\n);\n\n\n"
+	out, err := dbutil.NormalizeWhitespace([]byte(in))
+	require.NoError(t, err)
+	require.Equal(t, "SET x = 1;\n\nCREATE TABLE t (\n\tid int\n);\n", string(out))
+}
+
+func TestSortBlocks(t *testing.T) {
+	t.Run("pg_dump", func(t *testing.T) {
+		in := "SET x = 1;\n\n" +
+			"--\n-- Name: b_type; Type: TYPE; Schema: public; Owner: -\n--\n\nCREATE TYPE b_type;\n\n\n" +
+			"--\n-- Name: a_type; Type: TYPE; Schema: public; Owner: -\n--\n\nCREATE TYPE a_type;\n\n\n" +
+			"--\n-- Name: users; Type: TABLE; Schema: public; Owner: -\n--\n\nCREATE TABLE users ();\n\n\n" +
+			"--\n-- Name: posts; Type: TABLE; Schema: public; Owner: -\n--\n\nCREATE TABLE posts ();\n\n\n" +
+			"--\n-- Name: admins; Type: TABLE; Schema: public; Owner: -\n--\n\nCREATE TABLE admins () INHERITS (users);\n\n\n" +
+			"--\n-- Name: b_view; Type: VIEW; Schema: public; Owner: -\n--\n\nCREATE VIEW b_view;\n\n\n" +
+			"--\n-- Name: a_view; Type: VIEW; Schema: public; Owner: -\n--\n\nCREATE VIEW a_view;\n\n\n" +
+			"--\n-- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -\n--\n\nALTER TABLE users;\n\n\n" +
+			"--\n-- Name: posts posts_pkey; Type: CONSTRAINT; Schema: public; Owner: -\n--\n\nALTER TABLE posts;\n\n\n" +
+			"--\n-- PostgreSQL database dump complete\n--\n\n"
+		out, err := dbutil.SortBlocks([]byte(in))
+		require.NoError(t, err)
+		require.Equal(t, "SET x = 1;\n\n"+
+			// types and views keep their order, and inherited tables are not moved
+			"--\n-- Name: b_type; Type: TYPE; Schema: public; Owner: -\n--\n\nCREATE TYPE b_type;\n\n\n"+
+			"--\n-- Name: a_type; Type: TYPE; Schema: public; Owner: -\n--\n\nCREATE TYPE a_type;\n\n\n"+
+			"--\n-- Name: posts; Type: TABLE; Schema: public; Owner: -\n--\n\nCREATE TABLE posts ();\n\n\n"+
+			"--\n-- Name: users; Type: TABLE; Schema: public; Owner: -\n--\n\nCREATE TABLE users ();\n\n\n"+
+			"--\n-- Name: admins; Type: TABLE; Schema: public; Owner: -\n--\n\nCREATE TABLE admins () INHERITS (users);\n\n\n"+
+			"--\n-- Name: b_view; Type: VIEW; Schema: public; Owner: -\n--\n\nCREATE VIEW b_view;\n\n\n"+
+			"--\n-- Name: a_view; Type: VIEW; Schema: public; Owner: -\n--\n\nCREATE VIEW a_view;\n\n\n"+
+			"--\n-- Name: posts posts_pkey; Type: CONSTRAINT; Schema: public; Owner: -\n--\n\nALTER TABLE posts;\n\n\n"+
+			"--\n-- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -\n--\n\nALTER TABLE users;\n\n\n"+
+			"--\n-- PostgreSQL database dump complete\n--\n\n", string(out))
+	})
+
+	t.Run("mysqldump", func(t *testing.T) {
+		in := "/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE */;\n\n" +
+			"--\n-- Table structure for table `users`\n--\n\nCREATE TABLE `users` ();\n\n" +
+			"--\n-- Table structure for table `posts`\n--\n\nCREATE TABLE `posts` ();\n" +
+			"/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;\n\n" +
+			"/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;\n\n" +
+			"--\n-- Dbmate schema migrations\n--\n\nINSERT INTO `schema_migrations`;\n"
+		out, err := dbutil.SortBlocks([]byte(in))
+		require.NoError(t, err)
+		require.Equal(t, "/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE */;\n\n"+
+			"--\n-- Table structure for table `posts`\n--\n\nCREATE TABLE `posts` ();\n\n"+
+			"--\n-- Table structure for table `users`\n--\n\nCREATE TABLE `users` ();\n\n"+
+			"/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;\n\n"+
+			"/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;\n\n"+
+			"--\n-- Dbmate schema migrations\n--\n\nINSERT INTO `schema_migrations`;\n", string(out))
+	})
+
+	t.Run("no blocks", func(t *testing.T) {
+		out, err := dbutil.SortBlocks([]byte("CREATE TABLE b;\nCREATE TABLE a;\n"))
+		require.NoError(t, err)
+		require.Equal(t, "CREATE TABLE b;\nCREATE TABLE a;\n", string(out))
+	})
+}
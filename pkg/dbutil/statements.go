@@ -0,0 +1,469 @@
+package dbutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// StatementSyntax describes the quoting and comment syntax of an SQL dialect, which is needed
+// to split SQL into statements. The zero value is standard SQL, with backtick quoted
+// identifiers and BEGIN ... END blocks in triggers, functions, and procedures.
+type StatementSyntax struct {
+	// BackslashEscapes allows backslash escapes in quoted strings (MySQL, ClickHouse, BigQuery)
+	BackslashEscapes bool
+	// EscapeStrings allows backslash escapes in strings prefixed with E (PostgreSQL)
+	EscapeStrings bool
+	// DollarQuotes enables $$ and $tag$ quoted strings (PostgreSQL)
+	DollarQuotes bool
+	// TripleQuotes enables ''' and """ quoted strings (BigQuery)
+	TripleQuotes bool
+	// HashComments enables comments starting with # (MySQL, ClickHouse, BigQuery)
+	HashComments bool
+	// Delimiters enables the DELIMITER command, which changes the statement delimiter (MySQL)
+	Delimiters bool
+	// MetaCommands skips lines starting with a backslash between statements, such as the
+	// \restrict commands written by pg_dump (PostgreSQL)
+	MetaCommands bool
+	// BatchSeparator is a line which separates batches of statements, such as GO (SQL Server).
+	// When it is set, each batch is returned as one statement, and semicolons are ignored.
+	BatchSeparator string
+}
+
+// Statement is one statement read by a StatementScanner
+type Statement struct {
+	// SQL is the statement, without leading comments or the trailing delimiter
+	SQL string
+	// Line is the line number where the statement starts
+	Line int
+}
+
+// StatementScanner reads SQL statements from a stream one at a time, so that large files
+// do not need to be held in memory
+type StatementScanner struct {
+	r         *bufio.Reader
+	syntax    StatementSyntax
+	delimiter string
+	line      int
+	statement Statement
+	err       error
+	done      bool
+}
+
+// NewStatementScanner returns a StatementScanner which reads from r
+func NewStatementScanner(r io.Reader, syntax StatementSyntax) *StatementScanner {
+	return &StatementScanner{r: bufio.NewReader(r), syntax: syntax, delimiter: ";", line: 1}
+}
+
+// Statement returns the statement read by the last call to Scan
+func (s *StatementScanner) Statement() Statement {
+	return s.statement
+}
+
+// Err returns the first error encountered while reading, other than io.EOF
+func (s *StatementScanner) Err() error {
+	return s.err
+}
+
+// Scan reads the next statement, and returns false at the end of the input or on error.
+// Statements which only contain comments are skipped.
+func (s *StatementScanner) Scan() bool {
+	if s.done {
+		return false
+	}
+
+	var buf, word strings.Builder
+	var block statementBlock
+	start, startLine := -1, 0
+	lineStart := true
+
+	// content marks the start of the statement, before writing its first character
+	content := func() {
+		if start < 0 {
+			start, startLine = buf.Len(), s.line
+		}
+	}
+	// flushWord processes the word which has been read so far
+	flushWord := func() {
+		if word.Len() > 0 {
+			block.word(word.String())
+			word.Reset()
+		}
+	}
+
+	for {
+		if lineStart && s.syntax.BatchSeparator != "" {
+			separator, err := s.skipBatchSeparator()
+			if err != nil {
+				return s.fail(err)
+			}
+			if separator && start >= 0 {
+				s.statement = Statement{SQL: strings.TrimSpace(buf.String()[start:]), Line: startLine}
+				return true
+			}
+			if separator {
+				continue
+			}
+		}
+		if lineStart && start < 0 {
+			skipped, err := s.skipCommandLine()
+			if err != nil {
+				return s.fail(err)
+			}
+			if skipped {
+				continue
+			}
+		}
+
+		c, err := s.read()
+		if errors.Is(err, io.EOF) {
+			flushWord()
+			s.done = true
+			if start < 0 {
+				return false
+			}
+			s.statement = Statement{SQL: strings.TrimRightFunc(buf.String()[start:], unicode.IsSpace), Line: startLine}
+			return true
+		}
+		if err != nil {
+			return s.fail(err)
+		}
+
+		switch {
+		case c == '\n':
+			flushWord()
+			buf.WriteByte(c)
+			lineStart = true
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			flushWord()
+			buf.WriteByte(c)
+			continue
+		}
+		lineStart = false
+
+		switch {
+		case isWordByte(c) || (c == '$' && word.Len() > 0):
+			content()
+			buf.WriteByte(c)
+			word.WriteByte(c)
+			continue
+		case c == '\'' || c == '"':
+			// E'...' strings allow backslash escapes in PostgreSQL
+			escapes := s.syntax.BackslashEscapes ||
+				(c == '\'' && s.syntax.EscapeStrings && strings.EqualFold(word.String(), "e"))
+			flushWord()
+			content()
+			buf.WriteByte(c)
+			err = s.readQuoted(&buf, c, escapes)
+		case c == '`':
+			flushWord()
+			content()
+			buf.WriteByte(c)
+			err = s.readQuoted(&buf, c, false)
+		case c == '$' && s.syntax.DollarQuotes:
+			content()
+			buf.WriteByte(c)
+			err = s.readDollarQuoted(&buf)
+		case c == '-' && s.peek("-"), c == '#' && s.syntax.HashComments:
+			flushWord()
+			buf.WriteByte(c)
+			err = s.readLineComment(&buf)
+			lineStart = true
+		case c == '/' && s.peek("*"):
+			flushWord()
+			// MySQL executes the contents of /*! ... */ comments
+			if s.peek("*!") {
+				content()
+			}
+			buf.WriteByte(c)
+			err = s.readBlockComment(&buf)
+		default:
+			flushWord()
+			content()
+			buf.WriteByte(c)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return s.fail(err)
+		}
+
+		// statements end with the delimiter, unless it is inside a BEGIN ... END block.
+		// Batches only end at a batch separator line.
+		text := buf.String()
+		if s.syntax.BatchSeparator != "" || !strings.HasSuffix(text, s.delimiter) || (s.delimiter == ";" && block.open()) {
+			continue
+		}
+		if sql := strings.TrimSpace(text[start : len(text)-len(s.delimiter)]); sql != "" {
+			s.statement = Statement{SQL: sql, Line: startLine}
+			return true
+		}
+
+		// empty statement
+		buf.Reset()
+		block = statementBlock{}
+		start = -1
+	}
+}
+
+// fail records an error, and stops scanning
+func (s *StatementScanner) fail(err error) bool {
+	s.err = err
+	s.done = true
+	return false
+}
+
+// read returns the next byte, counting lines
+func (s *StatementScanner) read() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err == nil && c == '\n' {
+		s.line++
+	}
+
+	return c, err
+}
+
+// peek returns true if the next bytes match prefix
+func (s *StatementScanner) peek(prefix string) bool {
+	b, _ := s.r.Peek(len(prefix))
+	return string(b) == prefix
+}
+
+// readLine reads the rest of the current line, including the newline
+func (s *StatementScanner) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if strings.HasSuffix(line, "\n") {
+		s.line++
+	}
+
+	return line, err
+}
+
+// skipCommandLine handles lines between statements which are commands for the client,
+// rather than SQL, and returns true if a line was skipped
+func (s *StatementScanner) skipCommandLine() (bool, error) {
+	b, _ := s.r.Peek(len("delimiter "))
+	switch {
+	case s.syntax.Delimiters && strings.EqualFold(string(b), "delimiter "):
+		line, err := s.readLine()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		if delimiter := strings.TrimSpace(line[len("delimiter "):]); delimiter != "" {
+			s.delimiter = delimiter
+		}
+		return true, nil
+	case s.syntax.MetaCommands && len(b) > 0 && b[0] == '\\':
+		_, err := s.readLine()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// skipBatchSeparator returns true if the current line is a batch separator, after reading it
+func (s *StatementScanner) skipBatchSeparator() (bool, error) {
+	// the separator may be followed by whitespace, but nothing else
+	b, _ := s.r.Peek(len(s.syntax.BatchSeparator) + 64)
+	line := string(b)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	} else if len(b) == len(s.syntax.BatchSeparator)+64 {
+		return false, nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(line), s.syntax.BatchSeparator) {
+		return false, nil
+	}
+
+	_, err := s.readLine()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// readQuoted reads the rest of a quoted string or identifier. Quotes are escaped by doubling
+// them, or with a backslash if escapes is true.
+func (s *StatementScanner) readQuoted(buf *strings.Builder, quote byte, escapes bool) error {
+	// triple quoted strings may contain single quotes
+	triple := s.syntax.TripleQuotes && quote != '`' && s.peek(string([]byte{quote, quote}))
+	if triple {
+		b, _ := s.r.Peek(3)
+		// '' followed by anything other than a third quote is an empty string
+		if len(b) == 3 && b[2] == quote {
+			triple = false
+		} else {
+			_, _ = s.read()
+			_, _ = s.read()
+			buf.WriteByte(quote)
+			buf.WriteByte(quote)
+		}
+	}
+
+	for {
+		c, err := s.read()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+
+		switch {
+		case c == '\\' && escapes:
+			c, err = s.read()
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(c)
+		case c == quote && triple:
+			if s.peek(string([]byte{quote, quote})) {
+				_, _ = s.read()
+				_, _ = s.read()
+				buf.WriteByte(quote)
+				buf.WriteByte(quote)
+				return nil
+			}
+		case c == quote:
+			if !s.peek(string(quote)) {
+				return nil
+			}
+			_, _ = s.read()
+			buf.WriteByte(quote)
+		}
+	}
+}
+
+// readDollarQuoted reads a PostgreSQL dollar quoted string, after the first $.
+// A $ which does not start a tag (such as a $1 parameter) is left as it is.
+func (s *StatementScanner) readDollarQuoted(buf *strings.Builder) error {
+	b, _ := s.r.Peek(64)
+	end := -1
+	for i, c := range b {
+		if c == '$' {
+			end = i
+			break
+		}
+		if !(c == '_' || unicode.IsLetter(rune(c)) || (i > 0 && unicode.IsDigit(rune(c)))) {
+			break
+		}
+	}
+	if end < 0 {
+		return nil
+	}
+
+	tag := "$" + string(b[:end+1])
+	for range end + 1 {
+		_, _ = s.read()
+	}
+	buf.WriteString(tag[1:])
+
+	bodyStart := buf.Len()
+	for {
+		c, err := s.read()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+		if c == '$' && buf.Len()-bodyStart >= len(tag) && strings.HasSuffix(buf.String(), tag) {
+			return nil
+		}
+	}
+}
+
+// readLineComment reads the rest of a comment which ends at the end of the line
+func (s *StatementScanner) readLineComment(buf *strings.Builder) error {
+	line, err := s.readLine()
+	buf.WriteString(line)
+
+	return err
+}
+
+// readBlockComment reads a /* ... */ comment, after the first /
+func (s *StatementScanner) readBlockComment(buf *strings.Builder) error {
+	c, err := s.read()
+	if err != nil {
+		return err
+	}
+	buf.WriteByte(c)
+
+	for {
+		c, err := s.read()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+		if c == '*' && s.peek("/") {
+			_, _ = s.read()
+			buf.WriteByte('/')
+			return nil
+		}
+	}
+}
+
+// isWordByte returns true for bytes which can be part of a keyword or unquoted identifier
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// statementBlock tracks BEGIN ... END blocks in CREATE TRIGGER, FUNCTION, PROCEDURE, and EVENT
+// statements, whose bodies may contain semicolons
+type statementBlock struct {
+	words      int
+	create     bool
+	enabled    bool
+	depth      int
+	pendingEnd bool
+}
+
+// word processes each keyword or identifier in the statement
+func (b *statementBlock) word(w string) {
+	w = strings.ToUpper(w)
+	b.words++
+	if b.words == 1 {
+		b.create = w == "CREATE"
+		return
+	}
+	if !b.create {
+		return
+	}
+	if !b.enabled {
+		// e.g. CREATE OR REPLACE DEFINER = user TRIGGER
+		b.enabled = b.words <= 8 && (w == "TRIGGER" || w == "FUNCTION" || w == "PROCEDURE" || w == "EVENT")
+		return
+	}
+
+	if b.pendingEnd {
+		b.pendingEnd = false
+		switch w {
+		case "IF", "LOOP", "WHILE", "REPEAT", "FOR":
+			// END IF etc. close statements which do not open a block
+			return
+		case "CASE":
+			b.depth--
+			return
+		}
+		b.depth--
+	}
+
+	switch w {
+	case "BEGIN", "CASE":
+		b.depth++
+	case "END":
+		b.pendingEnd = true
+	}
+}
+
+// open returns true inside a BEGIN ... END block
+func (b *statementBlock) open() bool {
+	depth := b.depth
+	if b.pendingEnd {
+		depth--
+	}
+
+	return depth > 0
+}
@@ -0,0 +1,142 @@
+package dbutil_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func scanStatements(t *testing.T, sql string, syntax dbutil.StatementSyntax) []dbutil.Statement {
+	scanner := dbutil.NewStatementScanner(strings.NewReader(sql), syntax)
+	statements := []dbutil.Statement{}
+	for scanner.Scan() {
+		statements = append(statements, scanner.Statement())
+	}
+	require.NoError(t, scanner.Err())
+
+	return statements
+}
+
+func TestStatementScanner(t *testing.T) {
+	t.Run("standard", func(t *testing.T) {
+		sql := "-- comment\n" +
+			"CREATE TABLE a (b text DEFAULT 'x;''y', \"c;\" int, `d;` int); -- trailing\n" +
+			"\n" +
+			"/* block; comment */\n" +
+			";\n" +
+			"INSERT INTO a VALUES ('1\\'); SELECT 2\n" +
+			"-- only a comment\n"
+		require.Equal(t, []dbutil.Statement{
+			{SQL: "CREATE TABLE a (b text DEFAULT 'x;''y', \"c;\" int, `d;` int)", Line: 2},
+			{SQL: "INSERT INTO a VALUES ('1\\')", Line: 6},
+			{SQL: "SELECT 2\n-- only a comment", Line: 6},
+		}, scanStatements(t, sql, dbutil.StatementSyntax{}))
+	})
+
+	t.Run("begin end blocks", func(t *testing.T) {
+		sql := "CREATE TRIGGER t AFTER INSERT ON a\n" +
+			"BEGIN\n" +
+			"  UPDATE a SET b = CASE WHEN 1 THEN 'x' END;\n" +
+			"  SELECT 1;\n" +
+			"END;\n" +
+			"CREATE PROCEDURE p()\n" +
+			"BEGIN\n" +
+			"  IF 1 THEN SELECT 1; END IF;\n" +
+			"  CASE WHEN 1 THEN SELECT 2; END CASE;\n" +
+			"  WHILE 0 DO SELECT 3; END WHILE;\n" +
+			"END;\n" +
+			"CREATE TABLE x (begin int, \"end\" int);\n" +
+			"BEGIN;\n" +
+			"END;\n"
+		statements := scanStatements(t, sql, dbutil.StatementSyntax{})
+		require.Len(t, statements, 5)
+		require.Equal(t, 1, statements[0].Line)
+		require.True(t, strings.HasSuffix(statements[0].SQL, "SELECT 1;\nEND"))
+		require.Equal(t, 6, statements[1].Line)
+		require.True(t, strings.HasSuffix(statements[1].SQL, "END WHILE;\nEND"))
+		require.Equal(t, dbutil.Statement{SQL: "CREATE TABLE x (begin int, \"end\" int)", Line: 12}, statements[2])
+		require.Equal(t, dbutil.Statement{SQL: "BEGIN", Line: 13}, statements[3])
+		require.Equal(t, dbutil.Statement{SQL: "END", Line: 14}, statements[4])
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		sql := "\\restrict abc\n" +
+			"SET search_path = '';\n" +
+			"CREATE FUNCTION f() RETURNS text AS $body$\n" +
+			"BEGIN\n" +
+			"  RETURN 'a;' || $$b;$$;\n" +
+			"END;\n" +
+			"$body$ LANGUAGE plpgsql;\n" +
+			"SELECT E'\\';', $1, a$b FROM x;\n" +
+			"\\unrestrict abc\n"
+		syntax := dbutil.StatementSyntax{EscapeStrings: true, DollarQuotes: true, MetaCommands: true}
+		require.Equal(t, []dbutil.Statement{
+			{SQL: "SET search_path = ''", Line: 2},
+			{SQL: "CREATE FUNCTION f() RETURNS text AS $body$\nBEGIN\n  RETURN 'a;' || $$b;$$;\nEND;\n$body$ LANGUAGE plpgsql", Line: 3},
+			{SQL: "SELECT E'\\';', $1, a$b FROM x", Line: 8},
+		}, scanStatements(t, sql, syntax))
+
+		// without EscapeStrings, the backslash does not escape the quote
+		require.Len(t, scanStatements(t, "SELECT E'\\';', 1;", dbutil.StatementSyntax{}), 2)
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		sql := "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+			"# hash comment;\n" +
+			"INSERT INTO a VALUES ('it\\'s;', \"b\\\";\");\n" +
+			"DELIMITER ;;\n" +
+			"/*!50003 CREATE*/ /*!50003 TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN\n" +
+			"  SET NEW.b = 1;\n" +
+			"END */;;\n" +
+			"delimiter ;\n" +
+			"SELECT 1;\n"
+		syntax := dbutil.StatementSyntax{BackslashEscapes: true, HashComments: true, Delimiters: true}
+		require.Equal(t, []dbutil.Statement{
+			{SQL: "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */", Line: 1},
+			{SQL: "INSERT INTO a VALUES ('it\\'s;', \"b\\\";\")", Line: 3},
+			{SQL: "/*!50003 CREATE*/ /*!50003 TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN\n  SET NEW.b = 1;\nEND */", Line: 5},
+			{SQL: "SELECT 1", Line: 9},
+		}, scanStatements(t, sql, syntax))
+	})
+
+	t.Run("triple quotes", func(t *testing.T) {
+		sql := "SELECT '''a';b''', \"\"\"c\"\";\"\"\", '';\nSELECT 2;"
+		syntax := dbutil.StatementSyntax{BackslashEscapes: true, TripleQuotes: true}
+		require.Equal(t, []dbutil.Statement{
+			{SQL: "SELECT '''a';b''', \"\"\"c\"\";\"\"\", ''", Line: 1},
+			{SQL: "SELECT 2", Line: 2},
+		}, scanStatements(t, sql, syntax))
+	})
+
+	t.Run("batch separator", func(t *testing.T) {
+		sql := "-- comment\n" +
+			"CREATE TABLE a (b int);\n" +
+			"INSERT INTO a VALUES (1);\n" +
+			"go\n" +
+			"GO\n" +
+			"CREATE PROCEDURE p AS\n" +
+			"  SELECT 'x\nGO\n';\n" +
+			"  /*\nGO\n*/\n" +
+			"  SELECT 2;\n" +
+			"GO  \n" +
+			"SELECT 3\n" +
+			"GOTO x\n" +
+			"GO"
+		syntax := dbutil.StatementSyntax{BatchSeparator: "GO"}
+		require.Equal(t, []dbutil.Statement{
+			{SQL: "CREATE TABLE a (b int);\nINSERT INTO a VALUES (1);", Line: 2},
+			{SQL: "CREATE PROCEDURE p AS\n  SELECT 'x\nGO\n';\n  /*\nGO\n*/\n  SELECT 2;", Line: 6},
+			{SQL: "SELECT 3\nGOTO x", Line: 15},
+		}, scanStatements(t, sql, syntax))
+	})
+
+	t.Run("unterminated", func(t *testing.T) {
+		require.Equal(t, []dbutil.Statement{
+			{SQL: "SELECT 1", Line: 1},
+			{SQL: "SELECT 'a;\nb", Line: 1},
+		}, scanStatements(t, "SELECT 1; SELECT 'a;\nb", dbutil.StatementSyntax{}))
+	})
+}
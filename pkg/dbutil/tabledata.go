@@ -0,0 +1,96 @@
+package dbutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrTableNotFound is returned when a table whose data is dumped does not exist
+var ErrTableNotFound = errors.New("table does not exist")
+
+// DataTable describes a table whose rows are dumped as INSERT statements
+type DataTable struct {
+	// Name is the quoted (and qualified) name of the table
+	Name string
+	// Header is written before the rows of the table, even if it has no rows
+	Header string
+	// Columns are the quoted names of the columns which are inserted
+	Columns []string
+	// Values are SQL expressions which format each column as a literal, or NULL
+	Values []string
+	// Before and After are written around the INSERT statements, if the table has rows
+	Before, After string
+}
+
+// DataDumpSyntax describes how a driver formats the rows of tables as INSERT statements
+type DataDumpSyntax struct {
+	// RowSQL returns an expression which joins the literals of a row into a list, e.g. 1, 'a'
+	RowSQL func(values []string) string
+	// Indent is written before each row
+	Indent string
+	// BatchSize limits the number of rows in each INSERT statement, unless it is zero
+	BatchSize int
+}
+
+// DumpTableData returns INSERT statements for the rows of each table. The driver selects the
+// columns of each table with the table function, which returns ErrTableNotFound (or no columns)
+// if the table does not exist. Rows are sorted by their values, so that the output does not
+// depend on the physical order.
+func (s DataDumpSyntax) DumpTableData(db Transaction, tables []string,
+	table func(name string) (DataTable, error),
+) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, name := range tables {
+		rows, t, err := s.queryRows(db, name, table)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dump data for table %s: %w", name, err)
+		}
+
+		buf.WriteString(t.Header)
+		if len(rows) > 0 {
+			buf.WriteString(t.Before)
+			s.WriteInserts(&buf, t.Name, t.Columns, rows)
+			buf.WriteString(t.After)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// queryRows returns the sorted rows of a table, formatted as lists of literals
+func (s DataDumpSyntax) queryRows(db Transaction, name string,
+	table func(name string) (DataTable, error),
+) ([]string, DataTable, error) {
+	t, err := table(name)
+	if err != nil {
+		return nil, t, err
+	}
+	if len(t.Columns) == 0 {
+		return nil, t, ErrTableNotFound
+	}
+
+	rows, err := QueryColumn(db, "select "+s.RowSQL(t.Values)+" from "+t.Name)
+	if err != nil {
+		return nil, t, err
+	}
+	sort.Strings(rows)
+
+	return rows, t, nil
+}
+
+// WriteInserts writes INSERT statements for rows which are formatted as lists of literals
+func (s DataDumpSyntax) WriteInserts(buf *bytes.Buffer, table string, columns, rows []string) {
+	size := s.BatchSize
+	if size == 0 {
+		size = len(rows)
+	}
+
+	for start := 0; start < len(rows); start += size {
+		end := min(start+size, len(rows))
+		buf.WriteString("INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES\n" +
+			s.Indent + "(" + strings.Join(rows[start:end], "),\n"+s.Indent+"(") + ");\n")
+	}
+}
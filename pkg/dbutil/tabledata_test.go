@@ -0,0 +1,88 @@
+package dbutil_test
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testDataDumpSyntax = dbutil.DataDumpSyntax{
+	RowSQL: func(values []string) string {
+		return strings.Join(values, " || ', ' || ")
+	},
+	Indent: "  ",
+}
+
+// testDataTable selects the columns of a sqlite table
+func testDataTable(db *sql.DB) func(string) (dbutil.DataTable, error) {
+	return func(table string) (dbutil.DataTable, error) {
+		columns, err := dbutil.QueryColumn(db,
+			"select name from pragma_table_xinfo(?) where hidden = 0 order by cid", table)
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+
+		data := dbutil.DataTable{
+			Name:   `"` + table + `"`,
+			Header: "-- " + table + "\n",
+			Before: "-- before\n",
+			After:  "-- after\n",
+		}
+		for _, column := range columns {
+			data.Columns = append(data.Columns, `"`+column+`"`)
+			data.Values = append(data.Values, `quote("`+column+`")`)
+		}
+
+		return data, nil
+	}
+}
+
+func TestDumpTableData(t *testing.T) {
+	db, err := sql.Open(sqlDriverName, ":memory:")
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+
+	_, err = db.Exec(`create table countries (code text, name text);
+		insert into countries values ('nz', 'New Zealand'), ('au', 'Australia'), ('xx', null);
+		create table empty (id integer)`)
+	require.NoError(t, err)
+
+	// rows are sorted, and empty tables only have a header
+	data, err := testDataDumpSyntax.DumpTableData(db, []string{"countries", "empty"}, testDataTable(db))
+	require.NoError(t, err)
+	require.Equal(t, "-- countries\n"+
+		"-- before\n"+
+		"INSERT INTO \"countries\" (\"code\", \"name\") VALUES\n"+
+		"  ('au', 'Australia'),\n"+
+		"  ('nz', 'New Zealand'),\n"+
+		"  ('xx', NULL);\n"+
+		"-- after\n"+
+		"-- empty\n", string(data))
+
+	// tables without columns do not exist
+	_, err = testDataDumpSyntax.DumpTableData(db, []string{"missing"}, testDataTable(db))
+	require.ErrorIs(t, err, dbutil.ErrTableNotFound)
+	require.EqualError(t, err, "unable to dump data for table missing: table does not exist")
+}
+
+func TestWriteInserts(t *testing.T) {
+	syntax := dbutil.DataDumpSyntax{Indent: "    ", BatchSize: 2}
+
+	var buf bytes.Buffer
+	syntax.WriteInserts(&buf, "t", []string{"a", "b"}, []string{"1, 2", "3, 4", "5, 6"})
+	require.Equal(t, "INSERT INTO t (a, b) VALUES\n"+
+		"    (1, 2),\n"+
+		"    (3, 4);\n"+
+		"INSERT INTO t (a, b) VALUES\n"+
+		"    (5, 6);\n", buf.String())
+
+	// nothing is written without rows
+	buf.Reset()
+	syntax.WriteInserts(&buf, "t", []string{"a"}, nil)
+	require.Empty(t, buf.String())
+}
@@ -28,6 +28,7 @@ type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
 	log                 io.Writer
+	dumpOptions         dbmate.DumpOptions
 }
 
 func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
@@ -35,6 +36,7 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
 		log:                 config.Log,
+		dumpOptions:         config.DumpOptions,
 	}
 }
 
@@ -182,10 +184,10 @@ func (drv *Driver) schemaDump(db *sql.DB) ([]byte, error) {
 	}
 
 	query := fmt.Sprintf(
-		`SELECT table_name AS object_name, 'TABLE' AS object_type, ddl
+		`SELECT table_name AS object_name, 'TABLE' AS object_type, table_type AS object_subtype, ddl
 		FROM `+"`%s.%s.INFORMATION_SCHEMA.TABLES`"+`
 		UNION ALL
-		SELECT routine_name AS object_name, 'FUNCTION' AS object_type, ddl
+		SELECT routine_name AS object_name, 'FUNCTION' AS object_type, routine_type AS object_subtype, ddl
 		FROM `+"`%s.%s.INFORMATION_SCHEMA.ROUTINES`"+`
 		ORDER BY CASE object_type
 			WHEN 'TABLE' THEN 1
@@ -205,10 +207,13 @@ func (drv *Driver) schemaDump(db *sql.DB) ([]byte, error) {
 
 	// Iterate over the results and generate DDL for each object
 	for rows.Next() {
-		var objectName, objectType, ddl string
-		if err := rows.Scan(&objectName, &objectType, &ddl); err != nil {
+		var objectName, objectType, objectSubtype, ddl string
+		if err := rows.Scan(&objectName, &objectType, &objectSubtype, &ddl); err != nil {
 			return nil, fmt.Errorf("error scanning object: %v", err)
 		}
+		if drv.skipsObject(config.dataSet, objectName, objectType, objectSubtype) {
+			continue
+		}
 
 		buf.WriteString(ddl + "\n")
 	}
@@ -220,6 +225,23 @@ func (drv *Driver) schemaDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// skipsObject returns true if an object should be left out of the schema dump
+func (drv *Driver) skipsObject(dataSet, name, objectType, subtype string) bool {
+	if objectType == "FUNCTION" {
+		return drv.dumpOptions.Skips(dbmate.DumpSkipFunctions)
+	}
+	if strings.HasSuffix(subtype, "VIEW") && drv.dumpOptions.Skips(dbmate.DumpSkipViews) {
+		return true
+	}
+
+	return drv.dumpOptions.ExcludesTable(dataSet, name)
+}
+
+// DumpMigrations returns the versions recorded in the migrations table, as in DumpSchema
+func (drv *Driver) DumpMigrations(db *sql.DB) ([]byte, error) {
+	return drv.schemaMigrationsDump(db)
+}
+
 func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	migrationsTable := drv.migrationsTableName
 
@@ -250,6 +272,10 @@ func (drv *Driver) DumpSchema(db *sql.DB, _ ...string) ([]byte, error) {
 		return nil, err
 	}
 
+	if drv.dumpOptions.NoMigrationsData {
+		return schema, nil
+	}
+
 	migrations, err := drv.schemaMigrationsDump(db)
 	if err != nil {
 		return nil, err
@@ -284,19 +310,8 @@ func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
 }
 
 func (drv *Driver) DeleteMigration(tx dbutil.Transaction, version string) error {
-	db, err := drv.Open()
-	if err != nil {
-		return err
-	}
-	defer dbutil.MustClose(db)
-
-	config, err := drv.getConfig(db)
-	if err != nil {
-		return err
-	}
-
-	query := fmt.Sprintf("DELETE FROM %s.%s WHERE version = ?;", config.dataSet, drv.migrationsTableName)
-	_, err = tx.Exec(query, version)
+	query := fmt.Sprintf("DELETE FROM %s.%s WHERE version = ?;", drv.dataSet(), drv.migrationsTableName)
+	_, err := tx.Exec(query, version)
 	if err != nil {
 		return err
 	}
@@ -305,24 +320,26 @@ func (drv *Driver) DeleteMigration(tx dbutil.Transaction, version string) error
 }
 
 func (drv *Driver) InsertMigration(tx dbutil.Transaction, version string) error {
-	db, err := drv.Open()
+	query := fmt.Sprintf("INSERT INTO %s.%s (version) VALUES (?);", drv.dataSet(), drv.migrationsTableName)
+	_, err := tx.Exec(query, version)
 	if err != nil {
 		return err
 	}
-	defer dbutil.MustClose(db)
 
-	config, err := drv.getConfig(db)
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
-	query := fmt.Sprintf("INSERT INTO %s.%s (version) VALUES (?);", config.dataSet, drv.migrationsTableName)
-	_, err = tx.Exec(query, version)
-	if err != nil {
-		return err
-	}
+// dataSet returns the dataset from the database URL, which is the last element of the path
+// (parsed in the same way as go-gorm/bigquery), without opening a connection
+func (drv *Driver) dataSet() string {
+	fields := strings.Split(strings.TrimPrefix(drv.databaseURL.Path, "/"), "/")
+	return fields[len(fields)-1]
+}
 
-	return nil
+// TransactionalDDL returns false, since BigQuery transactions opened through database/sql
+// do not roll anything back
+func (drv *Driver) TransactionalDDL() bool {
+	return false
 }
 
 func (drv *Driver) Open() (*sql.DB, error) {
@@ -343,6 +360,15 @@ func (*Driver) QueryError(query string, err error) error {
 	return &dbmate.QueryError{Err: err, Query: query}
 }
 
+// StatementSyntax returns the syntax used to split schema files into statements
+func (*Driver) StatementSyntax() dbutil.StatementSyntax {
+	return dbutil.StatementSyntax{
+		BackslashEscapes: true,
+		TripleQuotes:     true,
+		HashComments:     true,
+	}
+}
+
 func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
 	config, err := drv.getConfig(db)
 	if err != nil {
@@ -114,6 +114,24 @@ func TestGetConfig(t *testing.T) {
 	require.Equal(t, "dbmate_test", config.dataSet)
 }
 
+func TestDataSet(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"bigquery://projectid/dataset", "dataset"},
+		{"bigquery://projectid/location/dataset", "dataset"},
+		{"bigquery://projectid/location/dataset?disable_auth=true", "dataset"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, c.input)}).(*Driver)
+			require.Equal(t, c.expected, drv.dataSet())
+		})
+	}
+}
+
 func TestConnectionString(t *testing.T) {
 	cases := []struct {
 		input    string
@@ -28,6 +28,7 @@ type Driver struct {
 	databaseURL         *url.URL
 	log                 io.Writer
 	clusterParameters   *ClusterParameters
+	dumpOptions         dbmate.DumpOptions
 }
 
 // NewDriver initializes the driver
@@ -37,6 +38,7 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 		databaseURL:         config.DatabaseURL,
 		log:                 config.Log,
 		clusterParameters:   ExtractClusterParametersFromURL(config.DatabaseURL),
+		dumpOptions:         config.DumpOptions,
 	}
 }
 
@@ -199,7 +201,23 @@ func (drv *Driver) schemaDump(db *sql.DB, buf *bytes.Buffer) error {
 	}
 	sort.Strings(tables)
 
+	views := map[string]bool{}
+	if drv.dumpOptions.Skips(dbmate.DumpSkipViews) {
+		names, err := dbutil.QueryColumn(db, "select name from system.tables "+
+			"where database = currentDatabase() and engine like '%View'")
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			views[name] = true
+		}
+	}
+
 	for _, table := range tables {
+		if views[table] || drv.dumpOptions.ExcludesTable(drv.databaseName(), table) {
+			continue
+		}
+
 		var clause string
 		err = db.QueryRow("show create table " + drv.quoteIdentifier(table)).Scan(&clause)
 		if err != nil {
@@ -210,6 +228,13 @@ func (drv *Driver) schemaDump(db *sql.DB, buf *bytes.Buffer) error {
 	return nil
 }
 
+// DumpMigrations returns the versions recorded in the migrations table, as in DumpSchema
+func (drv *Driver) DumpMigrations(db *sql.DB) ([]byte, error) {
+	var buf bytes.Buffer
+	err := drv.schemaMigrationsDump(db, &buf)
+	return buf.Bytes(), err
+}
+
 func (drv *Driver) schemaMigrationsDump(db *sql.DB, buf *bytes.Buffer) error {
 	migrationsTable := drv.quotedMigrationsTableName()
 
@@ -240,6 +265,44 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB, buf *bytes.Buffer) error {
 	return nil
 }
 
+// dataDumpSyntax formats rows as lists of literals. formatRowNoNewline returns each row as a
+// tuple, e.g. (1,'a'), so the parentheses are removed.
+var dataDumpSyntax = dbutil.DataDumpSyntax{
+	RowSQL: func(values []string) string {
+		row := "formatRowNoNewline('Values', " + strings.Join(values, ", ") + ")"
+		return "substring(" + row + ", 2, length(" + row + ") - 2)"
+	},
+	Indent: "    ",
+}
+
+// tableDataDump writes INSERT statements for the rows of each table in DumpOptions.DataTables
+func (drv *Driver) tableDataDump(db *sql.DB, buf *bytes.Buffer) error {
+	data, err := dataDumpSyntax.DumpTableData(db, drv.dumpOptions.DataTables, func(table string) (dbutil.DataTable, error) {
+		// materialized and alias columns cannot be inserted
+		columns, err := dbutil.QueryColumn(db, `select name from system.columns
+			where database = ? and table = ? and default_kind not in ('MATERIALIZED', 'ALIAS')
+			order by position`, drv.databaseName(), table)
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+
+		data := dbutil.DataTable{
+			Name:   drv.quotedDatabaseName() + "." + drv.quoteIdentifier(table),
+			Header: fmt.Sprintf("\n--\n-- Data for table %s\n--\n\n", table),
+		}
+		for _, column := range columns {
+			column = drv.quoteIdentifier(column)
+			data.Columns = append(data.Columns, column)
+			data.Values = append(data.Values, column)
+		}
+
+		return data, nil
+	})
+	buf.Write(data)
+
+	return err
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB, _ ...string) ([]byte, error) {
 	var buf bytes.Buffer
@@ -250,11 +313,18 @@ func (drv *Driver) DumpSchema(db *sql.DB, _ ...string) ([]byte, error) {
 		return nil, err
 	}
 
-	err = drv.schemaMigrationsDump(db, &buf)
+	err = drv.tableDataDump(db, &buf)
 	if err != nil {
 		return nil, err
 	}
 
+	if !drv.dumpOptions.NoMigrationsData {
+		err = drv.schemaMigrationsDump(db, &buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return buf.Bytes(), nil
 }
 
@@ -388,11 +458,35 @@ func (drv *Driver) Ping() error {
 	return err
 }
 
+// ServerVersion returns the version of the database server
+func (drv *Driver) ServerVersion() (string, error) {
+	db, err := drv.openClickHouseDB()
+	if err != nil {
+		return "", err
+	}
+	defer dbutil.MustClose(db)
+
+	return dbutil.QueryValue(db, "select version()")
+}
+
+// TransactionalDDL returns false, since ClickHouse does not roll back transactions
+func (drv *Driver) TransactionalDDL() bool {
+	return false
+}
+
 // Return a normalized version of the driver-specific error type.
 func (drv *Driver) QueryError(query string, err error) error {
 	return &dbmate.QueryError{Err: err, Query: query}
 }
 
+// StatementSyntax returns the syntax used to split schema files into statements
+func (drv *Driver) StatementSyntax() dbutil.StatementSyntax {
+	return dbutil.StatementSyntax{
+		BackslashEscapes: true,
+		HashComments:     true,
+	}
+}
+
 func (drv *Driver) quotedMigrationsTableName() string {
 	return drv.quoteIdentifier(drv.migrationsTableName)
 }
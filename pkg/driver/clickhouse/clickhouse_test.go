@@ -174,6 +174,32 @@ func TestClickHouseDumpSchema(t *testing.T) {
 	require.EqualError(t, err, "code: 81, message: Database fakedb doesn't exist")
 }
 
+func TestClickHouseDumpSchemaDataTables(t *testing.T) {
+	drv := testClickHouseDriver(t)
+	drv.dumpOptions = dbmate.DumpOptions{DataTables: []string{"countries"}}
+
+	// prepare database
+	db := prepTestClickHouseDB(t, drv)
+	defer dbutil.MustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	_, err = db.Exec("create table countries (code String, name Nullable(String), " +
+		"upper_code String materialized upper(code)) engine = MergeTree order by code")
+	require.NoError(t, err)
+	_, err = db.Exec("insert into countries (code, name) values ('nz', 'New Zealand'), ('au', 'Australia'), ('xx', null)")
+	require.NoError(t, err)
+
+	// rows are sorted, and materialized columns are left out
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "-- Data for table countries\n"+
+		"--\n\n"+
+		"INSERT INTO "+drv.databaseName()+".countries (code, name) VALUES\n"+
+		"    ('au','Australia'),\n"+
+		"    ('nz','New Zealand'),\n"+
+		"    ('xx',NULL);\n")
+}
+
 func TestClickHouseDatabaseExists(t *testing.T) {
 	drv := testClickHouseDriver(t)
 
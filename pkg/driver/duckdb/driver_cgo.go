@@ -0,0 +1,10 @@
+//go:build cgo && ((linux && (amd64 || arm64)) || (darwin && (amd64 || arm64)) || (windows && amd64))
+
+package duckdb
+
+import (
+	_ "github.com/duckdb/duckdb-go/v2" // database/sql driver
+)
+
+// sqlDriverName is the database/sql driver registered by duckdb-go
+const sqlDriverName = "duckdb"
@@ -0,0 +1,7 @@
+//go:build !(cgo && ((linux && (amd64 || arm64)) || (darwin && (amd64 || arm64)) || (windows && amd64)))
+
+package duckdb
+
+// sqlDriverName is empty when DuckDB is not linked into dbmate, which requires cgo and a
+// platform with prebuilt DuckDB libraries
+const sqlDriverName = ""
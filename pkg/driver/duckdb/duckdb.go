@@ -0,0 +1,315 @@
+package duckdb
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/lib/pq"
+)
+
+// ErrNotLinked is returned when dbmate was built without DuckDB
+var ErrNotLinked = errors.New("duckdb is not supported by this build of dbmate (it requires cgo on " +
+	"linux/amd64, linux/arm64, darwin/amd64, darwin/arm64, or windows/amd64)")
+
+func init() {
+	dbmate.RegisterDriver(NewDriver, "duckdb")
+}
+
+// Driver provides top level database functions
+type Driver struct {
+	migrationsTableName string
+	databaseURL         *url.URL
+	log                 io.Writer
+	dumpOptions         dbmate.DumpOptions
+}
+
+// NewDriver initializes the driver
+func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
+	return &Driver{
+		migrationsTableName: config.MigrationsTableName,
+		databaseURL:         config.DatabaseURL,
+		log:                 config.Log,
+		dumpOptions:         config.DumpOptions,
+	}
+}
+
+// filePathFromURL returns the database file, using the same rules as the sqlite driver:
+// duckdb:db/warehouse.duckdb is relative to the current directory, while
+// duckdb:/tmp/warehouse.duckdb and duckdb://tmp/warehouse.duckdb are absolute
+func filePathFromURL(u *url.URL) string {
+	var raw string
+	switch {
+	case u.Opaque != "":
+		raw = u.Opaque
+	case u.Host != "":
+		raw = "/" + u.Host + u.Path
+	default:
+		raw = u.Path
+	}
+
+	if p, err := url.PathUnescape(raw); err == nil {
+		raw = p
+	}
+
+	// collapse runs of leading slashes: ////tmp/foo -> /tmp/foo
+	for len(raw) > 1 && raw[0] == '/' && raw[1] == '/' {
+		raw = raw[1:]
+	}
+
+	return filepath.FromSlash(raw)
+}
+
+// connectionString returns the connection string passed to sql.Open, which includes
+// configuration options from the URL query, e.g. duckdb:warehouse.duckdb?threads=4
+func connectionString(u *url.URL) string {
+	s := filePathFromURL(u)
+	if u.RawQuery != "" {
+		s += "?" + u.RawQuery
+	}
+
+	return s
+}
+
+// Open creates a new database connection
+func (drv *Driver) Open() (*sql.DB, error) {
+	if sqlDriverName == "" {
+		return nil, ErrNotLinked
+	}
+
+	return sql.Open(sqlDriverName, connectionString(drv.databaseURL))
+}
+
+// CreateDatabase creates the specified database
+func (drv *Driver) CreateDatabase() error {
+	fmt.Fprintf(drv.log, "Creating: %s\n", filePathFromURL(drv.databaseURL))
+
+	db, err := drv.Open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	return db.Ping()
+}
+
+// DropDatabase drops the specified database (if it exists), along with its write-ahead log
+func (drv *Driver) DropDatabase() error {
+	path := filePathFromURL(drv.databaseURL)
+	fmt.Fprintf(drv.log, "Dropping: %s\n", path)
+
+	exists, err := drv.DatabaseExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	if err := os.Remove(path + ".wal"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// DumpMigrations returns the versions recorded in the migrations table, as in DumpSchema
+func (drv *Driver) DumpMigrations(db *sql.DB) ([]byte, error) {
+	return drv.schemaMigrationsDump(db)
+}
+
+func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
+	migrationsTable := drv.quotedMigrationsTableName()
+
+	// load applied migrations
+	migrations, err := dbutil.QueryColumn(db,
+		fmt.Sprintf("select version from %s order by version asc", migrationsTable))
+	if err != nil {
+		return nil, err
+	}
+
+	// build schema migrations table data
+	var buf bytes.Buffer
+	buf.WriteString("-- Dbmate schema migrations\n")
+
+	if len(migrations) > 0 {
+		for i := range migrations {
+			migrations[i] = quoteLiteral(migrations[i])
+		}
+		buf.WriteString(
+			fmt.Sprintf("INSERT INTO %s (version) VALUES\n  (", migrationsTable) +
+				strings.Join(migrations, "),\n  (") +
+				");\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DumpSchema returns the current database schema. DuckDB is linked into dbmate, so the
+// schema is always read from the database catalog.
+func (drv *Driver) DumpSchema(db *sql.DB, _ ...string) ([]byte, error) {
+	schema, err := drv.nativeDumpSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := drv.tableDataDump(db)
+	if err != nil {
+		return nil, err
+	}
+	schema = append(schema, data...)
+
+	if !drv.dumpOptions.NoMigrationsData {
+		migrations, err := drv.schemaMigrationsDump(db)
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, migrations...)
+	}
+
+	return schema, nil
+}
+
+// DatabaseExists determines whether the database exists
+func (drv *Driver) DatabaseExists() (bool, error) {
+	_, err := os.Stat(filePathFromURL(drv.databaseURL))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MigrationsTableExists checks if the schema_migrations table exists
+func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
+	exists := false
+	err := db.QueryRow("select true from duckdb_tables() "+
+		"where database_name = current_database() and schema_name = current_schema() and table_name = ?",
+		drv.migrationsTableName).
+		Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return exists, err
+}
+
+// CreateMigrationsTable creates the schema migrations table
+func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"create table if not exists %s (version varchar primary key)",
+		drv.quotedMigrationsTableName()))
+
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
+	query := fmt.Sprintf("select version from %s order by version desc", drv.quotedMigrationsTableName())
+	if limit >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, limit)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer dbutil.MustClose(rows)
+
+	migrations := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		migrations[version] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// InsertMigration adds a new migration record
+func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version) values (?)", drv.quotedMigrationsTableName()),
+		version)
+
+	return err
+}
+
+// DeleteMigration removes a migration record
+func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("delete from %s where version = ?", drv.quotedMigrationsTableName()),
+		version)
+
+	return err
+}
+
+// Ping verifies a connection to the database. Like SQLite, DuckDB creates the database
+// file if it does not already exist.
+func (drv *Driver) Ping() error {
+	db, err := drv.Open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	return db.Ping()
+}
+
+// Return a normalized version of the driver-specific error type.
+func (drv *Driver) QueryError(query string, err error) error {
+	return &dbmate.QueryError{Err: err, Query: query}
+}
+
+// StatementSyntax returns the syntax used to split schema files into statements
+func (drv *Driver) StatementSyntax() dbutil.StatementSyntax {
+	return dbutil.StatementSyntax{
+		EscapeStrings: true,
+		DollarQuotes:  true,
+	}
+}
+
+func (drv *Driver) quotedMigrationsTableName() string {
+	return quoteIdentifier(drv.migrationsTableName)
+}
+
+// quoteIdentifier quotes a table or column name. DuckDB uses the ANSI standard (double
+// quotes), so we use the lib/pq implementation.
+func quoteIdentifier(s string) string {
+	return pq.QuoteIdentifier(s)
+}
+
+// quoteLiteral quotes a string. Backslashes have no special meaning in DuckDB strings.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// qualifiedName quotes the name of an object, prefixed by its schema unless it is in the
+// default schema
+func qualifiedName(schema, name string) string {
+	if schema == "main" {
+		return quoteIdentifier(name)
+	}
+
+	return quoteIdentifier(schema) + "." + quoteIdentifier(name)
+}
@@ -0,0 +1,452 @@
+//go:build cgo && ((linux && (amd64 || arm64)) || (darwin && (amd64 || arm64)) || (windows && amd64))
+
+package duckdb
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbtest"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testDuckDBDriver(t *testing.T) *Driver {
+	u := dbtest.MustParseURL(t, "duckdb:"+filepath.ToSlash(filepath.Join(t.TempDir(), "dbmate_test.duckdb")))
+	drv, err := dbmate.New(u).Driver()
+	require.NoError(t, err)
+
+	return drv.(*Driver)
+}
+
+func prepTestDuckDB(t *testing.T, drv *Driver) *sql.DB {
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// connect database
+	db, err := drv.Open()
+	require.NoError(t, err)
+
+	return db
+}
+
+func execStatements(t *testing.T, db *sql.DB, statements ...string) {
+	for _, statement := range statements {
+		_, err := db.Exec(statement)
+		require.NoError(t, err, statement)
+	}
+}
+
+func TestGetDriver(t *testing.T) {
+	db := dbmate.New(dbtest.MustParseURL(t, "duckdb:warehouse.duckdb"))
+	drvInterface, err := db.Driver()
+	require.NoError(t, err)
+
+	// driver should have URL and default migrations table set
+	drv, ok := drvInterface.(*Driver)
+	require.True(t, ok)
+	require.Equal(t, db.DatabaseURL.String(), drv.databaseURL.String())
+	require.Equal(t, "schema_migrations", drv.migrationsTableName)
+}
+
+func TestConnectionString(t *testing.T) {
+	tests := []struct {
+		url, path, connection string
+	}{
+		{"duckdb:db/warehouse.duckdb", "db/warehouse.duckdb", "db/warehouse.duckdb"},
+		{"duckdb:./warehouse.duckdb?threads=4", "./warehouse.duckdb", "./warehouse.duckdb?threads=4"},
+		{"duckdb:/tmp/warehouse.duckdb", "/tmp/warehouse.duckdb", "/tmp/warehouse.duckdb"},
+		{"duckdb://tmp/warehouse.duckdb", "/tmp/warehouse.duckdb", "/tmp/warehouse.duckdb"},
+		{"duckdb:///tmp/warehouse.duckdb", "/tmp/warehouse.duckdb", "/tmp/warehouse.duckdb"},
+		{"duckdb:/tmp/my%20warehouse.duckdb?access_mode=READ_ONLY",
+			"/tmp/my warehouse.duckdb", "/tmp/my warehouse.duckdb?access_mode=READ_ONLY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u := dbtest.MustParseURL(t, tt.url)
+			require.Equal(t, filepath.FromSlash(tt.path), filePathFromURL(u))
+			require.Equal(t, filepath.FromSlash(tt.path)+strings.TrimPrefix(tt.connection, tt.path), connectionString(u))
+		})
+	}
+}
+
+func TestDuckDBCreateDropDatabase(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	path := filePathFromURL(drv.databaseURL)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// check that database exists
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	// drop the database
+	err = drv.DropDatabase()
+	require.NoError(t, err)
+
+	// check that database no longer exists
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path + ".wal")
+	require.True(t, os.IsNotExist(err))
+}
+
+// testSchema creates objects of each type, with names chosen so that sorting by name
+// would create them in the wrong order
+var testSchema = []string{
+	"CREATE SCHEMA staging",
+	"CREATE TYPE mood AS ENUM ('happy', 'it''s ok')",
+	"CREATE TYPE staging.point AS STRUCT(x DOUBLE, y DOUBLE)",
+	"CREATE SEQUENCE order_ids START 100",
+	"CREATE TABLE customers (id INTEGER PRIMARY KEY, name VARCHAR NOT NULL, feeling mood)",
+	"CREATE TABLE addresses (id INTEGER PRIMARY KEY, customer_id INTEGER REFERENCES customers (id))",
+	"CREATE TABLE orders (id INTEGER PRIMARY KEY DEFAULT nextval('order_ids'), " +
+		"customer_id INTEGER REFERENCES customers (id), total DECIMAL(10, 2), " +
+		"total_with_tax DECIMAL(10, 2) GENERATED ALWAYS AS (total * 1.2) VIRTUAL)",
+	"CREATE INDEX orders_customer_id_idx ON orders (customer_id)",
+	"CREATE TABLE staging.raw_orders (payload JSON, location staging.point)",
+	"CREATE MACRO add_tax(amount) AS amount * 1.2, (amount, rate) AS amount * rate",
+	"CREATE MACRO recent_orders(n) AS TABLE SELECT * FROM orders ORDER BY id DESC LIMIT n",
+	"CREATE VIEW z_orders AS SELECT * FROM orders",
+	"CREATE VIEW b_orders AS SELECT * FROM orders",
+	"CREATE VIEW a_orders AS SELECT * FROM z_orders",
+}
+
+func TestDuckDBDumpSchema(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	// prepare database
+	db := prepTestDuckDB(t, drv)
+	defer dbutil.MustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	execStatements(t, db, testSchema...)
+
+	// insert migrations
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc2")
+	require.NoError(t, err)
+
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE SCHEMA "staging";
+CREATE TYPE "mood" AS ENUM('happy', 'it''s ok');
+CREATE TYPE "staging"."point" AS STRUCT(x DOUBLE, y DOUBLE);
+CREATE SEQUENCE "order_ids" INCREMENT BY 1 MINVALUE 1 MAXVALUE 9223372036854775807 START 100 NO CYCLE;
+CREATE TABLE customers(id INTEGER PRIMARY KEY, "name" VARCHAR NOT NULL, feeling ENUM('happy', 'it''s ok'));
+CREATE TABLE addresses(id INTEGER PRIMARY KEY, customer_id INTEGER, FOREIGN KEY (customer_id) REFERENCES customers(id));
+CREATE TABLE orders(id INTEGER DEFAULT(nextval('order_ids')) PRIMARY KEY, customer_id INTEGER, total DECIMAL(10,2), total_with_tax DECIMAL(10,2) GENERATED ALWAYS AS((total * 1.2)), FOREIGN KEY (customer_id) REFERENCES customers(id));
+CREATE TABLE test_migrations("version" VARCHAR PRIMARY KEY);
+CREATE TABLE staging.raw_orders(payload JSON, "location" STRUCT(x DOUBLE, y DOUBLE));
+CREATE INDEX orders_customer_id_idx ON orders(customer_id);
+CREATE MACRO "add_tax"(amount) AS (amount * 1.2),
+  (amount, rate) AS (amount * rate);
+CREATE MACRO "recent_orders"(n) AS TABLE SELECT * FROM orders ORDER BY id DESC LIMIT n;
+CREATE VIEW z_orders AS SELECT * FROM orders;
+CREATE VIEW a_orders AS SELECT * FROM z_orders;
+CREATE VIEW b_orders AS SELECT * FROM orders;
+-- Dbmate schema migrations
+INSERT INTO "test_migrations" (version) VALUES
+  ('abc1'),
+  ('abc2');
+`, string(schema))
+
+	// the dump can be loaded into an empty database, and dumps the same schema
+	path := filepath.Join(t.TempDir(), "schema.sql")
+	err = os.WriteFile(path, schema, 0o644)
+	require.NoError(t, err)
+	loaded := dbmate.New(dbtest.MustParseURL(t, "duckdb:"+filepath.ToSlash(filepath.Join(t.TempDir(), "loaded.duckdb"))))
+	loaded.SchemaFile = path
+	loaded.MigrationsTableName = "test_migrations"
+	loaded.Log = &strings.Builder{}
+	err = loaded.LoadSchema()
+	require.NoError(t, err)
+
+	loadedDrv, err := loaded.Driver()
+	require.NoError(t, err)
+	loadedDB, err := loadedDrv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(loadedDB)
+	loadedSchema, err := loadedDrv.DumpSchema(loadedDB)
+	require.NoError(t, err)
+	require.Equal(t, string(schema), string(loadedSchema))
+}
+
+func TestDuckDBDumpSchemaFilters(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	db := prepTestDuckDB(t, drv)
+	defer dbutil.MustClose(db)
+	execStatements(t, db, testSchema...)
+
+	drv.dumpOptions = dbmate.DumpOptions{
+		ExcludeTables:    []string{"a*", "staging.*"},
+		Skip:             []string{dbmate.DumpSkipIndexes, dbmate.DumpSkipFunctions, dbmate.DumpSkipTypes},
+		NoMigrationsData: true,
+	}
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "CREATE TABLE customers(")
+	require.Contains(t, string(schema), "CREATE VIEW z_orders ")
+	require.Contains(t, string(schema), "CREATE SEQUENCE ")
+	for _, s := range []string{"addresses", "a_orders", "raw_orders", "CREATE INDEX", "CREATE MACRO", "CREATE TYPE", "Dbmate"} {
+		require.NotContains(t, string(schema), s)
+	}
+
+	drv.dumpOptions = dbmate.DumpOptions{
+		IncludeSchemas:   []string{"staging"},
+		NoMigrationsData: true,
+	}
+	schema, err = drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE SCHEMA "staging";
+CREATE TYPE "staging"."point" AS STRUCT(x DOUBLE, y DOUBLE);
+CREATE TABLE staging.raw_orders(payload JSON, "location" STRUCT(x DOUBLE, y DOUBLE));
+`, string(schema))
+}
+
+func TestDuckDBDumpSchemaDataTables(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	db := prepTestDuckDB(t, drv)
+	defer dbutil.MustClose(db)
+	execStatements(t, db, testSchema...)
+	execStatements(t, db,
+		"INSERT INTO customers VALUES (2, 'O''Brien', NULL), (1, 'Smith', 'happy')",
+		"INSERT INTO orders (customer_id, total) VALUES (1, 10.5)",
+	)
+
+	drv.dumpOptions = dbmate.DumpOptions{
+		DataTables:       []string{"customers", "main.orders", "staging.raw_orders"},
+		NoMigrationsData: true,
+	}
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), `CREATE VIEW b_orders AS SELECT * FROM orders;
+-- Data for table customers
+INSERT INTO "customers" ("id", "name", "feeling") VALUES
+  ('1', 'Smith', 'happy'),
+  ('2', 'O''Brien', NULL);
+-- Data for table main.orders
+INSERT INTO "orders" ("id", "customer_id", "total") VALUES
+  ('100', '1', '10.50');
+-- Data for table staging.raw_orders
+`)
+
+	// values are cast to the column types when the schema is loaded
+	path := filepath.Join(t.TempDir(), "schema.sql")
+	err = os.WriteFile(path, schema, 0o644)
+	require.NoError(t, err)
+	loaded := dbmate.New(dbtest.MustParseURL(t, "duckdb:"+filepath.ToSlash(filepath.Join(t.TempDir(), "loaded.duckdb"))))
+	loaded.SchemaFile = path
+	loaded.Log = &strings.Builder{}
+	err = loaded.LoadSchema()
+	require.NoError(t, err)
+
+	loadedDrv, err := loaded.Driver()
+	require.NoError(t, err)
+	loadedDB, err := loadedDrv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(loadedDB)
+	var total float64
+	err = loadedDB.QueryRow("select total_with_tax from orders where id = 100").Scan(&total)
+	require.NoError(t, err)
+	require.Equal(t, 12.6, total)
+
+	drv.dumpOptions.DataTables = []string{"missing"}
+	_, err = drv.DumpSchema(db)
+	require.EqualError(t, err, "unable to dump data for table missing: table does not exist")
+}
+
+func TestIsGeneratedColumn(t *testing.T) {
+	tableSQL := `CREATE TABLE t(a INTEGER, "name" VARCHAR GENERATED ALWAYS AS(upper(b)), ` +
+		`b VARCHAR, xa INTEGER GENERATED ALWAYS AS((a + 1)));`
+	require.False(t, isGeneratedColumn(tableSQL, "a", "INTEGER"))
+	require.True(t, isGeneratedColumn(tableSQL, "name", "VARCHAR"))
+	require.False(t, isGeneratedColumn(tableSQL, "b", "VARCHAR"))
+	require.True(t, isGeneratedColumn(tableSQL, "xa", "INTEGER"))
+	require.True(t, isGeneratedColumn(`CREATE TABLE t(a INTEGER GENERATED ALWAYS AS(1));`, "a", "INTEGER"))
+}
+
+func TestSortObjects(t *testing.T) {
+	tables := []dumpObject{
+		{schema: "main", name: "a"},
+		{schema: "main", name: "b"},
+		{schema: "main", name: "c"},
+		{schema: "main", name: "d"},
+	}
+	references := map[string][]string{
+		"main.a": {"main.c", "main.missing"},
+		"main.c": {"main.b", "main.d"},
+		"main.d": {"main.c"},
+	}
+
+	names := []string{}
+	for _, table := range sortObjects(tables, references) {
+		names = append(names, table.name)
+	}
+	require.Equal(t, []string{"b", "d", "c", "a"}, names)
+}
+
+func TestViewReferences(t *testing.T) {
+	views := []dumpObject{
+		{schema: "main", name: "a", sql: "CREATE VIEW a AS SELECT * FROM \"B\" JOIN s.c USING (id);"},
+		{schema: "main", name: "b", sql: "CREATE VIEW b AS SELECT * FROM bb;"},
+		{schema: "s", name: "c", sql: "CREATE VIEW s.c AS SELECT 1 AS id;"},
+	}
+	require.Equal(t, map[string][]string{"main.a": {"main.b", "s.c"}}, viewReferences(views))
+}
+
+func TestDuckDBDatabaseExists(t *testing.T) {
+	drv := testDuckDBDriver(t)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// DatabaseExists should return false
+	exists, err := drv.DatabaseExists()
+	require.NoError(t, err)
+	require.Equal(t, false, exists)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// DatabaseExists should return true
+	exists, err = drv.DatabaseExists()
+	require.NoError(t, err)
+	require.Equal(t, true, exists)
+}
+
+func TestDuckDBCreateMigrationsTable(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestDuckDB(t, drv)
+	defer dbutil.MustClose(db)
+
+	// migrations table should not exist
+	exists, err := drv.MigrationsTableExists(db)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// create table
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// migrations table should exist
+	exists, err = drv.MigrationsTableExists(db)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// create table should be idempotent
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+}
+
+func TestDuckDBSelectMigrations(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestDuckDB(t, drv)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`insert into test_migrations (version)
+		values ('abc2'), ('abc1'), ('abc3')`)
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true, "abc2": true, "abc3": true}, migrations)
+
+	// test limit param
+	migrations, err = drv.SelectMigrations(db, 1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc3": true}, migrations)
+}
+
+func TestDuckDBInsertDeleteMigration(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestDuckDB(t, drv)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// insert migrations
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc2")
+	require.NoError(t, err)
+
+	// delete migration
+	err = drv.DeleteMigration(db, "abc1")
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc2": true}, migrations)
+}
+
+func TestDuckDBTransactionalDDL(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	db := prepTestDuckDB(t, drv)
+	defer dbutil.MustClose(db)
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("CREATE TABLE rolled_back (id INTEGER)")
+	require.NoError(t, err)
+	err = tx.Rollback()
+	require.NoError(t, err)
+
+	var count int
+	err = db.QueryRow("select count(*) from duckdb_tables() where table_name = 'rolled_back'").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
+func TestDuckDBPing(t *testing.T) {
+	drv := testDuckDBDriver(t)
+	path := filePathFromURL(drv.databaseURL)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// ping database
+	err = drv.Ping()
+	require.NoError(t, err)
+
+	// check that the database was created (DuckDB-specific behavior)
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	// ping should fail if the path is a directory
+	drv.databaseURL = dbtest.MustParseURL(t, "duckdb:"+filepath.ToSlash(t.TempDir()))
+	err = drv.Ping()
+	require.Error(t, err)
+}
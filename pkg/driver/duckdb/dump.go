@@ -0,0 +1,435 @@
+package duckdb
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// nativeDumpSchema dumps the schema from the duckdb_*() catalog functions, in an order which
+// can be loaded: schemas, types, sequences, tables, indexes, macros, and views. Temporary
+// and internal objects are skipped.
+func (drv *Driver) nativeDumpSchema(db *sql.DB) ([]byte, error) {
+	var buf bytes.Buffer
+	dumps := []func(*sql.DB, *bytes.Buffer) error{
+		drv.dumpSchemas,
+		drv.dumpTypes,
+		drv.dumpSequences,
+		drv.dumpTables,
+		drv.dumpIndexes,
+		drv.dumpMacros,
+		drv.dumpViews,
+	}
+	for _, dump := range dumps {
+		if err := dump(db, &buf); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// dumpSchemas writes CREATE SCHEMA statements, except for the default schema
+func (drv *Driver) dumpSchemas(db *sql.DB, buf *bytes.Buffer) error {
+	schemas, err := dbutil.QueryColumn(db, `select schema_name from duckdb_schemas()
+		where database_name = current_database() and not internal
+		order by schema_name`)
+	if err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		if drv.dumpOptions.IncludesSchema(schema) {
+			fmt.Fprintf(buf, "CREATE SCHEMA %s;\n", quoteIdentifier(schema))
+		}
+	}
+
+	return nil
+}
+
+// dumpTypes writes CREATE TYPE statements. The catalog does not contain the definition of
+// each type, but typeof() returns it for a value of the type.
+func (drv *Driver) dumpTypes(db *sql.DB, buf *bytes.Buffer) error {
+	if drv.dumpOptions.Skips(dbmate.DumpSkipTypes) {
+		return nil
+	}
+
+	rows, err := db.Query(`select schema_name, type_name from duckdb_types()
+		where database_name = current_database() and not internal
+		order by schema_name, type_name`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	names := []string{}
+	for rows.Next() {
+		var schema, name string
+		if err := rows.Scan(&schema, &name); err != nil {
+			return err
+		}
+		if drv.dumpOptions.IncludesSchema(schema) {
+			names = append(names, qualifiedName(schema, name))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		var definition string
+		if err := db.QueryRow("select typeof(cast(null as " + name + "))").Scan(&definition); err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "CREATE TYPE %s AS %s;\n", name, definition)
+	}
+
+	return nil
+}
+
+// dumpSequences writes CREATE SEQUENCE statements. The sql column of duckdb_sequences()
+// leaves out the schema, so statements are built from the sequence options.
+func (drv *Driver) dumpSequences(db *sql.DB, buf *bytes.Buffer) error {
+	if drv.dumpOptions.Skips(dbmate.DumpSkipSequences) {
+		return nil
+	}
+
+	rows, err := db.Query(`select schema_name, sequence_name, increment_by, min_value, max_value,
+			start_value, cycle
+		from duckdb_sequences()
+		where database_name = current_database() and not temporary
+		order by schema_name, sequence_name`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var schema, name string
+		var increment, minValue, maxValue, start int64
+		var cycle bool
+		if err := rows.Scan(&schema, &name, &increment, &minValue, &maxValue, &start, &cycle); err != nil {
+			return err
+		}
+		if !drv.dumpOptions.IncludesSchema(schema) {
+			continue
+		}
+
+		cycleOption := "NO CYCLE"
+		if cycle {
+			cycleOption = "CYCLE"
+		}
+		fmt.Fprintf(buf, "CREATE SEQUENCE %s INCREMENT BY %d MINVALUE %d MAXVALUE %d START %d %s;\n",
+			qualifiedName(schema, name), increment, minValue, maxValue, start, cycleOption)
+	}
+
+	return rows.Err()
+}
+
+// dumpObject is a table or view in the schema dump
+type dumpObject struct {
+	schema, name, sql string
+}
+
+// key identifies a table or view in references
+func (o dumpObject) key() string {
+	return o.schema + "." + o.name
+}
+
+// dumpTables writes CREATE TABLE statements, sorted by name, except that tables are moved
+// after the tables their foreign keys refer to. Table OIDs change when a foreign key
+// refers to a table, so they cannot be used to find the order the tables were created in.
+func (drv *Driver) dumpTables(db *sql.DB, buf *bytes.Buffer) error {
+	rows, err := db.Query(`select schema_name, table_name, sql from duckdb_tables()
+		where database_name = current_database() and not internal and not temporary
+		order by schema_name, table_name`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	tables := []dumpObject{}
+	for rows.Next() {
+		var table dumpObject
+		if err := rows.Scan(&table.schema, &table.name, &table.sql); err != nil {
+			return err
+		}
+		if drv.dumpOptions.IncludesSchema(table.schema) && !drv.dumpOptions.ExcludesTable(table.schema, table.name) {
+			tables = append(tables, table)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// foreign keys can only refer to tables in the same schema
+	references := map[string][]string{}
+	rows, err = db.Query(`select distinct schema_name, table_name, referenced_table from duckdb_constraints()
+		where database_name = current_database() and constraint_type = 'FOREIGN KEY'
+		order by all`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var schema, table, referenced string
+		if err := rows.Scan(&schema, &table, &referenced); err != nil {
+			return err
+		}
+		key := schema + "." + table
+		references[key] = append(references[key], schema+"."+referenced)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range sortObjects(tables, references) {
+		buf.WriteString(table.sql + "\n")
+	}
+
+	return nil
+}
+
+// sortObjects returns objects in their original order, except that each object is moved
+// after the objects it refers to. References to objects which are not in the list are ignored.
+func sortObjects(objects []dumpObject, references map[string][]string) []dumpObject {
+	byKey := map[string]dumpObject{}
+	for _, object := range objects {
+		byKey[object.key()] = object
+	}
+
+	sorted := make([]dumpObject, 0, len(objects))
+	visited := map[string]bool{}
+	var visit func(object dumpObject)
+	visit = func(object dumpObject) {
+		if visited[object.key()] {
+			return
+		}
+		// mark objects before visiting references, so that cycles do not recurse forever
+		visited[object.key()] = true
+		for _, key := range references[object.key()] {
+			if referenced, ok := byKey[key]; ok {
+				visit(referenced)
+			}
+		}
+		sorted = append(sorted, object)
+	}
+	for _, object := range objects {
+		visit(object)
+	}
+
+	return sorted
+}
+
+// dumpIndexes writes CREATE INDEX statements. Indexes created by PRIMARY KEY and UNIQUE
+// constraints are part of the table, and have no sql.
+func (drv *Driver) dumpIndexes(db *sql.DB, buf *bytes.Buffer) error {
+	if drv.dumpOptions.Skips(dbmate.DumpSkipIndexes) {
+		return nil
+	}
+
+	rows, err := db.Query(`select schema_name, table_name, sql from duckdb_indexes()
+		where database_name = current_database() and sql is not null
+		order by schema_name, table_name, index_name`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var schema, table, statement string
+		if err := rows.Scan(&schema, &table, &statement); err != nil {
+			return err
+		}
+		if drv.dumpOptions.IncludesSchema(schema) && !drv.dumpOptions.ExcludesTable(schema, table) {
+			buf.WriteString(statement + "\n")
+		}
+	}
+
+	return rows.Err()
+}
+
+// dumpMacros writes CREATE MACRO statements. Each overload of a macro is returned as a
+// separate row, and they are combined into one statement. The catalog does not include
+// default parameter values.
+func (drv *Driver) dumpMacros(db *sql.DB, buf *bytes.Buffer) error {
+	if drv.dumpOptions.Skips(dbmate.DumpSkipFunctions) {
+		return nil
+	}
+
+	rows, err := db.Query(`select schema_name, function_name, function_type = 'table_macro',
+			array_to_string(parameters, ', '), macro_definition
+		from duckdb_functions()
+		where database_name = current_database() and not internal
+			and function_type in ('macro', 'table_macro')
+		order by schema_name, function_name, len(parameters)`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	previous := ""
+	for rows.Next() {
+		var schema, name, parameters, definition string
+		var table bool
+		if err := rows.Scan(&schema, &name, &table, &parameters, &definition); err != nil {
+			return err
+		}
+		if !drv.dumpOptions.IncludesSchema(schema) {
+			continue
+		}
+		if table {
+			definition = "TABLE " + definition
+		}
+
+		name = qualifiedName(schema, name)
+		if name == previous {
+			fmt.Fprintf(buf, ",\n  (%s) AS %s", parameters, definition)
+			continue
+		}
+		if previous != "" {
+			buf.WriteString(";\n")
+		}
+		fmt.Fprintf(buf, "CREATE MACRO %s(%s) AS %s", name, parameters, definition)
+		previous = name
+	}
+	if previous != "" {
+		buf.WriteString(";\n")
+	}
+
+	return rows.Err()
+}
+
+// dumpViews writes CREATE VIEW statements, sorted by name, except that views are moved after
+// the views they select from. DuckDB does not record dependencies between views, so a view
+// is assumed to depend on each view whose name appears in its definition.
+func (drv *Driver) dumpViews(db *sql.DB, buf *bytes.Buffer) error {
+	if drv.dumpOptions.Skips(dbmate.DumpSkipViews) {
+		return nil
+	}
+
+	rows, err := db.Query(`select schema_name, view_name, sql from duckdb_views()
+		where database_name = current_database() and not internal and not temporary
+		order by schema_name, view_name`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	views := []dumpObject{}
+	for rows.Next() {
+		var view dumpObject
+		if err := rows.Scan(&view.schema, &view.name, &view.sql); err != nil {
+			return err
+		}
+		if drv.dumpOptions.IncludesSchema(view.schema) && !drv.dumpOptions.ExcludesTable(view.schema, view.name) {
+			views = append(views, view)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, view := range sortObjects(views, viewReferences(views)) {
+		buf.WriteString(view.sql + "\n")
+	}
+
+	return nil
+}
+
+// viewReferences returns the views whose names appear in the definition of each view
+func viewReferences(views []dumpObject) map[string][]string {
+	references := map[string][]string{}
+	for _, referenced := range views {
+		pattern := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(referenced.name) + `\b`)
+		for _, view := range views {
+			if view.key() == referenced.key() {
+				continue
+			}
+			// skip the view's own name
+			_, definition, _ := strings.Cut(view.sql, " AS ")
+			if pattern.MatchString(definition) {
+				references[view.key()] = append(references[view.key()], referenced.key())
+			}
+		}
+	}
+
+	return references
+}
+
+// isGeneratedColumn returns true if a column is defined as a generated column in a
+// CREATE TABLE statement from duckdb_tables(). The catalog does not mark generated
+// columns, but their definition always has the form `name TYPE GENERATED ALWAYS AS(...)`.
+func isGeneratedColumn(tableSQL, column, dataType string) bool {
+	definition := " " + dataType + " GENERATED ALWAYS AS("
+	for _, name := range []string{column, quoteIdentifier(column)} {
+		for _, prefix := range []string{"(", ", "} {
+			if strings.Contains(tableSQL, prefix+name+definition) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// dataDumpSyntax formats rows as lists of string literals, which are cast to the column type
+// when the schema is loaded
+var dataDumpSyntax = dbutil.DataDumpSyntax{
+	RowSQL: func(values []string) string {
+		return strings.Join(values, " || ', ' || ")
+	},
+	Indent: "  ",
+}
+
+// tableDataDump returns INSERT statements for the rows of each table in DumpOptions.DataTables
+func (drv *Driver) tableDataDump(db *sql.DB) ([]byte, error) {
+	return dataDumpSyntax.DumpTableData(db, drv.dumpOptions.DataTables, func(table string) (dbutil.DataTable, error) {
+		var oid int64
+		var schema, name, tableSQL string
+		err := db.QueryRow(`select table_oid, schema_name, table_name, sql from duckdb_tables()
+			where database_name = current_database()
+				and (schema_name || '.' || table_name = ? or (schema_name = current_schema() and table_name = ?))`,
+			table, table).Scan(&oid, &schema, &name, &tableSQL)
+		if err == sql.ErrNoRows {
+			return dbutil.DataTable{}, dbutil.ErrTableNotFound
+		}
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+
+		rows, err := db.Query(`select column_name, data_type from duckdb_columns()
+			where table_oid = ? order by column_index`, oid)
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+		defer dbutil.MustClose(rows)
+
+		data := dbutil.DataTable{
+			Name:   qualifiedName(schema, name),
+			Header: fmt.Sprintf("-- Data for table %s\n", table),
+		}
+		for rows.Next() {
+			var column, dataType string
+			if err := rows.Scan(&column, &dataType); err != nil {
+				return dbutil.DataTable{}, err
+			}
+			// generated columns cannot be inserted
+			if isGeneratedColumn(tableSQL, column, dataType) {
+				continue
+			}
+			column = quoteIdentifier(column)
+			data.Columns = append(data.Columns, column)
+			data.Values = append(data.Values, fmt.Sprintf(
+				`coalesce('''' || replace(cast(%s as varchar), '''', '''''') || '''', 'NULL')`, column))
+		}
+
+		return data, rows.Err()
+	})
+}
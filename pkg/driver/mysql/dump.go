@@ -0,0 +1,288 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// dumpMethod returns the configured schema dump method. The --dump-method flag takes
+// precedence over the dump_method URL parameter, and mysqldump is used by default.
+func (drv *Driver) dumpMethod() (string, error) {
+	method := drv.dumpMethodName
+	if method == "" {
+		method = drv.databaseURL.Query().Get("dump_method")
+	}
+
+	switch method {
+	case "", "mysqldump", dbmate.DumpMethodTool:
+		return dbmate.DumpMethodTool, nil
+	case dbmate.DumpMethodNative:
+		return dbmate.DumpMethodNative, nil
+	case dbmate.DumpMethodAuto:
+		for _, command := range []string{"mariadb-dump", "mysqldump"} {
+			if _, err := execLookPath(command); err == nil {
+				return dbmate.DumpMethodTool, nil
+			}
+		}
+		return dbmate.DumpMethodNative, nil
+	default:
+		return "", fmt.Errorf("%w: %s", dbmate.ErrUnsupportedDumpMethod, method)
+	}
+}
+
+// dumpSection lists one type of object for a native schema dump. The list query must return
+// the name of each object in the current database along with the table it belongs to, and the
+// object definition is read from the Column of "SHOW CREATE <Type> <name>".
+type dumpSection struct {
+	Type   string
+	Skip   string
+	Title  string
+	List   string
+	Column string
+}
+
+// dumpSections are dumped in order. Foreign key checks are disabled while loading the dump,
+// so tables may be created in any order.
+var dumpSections = []dumpSection{
+	{"TABLE", "", "Table structure for table", `select table_name, table_name from information_schema.tables
+		where table_schema = database() and table_type = 'BASE TABLE'
+		order by table_name`, "Create Table"},
+	{"VIEW", dbmate.DumpSkipViews, "View structure for view", `select table_name, table_name from information_schema.views
+		where table_schema = database()
+		order by table_name`, "Create View"},
+	{"FUNCTION", dbmate.DumpSkipFunctions, "Function", `select routine_name, '' from information_schema.routines
+		where routine_schema = database() and routine_type = 'FUNCTION'
+		order by routine_name`, "Create Function"},
+	{"PROCEDURE", dbmate.DumpSkipFunctions, "Procedure", `select routine_name, '' from information_schema.routines
+		where routine_schema = database() and routine_type = 'PROCEDURE'
+		order by routine_name`, "Create Procedure"},
+	{"TRIGGER", dbmate.DumpSkipTriggers, "Trigger", `select trigger_name, event_object_table from information_schema.triggers
+		where trigger_schema = database()
+		order by event_object_table, action_timing, event_manipulation, action_order, trigger_name`,
+		"SQL Original Statement"},
+	{"EVENT", dbmate.DumpSkipEvents, "Event", `select event_name, '' from information_schema.events
+		where event_schema = database()
+		order by event_name`, "Create Event"},
+}
+
+// nativeDumpSchema dumps the schema using SHOW CREATE statements, without mysqldump
+func (drv *Driver) nativeDumpSchema(db *sql.DB) ([]byte, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var buf bytes.Buffer
+	buf.WriteString("/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+		"/*!40101 SET NAMES utf8mb4 */;\n" +
+		"/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;\n")
+
+	for _, section := range dumpSections {
+		if section.Skip != "" && drv.dumpOptions.Skips(section.Skip) {
+			continue
+		}
+
+		names, err := drv.listDumpObjects(tx, section.List)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dump %s: %w", strings.ToLower(section.Type), err)
+		}
+
+		definitions := make([]string, len(names))
+		for i, name := range names {
+			definitions[i], err = showCreate(tx, section, drv.quoteIdentifier(name))
+			if err != nil {
+				return nil, fmt.Errorf("unable to dump %s %s: %w", strings.ToLower(section.Type), name, err)
+			}
+		}
+		if section.Type == "VIEW" {
+			names, definitions = sortViews(names, definitions)
+		}
+
+		for i, name := range names {
+			fmt.Fprintf(&buf, "\n--\n-- %s `%s`\n--\n\n%s;\n", section.Title, name, definitions[i])
+		}
+	}
+
+	buf.WriteString("\n/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;\n" +
+		"/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;\n")
+
+	return buf.Bytes(), nil
+}
+
+// listDumpObjects returns the names of objects from a dump section list query,
+// excluding objects which belong to tables excluded by DumpOptions
+func (drv *Driver) listDumpObjects(db dbutil.Transaction, query string) ([]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	names := []string{}
+	for rows.Next() {
+		var name, table string
+		if err := rows.Scan(&name, &table); err != nil {
+			return nil, err
+		}
+		if table == "" || !drv.dumpOptions.ExcludesTable(dbutil.DatabaseName(drv.databaseURL), table) {
+			names = append(names, name)
+		}
+	}
+
+	return names, rows.Err()
+}
+
+// mysqldumpFilterArgs returns the mysqldump arguments which apply DumpOptions
+func (drv *Driver) mysqldumpFilterArgs(db *sql.DB) ([]string, error) {
+	args := []string{}
+	if drv.dumpOptions.Skips(dbmate.DumpSkipFunctions) {
+		args = append(args, "--skip-routines")
+	}
+	if drv.dumpOptions.Skips(dbmate.DumpSkipTriggers) {
+		args = append(args, "--skip-triggers")
+	}
+
+	// mysqldump only ignores tables by exact name
+	if len(drv.dumpOptions.ExcludeTables) == 0 && !drv.dumpOptions.Skips(dbmate.DumpSkipViews) {
+		return args, nil
+	}
+	rows, err := db.Query(`select table_name, table_type from information_schema.tables
+		where table_schema = database()
+		order by table_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	name := dbutil.DatabaseName(drv.databaseURL)
+	for rows.Next() {
+		var table, tableType string
+		if err := rows.Scan(&table, &tableType); err != nil {
+			return nil, err
+		}
+		if drv.dumpOptions.ExcludesTable(name, table) ||
+			(tableType == "VIEW" && drv.dumpOptions.Skips(dbmate.DumpSkipViews)) {
+			args = append(args, fmt.Sprintf("--ignore-table=%s.%s", name, table))
+		}
+	}
+
+	return args, rows.Err()
+}
+
+// showCreate returns the definition of an object, without its DEFINER clause
+func showCreate(db dbutil.Transaction, section dumpSection, name string) (string, error) {
+	rows, err := db.Query(fmt.Sprintf("show create %s %s", strings.ToLower(section.Type), name))
+	if err != nil {
+		return "", err
+	}
+	defer dbutil.MustClose(rows)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	index := -1
+	for i, column := range columns {
+		if strings.EqualFold(column, section.Column) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return "", fmt.Errorf("missing column %q", section.Column)
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		return "", sql.ErrNoRows
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", err
+	}
+	if !values[index].Valid {
+		// definitions are hidden from users without sufficient privileges
+		return "", fmt.Errorf("insufficient privileges to read definition")
+	}
+
+	definition, _ := dbutil.StripDefiners([]byte(values[index].String))
+	return string(definition), rows.Err()
+}
+
+// sortViews orders views so that each view is created after any views it selects from
+func sortViews(names, definitions []string) ([]string, []string) {
+	sortedNames := make([]string, 0, len(names))
+	sortedDefinitions := make([]string, 0, len(definitions))
+	done := make([]bool, len(names))
+
+	var visit func(i int, visiting map[int]bool)
+	visit = func(i int, visiting map[int]bool) {
+		if done[i] || visiting[i] {
+			return
+		}
+		visiting[i] = true
+		for j, name := range names {
+			if j != i && strings.Contains(definitions[i], "`"+strings.ReplaceAll(name, "`", "``")+"`") {
+				visit(j, visiting)
+			}
+		}
+		done[i] = true
+		sortedNames = append(sortedNames, names[i])
+		sortedDefinitions = append(sortedDefinitions, definitions[i])
+	}
+	for i := range names {
+		visit(i, map[int]bool{})
+	}
+
+	return sortedNames, sortedDefinitions
+}
+
+// dataDumpSyntax formats rows as lists of quoted values
+var dataDumpSyntax = dbutil.DataDumpSyntax{
+	RowSQL: func(values []string) string {
+		return "concat_ws(',', " + strings.Join(values, ", ") + ")"
+	},
+	Indent: "  ",
+}
+
+// tableDataDump returns INSERT statements for the rows of each table in DumpOptions.DataTables
+func (drv *Driver) tableDataDump(db *sql.DB) ([]byte, error) {
+	return dataDumpSyntax.DumpTableData(db, drv.dumpOptions.DataTables, func(table string) (dbutil.DataTable, error) {
+		// generated columns cannot be inserted. Their extra is VIRTUAL GENERATED or STORED GENERATED,
+		// but columns with an expression default (DEFAULT_GENERATED) are not generated. MariaDB
+		// returns a null generation expression for other columns.
+		columns, err := dbutil.QueryColumn(db, `select column_name from information_schema.columns
+			where table_schema = database() and table_name = ? and coalesce(generation_expression, '') = ''
+			order by ordinal_position`, table)
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+
+		quotedTable := drv.quoteIdentifier(table)
+		data := dbutil.DataTable{
+			Name:   quotedTable,
+			Header: fmt.Sprintf("\n--\n-- Dumping data for table %s\n--\n\n", quotedTable),
+		}
+		for _, column := range columns {
+			column = drv.quoteIdentifier(column)
+			data.Columns = append(data.Columns, column)
+			data.Values = append(data.Values, "quote("+column+")")
+		}
+
+		return data, nil
+	})
+}
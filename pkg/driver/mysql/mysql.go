@@ -2,8 +2,12 @@ package mysql
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/url"
 	"os/exec"
@@ -14,7 +18,7 @@ import (
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 
-	_ "github.com/go-sql-driver/mysql" // database/sql driver
+	"github.com/go-sql-driver/mysql"
 )
 
 // for mocking out during tests
@@ -36,6 +40,9 @@ type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
 	log                 io.Writer
+	dumpMethodName      string
+	dumpOptions         dbmate.DumpOptions
+	initSQL             string
 }
 
 // NewDriver initializes the driver
@@ -44,12 +51,17 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
 		log:                 config.Log,
+		dumpMethodName:      config.DumpMethod,
+		dumpOptions:         config.DumpOptions,
+		initSQL:             config.InitSQL,
 	}
 }
 
 func connectionString(u *url.URL) string {
 	query := u.Query()
 	query.Set("multiStatements", "true")
+	// dump_method is used by dbmate, not the server
+	query.Del("dump_method")
 
 	host := u.Host
 	protocol := "tcp"
@@ -83,7 +95,20 @@ func connectionString(u *url.URL) string {
 
 // Open creates a new database connection
 func (drv *Driver) Open() (*sql.DB, error) {
-	return sql.Open("mysql", connectionString(drv.databaseURL))
+	if drv.initSQL == "" {
+		return sql.Open("mysql", connectionString(drv.databaseURL))
+	}
+
+	config, err := mysql.ParseDSN(connectionString(drv.databaseURL))
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(dbutil.WithInitSQL(connector, drv.initSQL)), nil
 }
 
 func (drv *Driver) openRootDB() (*sql.DB, error) {
@@ -268,32 +293,85 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// DumpMigrations returns the versions recorded in the migrations table, as in DumpSchema
+func (drv *Driver) DumpMigrations(db *sql.DB) ([]byte, error) {
+	return drv.schemaMigrationsDump(db)
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB, extraArgs ...string) ([]byte, error) {
-	ver := getMysqldumpVersion()
+	method, err := drv.dumpMethod()
+	if err != nil {
+		return nil, err
+	}
 
-	schema, err := dbutil.RunCommand(ver.Command, drv.mysqldumpArgs(ver, extraArgs...)...)
+	var schema []byte
+	if method == dbmate.DumpMethodNative {
+		schema, err = drv.nativeDumpSchema(db)
+	} else {
+		schema, err = drv.mysqldumpSchema(db, extraArgs...)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	migrations, err := drv.schemaMigrationsDump(db)
+	data, err := drv.tableDataDump(db)
 	if err != nil {
 		return nil, err
 	}
+	schema = append(schema, data...)
+
+	if !drv.dumpOptions.NoMigrationsData {
+		migrations, err := drv.schemaMigrationsDump(db)
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, migrations...)
+	}
+
+	return dbutil.NormalizeSchema(schema, dbutil.TrimLeadingSQLComments, trimAutoincrementValues)
+}
 
-	schema = append(schema, migrations...)
-	schema, err = dbutil.TrimLeadingSQLComments(schema)
+// mysqldumpSchema dumps the schema using mysqldump or mariadb-dump
+func (drv *Driver) mysqldumpSchema(db *sql.DB, extraArgs ...string) ([]byte, error) {
+	filterArgs, err := drv.mysqldumpFilterArgs(db)
 	if err != nil {
 		return nil, err
 	}
-	return trimAutoincrementValues(schema), nil
+
+	ver := getMysqldumpVersion()
+	return dbutil.RunCommand(ver.Command, drv.mysqldumpArgs(ver, append(filterArgs, extraArgs...)...)...)
 }
 
 // trimAutoincrementValues removes AUTO_INCREMENT values from MySQL schema dumps
-func trimAutoincrementValues(data []byte) []byte {
+func trimAutoincrementValues(data []byte) ([]byte, error) {
 	aiPattern := regexp.MustCompile(" AUTO_INCREMENT=[0-9]*")
-	return aiPattern.ReplaceAll(data, []byte(""))
+	return aiPattern.ReplaceAll(data, []byte("")), nil
+}
+
+// maxIdentifierLength is the maximum length of a mysql database name
+const maxIdentifierLength = 64
+
+// ScratchDatabaseURL returns the URL of a temporary database on the same server, with a random
+// suffix so that it does not clash with an existing database
+func (drv *Driver) ScratchDatabaseURL() (*url.URL, error) {
+	u, err := url.Parse(drv.databaseURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	name := dbutil.DatabaseName(drv.databaseURL)
+	suffixName := fmt.Sprintf("_dbmate_scratch_%x", suffix)
+	if len(name)+len(suffixName) > maxIdentifierLength {
+		name = name[:maxIdentifierLength-len(suffixName)]
+	}
+	u.Path = "/" + name + suffixName
+
+	return u, nil
 }
 
 // DatabaseExists determines whether the database exists
@@ -399,11 +477,135 @@ func (drv *Driver) Ping() error {
 	return db.Ping()
 }
 
+// SessionSettingsSQL returns the SQL which switches to a role and default database for a
+// migration section. MySQL has no search path, so searchPath must name a single database.
+// Switching back restores the default roles and the database from the URL, and runs the init
+// SQL again, since it may have changed them.
+func (drv *Driver) SessionSettingsSQL(role, searchPath string) (string, string, error) {
+	if strings.Contains(searchPath, ",") {
+		return "", "", fmt.Errorf("search_path must name a single database: %s", searchPath)
+	}
+
+	set := []string{}
+	reset := []string{}
+	if role != "" {
+		set = append(set, "set role "+drv.quoteIdentifier(role))
+		reset = append(reset, "set role default")
+	}
+	if searchPath != "" {
+		set = append(set, "use "+drv.quoteIdentifier(searchPath))
+		reset = append(reset, "use "+drv.quoteIdentifier(dbutil.DatabaseName(drv.databaseURL)))
+	}
+	if drv.initSQL != "" {
+		reset = append(reset, strings.TrimRight(strings.TrimSpace(drv.initSQL), ";"))
+	}
+
+	return strings.Join(set, "; "), strings.Join(reset, "; "), nil
+}
+
+// ServerVersion returns the version of the database server
+func (drv *Driver) ServerVersion() (string, error) {
+	db, err := drv.openRootDB()
+	if err != nil {
+		return "", err
+	}
+	defer dbutil.MustClose(db)
+
+	return dbutil.QueryValue(db, "select version()")
+}
+
+// TransactionalDDL returns false, since schema changes implicitly commit the transaction
+func (drv *Driver) TransactionalDDL() bool {
+	return false
+}
+
+// lockTimeout is how many seconds Lock waits for a lock held by another process
+const lockTimeout = 24 * 60 * 60
+
+// Lock takes a named lock on a connection which is held until the lock is released. Named
+// locks are shared by all databases on a server, so the name includes the database name.
+func (drv *Driver) Lock() (io.Closer, error) {
+	db, err := drv.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &namedLock{ctx: context.Background(), db: db, name: lockName(drv.databaseURL, drv.migrationsTableName)}
+	if err := lock.acquire(drv.log); err != nil {
+		dbutil.MustClose(db)
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// lockName returns the name of the lock for a migrations table, which is hashed if it is
+// longer than the 64 characters allowed by MySQL
+func lockName(u *url.URL, migrationsTableName string) string {
+	name := "dbmate:" + dbutil.DatabaseName(u) + "." + migrationsTableName
+	if len(name) <= 64 {
+		return name
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return fmt.Sprintf("dbmate:%016x", h.Sum64())
+}
+
+// namedLock is a lock taken with GET_LOCK, which is held by a single connection
+type namedLock struct {
+	ctx  context.Context
+	db   *sql.DB
+	conn *sql.Conn
+	name string
+}
+
+// acquire waits until the lock is available, printing a message if another process holds it
+func (l *namedLock) acquire(log io.Writer) error {
+	conn, err := l.db.Conn(l.ctx)
+	if err != nil {
+		return err
+	}
+
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(l.ctx, "select get_lock(?, 0)", l.name).Scan(&locked)
+	if err == nil && locked.Int64 != 1 {
+		fmt.Fprintln(log, "Waiting for lock held by another dbmate process")
+		err = conn.QueryRowContext(l.ctx, "select get_lock(?, ?)", l.name, lockTimeout).Scan(&locked)
+		if err == nil && locked.Int64 != 1 {
+			err = fmt.Errorf("timed out waiting for lock %s", l.name)
+		}
+	}
+	if err != nil {
+		dbutil.MustClose(conn)
+		return err
+	}
+	l.conn = conn
+
+	return nil
+}
+
+// Close releases the lock, and closes its connection
+func (l *namedLock) Close() error {
+	_, err := l.conn.ExecContext(l.ctx, "do release_lock(?)", l.name)
+
+	return errors.Join(err, l.conn.Close(), l.db.Close())
+}
+
 // Return a normalized version of the driver-specific error type.
 func (drv *Driver) QueryError(query string, err error) error {
 	return &dbmate.QueryError{Err: err, Query: query}
 }
 
+// StatementSyntax returns the syntax used to split schema files into statements
+func (drv *Driver) StatementSyntax() dbutil.StatementSyntax {
+	return dbutil.StatementSyntax{
+		BackslashEscapes: true,
+		HashComments:     true,
+		Delimiters:       true,
+	}
+}
+
 func (drv *Driver) quotedMigrationsTableName() string {
 	return drv.quoteIdentifier(drv.migrationsTableName)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"os/exec"
+	"strings"
 	"testing"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
@@ -116,6 +117,14 @@ func TestConnectionString(t *testing.T) {
 		s = connectionString(u)
 		require.Equal(t, "bob:secret@unix(/var/run/mysqld/mysqld.sock)/foo?flag=on&multiStatements=true", s)
 	})
+
+	t.Run("dump method", func(t *testing.T) {
+		u, err := url.Parse("mysql://host/foo?dump_method=native&flag=on")
+		require.NoError(t, err)
+
+		s := connectionString(u)
+		require.Equal(t, "tcp(host:3306)/foo?flag=on&multiStatements=true", s)
+	})
 }
 
 func TestMySQLCreateDropDatabase(t *testing.T) {
@@ -292,6 +301,40 @@ func TestMySQLDumpSchema(t *testing.T) {
 	require.Contains(t, err.Error(), "Unknown database 'fakedb'")
 }
 
+func TestMySQLDumpSchemaDataTables(t *testing.T) {
+	drv := testMySQLDriver(t)
+	drv.dumpOptions = dbmate.DumpOptions{DataTables: []string{"countries"}}
+
+	// prepare database
+	db := prepTestMySQLDB(t)
+	defer dbutil.MustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	_, err = db.Exec("create table countries (code varchar(2) primary key, name text, " +
+		"created_at timestamp not null default current_timestamp, " +
+		"upper_code varchar(2) as (upper(code)))")
+	require.NoError(t, err)
+	_, err = db.Exec("insert into countries (code, name, created_at) values " +
+		"('nz', 'New Zealand', '2024-01-02 03:04:05'), ('au', 'Australia', '2024-01-02 03:04:05'), " +
+		"('xx', null, '2024-01-02 03:04:05')")
+	require.NoError(t, err)
+
+	// rows are sorted, and generated columns are left out (but not columns with an expression default)
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "-- Dumping data for table `countries`\n"+
+		"--\n\n"+
+		"INSERT INTO `countries` (`code`, `name`, `created_at`) VALUES\n"+
+		"  ('au','Australia','2024-01-02 03:04:05'),\n"+
+		"  ('nz','New Zealand','2024-01-02 03:04:05'),\n"+
+		"  ('xx',NULL,'2024-01-02 03:04:05');\n")
+
+	// missing tables are reported
+	drv.dumpOptions.DataTables = []string{"missing"}
+	_, err = drv.DumpSchema(db)
+	require.EqualError(t, err, "unable to dump data for table missing: table does not exist")
+}
+
 func TestMySQLDumpSchemaContainsNoAutoIncrement(t *testing.T) {
 	drv := testMySQLDriver(t)
 
@@ -320,6 +363,123 @@ func TestMySQLDumpSchemaContainsNoAutoIncrement(t *testing.T) {
 	require.NotContains(t, string(schema), "AUTO_INCREMENT=")
 }
 
+func TestMySQLDumpMethod(t *testing.T) {
+	cases := []struct {
+		name     string
+		url      string
+		config   string
+		commands []string
+		expected string
+	}{
+		{"default", "mysql:///foo", "", nil, dbmate.DumpMethodTool},
+		{"url param", "mysql:///foo?dump_method=native", "", nil, dbmate.DumpMethodNative},
+		{"mysqldump alias", "mysql:///foo?dump_method=mysqldump", "", nil, dbmate.DumpMethodTool},
+		{"config overrides url", "mysql:///foo?dump_method=native", dbmate.DumpMethodTool, nil, dbmate.DumpMethodTool},
+		{"auto with mysqldump", "mysql:///foo", dbmate.DumpMethodAuto, []string{"mysqldump"}, dbmate.DumpMethodTool},
+		{"auto with mariadb-dump", "mysql:///foo", dbmate.DumpMethodAuto, []string{"mariadb-dump"}, dbmate.DumpMethodTool},
+		{"auto without tools", "mysql:///foo", dbmate.DumpMethodAuto, nil, dbmate.DumpMethodNative},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			origExecLookPath := execLookPath
+			execLookPath = func(file string) (string, error) {
+				for _, command := range c.commands {
+					if file == command {
+						return file, nil
+					}
+				}
+				return file, exec.ErrNotFound
+			}
+			defer func() {
+				execLookPath = origExecLookPath
+			}()
+
+			u, err := url.Parse(c.url)
+			require.NoError(t, err)
+			drv := NewDriver(dbmate.DriverConfig{DatabaseURL: u, DumpMethod: c.config}).(*Driver)
+
+			method, err := drv.dumpMethod()
+			require.NoError(t, err)
+			require.Equal(t, c.expected, method)
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		u, err := url.Parse("mysql:///foo?dump_method=mysqlpump")
+		require.NoError(t, err)
+		drv := NewDriver(dbmate.DriverConfig{DatabaseURL: u}).(*Driver)
+
+		_, err = drv.dumpMethod()
+		require.ErrorIs(t, err, dbmate.ErrUnsupportedDumpMethod)
+	})
+}
+
+func TestSortViews(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	definitions := []string{
+		"CREATE VIEW `a` AS select * from `c`",
+		"CREATE VIEW `b` AS select * from `foo`",
+		"CREATE VIEW `c` AS select * from `b`",
+	}
+
+	names, definitions = sortViews(names, definitions)
+	require.Equal(t, []string{"b", "c", "a"}, names)
+	require.Equal(t, "CREATE VIEW `b` AS select * from `foo`", definitions[0])
+}
+
+func TestMySQLNativeDumpSchema(t *testing.T) {
+	drv := testMySQLDriver(t)
+	drv.dumpMethodName = dbmate.DumpMethodNative
+
+	// prepare database
+	db := prepTestMySQLDB(t)
+	defer func() {
+		dbutil.MustClose(db)
+	}()
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`create table users (id int not null primary key auto_increment, email varchar(255));
+		insert into users (email) values ('a@example.com');
+		create table posts (id int, user_id int, foreign key (user_id) references users (id));
+		create view user_emails as select email from users;
+		create view recent_emails as select email from user_emails;
+		create trigger users_email before insert on users for each row set new.email = lower(new.email);
+		create function add_one(i int) returns int deterministic return i + 1;`)
+	require.NoError(t, err)
+
+	// DumpSchema should return schema without mysqldump
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "-- Table structure for table `users`\n")
+	require.Contains(t, string(schema), "CREATE TABLE `schema_migrations`")
+	require.Contains(t, string(schema), "-- View structure for view `user_emails`\n")
+	require.Contains(t, string(schema), "-- Trigger `users_email`\n")
+	require.Contains(t, string(schema), "-- Function `add_one`\n")
+	require.Contains(t, string(schema), "-- Dbmate schema migrations\n"+
+		"--\n\n"+
+		"LOCK TABLES `schema_migrations` WRITE;\n"+
+		"INSERT INTO `schema_migrations` (version) VALUES\n"+
+		"  ('abc1');\n"+
+		"UNLOCK TABLES;\n")
+	require.NotContains(t, string(schema), "AUTO_INCREMENT=")
+	require.NotContains(t, string(schema), "DEFINER=")
+	require.Less(t, strings.Index(string(schema), "`user_emails`"), strings.Index(string(schema), "`recent_emails`"))
+
+	// the dump should be deterministic, and loadable into an empty database
+	again, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, string(schema), string(again))
+
+	dbutil.MustClose(db)
+	db = prepTestMySQLDB(t)
+	_, err = db.Exec(string(schema))
+	require.NoError(t, err)
+}
+
 func TestMySQLDatabaseExists(t *testing.T) {
 	drv := testMySQLDriver(t)
 
@@ -472,6 +632,102 @@ func TestMySQLPing(t *testing.T) {
 	require.Contains(t, err.Error(), "connect: connection refused")
 }
 
+func TestMySQLLock(t *testing.T) {
+	drv := testMySQLDriver(t)
+	prepTestMySQLDB(t).Close()
+
+	lock, err := drv.Lock()
+	require.NoError(t, err)
+
+	// the lock is held by another connection
+	db, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+	name := lockName(drv.databaseURL, "schema_migrations")
+	free, err := dbutil.QueryValue(db, "select is_free_lock(?)", name)
+	require.NoError(t, err)
+	require.Equal(t, "0", free)
+
+	err = lock.Close()
+	require.NoError(t, err)
+	free, err = dbutil.QueryValue(db, "select is_free_lock(?)", name)
+	require.NoError(t, err)
+	require.Equal(t, "1", free)
+}
+
+func TestLockName(t *testing.T) {
+	u := dbtest.MustParseURL(t, "mysql://localhost/dbname")
+	require.Equal(t, "dbmate:dbname.schema_migrations", lockName(u, "schema_migrations"))
+
+	// names are limited to 64 characters
+	name := lockName(u, strings.Repeat("x", 64))
+	require.Len(t, name, 23)
+	require.Equal(t, name, lockName(u, strings.Repeat("x", 64)))
+	require.NotEqual(t, name, lockName(u, strings.Repeat("y", 64)))
+}
+
+func TestMySQLScratchDatabaseURL(t *testing.T) {
+	drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "mysql://root@localhost:3306/app?tls=false")}).(*Driver)
+
+	u, err := drv.ScratchDatabaseURL()
+	require.NoError(t, err)
+	require.Regexp(t, `^mysql://root@localhost:3306/app_dbmate_scratch_[0-9a-f]{8}\?tls=false$`, u.String())
+
+	// each run uses a new scratch database
+	other, err := drv.ScratchDatabaseURL()
+	require.NoError(t, err)
+	require.NotEqual(t, u.String(), other.String())
+
+	// long names are truncated to the mysql identifier length
+	drv = NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "mysql://localhost/"+strings.Repeat("a", 64))}).(*Driver)
+	u, err = drv.ScratchDatabaseURL()
+	require.NoError(t, err)
+	require.Len(t, strings.TrimPrefix(u.Path, "/"), 64)
+}
+
+func TestMySQLServerVersion(t *testing.T) {
+	drv := testMySQLDriver(t)
+
+	version, err := drv.ServerVersion()
+	require.NoError(t, err)
+	require.Regexp(t, `^\d+\.\d+`, version)
+}
+
+func TestSessionSettingsSQL(t *testing.T) {
+	drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "mysql://localhost/dbname")}).(*Driver)
+
+	set, reset, err := drv.SessionSettingsSQL("owner", "billing")
+	require.NoError(t, err)
+	require.Equal(t, "set role `owner`; use `billing`", set)
+	require.Equal(t, "set role default; use `dbname`", reset)
+
+	// the init SQL is run again after resetting
+	drv.initSQL = "set role migrator"
+	_, reset, err = drv.SessionSettingsSQL("owner", "")
+	require.NoError(t, err)
+	require.Equal(t, "set role default; set role migrator", reset)
+
+	_, _, err = drv.SessionSettingsSQL("", "billing,public")
+	require.EqualError(t, err, "search_path must name a single database: billing,public")
+}
+
+func TestMySQLInitSQL(t *testing.T) {
+	u := dbtest.GetenvURLOrSkip(t, "MYSQL_TEST_URL")
+	db := dbmate.New(u)
+	db.InitSQL = "set @dbmate_init = 'yes'"
+	drv, err := db.Driver()
+	require.NoError(t, err)
+	prepTestMySQLDB(t).Close()
+
+	sqlDB, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(sqlDB)
+
+	value, err := dbutil.QueryValue(sqlDB, "select @dbmate_init")
+	require.NoError(t, err)
+	require.Equal(t, "yes", value)
+}
+
 func TestMySQLQuotedMigrationsTableName(t *testing.T) {
 	t.Run("default name", func(t *testing.T) {
 		drv := testMySQLDriver(t)
@@ -0,0 +1,347 @@
+package postgres
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/lib/pq"
+)
+
+// cockroachMaxRetries is the number of times a transaction is retried after a serialization error
+const cockroachMaxRetries = 5
+
+// cockroachRetryDelay is the delay before the first retry, which doubles after each attempt
+var cockroachRetryDelay = 100 * time.Millisecond
+
+// CockroachDriver provides top level database functions for CockroachDB. CockroachDB speaks the
+// postgres wire protocol, so only schema dumps and transactions differ from postgres.
+type CockroachDriver struct {
+	*Driver
+}
+
+// NewCockroachDriver initializes the driver
+func NewCockroachDriver(config dbmate.DriverConfig) dbmate.Driver {
+	return &CockroachDriver{Driver: NewDriver(config).(*Driver)}
+}
+
+// isCockroach returns true if the URL uses a CockroachDB scheme
+func isCockroach(u *url.URL) bool {
+	return u.Scheme == "cockroach" || u.Scheme == "cockroachdb"
+}
+
+// Lock returns errors.ErrUnsupported, since CockroachDB does not implement advisory locks
+func (drv *CockroachDriver) Lock() (io.Closer, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// NonTransactionalReason returns an empty string, since CockroachDB accepts the statements
+// which postgres refuses to run in a transaction (or does not support them at all)
+func (drv *CockroachDriver) NonTransactionalReason(string) string {
+	return ""
+}
+
+// CreateMigrationsTable creates the schema_migrations table. Unlike postgres, CockroachDB
+// does not report a missing schema with a distinct error code, so the schema is looked up first.
+func (drv *CockroachDriver) CreateMigrationsTable(db *sql.DB) error {
+	schemaName, _, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return err
+	}
+	schema, migrationsTable, err := drv.quotedMigrationsTableNameParts(db)
+	if err != nil {
+		return err
+	}
+
+	// only attempt to create the schema if it is missing, in case the user doesn't have
+	// permission to create schemas
+	exists, err := dbutil.QueryValue(db,
+		"select count(*) from information_schema.schemata where schema_name = $1", schemaName)
+	if err != nil {
+		return err
+	}
+	if exists == "0" {
+		fmt.Fprintf(drv.log, "Creating schema: %s\n", schema)
+		if _, err := db.Exec(fmt.Sprintf("create schema if not exists %s", schema)); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(fmt.Sprintf(
+		"create table if not exists %s.%s (version varchar primary key)",
+		schema, migrationsTable))
+
+	return err
+}
+
+// RunTransaction runs a migration in a transaction. CockroachDB runs transactions with
+// serializable isolation, and asks clients to retry transactions which fail with a
+// serialization error (40001), so these are retried with exponential backoff.
+//
+// Recent CockroachDB versions commit each DDL statement in its own transaction by default
+// (autocommit_before_ddl), which would apply part of a failed migration, so this is turned
+// off for the migration transaction.
+func (drv *CockroachDriver) RunTransaction(db *sql.DB, txFunc func(dbutil.Transaction) error) error {
+	// the setting does not exist before CockroachDB v24.3
+	autocommit, err := dbutil.QueryValue(db, "select current_setting('autocommit_before_ddl', true)")
+	if err != nil {
+		return err
+	}
+
+	delay := cockroachRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := cockroachTransaction(db, autocommit == "on", txFunc)
+		if err == nil || !isCockroachRetryError(err) || attempt > cockroachMaxRetries {
+			return err
+		}
+
+		fmt.Fprintf(drv.log, "Retrying transaction after serialization error (attempt %d of %d)\n",
+			attempt, cockroachMaxRetries)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// cockroachTransaction runs txFunc once in a transaction
+func cockroachTransaction(db *sql.DB, disableAutocommitDDL bool, txFunc func(dbutil.Transaction) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if disableAutocommitDDL {
+		if _, err := tx.Exec("set local autocommit_before_ddl = off"); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	if err := txFunc(tx); err != nil {
+		if err1 := tx.Rollback(); err1 != nil {
+			return err1
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isCockroachRetryError returns true if a transaction failed with a serialization error,
+// which CockroachDB clients are expected to retry
+func isCockroachRetryError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
+
+// DumpSchema returns the current database schema. pg_dump does not support CockroachDB, so
+// the schema is read with SHOW CREATE statements.
+func (drv *CockroachDriver) DumpSchema(db *sql.DB, _ ...string) ([]byte, error) {
+	method := drv.dumpMethodName
+	if method == "" {
+		method = drv.databaseURL.Query().Get("dump_method")
+	}
+	switch method {
+	case "", dbmate.DumpMethodNative, dbmate.DumpMethodAuto:
+	default:
+		return nil, fmt.Errorf("%w: %s", dbmate.ErrUnsupportedDumpMethod, method)
+	}
+
+	schema, err := drv.showCreateDumpSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := drv.tableDataDump(db)
+	if err != nil {
+		return nil, err
+	}
+	schema = append(schema, data...)
+
+	if !drv.dumpOptions.NoMigrationsData {
+		migrations, err := drv.schemaMigrationsDump(db)
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, migrations...)
+	}
+
+	return bytes.TrimLeft(schema, "\n"), nil
+}
+
+// cockroachObject is a statement returned by SHOW CREATE, which creates or alters an object
+type cockroachObject struct {
+	// Type is the object type, as written in pg_dump block headers (e.g. TABLE or FK CONSTRAINT)
+	Type   string
+	Schema string
+	Name   string
+	// Table is the table, view, or sequence which the object belongs to
+	Table      string
+	Constraint string
+	// Validate is true for statements which validate a constraint added by an earlier statement
+	Validate   bool
+	Definition string
+}
+
+// cockroachIdentifier matches a quoted or unquoted identifier in SHOW CREATE output
+const cockroachIdentifier = `(?:"(?:[^"]|"")*"|[^\s(".;]+)`
+
+var (
+	// cockroachStatementRegexp matches the start of each statement in SHOW CREATE output
+	cockroachStatementRegexp = regexp.MustCompile(`^(CREATE|ALTER) (?:(MATERIALIZED) )?` +
+		`(SCHEMA|TYPE|TABLE|VIEW|SEQUENCE) (?:IF NOT EXISTS )?` +
+		`(` + cockroachIdentifier + `(?:\.` + cockroachIdentifier + `)*)`)
+	// cockroachConstraintRegexp matches the constraint of an ALTER TABLE statement
+	cockroachConstraintRegexp = regexp.MustCompile(`^ (ADD|VALIDATE) CONSTRAINT (` + cockroachIdentifier + `)`)
+	// cockroachIdentifierRegexp matches each part of a qualified name
+	cockroachIdentifierRegexp = regexp.MustCompile(cockroachIdentifier)
+)
+
+// cockroachSkipTypes maps DumpOptions.Skip object types to the object types in SHOW CREATE output
+var cockroachSkipTypes = map[string][]string{
+	dbmate.DumpSkipViews:     {"VIEW", "MATERIALIZED VIEW"},
+	dbmate.DumpSkipSequences: {"SEQUENCE"},
+	dbmate.DumpSkipTypes:     {"TYPE"},
+}
+
+// parseCockroachObject parses a statement returned by SHOW CREATE
+func parseCockroachObject(definition string) (cockroachObject, bool) {
+	definition = strings.TrimSpace(definition)
+	object := cockroachObject{Definition: strings.TrimSuffix(definition, ";") + ";"}
+
+	match := cockroachStatementRegexp.FindStringSubmatchIndex(definition)
+	if match == nil {
+		return object, false
+	}
+
+	object.Type = definition[match[6]:match[7]]
+	if match[4] >= 0 {
+		object.Type = "MATERIALIZED " + object.Type
+	}
+
+	parts := cockroachIdentifierRegexp.FindAllString(definition[match[8]:match[9]], -1)
+	for i := range parts {
+		parts[i] = unquoteCockroachIdentifier(parts[i])
+	}
+	switch {
+	case object.Type == "SCHEMA":
+		object.Schema, object.Name = "-", parts[len(parts)-1]
+	case len(parts) > 1:
+		object.Schema, object.Name = parts[len(parts)-2], parts[len(parts)-1]
+	default:
+		object.Schema, object.Name = "public", parts[0]
+	}
+	if object.Type != "SCHEMA" && object.Type != "TYPE" {
+		object.Table = object.Name
+	}
+
+	if definition[match[2]:match[3]] == "ALTER" {
+		constraint := cockroachConstraintRegexp.FindStringSubmatch(definition[match[1]:])
+		if constraint == nil {
+			return object, true
+		}
+
+		object.Type = "FK CONSTRAINT"
+		object.Constraint = unquoteCockroachIdentifier(constraint[2])
+		object.Validate = constraint[1] == "VALIDATE"
+		object.Name = object.Table + " " + object.Constraint
+	}
+
+	return object, true
+}
+
+// unquoteCockroachIdentifier removes the quotes from an identifier
+func unquoteCockroachIdentifier(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+	}
+
+	return s
+}
+
+// showCreateDumpSchema dumps the schemas, types, and tables (including views and sequences)
+// of the current database, in the pg_dump block format
+func (drv *CockroachDriver) showCreateDumpSchema(db *sql.DB) ([]byte, error) {
+	definitions := []string{}
+	for _, query := range []string{"show create all schemas", "show create all types", "show create all tables"} {
+		rows, err := dbutil.QueryColumn(db, query)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dump schema (%s): %w", query, err)
+		}
+		definitions = append(definitions, rows...)
+	}
+
+	return drv.formatCockroachObjects(definitions), nil
+}
+
+// formatCockroachObjects filters SHOW CREATE statements by DumpOptions, and writes each
+// object with a pg_dump style block header. Constraints are validated in the same block
+// they are added in.
+func (drv *CockroachDriver) formatCockroachObjects(definitions []string) []byte {
+	schemas := drv.dumpSchemas()
+	skip := map[string]bool{}
+	for _, objectType := range drv.dumpOptions.Skip {
+		for _, cockroachType := range cockroachSkipTypes[objectType] {
+			skip[cockroachType] = true
+		}
+	}
+
+	objects := []cockroachObject{}
+	constraints := map[string]int{}
+	previous := -1
+	for _, definition := range definitions {
+		object, ok := parseCockroachObject(definition)
+		if !ok {
+			// unknown statements are kept with the previous object
+			if previous >= 0 {
+				objects[previous].Definition += "\n" + object.Definition
+			}
+			continue
+		}
+		previous = -1
+
+		schema := object.Schema
+		if object.Type == "SCHEMA" {
+			// the public schema is created with the database
+			if object.Name == "public" {
+				continue
+			}
+			schema = object.Name
+		}
+		if len(schemas) > 0 && !slices.Contains(schemas, schema) {
+			continue
+		}
+		if skip[object.Type] || (object.Table != "" && drv.dumpOptions.ExcludesTable(object.Schema, object.Table)) {
+			continue
+		}
+
+		key := object.Schema + "." + object.Name
+		if i, ok := constraints[key]; ok && object.Validate {
+			objects[i].Definition += "\n" + object.Definition
+			continue
+		}
+		if object.Constraint != "" {
+			constraints[key] = len(objects)
+		}
+		previous = len(objects)
+		objects = append(objects, object)
+	}
+
+	var buf bytes.Buffer
+	for _, object := range objects {
+		fmt.Fprintf(&buf, "\n--\n-- Name: %s; Type: %s; Schema: %s; Owner: -\n--\n\n%s\n",
+			object.Name, object.Type, object.Schema, object.Definition)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,390 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbtest"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func testCockroachDriver(t *testing.T) *CockroachDriver {
+	u := dbtest.GetenvURLOrSkip(t, "COCKROACH_TEST_URL")
+	drv, err := dbmate.New(u).Driver()
+	require.NoError(t, err)
+
+	return drv.(*CockroachDriver)
+}
+
+func prepTestCockroachDB(t *testing.T) (*CockroachDriver, *sql.DB) {
+	drv := testCockroachDriver(t)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// connect database
+	db, err := drv.Open()
+	require.NoError(t, err)
+
+	return drv, db
+}
+
+func TestGetCockroachDriver(t *testing.T) {
+	for _, scheme := range []string{"cockroach", "cockroachdb"} {
+		t.Run(scheme, func(t *testing.T) {
+			db := dbmate.New(dbtest.MustParseURL(t, scheme+"://"))
+			drvInterface, err := db.Driver()
+			require.NoError(t, err)
+
+			drv, ok := drvInterface.(*CockroachDriver)
+			require.True(t, ok)
+			require.Equal(t, db.DatabaseURL.String(), drv.databaseURL.String())
+			require.Equal(t, "schema_migrations", drv.migrationsTableName)
+			require.Implements(t, (*dbmate.TransactionRunner)(nil), drvInterface)
+		})
+	}
+}
+
+func TestCockroachConnectionString(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		// cockroach listens on port 26257, and not on a unix socket
+		{"cockroach:///foo", "postgres://localhost:26257/foo"},
+		{"cockroachdb://root@myhost/foo?sslmode=disable", "postgres://root@myhost:26257/foo?sslmode=disable"},
+		{"cockroach://myhost:1234/foo", "postgres://myhost:1234/foo"},
+		{"cockroach:///foo?host=/tmp", "postgres://:26257/foo?host=%2Ftmp"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			u, err := url.Parse(c.input)
+			require.NoError(t, err)
+
+			require.Equal(t, c.expected, connectionString(u))
+		})
+	}
+}
+
+func TestParseCockroachObject(t *testing.T) {
+	cases := []struct {
+		definition string
+		expected   cockroachObject
+	}{
+		{"CREATE SCHEMA app;", cockroachObject{Type: "SCHEMA", Schema: "-", Name: "app"}},
+		{"CREATE TYPE public.mood AS ENUM ('happy', 'sad');", cockroachObject{Type: "TYPE", Schema: "public", Name: "mood"}},
+		{"CREATE TABLE public.users (\n\tid INT8 NOT NULL\n);",
+			cockroachObject{Type: "TABLE", Schema: "public", Name: "users", Table: "users"}},
+		{`CREATE TABLE "My Schema"."user.ids" (id INT8);`,
+			cockroachObject{Type: "TABLE", Schema: "My Schema", Name: "user.ids", Table: "user.ids"}},
+		{"CREATE SEQUENCE app.ids MINVALUE 1;",
+			cockroachObject{Type: "SEQUENCE", Schema: "app", Name: "ids", Table: "ids"}},
+		{"CREATE MATERIALIZED VIEW public.totals (\n\ttotal\n) AS SELECT 1;",
+			cockroachObject{Type: "MATERIALIZED VIEW", Schema: "public", Name: "totals", Table: "totals"}},
+		{"ALTER TABLE public.orders ADD CONSTRAINT orders_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);",
+			cockroachObject{Type: "FK CONSTRAINT", Schema: "public", Name: "orders orders_user_id_fkey",
+				Table: "orders", Constraint: "orders_user_id_fkey"}},
+		{`ALTER TABLE public.orders VALIDATE CONSTRAINT "orders_user_id_fkey";`,
+			cockroachObject{Type: "FK CONSTRAINT", Schema: "public", Name: "orders orders_user_id_fkey",
+				Table: "orders", Constraint: "orders_user_id_fkey", Validate: true}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.definition, func(t *testing.T) {
+			object, ok := parseCockroachObject(c.definition)
+			require.True(t, ok)
+			c.expected.Definition = c.definition
+			require.Equal(t, c.expected, object)
+		})
+	}
+
+	_, ok := parseCockroachObject("COMMENT ON TABLE public.users IS 'users'")
+	require.False(t, ok)
+}
+
+func TestFormatCockroachObjects(t *testing.T) {
+	definitions := []string{
+		"CREATE SCHEMA public;",
+		"CREATE SCHEMA app;",
+		"CREATE TYPE public.mood AS ENUM ('happy', 'sad');",
+		"CREATE TABLE public.users (id INT8 NOT NULL);",
+		"COMMENT ON TABLE public.users IS 'users';",
+		"CREATE TABLE public.audit_log (id INT8 NOT NULL);",
+		"COMMENT ON TABLE public.audit_log IS 'audit';",
+		"CREATE TABLE app.orders (id INT8 NOT NULL, user_id INT8);",
+		"CREATE VIEW public.user_ids (id) AS SELECT id FROM public.users;",
+		"ALTER TABLE app.orders ADD CONSTRAINT orders_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);",
+		"ALTER TABLE app.orders VALIDATE CONSTRAINT orders_user_id_fkey;",
+	}
+
+	t.Run("all objects", func(t *testing.T) {
+		drv := NewCockroachDriver(dbmate.DriverConfig{
+			DatabaseURL: dbtest.MustParseURL(t, "cockroach://localhost/foo"),
+		}).(*CockroachDriver)
+
+		require.Equal(t, "\n--\n-- Name: app; Type: SCHEMA; Schema: -; Owner: -\n--\n\n"+
+			"CREATE SCHEMA app;\n"+
+			"\n--\n-- Name: mood; Type: TYPE; Schema: public; Owner: -\n--\n\n"+
+			"CREATE TYPE public.mood AS ENUM ('happy', 'sad');\n"+
+			"\n--\n-- Name: users; Type: TABLE; Schema: public; Owner: -\n--\n\n"+
+			"CREATE TABLE public.users (id INT8 NOT NULL);\n"+
+			"COMMENT ON TABLE public.users IS 'users';\n"+
+			"\n--\n-- Name: audit_log; Type: TABLE; Schema: public; Owner: -\n--\n\n"+
+			"CREATE TABLE public.audit_log (id INT8 NOT NULL);\n"+
+			"COMMENT ON TABLE public.audit_log IS 'audit';\n"+
+			"\n--\n-- Name: orders; Type: TABLE; Schema: app; Owner: -\n--\n\n"+
+			"CREATE TABLE app.orders (id INT8 NOT NULL, user_id INT8);\n"+
+			"\n--\n-- Name: user_ids; Type: VIEW; Schema: public; Owner: -\n--\n\n"+
+			"CREATE VIEW public.user_ids (id) AS SELECT id FROM public.users;\n"+
+			"\n--\n-- Name: orders orders_user_id_fkey; Type: FK CONSTRAINT; Schema: app; Owner: -\n--\n\n"+
+			"ALTER TABLE app.orders ADD CONSTRAINT orders_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);\n"+
+			"ALTER TABLE app.orders VALIDATE CONSTRAINT orders_user_id_fkey;\n",
+			string(drv.formatCockroachObjects(definitions)))
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		drv := NewCockroachDriver(dbmate.DriverConfig{
+			DatabaseURL: dbtest.MustParseURL(t, "cockroach://localhost/foo"),
+			DumpOptions: dbmate.DumpOptions{
+				ExcludeTables:  []string{"audit_*"},
+				IncludeSchemas: []string{"public"},
+				Skip:           []string{dbmate.DumpSkipViews, dbmate.DumpSkipTypes},
+			},
+		}).(*CockroachDriver)
+
+		require.Equal(t, "\n--\n-- Name: users; Type: TABLE; Schema: public; Owner: -\n--\n\n"+
+			"CREATE TABLE public.users (id INT8 NOT NULL);\n"+
+			"COMMENT ON TABLE public.users IS 'users';\n",
+			string(drv.formatCockroachObjects(definitions)))
+	})
+}
+
+func TestIsCockroachRetryError(t *testing.T) {
+	retryErr := &pq.Error{Code: "40001", Message: "restart transaction"}
+
+	require.True(t, isCockroachRetryError(retryErr))
+	require.True(t, isCockroachRetryError(&dbmate.QueryError{Err: retryErr}))
+	require.True(t, isCockroachRetryError(fmt.Errorf("batch at line 1: %w", retryErr)))
+	require.False(t, isCockroachRetryError(&pq.Error{Code: "42601"}))
+	require.False(t, isCockroachRetryError(errors.New("40001")))
+}
+
+func TestCockroachDumpMethod(t *testing.T) {
+	drv := NewCockroachDriver(dbmate.DriverConfig{
+		DatabaseURL: dbtest.MustParseURL(t, "cockroach://localhost/foo"),
+		DumpMethod:  dbmate.DumpMethodTool,
+	})
+
+	_, err := drv.DumpSchema(nil)
+	require.ErrorIs(t, err, dbmate.ErrUnsupportedDumpMethod)
+}
+
+func TestCockroachCreateDropDatabase(t *testing.T) {
+	drv := testCockroachDriver(t)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	exists, err := drv.DatabaseExists()
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// drop the database
+	err = drv.DropDatabase()
+	require.NoError(t, err)
+
+	exists, err = drv.DatabaseExists()
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestCockroachCreateMigrationsTable(t *testing.T) {
+	t.Run("default schema", func(t *testing.T) {
+		drv, db := prepTestCockroachDB(t)
+		defer dbutil.MustClose(db)
+
+		exists, err := drv.MigrationsTableExists(db)
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		err = drv.CreateMigrationsTable(db)
+		require.NoError(t, err)
+
+		exists, err = drv.MigrationsTableExists(db)
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		// create table should be idempotent
+		err = drv.CreateMigrationsTable(db)
+		require.NoError(t, err)
+	})
+
+	t.Run("custom schema", func(t *testing.T) {
+		drv, db := prepTestCockroachDB(t)
+		defer dbutil.MustClose(db)
+		drv.migrationsTableName = "camelSchema.testMigrations"
+
+		err := drv.CreateMigrationsTable(db)
+		require.NoError(t, err)
+
+		exists, err := drv.MigrationsTableExists(db)
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		count, err := dbutil.QueryValue(db, `select count(*) from "camelSchema"."testMigrations"`)
+		require.NoError(t, err)
+		require.Equal(t, "0", count)
+	})
+}
+
+func TestCockroachDumpSchema(t *testing.T) {
+	drv, db := prepTestCockroachDB(t)
+	defer dbutil.MustClose(db)
+	drv.dumpOptions = dbmate.DumpOptions{DataTables: []string{"countries"}}
+
+	_, err := db.Exec(`create type mood as enum ('happy', 'sad');
+		create table users (id int8 primary key, mood mood);
+		create table orders (id int8 primary key, user_id int8 references users (id));
+		create view user_ids as select id from users;
+		create table countries (code string primary key);
+		insert into countries (code) values ('NZ'), ('AU')`)
+	require.NoError(t, err)
+
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "-- Name: mood; Type: TYPE; Schema: public; Owner: -\n")
+	require.Contains(t, string(schema), "CREATE TABLE public.users (")
+	require.Contains(t, string(schema), "CREATE VIEW public.user_ids")
+	require.Contains(t, string(schema), "-- Name: orders orders_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -\n")
+	require.Contains(t, string(schema), "INSERT INTO public.countries (code) VALUES\n"+
+		"    ('AU'),\n"+
+		"    ('NZ');\n")
+	require.Contains(t, string(schema), "-- Dbmate schema migrations\n"+
+		"--\n\n"+
+		"INSERT INTO public.schema_migrations (version) VALUES\n"+
+		"    ('abc1');\n")
+
+	// the dump should recreate the schema
+	drv.dumpOptions = dbmate.DumpOptions{}
+	before, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+
+	err = drv.DropDatabase()
+	require.NoError(t, err)
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+	_, err = db.Exec(string(schema))
+	require.NoError(t, err)
+
+	after, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, string(before), string(after))
+}
+
+func TestCockroachRunTransaction(t *testing.T) {
+	cockroachRetryDelay = time.Millisecond
+
+	t.Run("retries serialization errors", func(t *testing.T) {
+		drv, db := prepTestCockroachDB(t)
+		defer dbutil.MustClose(db)
+
+		_, err := db.Exec("create table counter (n int8)")
+		require.NoError(t, err)
+
+		attempts := 0
+		err = drv.RunTransaction(db, func(tx dbutil.Transaction) error {
+			attempts++
+			if _, err := tx.Exec("insert into counter (n) values (1)"); err != nil {
+				return err
+			}
+			if attempts < 3 {
+				// crdb_internal.force_retry fails the transaction with a retry error
+				_, err := tx.Exec("select crdb_internal.force_retry('1h')")
+				return err
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, attempts)
+
+		count, err := dbutil.QueryValue(db, "select count(*) from counter")
+		require.NoError(t, err)
+		require.Equal(t, "1", count)
+	})
+
+	t.Run("rolls back ddl", func(t *testing.T) {
+		drv, db := prepTestCockroachDB(t)
+		defer dbutil.MustClose(db)
+
+		err := drv.RunTransaction(db, func(tx dbutil.Transaction) error {
+			if _, err := tx.Exec("create table users (id int8 primary key)"); err != nil {
+				return err
+			}
+			_, err := tx.Exec("select fail")
+			return err
+		})
+		require.Error(t, err)
+
+		count, err := dbutil.QueryValue(db,
+			"select count(*) from information_schema.tables where table_name = 'users'")
+		require.NoError(t, err)
+		require.Equal(t, "0", count)
+	})
+}
+
+func TestCockroachSelectInsertDeleteMigrations(t *testing.T) {
+	drv, db := prepTestCockroachDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	err = drv.RunTransaction(db, func(tx dbutil.Transaction) error {
+		return drv.InsertMigration(tx, "abc2")
+	})
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true, "abc2": true}, migrations)
+
+	err = drv.DeleteMigration(db, "abc2")
+	require.NoError(t, err)
+
+	migrations, err = drv.SelectMigrations(db, 1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true}, migrations)
+}
+
+func TestCockroachPing(t *testing.T) {
+	drv := testCockroachDriver(t)
+
+	err := drv.Ping()
+	require.NoError(t, err)
+}
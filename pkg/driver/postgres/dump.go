@@ -0,0 +1,465 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/lib/pq"
+)
+
+// dumpMethod returns the configured schema dump method. The --dump-method flag takes
+// precedence over the dump_method URL parameter, and pg_dump is used by default.
+func (drv *Driver) dumpMethod() (string, error) {
+	method := drv.dumpMethodName
+	if method == "" {
+		method = drv.databaseURL.Query().Get("dump_method")
+	}
+
+	switch method {
+	case "", "pg_dump", dbmate.DumpMethodTool:
+		return dbmate.DumpMethodTool, nil
+	case dbmate.DumpMethodNative:
+		return dbmate.DumpMethodNative, nil
+	case dbmate.DumpMethodAuto:
+		if _, err := exec.LookPath("pg_dump"); err != nil {
+			return dbmate.DumpMethodNative, nil
+		}
+		return dbmate.DumpMethodTool, nil
+	default:
+		return "", fmt.Errorf("%w: %s", dbmate.ErrUnsupportedDumpMethod, method)
+	}
+}
+
+// dumpObject is a single object in a native schema dump
+type dumpObject struct {
+	Schema     string
+	Name       string
+	Definition string
+	// Tables lists the tables (or views and sequences) which the object belongs to
+	Tables []string
+}
+
+// dumpSection queries one type of object for a native schema dump. Each query must return
+// the schema, name, definition, and tables of each object, and may use the following placeholders:
+//
+//	%[1]s  condition which limits the pg_namespace alias n to the dumped schemas
+//	%[2]s  condition which excludes objects created by an extension (identified by Object)
+//
+// and the dumped schemas as the $1 parameter (an empty array means all schemas).
+type dumpSection struct {
+	Type   string
+	Skip   string
+	Object string
+	Query  string
+}
+
+// dumpSections are dumped in order, so that each object is created after its dependencies
+var dumpSections = []dumpSection{
+	{"SCHEMA", "", "n.oid", `select n.nspname, n.nspname, format('CREATE SCHEMA %%I;', n.nspname),
+			'{}'::text[]
+		from pg_namespace n
+		where %[1]s and n.nspname <> 'public' and %[2]s
+		order by n.nspname`},
+	{"EXTENSION", "extensions", "e.oid", `select n.nspname, e.extname,
+			format('CREATE EXTENSION IF NOT EXISTS %%I WITH SCHEMA %%I;', e.extname, n.nspname),
+			'{}'::text[]
+		from pg_extension e
+		join pg_namespace n on n.oid = e.extnamespace
+		where %[1]s and e.extname <> 'plpgsql'
+		order by e.extname`},
+	{"TYPE", "types", "t.oid", `select n.nspname, t.typname,
+			format(E'CREATE TYPE %%I.%%I AS ENUM (\n    %%s\n);', n.nspname, t.typname,
+				(select string_agg(quote_literal(e.enumlabel), E',\n    ' order by e.enumsortorder)
+				from pg_enum e where e.enumtypid = t.oid)),
+			'{}'::text[]
+		from pg_type t
+		join pg_namespace n on n.oid = t.typnamespace
+		where t.typtype = 'e' and %[1]s and %[2]s
+		order by n.nspname, t.typname`},
+	{"DOMAIN", "types", "t.oid", `select n.nspname, t.typname,
+			format('CREATE DOMAIN %%I.%%I AS %%s%%s%%s%%s;', n.nspname, t.typname,
+				format_type(t.typbasetype, t.typtypmod),
+				coalesce(' DEFAULT ' || t.typdefault, ''),
+				case when t.typnotnull then ' NOT NULL' else '' end,
+				coalesce((select string_agg(format(E'\n    CONSTRAINT %%I %%s', c.conname, pg_get_constraintdef(c.oid)), ''
+					order by c.conname)
+				from pg_constraint c where c.contypid = t.oid), '')),
+			'{}'::text[]
+		from pg_type t
+		join pg_namespace n on n.oid = t.typnamespace
+		where t.typtype = 'd' and %[1]s and %[2]s
+		order by n.nspname, t.typname`},
+	{"TYPE", "types", "t.oid", `select n.nspname, t.typname,
+			format(E'CREATE TYPE %%I.%%I AS (\n    %%s\n);', n.nspname, t.typname,
+				(select string_agg(format('%%I %%s', a.attname, format_type(a.atttypid, a.atttypmod)), E',\n    '
+					order by a.attnum)
+				from pg_attribute a where a.attrelid = t.typrelid and a.attnum > 0 and not a.attisdropped)),
+			'{}'::text[]
+		from pg_type t
+		join pg_namespace n on n.oid = t.typnamespace
+		join pg_class c on c.oid = t.typrelid
+		where t.typtype = 'c' and c.relkind = 'c' and %[1]s and %[2]s
+		order by n.nspname, t.typname`},
+	{"FUNCTION", "functions", "p.oid", `select n.nspname, format('%%s(%%s)', p.proname, pg_get_function_identity_arguments(p.oid)),
+			rtrim(pg_get_functiondef(p.oid), E'\n') || ';',
+			'{}'::text[]
+		from pg_proc p
+		join pg_namespace n on n.oid = p.pronamespace
+		where p.prokind in ('f', 'p') and %[1]s and %[2]s
+		order by n.nspname, p.proname, pg_get_function_identity_arguments(p.oid)`},
+	{"SEQUENCE", "sequences", "c.oid", `select n.nspname, c.relname,
+			format(E'CREATE SEQUENCE %%I.%%I\n    AS %%s\n    START WITH %%s\n    INCREMENT BY %%s\n    MINVALUE %%s\n    MAXVALUE %%s\n    CACHE %%s%%s;',
+				n.nspname, c.relname, format_type(s.seqtypid, null), s.seqstart, s.seqincrement,
+				s.seqmin, s.seqmax, s.seqcache, case when s.seqcycle then E'\n    CYCLE' else '' end),
+			array[c.relname]
+		from pg_sequence s
+		join pg_class c on c.oid = s.seqrelid
+		join pg_namespace n on n.oid = c.relnamespace
+		where %[1]s and %[2]s
+		and not exists (select 1 from pg_depend d
+			where d.classid = 'pg_class'::regclass and d.objid = c.oid and d.deptype = 'i')
+		order by n.nspname, c.relname`},
+	{"TABLE", "", "c.oid", `select n.nspname, c.relname,
+			format(E'CREATE %%sTABLE %%I.%%I (\n%%s\n)%%s;',
+				case when c.relpersistence = 'u' then 'UNLOGGED ' else '' end,
+				n.nspname, c.relname,
+				concat_ws(E',\n',
+					(select string_agg(format('    %%I %%s%%s%%s%%s%%s', a.attname, format_type(a.atttypid, a.atttypmod),
+						case when a.attcollation <> t.typcollation and co.oid is not null
+							then format(' COLLATE %%I.%%I', cn.nspname, co.collname) else '' end,
+						case when a.attgenerated = 's'
+							then format(' GENERATED ALWAYS AS (%%s) STORED', pg_get_expr(d.adbin, d.adrelid))
+							when d.adbin is not null then ' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid)
+							else '' end,
+						case a.attidentity when 'a' then ' GENERATED ALWAYS AS IDENTITY'
+							when 'd' then ' GENERATED BY DEFAULT AS IDENTITY' else '' end,
+						case when a.attnotnull then ' NOT NULL' else '' end), E',\n' order by a.attnum)
+					from pg_attribute a
+					join pg_type t on t.oid = a.atttypid
+					left join pg_attrdef d on d.adrelid = a.attrelid and d.adnum = a.attnum
+					left join pg_collation co on co.oid = a.attcollation
+					left join pg_namespace cn on cn.oid = co.collnamespace
+					where a.attrelid = c.oid and a.attnum > 0 and not a.attisdropped),
+					(select string_agg(format('    CONSTRAINT %%I %%s', k.conname, pg_get_constraintdef(k.oid)), E',\n'
+						order by k.conname)
+					from pg_constraint k
+					where k.conrelid = c.oid and k.contype in ('p', 'u', 'c', 'x'))),
+				case when c.relkind = 'p' then ' PARTITION BY ' || pg_get_partkeydef(c.oid) else '' end),
+			array[c.relname]
+		from pg_class c
+		join pg_namespace n on n.oid = c.relnamespace
+		where c.relkind in ('r', 'p') and not c.relispartition and %[1]s and %[2]s
+		order by n.nspname, c.relname`},
+	{"TABLE", "partitions", "c.oid", `select n.nspname, c.relname,
+			format('CREATE TABLE %%I.%%I PARTITION OF %%I.%%I %%s;', n.nspname, c.relname,
+				pn.nspname, p.relname, pg_get_expr(c.relpartbound, c.oid)),
+			array[c.relname, p.relname]
+		from pg_class c
+		join pg_namespace n on n.oid = c.relnamespace
+		join pg_inherits i on i.inhrelid = c.oid
+		join pg_class p on p.oid = i.inhparent
+		join pg_namespace pn on pn.oid = p.relnamespace
+		where c.relkind in ('r', 'p') and c.relispartition and %[1]s and %[2]s
+		order by c.oid`},
+	{"VIEW", "views", "c.oid", `select n.nspname, c.relname,
+			case when c.relkind = 'm'
+				then format(E'CREATE MATERIALIZED VIEW %%I.%%I AS\n%%s\n  WITH NO DATA;', n.nspname, c.relname,
+					rtrim(pg_get_viewdef(c.oid), ';'))
+				else format(E'CREATE VIEW %%I.%%I AS\n%%s', n.nspname, c.relname, pg_get_viewdef(c.oid))
+			end,
+			array[c.relname]
+		from pg_class c
+		join pg_namespace n on n.oid = c.relnamespace
+		where c.relkind in ('v', 'm') and %[1]s and %[2]s
+		order by c.oid`},
+	{"FK CONSTRAINT", "", "c.oid", `select n.nspname, format('%%s %%s', c.relname, k.conname),
+			format(E'ALTER TABLE ONLY %%I.%%I\n    ADD CONSTRAINT %%I %%s;', n.nspname, c.relname,
+				k.conname, pg_get_constraintdef(k.oid)),
+			array[c.relname, (select f.relname from pg_class f where f.oid = k.confrelid)]
+		from pg_constraint k
+		join pg_class c on c.oid = k.conrelid
+		join pg_namespace n on n.oid = c.relnamespace
+		where k.contype = 'f' and k.conparentid = 0 and %[1]s and %[2]s
+		order by n.nspname, c.relname, k.conname`},
+	{"INDEX", "indexes", "c.oid", `select n.nspname, i.relname, pg_get_indexdef(x.indexrelid) || ';',
+			array[c.relname]
+		from pg_index x
+		join pg_class i on i.oid = x.indexrelid
+		join pg_class c on c.oid = x.indrelid
+		join pg_namespace n on n.oid = c.relnamespace
+		where c.relkind in ('r', 'p', 'm') and %[1]s and %[2]s
+		and not exists (select 1 from pg_constraint k where k.conindid = x.indexrelid and k.conrelid = x.indrelid)
+		and not exists (select 1 from pg_inherits h where h.inhrelid = x.indexrelid)
+		order by n.nspname, i.relname`},
+	{"TRIGGER", "triggers", "c.oid", `select n.nspname, format('%%s %%s', c.relname, t.tgname), pg_get_triggerdef(t.oid) || ';',
+			array[c.relname]
+		from pg_trigger t
+		join pg_class c on c.oid = t.tgrelid
+		join pg_namespace n on n.oid = c.relnamespace
+		where not t.tgisinternal and %[1]s and %[2]s
+		and not exists (select 1 from pg_depend d
+			where d.classid = 'pg_trigger'::regclass and d.objid = t.oid and d.deptype = 'P')
+		order by n.nspname, c.relname, t.tgname`},
+	{"SEQUENCE OWNED BY", "sequences", "s.oid", `select n.nspname, s.relname,
+			format('ALTER SEQUENCE %%I.%%I OWNED BY %%I.%%I.%%I;', n.nspname, s.relname, tn.nspname, t.relname, a.attname),
+			array[s.relname, t.relname]
+		from pg_depend d
+		join pg_class s on s.oid = d.objid and s.relkind = 'S'
+		join pg_namespace n on n.oid = s.relnamespace
+		join pg_class t on t.oid = d.refobjid
+		join pg_namespace tn on tn.oid = t.relnamespace
+		join pg_attribute a on a.attrelid = t.oid and a.attnum = d.refobjsubid
+		where d.classid = 'pg_class'::regclass and d.refclassid = 'pg_class'::regclass
+		and d.deptype = 'a' and %[1]s and %[2]s
+		order by n.nspname, s.relname`},
+}
+
+// dumpSectionQuery fills in the placeholders of a dump section query
+func dumpSectionQuery(section dumpSection) string {
+	schemaFilter := "(n.nspname = any($1) or (cardinality($1::text[]) = 0 " +
+		"and n.nspname <> 'information_schema' and n.nspname !~ '^pg_'))"
+	extensionFilter := fmt.Sprintf("not exists (select 1 from pg_depend ext "+
+		"where ext.objid = %s and ext.deptype = 'e')", section.Object)
+
+	return fmt.Sprintf(section.Query, schemaFilter, extensionFilter)
+}
+
+// nativeDumpSchema dumps the schema by querying the system catalogs, without pg_dump
+func (drv *Driver) nativeDumpSchema(db *sql.DB) ([]byte, error) {
+	schemas := drv.dumpSchemas()
+
+	// use an empty search path so that all names are qualified with their schema
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if _, err := tx.Exec("set local search_path = ''"); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("SET statement_timeout = 0;\n" +
+		"SET lock_timeout = 0;\n" +
+		"SET client_encoding = 'UTF8';\n" +
+		"SET standard_conforming_strings = on;\n" +
+		"SELECT pg_catalog.set_config('search_path', '', false);\n" +
+		"SET check_function_bodies = false;\n" +
+		"SET client_min_messages = warning;\n")
+
+	for _, section := range dumpSections {
+		if section.Skip != "" && drv.dumpOptions.Skips(section.Skip) {
+			continue
+		}
+
+		objects, err := queryDumpObjects(tx, dumpSectionQuery(section), schemas)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dump %s: %w", strings.ToLower(section.Type), err)
+		}
+
+		for _, object := range objects {
+			if drv.excludesDumpObject(object) {
+				continue
+			}
+			fmt.Fprintf(&buf, "\n--\n-- Name: %s; Type: %s; Schema: %s\n--\n\n%s\n",
+				object.Name, section.Type, object.Schema, object.Definition)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func queryDumpObjects(db dbutil.Transaction, query string, schemas []string) ([]dumpObject, error) {
+	rows, err := db.Query(query, pq.Array(schemas))
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	objects := []dumpObject{}
+	for rows.Next() {
+		var object dumpObject
+		if err := rows.Scan(&object.Schema, &object.Name, &object.Definition, pq.Array(&object.Tables)); err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, rows.Err()
+}
+
+// dumpSchemas returns the schemas to dump: DumpOptions.IncludeSchemas if set, otherwise
+// the search_path URL parameter. An empty list means all schemas.
+func (drv *Driver) dumpSchemas() []string {
+	if len(drv.dumpOptions.IncludeSchemas) > 0 {
+		return drv.dumpOptions.IncludeSchemas
+	}
+
+	schemas := []string{}
+	for _, schema := range strings.Split(drv.databaseURL.Query().Get("search_path"), ",") {
+		if schema = strings.TrimSpace(schema); schema != "" {
+			schemas = append(schemas, schema)
+		}
+	}
+
+	return schemas
+}
+
+// excludesDumpObject returns true if an object belongs to a table excluded by DumpOptions
+func (drv *Driver) excludesDumpObject(object dumpObject) bool {
+	for _, table := range object.Tables {
+		if drv.dumpOptions.ExcludesTable(object.Schema, table) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pgDumpSkipTypes maps DumpOptions.Skip object types to the object types in pg_dump output
+var pgDumpSkipTypes = map[string][]string{
+	dbmate.DumpSkipFunctions:  {"FUNCTION", "PROCEDURE", "AGGREGATE"},
+	dbmate.DumpSkipViews:      {"VIEW", "MATERIALIZED VIEW"},
+	dbmate.DumpSkipTriggers:   {"TRIGGER"},
+	dbmate.DumpSkipIndexes:    {"INDEX", "INDEX ATTACH"},
+	dbmate.DumpSkipSequences:  {"SEQUENCE", "SEQUENCE OWNED BY", "DEFAULT"},
+	dbmate.DumpSkipTypes:      {"TYPE", "DOMAIN"},
+	dbmate.DumpSkipExtensions: {"EXTENSION"},
+	dbmate.DumpSkipPartitions: {"TABLE ATTACH"},
+}
+
+// pgDumpBlockRegexp matches the comment which starts each object in pg_dump output
+var pgDumpBlockRegexp = regexp.MustCompile(`(?m)^--\n-- Name: (.*); Type: (.*?); Schema: .*\n--\n`)
+
+// pgDumpEndRegexp matches the comment at the end of pg_dump output
+var pgDumpEndRegexp = regexp.MustCompile(`(?m)^--\n-- PostgreSQL database dump complete\n`)
+
+// dumpURL returns the database URL for pg_dump, with search_path replaced by
+// DumpOptions.IncludeSchemas if set
+func (drv *Driver) dumpURL() *url.URL {
+	if len(drv.dumpOptions.IncludeSchemas) == 0 {
+		return drv.databaseURL
+	}
+
+	u := *drv.databaseURL
+	query := u.Query()
+	query.Set("search_path", strings.Join(drv.dumpOptions.IncludeSchemas, ","))
+	u.RawQuery = query.Encode()
+
+	return &u
+}
+
+// pgDumpFilterArgs returns the pg_dump arguments which exclude tables and partitions
+func (drv *Driver) pgDumpFilterArgs(db *sql.DB) ([]string, error) {
+	args := []string{}
+	for _, pattern := range drv.dumpOptions.ExcludeTables {
+		args = append(args, "--exclude-table="+pattern)
+	}
+
+	if drv.dumpOptions.Skips(dbmate.DumpSkipPartitions) {
+		partitions, err := dbutil.QueryColumn(db, `select format('%I.%I', n.nspname, c.relname)
+			from pg_class c
+			join pg_namespace n on n.oid = c.relnamespace
+			where c.relispartition and c.relkind in ('r', 'p')
+			order by 1`)
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			args = append(args, "--exclude-table="+partition)
+		}
+	}
+
+	return args, nil
+}
+
+// filterPgDumpBlocks removes objects with types listed in DumpOptions.Skip from pg_dump output
+func (drv *Driver) filterPgDumpBlocks(schema []byte) []byte {
+	skip := map[string]bool{}
+	for _, objectType := range drv.dumpOptions.Skip {
+		for _, pgType := range pgDumpSkipTypes[objectType] {
+			skip[pgType] = true
+		}
+	}
+	if len(skip) == 0 {
+		return schema
+	}
+
+	end := len(schema)
+	if loc := pgDumpEndRegexp.FindIndex(schema); loc != nil {
+		end = loc[0]
+	}
+
+	var buf bytes.Buffer
+	offset := 0
+	blocks := pgDumpBlockRegexp.FindAllSubmatchIndex(schema[:end], -1)
+	for i, block := range blocks {
+		blockEnd := end
+		if i+1 < len(blocks) {
+			blockEnd = blocks[i+1][0]
+		}
+
+		name := string(schema[block[2]:block[3]])
+		objectType := string(schema[block[4]:block[5]])
+		// extension comments are named "EXTENSION <name>"
+		if skip[objectType] || (objectType == "COMMENT" && strings.HasPrefix(name, "EXTENSION ") && skip["EXTENSION"]) {
+			buf.Write(schema[offset:block[0]])
+			offset = blockEnd
+		}
+	}
+	buf.Write(schema[offset:])
+
+	return buf.Bytes()
+}
+
+// dataDumpSyntax formats rows as lists of quoted values
+var dataDumpSyntax = dbutil.DataDumpSyntax{
+	RowSQL: func(values []string) string {
+		return "concat_ws(', ', " + strings.Join(values, ", ") + ")"
+	},
+	Indent: "    ",
+}
+
+// tableDataDump returns INSERT statements for the rows of each table in DumpOptions.DataTables
+func (drv *Driver) tableDataDump(db *sql.DB) ([]byte, error) {
+	return dataDumpSyntax.DumpTableData(db, drv.dumpOptions.DataTables, func(table string) (dbutil.DataTable, error) {
+		var schema, name string
+		err := db.QueryRow(`select n.nspname, c.relname from pg_class c
+			join pg_namespace n on n.oid = c.relnamespace
+			where c.oid = $1::regclass`, table).Scan(&schema, &name)
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+
+		// generated columns cannot be inserted
+		columns, err := dbutil.QueryColumn(db, `select column_name from information_schema.columns
+			where table_schema = $1 and table_name = $2 and is_generated = 'NEVER'
+			order by ordinal_position`, schema, name)
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+
+		data := dbutil.DataTable{
+			Name:   pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name),
+			Header: fmt.Sprintf("\n--\n-- Data for Name: %s; Type: TABLE DATA; Schema: %s; Owner: -\n--\n\n", name, schema),
+		}
+		for _, column := range columns {
+			column = pq.QuoteIdentifier(column)
+			data.Columns = append(data.Columns, column)
+			data.Values = append(data.Values, "quote_nullable("+column+")")
+		}
+
+		return data, nil
+	})
+}
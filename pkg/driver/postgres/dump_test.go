@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbtest"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dumpRowsDriver is a database/sql driver whose queries all return dumpRowsValues, so that
+// dump queries can be scanned without a postgres server
+type dumpRowsDriver struct{}
+
+var dumpRowsValues = [][]driver.Value{
+	{"public", "users_email", "CREATE INDEX users_email ON public.users USING btree (email);", []byte("{users}")},
+	{"public", "posts_title", "CREATE INDEX posts_title ON public.posts USING btree (title);", []byte("{posts}")},
+	{"public", "public", "CREATE SCHEMA public;", []byte("{}")},
+}
+
+func init() {
+	sql.Register("dbmate-dump-rows", dumpRowsDriver{})
+}
+
+func (dumpRowsDriver) Open(string) (driver.Conn, error) { return dumpRowsConn{}, nil }
+
+type dumpRowsConn struct{}
+
+func (dumpRowsConn) Prepare(string) (driver.Stmt, error) { return dumpRowsStmt{}, nil }
+func (dumpRowsConn) Close() error                        { return nil }
+func (dumpRowsConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+type dumpRowsStmt struct{}
+
+func (dumpRowsStmt) Close() error                               { return nil }
+func (dumpRowsStmt) NumInput() int                              { return -1 }
+func (dumpRowsStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (dumpRowsStmt) Query([]driver.Value) (driver.Rows, error)  { return &dumpRows{}, nil }
+
+type dumpRows struct {
+	next int
+}
+
+func (*dumpRows) Columns() []string { return []string{"schema", "name", "definition", "tables"} }
+func (*dumpRows) Close() error      { return nil }
+
+func (r *dumpRows) Next(dest []driver.Value) error {
+	if r.next >= len(dumpRowsValues) {
+		return io.EOF
+	}
+	copy(dest, dumpRowsValues[r.next])
+	r.next++
+
+	return nil
+}
+
+func TestQueryDumpObjects(t *testing.T) {
+	db, err := sql.Open("dbmate-dump-rows", "")
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+
+	objects, err := queryDumpObjects(db, "select", []string{"public"})
+	require.NoError(t, err)
+	require.Equal(t, []dumpObject{
+		{"public", "users_email", "CREATE INDEX users_email ON public.users USING btree (email);", []string{"users"}},
+		{"public", "posts_title", "CREATE INDEX posts_title ON public.posts USING btree (title);", []string{"posts"}},
+		{"public", "public", "CREATE SCHEMA public;", []string{}},
+	}, objects)
+
+	// objects are excluded along with their tables
+	drv := NewDriver(dbmate.DriverConfig{
+		DatabaseURL: dbtest.MustParseURL(t, "postgres://localhost/dbname"),
+		DumpOptions: dbmate.DumpOptions{ExcludeTables: []string{"post*"}},
+	}).(*Driver)
+	require.False(t, drv.excludesDumpObject(objects[0]))
+	require.True(t, drv.excludesDumpObject(objects[1]))
+	require.False(t, drv.excludesDumpObject(objects[2]))
+}
@@ -0,0 +1,358 @@
+package postgres
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/lib/pq"
+)
+
+// serialDefaultRegexp matches the default of a column created with a serial type
+var serialDefaultRegexp = regexp.MustCompile(`^nextval\('[^']*_seq'::regclass\)$`)
+
+// serialTypes maps integer types to their serial equivalent
+var serialTypes = map[string]string{
+	"smallint": "smallserial",
+	"integer":  "serial",
+	"bigint":   "bigserial",
+}
+
+// InspectSchema returns the tables in the current schema
+func (drv *Driver) InspectSchema(db *sql.DB) (*dbmate.Schema, error) {
+	rows, err := db.Query(`select c.oid, c.relname
+		from pg_class c
+		join pg_namespace n on n.oid = c.relnamespace
+		where n.nspname = current_schema()
+		and c.relkind in ('r', 'p')
+		and not c.relispartition
+		order by c.relname`)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	oids := []int64{}
+	schema := &dbmate.Schema{}
+	for rows.Next() {
+		var oid int64
+		var table dbmate.SchemaTable
+		if err := rows.Scan(&oid, &table.Name); err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+		schema.Tables = append(schema.Tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range schema.Tables {
+		if err := drv.inspectTable(db, oids[i], &schema.Tables[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// views, functions and triggers are not compared
+	schema.Unplanned, err = dbutil.QueryColumn(db, `select kind || ' ' || name from (
+			select case c.relkind when 'v' then 'view' else 'materialized view' end as kind, c.relname as name
+			from pg_class c
+			join pg_namespace n on n.oid = c.relnamespace
+			where n.nspname = current_schema()
+			and c.relkind in ('v', 'm')
+			union all
+			select 'function', p.proname
+			from pg_proc p
+			join pg_namespace n on n.oid = p.pronamespace
+			where n.nspname = current_schema()
+			and not exists (
+				select 1 from pg_depend d
+				where d.classid = 'pg_proc'::regclass and d.objid = p.oid and d.deptype = 'e'
+			)
+			union all
+			select 'trigger', t.tgname || ' on ' || c.relname
+			from pg_trigger t
+			join pg_class c on c.oid = t.tgrelid
+			join pg_namespace n on n.oid = c.relnamespace
+			where n.nspname = current_schema()
+			and not t.tgisinternal
+		) objects
+		order by kind, name`)
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+func (drv *Driver) inspectTable(db *sql.DB, oid int64, table *dbmate.SchemaTable) error {
+	if err := drv.inspectColumns(db, oid, table); err != nil {
+		return err
+	}
+	if err := drv.inspectConstraints(db, oid, table); err != nil {
+		return err
+	}
+	if err := drv.inspectIndexes(db, oid, table); err != nil {
+		return err
+	}
+
+	lines := []string{}
+	for _, column := range table.Columns {
+		lines = append(lines, "    "+column.Definition)
+	}
+	for _, constraint := range table.Constraints {
+		lines = append(lines, fmt.Sprintf("    CONSTRAINT %s %s", pq.QuoteIdentifier(constraint.Name), constraint.Definition))
+	}
+	table.Definition = fmt.Sprintf("CREATE TABLE %s (\n%s\n)", pq.QuoteIdentifier(table.Name), strings.Join(lines, ",\n"))
+
+	return nil
+}
+
+func (drv *Driver) inspectColumns(db *sql.DB, oid int64, table *dbmate.SchemaTable) error {
+	rows, err := db.Query(`select a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+			coalesce(pg_get_expr(d.adbin, d.adrelid), ''), a.attidentity, a.attgenerated
+		from pg_attribute a
+		left join pg_attrdef d on d.adrelid = a.attrelid and d.adnum = a.attnum
+		where a.attrelid = $1 and a.attnum > 0 and not a.attisdropped
+		order by a.attnum`, oid)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var column dbmate.SchemaColumn
+		var identity, generated string
+		if err := rows.Scan(&column.Name, &column.Type, &column.NotNull, &column.Default, &identity, &generated); err != nil {
+			return err
+		}
+
+		suffix := ""
+		switch {
+		case generated == "s":
+			suffix = fmt.Sprintf(" GENERATED ALWAYS AS (%s) STORED", column.Default)
+			column.Default = ""
+		case identity == "a":
+			suffix = " GENERATED ALWAYS AS IDENTITY"
+		case identity == "d":
+			suffix = " GENERATED BY DEFAULT AS IDENTITY"
+		case serialTypes[column.Type] != "" && serialDefaultRegexp.MatchString(column.Default):
+			// the sequence is created along with the column
+			column.Type = serialTypes[column.Type]
+			column.Default = ""
+		}
+
+		column.Definition = pq.QuoteIdentifier(column.Name) + " " + column.Type
+		if column.Default != "" {
+			column.Definition += " DEFAULT " + column.Default
+		}
+		column.Definition += suffix
+		// identity columns are implicitly not null
+		if column.NotNull && identity == "" {
+			column.Definition += " NOT NULL"
+		}
+
+		table.Columns = append(table.Columns, column)
+	}
+
+	return rows.Err()
+}
+
+func (drv *Driver) inspectConstraints(db *sql.DB, oid int64, table *dbmate.SchemaTable) error {
+	rows, err := db.Query(`select c.conname, pg_get_constraintdef(c.oid), coalesce(r.relname, '')
+		from pg_constraint c
+		left join pg_class r on r.oid = c.confrelid
+		where c.conrelid = $1 and c.contype in ('p', 'u', 'f', 'c', 'x')
+		order by c.contype = 'f', c.conname`, oid)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var constraint dbmate.SchemaConstraint
+		var ref string
+		if err := rows.Scan(&constraint.Name, &constraint.Definition, &ref); err != nil {
+			return err
+		}
+		table.Constraints = append(table.Constraints, constraint)
+		if ref != "" && ref != table.Name {
+			table.References = append(table.References, ref)
+		}
+	}
+
+	return rows.Err()
+}
+
+func (drv *Driver) inspectIndexes(db *sql.DB, oid int64, table *dbmate.SchemaTable) error {
+	// strip the schema from index definitions, so that they can be compared across databases
+	rows, err := db.Query(`select c.relname,
+			replace(pg_get_indexdef(i.indexrelid), ' ON ' || quote_ident(n.nspname) || '.', ' ON ')
+		from pg_index i
+		join pg_class c on c.oid = i.indexrelid
+		join pg_namespace n on n.oid = c.relnamespace
+		where i.indrelid = $1
+		and not exists (select 1 from pg_constraint k where k.conindid = i.indexrelid and k.conrelid = i.indrelid)
+		order by c.relname`, oid)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var index dbmate.SchemaIndex
+		if err := rows.Scan(&index.Name, &index.Definition); err != nil {
+			return err
+		}
+		table.Indexes = append(table.Indexes, index)
+	}
+
+	return rows.Err()
+}
+
+// maxIdentifierLength is the number of bytes postgres keeps of an identifier
+const maxIdentifierLength = 63
+
+// ScratchDatabaseURL returns the URL of a temporary database on the same server, with a random
+// suffix so that it does not clash with an existing database
+func (drv *Driver) ScratchDatabaseURL() (*url.URL, error) {
+	u, err := url.Parse(drv.databaseURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	name := dbutil.DatabaseName(drv.databaseURL)
+	suffixName := fmt.Sprintf("_dbmate_scratch_%x", suffix)
+	if len(name)+len(suffixName) > maxIdentifierLength {
+		name = name[:maxIdentifierLength-len(suffixName)]
+	}
+	u.Path = "/" + name + suffixName
+
+	return u, nil
+}
+
+// AlterTableSQL returns the statements which change a table from one definition to another
+func (drv *Driver) AlterTableSQL(from, to dbmate.SchemaTable) ([]string, error) {
+	name := pq.QuoteIdentifier(to.Name)
+	statements := []string{}
+
+	// drop constraints which were removed or changed
+	toConstraints := map[string]dbmate.SchemaConstraint{}
+	for _, constraint := range to.Constraints {
+		toConstraints[constraint.Name] = constraint
+	}
+	for _, constraint := range from.Constraints {
+		if toConstraints[constraint.Name] != constraint {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s",
+				name, pq.QuoteIdentifier(constraint.Name)))
+		}
+	}
+
+	// columns
+	fromColumns := map[string]dbmate.SchemaColumn{}
+	for _, column := range from.Columns {
+		fromColumns[column.Name] = column
+	}
+	toColumns := map[string]dbmate.SchemaColumn{}
+	for _, column := range to.Columns {
+		toColumns[column.Name] = column
+	}
+	for _, column := range from.Columns {
+		if _, ok := toColumns[column.Name]; !ok {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s",
+				name, pq.QuoteIdentifier(column.Name)))
+		}
+	}
+	for _, column := range to.Columns {
+		fromColumn, ok := fromColumns[column.Name]
+		if !ok {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", name, column.Definition))
+			continue
+		}
+		if fromColumn.Definition == column.Definition {
+			continue
+		}
+
+		alter, err := alterColumnSQL(name, fromColumn, column)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s.%s", err, to.Name, column.Name)
+		}
+		statements = append(statements, alter...)
+	}
+
+	// add constraints which were added or changed
+	fromConstraints := map[string]dbmate.SchemaConstraint{}
+	for _, constraint := range from.Constraints {
+		fromConstraints[constraint.Name] = constraint
+	}
+	for _, constraint := range to.Constraints {
+		if fromConstraints[constraint.Name] != constraint {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s",
+				name, pq.QuoteIdentifier(constraint.Name), constraint.Definition))
+		}
+	}
+
+	return statements, nil
+}
+
+// alterColumnSQL returns the statements which change the type, default, and nullability of a column
+func alterColumnSQL(table string, from, to dbmate.SchemaColumn) ([]string, error) {
+	// serial, identity, and generated columns cannot be converted with a simple alter
+	if !isPlainColumn(from) || !isPlainColumn(to) {
+		return nil, fmt.Errorf("%w: unable to convert serial, identity, or generated column",
+			dbmate.ErrPlanUnsupportedChange)
+	}
+
+	column := pq.QuoteIdentifier(to.Name)
+	prefix := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s", table, column)
+	statements := []string{}
+
+	if from.Type != to.Type {
+		statements = append(statements, fmt.Sprintf("%s TYPE %s USING %s::%s", prefix, to.Type, column, to.Type))
+	}
+	if from.Default != to.Default {
+		if to.Default == "" {
+			statements = append(statements, prefix+" DROP DEFAULT")
+		} else {
+			statements = append(statements, prefix+" SET DEFAULT "+to.Default)
+		}
+	}
+	if from.NotNull != to.NotNull {
+		if to.NotNull {
+			statements = append(statements, prefix+" SET NOT NULL")
+		} else {
+			statements = append(statements, prefix+" DROP NOT NULL")
+		}
+	}
+
+	return statements, nil
+}
+
+// isPlainColumn returns true if a column is not a serial, identity, or generated column
+func isPlainColumn(column dbmate.SchemaColumn) bool {
+	for _, serialType := range serialTypes {
+		if column.Type == serialType {
+			return false
+		}
+	}
+
+	def := pq.QuoteIdentifier(column.Name) + " " + column.Type
+	if column.Default != "" {
+		def += " DEFAULT " + column.Default
+	}
+	if column.NotNull {
+		def += " NOT NULL"
+	}
+
+	return column.Definition == def
+}
@@ -2,8 +2,12 @@ package postgres
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/url"
 	"os/exec"
@@ -70,6 +74,8 @@ func init() {
 	dbmate.RegisterDriver(NewDriver, "postgresql")
 	dbmate.RegisterDriver(NewDriver, "redshift")
 	dbmate.RegisterDriver(NewDriver, "spanner-postgres")
+	dbmate.RegisterDriver(NewCockroachDriver, "cockroach")
+	dbmate.RegisterDriver(NewCockroachDriver, "cockroachdb")
 }
 
 // Driver provides top level database functions
@@ -77,6 +83,12 @@ type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
 	log                 io.Writer
+	dumpMethodName      string
+	dumpOptions         dbmate.DumpOptions
+	initSQL             string
+	noticeHandler       func(string)
+	// versionNum caches the server version for NonTransactionalReason
+	versionNum *int
 }
 
 // NewDriver initializes the driver
@@ -85,6 +97,9 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
 		log:                 config.Log,
+		dumpMethodName:      config.DumpMethod,
+		dumpOptions:         config.DumpOptions,
+		initSQL:             config.InitSQL,
 	}
 }
 
@@ -93,12 +108,20 @@ func connectionString(u *url.URL) string {
 	port := u.Port()
 	query := u.Query()
 
+	// dump_method is used by dbmate, not the server
+	query.Del("dump_method")
+
 	// support socket parameter for consistency with mysql
 	if query.Get("socket") != "" {
 		query.Set("host", query.Get("socket"))
 		query.Del("socket")
 	}
 
+	// CockroachDB does not listen on a unix socket by default
+	if hostname == "" && query.Get("host") == "" && isCockroach(u) {
+		hostname = "localhost"
+	}
+
 	// default hostname
 	if hostname == "" && query.Get("host") == "" {
 		switch runtime.GOOS {
@@ -125,6 +148,8 @@ func connectionString(u *url.URL) string {
 		switch u.Scheme {
 		case "redshift":
 			port = "5439"
+		case "cockroach", "cockroachdb":
+			port = "26257"
 		default:
 			port = "5432"
 		}
@@ -168,7 +193,29 @@ func connectionArgsForDump(conn *url.URL, userArgs ...string) []string {
 
 // Open creates a new database connection
 func (drv *Driver) Open() (*sql.DB, error) {
-	return sql.Open("postgres", connectionString(drv.databaseURL))
+	if drv.noticeHandler == nil && drv.initSQL == "" {
+		return sql.Open("postgres", connectionString(drv.databaseURL))
+	}
+
+	var connector driver.Connector
+	connector, err := pq.NewConnector(connectionString(drv.databaseURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if drv.noticeHandler != nil {
+		connector = pq.ConnectorWithNoticeHandler(connector, func(notice *pq.Error) {
+			drv.noticeHandler(notice.Severity + ": " + notice.Message)
+		})
+	}
+
+	return sql.OpenDB(dbutil.WithInitSQL(connector, drv.initSQL)), nil
+}
+
+// SetNoticeHandler sets a function which is called with each notice raised by statements
+// (such as RAISE NOTICE), on databases opened after it is set
+func (drv *Driver) SetNoticeHandler(handler func(string)) {
+	drv.noticeHandler = handler
 }
 
 func (drv *Driver) openPostgresDB() (*sql.DB, error) {
@@ -179,7 +226,12 @@ func (drv *Driver) openPostgresDB() (*sql.DB, error) {
 	}
 
 	// connect to postgres database, unless this is a Redshift connection
-	if drv.databaseURL.Scheme != "redshift" {
+	switch {
+	case drv.databaseURL.Scheme == "redshift":
+	case isCockroach(drv.databaseURL):
+		// every CockroachDB cluster has a defaultdb database
+		postgresURL.Path = "defaultdb"
+	default:
 		postgresURL.Path = "postgres"
 	}
 
@@ -246,8 +298,47 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// DumpMigrations returns the versions recorded in the migrations table, as in DumpSchema
+func (drv *Driver) DumpMigrations(db *sql.DB) ([]byte, error) {
+	return drv.schemaMigrationsDump(db)
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB, extraArgs ...string) ([]byte, error) {
+	method, err := drv.dumpMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	var schema []byte
+	if method == dbmate.DumpMethodNative {
+		schema, err = drv.nativeDumpSchema(db)
+	} else {
+		schema, err = drv.pgDumpSchema(db, extraArgs...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := drv.tableDataDump(db)
+	if err != nil {
+		return nil, err
+	}
+	schema = append(schema, data...)
+
+	if !drv.dumpOptions.NoMigrationsData {
+		migrations, err := drv.schemaMigrationsDump(db)
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, migrations...)
+	}
+
+	return dbutil.TrimLeadingSQLComments(schema)
+}
+
+// pgDumpSchema dumps the schema using pg_dump
+func (drv *Driver) pgDumpSchema(db *sql.DB, extraArgs ...string) ([]byte, error) {
 	// load schema
 	args := []string{"--format=plain", "--encoding=UTF8", "--schema-only",
 		"--no-privileges", "--no-owner"}
@@ -259,19 +350,18 @@ func (drv *Driver) DumpSchema(db *sql.DB, extraArgs ...string) ([]byte, error) {
 		args = append(args, "--restrict-key=dbmate")
 	}
 
-	args = append(args, connectionArgsForDump(drv.databaseURL, extraArgs...)...)
-	schema, err := dbutil.RunCommand("pg_dump", args...)
+	filterArgs, err := drv.pgDumpFilterArgs(db)
 	if err != nil {
 		return nil, err
 	}
 
-	migrations, err := drv.schemaMigrationsDump(db)
+	args = append(args, connectionArgsForDump(drv.dumpURL(), append(filterArgs, extraArgs...)...)...)
+	schema, err := dbutil.RunCommand("pg_dump", args...)
 	if err != nil {
 		return nil, err
 	}
 
-	schema = append(schema, migrations...)
-	return dbutil.TrimLeadingSQLComments(schema)
+	return drv.filterPgDumpBlocks(schema), nil
 }
 
 // DatabaseExists determines whether the database exists
@@ -438,6 +528,113 @@ func (drv *Driver) Ping() error {
 	return err
 }
 
+// SessionSettingsSQL returns the SQL which switches to a role and search path for a migration
+// section. Switching back resets them to the defaults of the connection, and runs the init
+// SQL again, since it may have changed them.
+func (drv *Driver) SessionSettingsSQL(role, searchPath string) (string, string, error) {
+	set := []string{}
+	reset := []string{}
+	if role != "" {
+		set = append(set, "set role "+pq.QuoteIdentifier(role))
+		reset = append(reset, "reset role")
+	}
+	if searchPath != "" {
+		schemas := strings.Split(searchPath, ",")
+		for i, schema := range schemas {
+			schemas[i] = pq.QuoteIdentifier(strings.TrimSpace(schema))
+		}
+		set = append(set, "set search_path to "+strings.Join(schemas, ", "))
+		reset = append(reset, "reset search_path")
+	}
+	if drv.initSQL != "" {
+		reset = append(reset, strings.TrimRight(strings.TrimSpace(drv.initSQL), ";"))
+	}
+
+	return strings.Join(set, "; "), strings.Join(reset, "; "), nil
+}
+
+// ServerVersion returns the version of the database server
+func (drv *Driver) ServerVersion() (string, error) {
+	db, err := drv.Open()
+	if err != nil {
+		return "", err
+	}
+	defer dbutil.MustClose(db)
+
+	return dbutil.QueryValue(db, "show server_version")
+}
+
+// TransactionalDDL returns true, since postgres rolls back schema changes along with the
+// transaction. Spanner does not allow schema changes in transactions at all.
+func (drv *Driver) TransactionalDDL() bool {
+	return drv.databaseURL.Scheme != "spanner-postgres"
+}
+
+// Lock takes a session level advisory lock, named after the migrations table, on a connection
+// which is held until the lock is released. Redshift and Spanner do not support advisory locks.
+func (drv *Driver) Lock() (io.Closer, error) {
+	if drv.databaseURL.Scheme == "redshift" || drv.databaseURL.Scheme == "spanner-postgres" {
+		return nil, errors.ErrUnsupported
+	}
+
+	db, err := drv.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &advisoryLock{ctx: context.Background(), db: db, key: advisoryLockKey(drv.migrationsTableName)}
+	if err := lock.acquire(drv.log); err != nil {
+		dbutil.MustClose(db)
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// advisoryLockKey returns the advisory lock key for a migrations table
+func advisoryLockKey(migrationsTableName string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("dbmate:" + migrationsTableName))
+	return int64(h.Sum64())
+}
+
+// advisoryLock is a session level advisory lock, which is held by a single connection
+type advisoryLock struct {
+	ctx  context.Context
+	db   *sql.DB
+	conn *sql.Conn
+	key  int64
+}
+
+// acquire waits until the lock is available, printing a message if another process holds it
+func (l *advisoryLock) acquire(log io.Writer) error {
+	conn, err := l.db.Conn(l.ctx)
+	if err != nil {
+		return err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(l.ctx, "select pg_try_advisory_lock($1)", l.key).Scan(&locked)
+	if err == nil && !locked {
+		fmt.Fprintln(log, "Waiting for lock held by another dbmate process")
+		_, err = conn.ExecContext(l.ctx, "select pg_advisory_lock($1)", l.key)
+	}
+	if err != nil {
+		dbutil.MustClose(conn)
+		return err
+	}
+	l.conn = conn
+
+	return nil
+}
+
+// Close releases the lock, and closes its connection
+func (l *advisoryLock) Close() error {
+	_, err := l.conn.ExecContext(l.ctx, "select pg_advisory_unlock($1)", l.key)
+
+	return errors.Join(err, l.conn.Close(), l.db.Close())
+}
+
 // Return a normalized version of the driver-specific error type.
 func (drv *Driver) QueryError(query string, err error) error {
 	position := 0
@@ -451,6 +648,15 @@ func (drv *Driver) QueryError(query string, err error) error {
 	return &dbmate.QueryError{Err: err, Query: query, Position: position}
 }
 
+// StatementSyntax returns the syntax used to split schema files into statements
+func (drv *Driver) StatementSyntax() dbutil.StatementSyntax {
+	return dbutil.StatementSyntax{
+		EscapeStrings: true,
+		DollarQuotes:  true,
+		MetaCommands:  true,
+	}
+}
+
 func (drv *Driver) quotedMigrationsTableName(db dbutil.Transaction) (string, error) {
 	schema, name, err := drv.quotedMigrationsTableNameParts(db)
 	if err != nil {
@@ -2,9 +2,11 @@ package postgres
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/url"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
@@ -180,6 +182,8 @@ func TestConnectionString(t *testing.T) {
 		// redshift default port is 5439, not 5432
 		{"redshift://myhost/foo", "postgres://myhost:5439/foo"},
 		{"spanner-postgres://myhost/foo", "postgres://myhost:5432/foo"},
+		// dump_method is not sent to the server
+		{"postgres://myhost/foo?dump_method=native&bar=baz", "postgres://myhost:5432/foo?bar=baz"},
 	}
 
 	for _, c := range cases {
@@ -321,6 +325,298 @@ func TestPostgresDumpSchema(t *testing.T) {
 	})
 }
 
+func TestPostgresDumpSchemaDataTables(t *testing.T) {
+	drv := testPostgresDriver(t)
+	drv.dumpOptions = dbmate.DumpOptions{DataTables: []string{"countries"}}
+
+	// prepare database
+	db := prepTestPostgresDB(t)
+	defer dbutil.MustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	_, err = db.Exec(`create table countries (code text primary key, name text,
+			upper_code text generated always as (upper(code)) stored);
+		insert into countries (code, name) values ('nz', 'New Zealand'), ('au', 'Australia'), ('xx', null)`)
+	require.NoError(t, err)
+
+	// rows are sorted, and generated columns are left out
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "-- Data for Name: countries; Type: TABLE DATA; Schema: public; Owner: -\n"+
+		"--\n\n"+
+		"INSERT INTO public.countries (code, name) VALUES\n"+
+		"    ('au', 'Australia'),\n"+
+		"    ('nz', 'New Zealand'),\n"+
+		"    ('xx', NULL);\n")
+
+	// missing tables are reported
+	drv.dumpOptions.DataTables = []string{"missing"}
+	_, err = drv.DumpSchema(db)
+	require.EqualError(t, err, "unable to dump data for table missing: pq: relation \"missing\" does not exist")
+}
+
+func TestPostgresDumpMethod(t *testing.T) {
+	cases := []struct {
+		url      string
+		config   string
+		expected string
+	}{
+		// pg_dump is the default
+		{"postgres:///foo", "", dbmate.DumpMethodTool},
+		{"postgres:///foo?dump_method=pg_dump", "", dbmate.DumpMethodTool},
+		{"postgres:///foo?dump_method=native", "", dbmate.DumpMethodNative},
+		// config takes precedence over url param
+		{"postgres:///foo?dump_method=native", dbmate.DumpMethodTool, dbmate.DumpMethodTool},
+		{"postgres:///foo", dbmate.DumpMethodNative, dbmate.DumpMethodNative},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url+" "+c.config, func(t *testing.T) {
+			u, err := url.Parse(c.url)
+			require.NoError(t, err)
+			drv := NewDriver(dbmate.DriverConfig{DatabaseURL: u, DumpMethod: c.config}).(*Driver)
+
+			method, err := drv.dumpMethod()
+			require.NoError(t, err)
+			require.Equal(t, c.expected, method)
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		u, err := url.Parse("postgres:///foo?dump_method=psql")
+		require.NoError(t, err)
+		drv := NewDriver(dbmate.DriverConfig{DatabaseURL: u}).(*Driver)
+
+		_, err = drv.dumpMethod()
+		require.ErrorIs(t, err, dbmate.ErrUnsupportedDumpMethod)
+		require.EqualError(t, err, "unsupported dump method: psql")
+	})
+}
+
+func TestPostgresDumpURL(t *testing.T) {
+	drv := NewDriver(dbmate.DriverConfig{
+		DatabaseURL: dbtest.MustParseURL(t, "postgres://localhost/foo?search_path=public&sslmode=disable"),
+	}).(*Driver)
+	require.Equal(t, "postgres://localhost/foo?search_path=public&sslmode=disable", drv.dumpURL().String())
+
+	drv.dumpOptions = dbmate.DumpOptions{IncludeSchemas: []string{"app", "audit"}}
+	require.Equal(t, "postgres://localhost/foo?search_path=app%2Caudit&sslmode=disable", drv.dumpURL().String())
+	// the database url is not modified
+	require.Equal(t, "search_path=public&sslmode=disable", drv.databaseURL.RawQuery)
+}
+
+func TestFilterPgDumpBlocks(t *testing.T) {
+	schema := `SET client_encoding = 'UTF8';
+
+--
+-- Name: pgcrypto; Type: EXTENSION; Schema: -; Owner: -
+--
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;
+
+
+--
+-- Name: EXTENSION pgcrypto; Type: COMMENT; Schema: -; Owner: -
+--
+
+COMMENT ON EXTENSION pgcrypto IS 'cryptographic functions';
+
+
+--
+-- Name: add(integer, integer); Type: FUNCTION; Schema: public; Owner: -
+--
+
+CREATE FUNCTION public.add(integer, integer) RETURNS integer
+    LANGUAGE sql
+    AS $$select $1 + $2$$;
+
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.users (
+    id integer NOT NULL
+);
+
+
+--
+-- Name: user_ids; Type: VIEW; Schema: public; Owner: -
+--
+
+CREATE VIEW public.user_ids AS
+ SELECT id FROM public.users;
+
+
+--
+-- PostgreSQL database dump complete
+--
+
+`
+
+	drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "postgres:///foo")}).(*Driver)
+	require.Equal(t, schema, string(drv.filterPgDumpBlocks([]byte(schema))))
+
+	drv.dumpOptions = dbmate.DumpOptions{Skip: []string{dbmate.DumpSkipExtensions, dbmate.DumpSkipViews}}
+	filtered := string(drv.filterPgDumpBlocks([]byte(schema)))
+	require.NotContains(t, filtered, "EXTENSION")
+	require.NotContains(t, filtered, "VIEW")
+	require.Contains(t, filtered, "CREATE FUNCTION public.add")
+	require.Contains(t, filtered, "CREATE TABLE public.users")
+	require.True(t, strings.HasPrefix(filtered, "SET client_encoding = 'UTF8';\n\n--\n-- Name: add("))
+	require.True(t, strings.HasSuffix(filtered, ");\n\n\n--\n-- PostgreSQL database dump complete\n--\n\n"))
+
+	drv.dumpOptions = dbmate.DumpOptions{Skip: []string{dbmate.DumpSkipFunctions}}
+	filtered = string(drv.filterPgDumpBlocks([]byte(schema)))
+	require.NotContains(t, filtered, "FUNCTION")
+	require.Contains(t, filtered, "CREATE EXTENSION")
+	require.Contains(t, filtered, "COMMENT ON EXTENSION")
+}
+
+func TestPostgresNativeDumpSchema(t *testing.T) {
+	drv := testPostgresDriver(t)
+	drv.dumpMethodName = dbmate.DumpMethodNative
+
+	// prepare database
+	db := prepTestPostgresDB(t)
+	defer func() {
+		dbutil.MustClose(db)
+	}()
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`create type mood as enum ('sad', 'happy');
+		create table users (id serial primary key, email text not null unique, mood mood);
+		create index users_mood_idx on users (mood);
+		create table posts (id bigint generated always as identity, user_id integer references users (id));
+		create view user_emails as select email from users;`)
+	require.NoError(t, err)
+
+	// DumpSchema should return schema without pg_dump
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "CREATE TYPE public.mood AS ENUM (\n"+
+		"    'sad',\n"+
+		"    'happy'\n"+
+		");\n")
+	require.Contains(t, string(schema), "CREATE SEQUENCE public.users_id_seq\n")
+	require.Contains(t, string(schema), "CREATE TABLE public.schema_migrations (\n"+
+		"    version character varying NOT NULL,\n"+
+		"    CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)\n"+
+		");\n")
+	require.Contains(t, string(schema), "    id bigint GENERATED ALWAYS AS IDENTITY NOT NULL,\n")
+	require.Contains(t, string(schema), "CREATE VIEW public.user_emails AS\n")
+	require.Contains(t, string(schema), "ALTER TABLE ONLY public.posts\n"+
+		"    ADD CONSTRAINT posts_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);\n")
+	require.Contains(t, string(schema), "CREATE INDEX users_mood_idx ON public.users USING btree (mood);\n")
+	require.Contains(t, string(schema), "ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;\n")
+	require.Contains(t, string(schema), "-- Dbmate schema migrations\n"+
+		"--\n\n"+
+		"INSERT INTO public.schema_migrations (version) VALUES\n"+
+		"    ('abc1');\n")
+
+	// the dump should be deterministic, and loadable into an empty database
+	again, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, string(schema), string(again))
+
+	dbutil.MustClose(db)
+	db = prepTestPostgresDB(t)
+	_, err = db.Exec(string(schema))
+	require.NoError(t, err)
+}
+
+func TestPostgresInspectSchema(t *testing.T) {
+	drv := testPostgresDriver(t)
+	db := prepTestPostgresDB(t)
+	defer dbutil.MustClose(db)
+
+	_, err := db.Exec(`create table users (id serial primary key, email text not null default '');
+		create table posts (id bigint generated always as identity, user_id integer references users (id));
+		create index posts_user_id on posts (user_id);
+		create view active_users as select * from users where email != '';
+		create function touch() returns trigger language plpgsql as $$ begin return new; end $$;
+		create trigger users_touch before update on users for each row execute procedure touch();`)
+	require.NoError(t, err)
+
+	schema, err := drv.InspectSchema(db)
+	require.NoError(t, err)
+	require.Len(t, schema.Tables, 2)
+	require.Equal(t, []string{"function touch", "trigger users_touch on users", "view active_users"}, schema.Unplanned)
+
+	posts := schema.Tables[0]
+	require.Equal(t, "posts", posts.Name)
+	require.Equal(t, `"id" bigint GENERATED ALWAYS AS IDENTITY`, posts.Columns[0].Definition)
+	require.Equal(t, []string{"users"}, posts.References)
+	require.Equal(t, []dbmate.SchemaIndex{{
+		Name:       "posts_user_id",
+		Definition: "CREATE INDEX posts_user_id ON posts USING btree (user_id)",
+	}}, posts.Indexes)
+
+	users := schema.Tables[1]
+	require.Equal(t, `CREATE TABLE "users" (`+"\n"+
+		`    "id" serial NOT NULL,`+"\n"+
+		`    "email" text DEFAULT ''::text NOT NULL,`+"\n"+
+		`    CONSTRAINT "users_pkey" PRIMARY KEY (id)`+"\n"+
+		")", users.Definition)
+}
+
+func TestPostgresScratchDatabaseURL(t *testing.T) {
+	drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "postgres://user@localhost:5432/app?sslmode=disable")}).(*Driver)
+
+	u, err := drv.ScratchDatabaseURL()
+	require.NoError(t, err)
+	require.Regexp(t, `^postgres://user@localhost:5432/app_dbmate_scratch_[0-9a-f]{8}\?sslmode=disable$`, u.String())
+
+	// each run uses a new scratch database
+	other, err := drv.ScratchDatabaseURL()
+	require.NoError(t, err)
+	require.NotEqual(t, u.String(), other.String())
+
+	// long names are truncated to the postgres identifier length
+	drv = NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "postgres://localhost/"+strings.Repeat("a", 63))}).(*Driver)
+	u, err = drv.ScratchDatabaseURL()
+	require.NoError(t, err)
+	require.Len(t, strings.TrimPrefix(u.Path, "/"), 63)
+}
+
+func TestPostgresAlterTableSQL(t *testing.T) {
+	drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "postgres://localhost/app")}).(*Driver)
+
+	id := dbmate.SchemaColumn{Name: "id", Type: "integer", Definition: `"id" integer`}
+	email := dbmate.SchemaColumn{Name: "email", Type: "text", Definition: `"email" text`}
+	required := dbmate.SchemaColumn{Name: "email", Type: "character varying(255)", NotNull: true,
+		Definition: `"email" character varying(255) NOT NULL`}
+	pkey := dbmate.SchemaConstraint{Name: "users_pkey", Definition: "PRIMARY KEY (id)"}
+
+	from := dbmate.SchemaTable{Name: "users", Columns: []dbmate.SchemaColumn{id, email}}
+	to := dbmate.SchemaTable{Name: "users", Columns: []dbmate.SchemaColumn{id, required},
+		Constraints: []dbmate.SchemaConstraint{pkey}}
+
+	statements, err := drv.AlterTableSQL(from, to)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`ALTER TABLE "users" ALTER COLUMN "email" TYPE character varying(255) USING "email"::character varying(255)`,
+		`ALTER TABLE "users" ALTER COLUMN "email" SET NOT NULL`,
+		`ALTER TABLE "users" ADD CONSTRAINT "users_pkey" PRIMARY KEY (id)`,
+	}, statements)
+
+	statements, err = drv.AlterTableSQL(to, from)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`ALTER TABLE "users" DROP CONSTRAINT "users_pkey"`,
+		`ALTER TABLE "users" ALTER COLUMN "email" TYPE text USING "email"::text`,
+		`ALTER TABLE "users" ALTER COLUMN "email" DROP NOT NULL`,
+	}, statements)
+
+	// serial columns cannot be converted in place
+	serial := dbmate.SchemaColumn{Name: "id", Type: "serial", NotNull: true, Definition: `"id" serial NOT NULL`}
+	_, err = drv.AlterTableSQL(from, dbmate.SchemaTable{Name: "users", Columns: []dbmate.SchemaColumn{serial, email}})
+	require.ErrorIs(t, err, dbmate.ErrPlanUnsupportedChange)
+}
+
 func TestPostgresDatabaseExists(t *testing.T) {
 	drv := testPostgresDriver(t)
 
@@ -598,6 +894,121 @@ func TestPostgresPing(t *testing.T) {
 	require.ErrorContains(t, err, "connect: connection refused")
 }
 
+func TestPostgresLock(t *testing.T) {
+	drv := testPostgresDriver(t)
+	prepTestPostgresDB(t).Close()
+
+	lock, err := drv.Lock()
+	require.NoError(t, err)
+
+	// the lock is held by another connection
+	db, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+	locked, err := dbutil.QueryValue(db, "select pg_try_advisory_lock($1)", advisoryLockKey("schema_migrations"))
+	require.NoError(t, err)
+	require.Equal(t, "false", locked)
+
+	err = lock.Close()
+	require.NoError(t, err)
+	locked, err = dbutil.QueryValue(db, "select pg_try_advisory_lock($1)", advisoryLockKey("schema_migrations"))
+	require.NoError(t, err)
+	require.Equal(t, "true", locked)
+}
+
+func TestPostgresLockUnsupported(t *testing.T) {
+	drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "redshift://localhost/dbname")})
+	_, err := drv.(*Driver).Lock()
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+
+	drv = NewCockroachDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "cockroach://localhost/dbname")})
+	_, err = drv.(*CockroachDriver).Lock()
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestPostgresServerVersion(t *testing.T) {
+	drv := testPostgresDriver(t)
+
+	version, err := drv.ServerVersion()
+	require.NoError(t, err)
+	require.Regexp(t, `^\d+\.\d+`, version)
+}
+
+func TestPostgresNoticeHandler(t *testing.T) {
+	drv := testPostgresDriver(t)
+	prepTestPostgresDB(t).Close()
+
+	notices := []string{}
+	drv.SetNoticeHandler(func(notice string) {
+		notices = append(notices, notice)
+	})
+
+	db, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+
+	_, err = db.Exec("do $$ begin raise notice 'hello'; end $$")
+	require.NoError(t, err)
+	require.Equal(t, []string{"NOTICE: hello"}, notices)
+}
+
+func TestSessionSettingsSQL(t *testing.T) {
+	drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "postgres://localhost/dbname")}).(*Driver)
+
+	set, reset, err := drv.SessionSettingsSQL("owner", "")
+	require.NoError(t, err)
+	require.Equal(t, `set role "owner"`, set)
+	require.Equal(t, "reset role", reset)
+
+	set, reset, err = drv.SessionSettingsSQL("", "billing, public")
+	require.NoError(t, err)
+	require.Equal(t, `set search_path to "billing", "public"`, set)
+	require.Equal(t, "reset search_path", reset)
+
+	// the init SQL is run again after resetting
+	drv.initSQL = "set role migrator;"
+	set, reset, err = drv.SessionSettingsSQL("owner", "billing")
+	require.NoError(t, err)
+	require.Equal(t, `set role "owner"; set search_path to "billing"`, set)
+	require.Equal(t, "reset role; reset search_path; set role migrator", reset)
+}
+
+func TestNonTransactionalReason(t *testing.T) {
+	drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "postgres://localhost/dbname")}).(*Driver)
+	version := 160000
+	drv.versionNum = &version
+
+	require.Equal(t, "CREATE INDEX CONCURRENTLY cannot run in a transaction",
+		drv.NonTransactionalReason("CREATE UNIQUE INDEX\n  CONCURRENTLY users_email ON users (email)"))
+	require.Equal(t, "DROP INDEX CONCURRENTLY cannot run in a transaction",
+		drv.NonTransactionalReason("drop index concurrently users_email"))
+	require.Equal(t, "VACUUM cannot run in a transaction", drv.NonTransactionalReason("vacuum analyze users"))
+	require.Equal(t, "", drv.NonTransactionalReason("create index users_email on users (email)"))
+	require.Equal(t, "", drv.NonTransactionalReason("alter type colors add value 'orange'"))
+
+	// enum values can only be added in a transaction from PostgreSQL 12
+	version = 110005
+	require.Equal(t, "ALTER TYPE ... ADD VALUE cannot run in a transaction before PostgreSQL 12",
+		drv.NonTransactionalReason("alter type colors add value 'orange'"))
+}
+
+func TestPostgresInitSQL(t *testing.T) {
+	u := dbtest.GetenvURLOrSkip(t, "POSTGRES_TEST_URL")
+	db := dbmate.New(u)
+	db.InitSQL = "set search_path to information_schema"
+	drv, err := db.Driver()
+	require.NoError(t, err)
+	prepTestPostgresDB(t).Close()
+
+	sqlDB, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(sqlDB)
+
+	searchPath, err := dbutil.QueryValue(sqlDB, "show search_path")
+	require.NoError(t, err)
+	require.Equal(t, "information_schema", searchPath)
+}
+
 func TestPostgresQuotedMigrationsTableName(t *testing.T) {
 	t.Run("default schema", func(t *testing.T) {
 		drv := testPostgresDriver(t)
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// nonTransactionalStatement matches statements which postgres refuses to run in a transaction block
+type nonTransactionalStatement struct {
+	regexp *regexp.Regexp
+	reason string
+}
+
+var nonTransactionalStatements = []nonTransactionalStatement{
+	{regexp.MustCompile(`(?is)^create\s+(unique\s+)?index\s+concurrently\b`), "CREATE INDEX CONCURRENTLY"},
+	{regexp.MustCompile(`(?is)^drop\s+index\s+concurrently\b`), "DROP INDEX CONCURRENTLY"},
+	{regexp.MustCompile(`(?is)^reindex\b.*\bconcurrently\b`), "REINDEX CONCURRENTLY"},
+	{regexp.MustCompile(`(?is)^reindex\s+(\([^)]*\)\s*)?(database|system)\b`), "REINDEX DATABASE and SYSTEM"},
+	{regexp.MustCompile(`(?is)^alter\s+table\b.*\bdetach\s+partition\b.*\bconcurrently\b`), "DETACH PARTITION CONCURRENTLY"},
+	{regexp.MustCompile(`(?is)^vacuum\b`), "VACUUM"},
+	{regexp.MustCompile(`(?is)^create\s+database\b`), "CREATE DATABASE"},
+	{regexp.MustCompile(`(?is)^drop\s+database\b`), "DROP DATABASE"},
+	{regexp.MustCompile(`(?is)^create\s+tablespace\b`), "CREATE TABLESPACE"},
+	{regexp.MustCompile(`(?is)^drop\s+tablespace\b`), "DROP TABLESPACE"},
+	{regexp.MustCompile(`(?is)^create\s+subscription\b`), "CREATE SUBSCRIPTION"},
+	{regexp.MustCompile(`(?is)^drop\s+subscription\b`), "DROP SUBSCRIPTION"},
+	{regexp.MustCompile(`(?is)^alter\s+system\b`), "ALTER SYSTEM"},
+}
+
+// addEnumValueRegexp matches ALTER TYPE ... ADD VALUE, which can only run in a transaction
+// block from PostgreSQL 12
+var addEnumValueRegexp = regexp.MustCompile(`(?is)^alter\s+type\b.*\badd\s+value\b`)
+
+// NonTransactionalReason returns why a statement cannot run in a transaction block, or an
+// empty string if it can
+func (drv *Driver) NonTransactionalReason(statement string) string {
+	for _, s := range nonTransactionalStatements {
+		if s.regexp.MatchString(statement) {
+			return s.reason + " cannot run in a transaction"
+		}
+	}
+
+	if addEnumValueRegexp.MatchString(statement) && drv.serverVersionNum() < 120000 {
+		return "ALTER TYPE ... ADD VALUE cannot run in a transaction before PostgreSQL 12"
+	}
+
+	return ""
+}
+
+// serverVersionNum returns the version of the server as a number (e.g. 110005 for 11.5),
+// or 0 if it can't be read. The version is read once, when it is first needed.
+func (drv *Driver) serverVersionNum() int {
+	if drv.versionNum != nil {
+		return *drv.versionNum
+	}
+
+	version := 0
+	if db, err := drv.Open(); err == nil {
+		defer dbutil.MustClose(db)
+		if value, err := dbutil.QueryValue(db, "show server_version_num"); err == nil {
+			version, _ = strconv.Atoi(value)
+		}
+	}
+	drv.versionNum = &version
+
+	return version
+}
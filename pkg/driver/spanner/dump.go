@@ -0,0 +1,254 @@
+package spanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// identifier matches a quoted or unquoted identifier in GetDatabaseDdl output
+const identifier = "(?:`(?:[^`\\\\]|\\\\.)*`|[A-Za-z_][A-Za-z0-9_]*)"
+
+var (
+	// ddlObjectRegexp matches the type and name of the object created or altered by a
+	// DDL statement, along with the table of an index
+	ddlObjectRegexp = regexp.MustCompile(`(?is)^(?:CREATE|ALTER)\s+(?:OR\s+REPLACE\s+)?` +
+		`((?:(?:UNIQUE|NULL_FILTERED|SEARCH|VECTOR)\s+)*INDEX|TABLE|VIEW|SEQUENCE|FUNCTION|SCHEMA)\s+` +
+		`(?:IF\s+NOT\s+EXISTS\s+)?` +
+		`(` + identifier + `(?:\.` + identifier + `)?)` +
+		`(?:\s+ON\s+(` + identifier + `(?:\.` + identifier + `)?))?`)
+	// identifierRegexp matches each part of a qualified name
+	identifierRegexp = regexp.MustCompile(identifier)
+)
+
+// ddlSkipTypes maps object types in GetDatabaseDdl output to DumpOptions.Skip object types
+var ddlSkipTypes = map[string]string{
+	"INDEX":    dbmate.DumpSkipIndexes,
+	"VIEW":     dbmate.DumpSkipViews,
+	"SEQUENCE": dbmate.DumpSkipSequences,
+	"FUNCTION": dbmate.DumpSkipFunctions,
+}
+
+// ddlObject is a statement returned by GetDatabaseDdl
+type ddlObject struct {
+	// Type is the object type, e.g. TABLE or INDEX (the modifiers of an index are removed)
+	Type   string
+	Schema string
+	Name   string
+	// Table is the table or view which the object belongs to
+	Table string
+}
+
+// parseDDLObject parses a statement returned by GetDatabaseDdl. Statements which do not
+// create or alter a schema object (such as roles and grants) are not parsed.
+func parseDDLObject(statement string) (ddlObject, bool) {
+	match := ddlObjectRegexp.FindStringSubmatch(strings.TrimSpace(statement))
+	if match == nil {
+		return ddlObject{}, false
+	}
+
+	object := ddlObject{Type: strings.ToUpper(match[1])}
+	if strings.HasSuffix(object.Type, "INDEX") {
+		object.Type = "INDEX"
+	}
+	object.Schema, object.Name = splitQualifiedName(match[2])
+
+	switch object.Type {
+	case "SCHEMA":
+		object.Schema, object.Name = object.Name, ""
+	case "TABLE", "VIEW":
+		object.Table = object.Name
+	case "INDEX":
+		_, object.Table = splitQualifiedName(match[3])
+	}
+
+	return object, true
+}
+
+// splitQualifiedName returns the unquoted schema and name of an object. Objects in the
+// default schema have an empty schema name.
+func splitQualifiedName(s string) (string, string) {
+	parts := identifierRegexp.FindAllString(s, -1)
+	for i := range parts {
+		parts[i] = unquoteIdentifier(parts[i])
+	}
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+
+	return parts[0], parts[1]
+}
+
+// unquoteIdentifier removes the backticks from an identifier
+func unquoteIdentifier(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, "`") && strings.HasSuffix(s, "`") {
+		return strings.ReplaceAll(s[1:len(s)-1], "\\`", "`")
+	}
+
+	return s
+}
+
+// ddlDumpSchema dumps the schema of the database, as returned by GetDatabaseDdl. Spanner
+// returns statements in an order in which they can be applied.
+func (s *session) ddlDumpSchema(ctx context.Context) ([]byte, error) {
+	resp, err := s.admin.GetDatabaseDdl(ctx, &databasepb.GetDatabaseDdlRequest{Database: s.name})
+	if err != nil {
+		return nil, fmt.Errorf("unable to dump schema: %w", err)
+	}
+
+	return s.drv.formatDDL(resp.GetStatements()), nil
+}
+
+// formatDDL filters DDL statements by DumpOptions
+func (drv *Driver) formatDDL(statements []string) []byte {
+	var buf bytes.Buffer
+	for _, statement := range statements {
+		if object, ok := parseDDLObject(statement); ok {
+			if !drv.dumpOptions.IncludesSchema(object.Schema) || drv.dumpOptions.Skips(ddlSkipTypes[object.Type]) {
+				continue
+			}
+			if object.Table != "" && drv.dumpOptions.ExcludesTable(object.Schema, object.Table) {
+				continue
+			}
+		}
+		buf.WriteString(strings.TrimSpace(statement) + ";\n\n")
+	}
+
+	return buf.Bytes()
+}
+
+// dataDumpSyntax writes the rows of tables, which are formatted by tableValues
+var dataDumpSyntax = dbutil.DataDumpSyntax{Indent: "  "}
+
+// tableDataDump returns INSERT statements for the rows of each table in DumpOptions.DataTables.
+// Spanner does not have a function which quotes values, so rows are formatted by the driver.
+// Rows are sorted by their values, so that the output does not depend on the physical order.
+func (s *session) tableDataDump(ctx context.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, table := range s.drv.dumpOptions.DataTables {
+		schema, name := splitQualifiedName(table)
+
+		// generated columns cannot be inserted
+		columns := []string{}
+		types := []string{}
+		err := s.client.Single().Query(ctx, spanner.Statement{
+			SQL: `select column_name, spanner_type from information_schema.columns
+				where table_catalog = '' and table_schema = @schema and table_name = @name and is_generated = 'NEVER'
+				order by ordinal_position`,
+			Params: map[string]interface{}{"schema": schema, "name": name},
+		}).Do(func(row *spanner.Row) error {
+			var column, columnType string
+			if err := row.Columns(&column, &columnType); err != nil {
+				return err
+			}
+			columns = append(columns, quoteIdentifier(column))
+			types = append(types, columnType)
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to dump data for table %s: %w", table, err)
+		}
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("unable to dump data for table %s: table does not exist", table)
+		}
+
+		quotedTable := qualifiedName(schema, name)
+		values, err := s.tableValues(ctx, quotedTable, columns, types)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dump data for table %s: %w", table, err)
+		}
+		sort.Strings(values)
+
+		fmt.Fprintf(&buf, "-- Data for table %s\n", table)
+		dataDumpSyntax.WriteInserts(&buf, quotedTable, columns, values)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// tableValues returns the rows of a table as lists of literals, e.g. 1, 'a'
+func (s *session) tableValues(ctx context.Context, table string, columns, types []string) ([]string, error) {
+	values := []string{}
+	statement := spanner.Statement{SQL: "select " + strings.Join(columns, ", ") + " from " + table}
+	err := s.client.Single().Query(ctx, statement).Do(func(row *spanner.Row) error {
+		literals := make([]string, len(columns))
+		for i := range columns {
+			var column spanner.GenericColumnValue
+			if err := row.Column(i, &column); err != nil {
+				return err
+			}
+			value, err := columnValue(column)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", columns[i], err)
+			}
+			if literals[i], err = formatLiteral(value, types[i]); err != nil {
+				return fmt.Errorf("column %s: %w", columns[i], err)
+			}
+		}
+		values = append(values, strings.Join(literals, ", "))
+
+		return nil
+	})
+
+	return values, err
+}
+
+// formatLiteral formats a value returned by columnValue as a literal of a column type
+// (e.g. STRING(MAX) or DATE)
+func formatLiteral(value interface{}, columnType string) (string, error) {
+	baseType, _, _ := strings.Cut(columnType, "(")
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v)), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Sprintf("CAST('%s' AS %s)", formatFloat(v), baseType), nil
+		}
+		return formatFloat(v), nil
+	case []byte:
+		return "FROM_BASE64('" + base64.StdEncoding.EncodeToString(v) + "')", nil
+	case string:
+		switch {
+		case baseType == "STRING":
+			return quoteLiteral(v), nil
+		case strings.HasPrefix(baseType, "ARRAY") || strings.HasPrefix(baseType, "STRUCT"):
+			return "", fmt.Errorf("unsupported column type %s", columnType)
+		default:
+			// DATE, TIMESTAMP, NUMERIC, and JSON literals are prefixed with their type
+			return baseType + " " + quoteLiteral(v), nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// formatFloat formats a float, including NaN and infinity as understood by Spanner
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,422 @@
+package spanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/spanner"
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	"cloud.google.com/go/spanner/apiv1/spannerpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrDDLInTransaction is returned when a DDL statement is executed in a transaction
+var ErrDDLInTransaction = errors.New("spanner does not support DDL statements in transactions " +
+	"(use transaction:false for migrations which change the schema)")
+
+// statementSyntax is the quoting and comment syntax of GoogleSQL
+var statementSyntax = dbutil.StatementSyntax{
+	BackslashEscapes: true,
+	TripleQuotes:     true,
+	HashComments:     true,
+}
+
+// ddlKeywords are the first words of GoogleSQL DDL statements
+var ddlKeywords = []string{"CREATE", "ALTER", "DROP", "GRANT", "REVOKE", "RENAME", "ANALYZE"}
+
+// session is an open connection to a Spanner database. DDL statements are applied with the
+// database admin API, and DML statements are executed with the Spanner client.
+type session struct {
+	drv    *Driver
+	name   string
+	client *spanner.Client
+	admin  *database.DatabaseAdminClient
+}
+
+// Close closes the Spanner clients
+func (s *session) Close() error {
+	s.client.Close()
+	return s.admin.Close()
+}
+
+// DumpSchema returns the current database schema, as returned by GetDatabaseDdl, followed by
+// the data of DumpOptions.DataTables and the applied migrations
+func (s *session) DumpSchema(...string) ([]byte, error) {
+	ctx := context.Background()
+	schema, err := s.ddlDumpSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.tableDataDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+	schema = append(schema, data...)
+
+	if !s.drv.dumpOptions.NoMigrationsData {
+		migrations, err := s.DumpMigrations(s.drv.migrationsTableName)
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, migrations...)
+	}
+
+	return schema, nil
+}
+
+// DumpMigrations returns the versions recorded in a migrations table, as in DumpSchema
+func (s *session) DumpMigrations(table string) ([]byte, error) {
+	versions, err := s.queryVersions(context.Background(),
+		fmt.Sprintf("select version from %s order by version asc", quotedTableName(table)))
+	if err != nil {
+		return nil, err
+	}
+
+	// build schema migrations table data
+	var buf bytes.Buffer
+	buf.WriteString("-- Dbmate schema migrations\n")
+
+	if len(versions) > 0 {
+		for i := range versions {
+			versions[i] = quoteLiteral(versions[i])
+		}
+		buf.WriteString(
+			fmt.Sprintf("INSERT INTO %s (version) VALUES\n  (", quotedTableName(table)) +
+				strings.Join(versions, "),\n  (") +
+				");\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MigrationsTableExists checks if a migrations table exists
+func (s *session) MigrationsTableExists(table string) (bool, error) {
+	schema, name := splitTableName(table)
+	count, err := s.queryCount(context.Background(), spanner.Statement{
+		SQL: "select count(*) from information_schema.tables " +
+			"where table_catalog = '' and table_schema = @schema and table_name = @name",
+		Params: map[string]interface{}{"schema": schema, "name": name},
+	})
+
+	return count > 0, err
+}
+
+// CreateMigrationsTable creates a migrations table, along with its schema
+func (s *session) CreateMigrationsTable(table string) error {
+	exists, err := s.MigrationsTableExists(table)
+	if err != nil || exists {
+		return err
+	}
+
+	ctx := context.Background()
+	statements := []string{}
+	schema, _ := splitTableName(table)
+	if schema != "" {
+		// only attempt to create the schema if it is missing, in case the user doesn't have
+		// permission to create schemas
+		count, err := s.queryCount(ctx, spanner.Statement{
+			SQL:    "select count(*) from information_schema.schemata where schema_name = @schema",
+			Params: map[string]interface{}{"schema": schema},
+		})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			fmt.Fprintf(s.drv.log, "Creating schema: %s\n", schema)
+			statements = append(statements, "CREATE SCHEMA "+quoteIdentifier(schema))
+		}
+	}
+
+	// the statements are applied together in one schema change
+	statements = append(statements, fmt.Sprintf(
+		"CREATE TABLE %s (version STRING(MAX) NOT NULL) PRIMARY KEY (version)", quotedTableName(table)))
+	_, err = s.updateDDL(ctx, statements)
+
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (s *session) SelectMigrations(table string, limit int) (map[string]bool, error) {
+	query := fmt.Sprintf("select version from %s order by version desc", quotedTableName(table))
+	if limit >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, limit)
+	}
+	versions, err := s.queryVersions(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := map[string]bool{}
+	for _, version := range versions {
+		migrations[version] = true
+	}
+
+	return migrations, nil
+}
+
+// Run calls fn in a read/write transaction, or otherwise with an Executor which commits each
+// DML statement on its own. Spanner may abort a transaction which conflicts with another
+// transaction, in which case the client library rolls it back and calls fn again.
+func (s *session) Run(transaction bool, fn func(dbmate.Executor) error) error {
+	ctx := context.Background()
+	if !transaction {
+		return fn(&executor{session: s, ctx: ctx})
+	}
+
+	_, err := s.client.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		return fn(&executor{session: s, ctx: ctx, tx: tx})
+	})
+
+	return err
+}
+
+// queryVersions returns the versions selected by a query
+func (s *session) queryVersions(ctx context.Context, query string) ([]string, error) {
+	versions := []string{}
+	err := s.client.Single().Query(ctx, spanner.Statement{SQL: query}).Do(func(row *spanner.Row) error {
+		var version string
+		if err := row.Columns(&version); err != nil {
+			return err
+		}
+		versions = append(versions, version)
+
+		return nil
+	})
+
+	return versions, err
+}
+
+// queryCount returns the result of a count(*) query
+func (s *session) queryCount(ctx context.Context, statement spanner.Statement) (int64, error) {
+	var count int64
+	err := s.client.Single().Query(ctx, statement).Do(func(row *spanner.Row) error {
+		return row.Columns(&count)
+	})
+
+	return count, err
+}
+
+// updateDDL applies DDL statements, which Spanner executes in order. If a statement fails,
+// the statements before it remain applied, and the index of the failed statement is returned.
+func (s *session) updateDDL(ctx context.Context, statements []string) (int, error) {
+	op, err := s.admin.UpdateDatabaseDdl(ctx, &databasepb.UpdateDatabaseDdlRequest{
+		Database:   s.name,
+		Statements: statements,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = op.Wait(ctx)
+	if err == nil {
+		return 0, nil
+	}
+
+	// the operation records the commit time of each statement which was applied
+	failed := 0
+	if metadata, _ := op.Metadata(); metadata != nil {
+		failed = min(len(metadata.GetCommitTimestamps()), len(statements)-1)
+	}
+
+	return failed, err
+}
+
+// executor runs migrations in a read/write transaction, or outside of a transaction if tx is nil
+type executor struct {
+	session *session
+	ctx     context.Context
+	tx      *spanner.ReadWriteTransaction
+}
+
+// Exec executes each statement in a script
+func (e *executor) Exec(script string) (dbmate.Result, error) {
+	count, err := e.exec(script)
+	if err != nil {
+		return nil, &dbmate.QueryError{Err: err, Query: script}
+	}
+
+	return result(count), nil
+}
+
+// exec executes each statement in a script, and returns the number of rows affected. Consecutive
+// DDL statements are applied together with one UpdateDatabaseDdl call.
+func (e *executor) exec(script string) (int64, error) {
+	statements, err := splitStatements(script)
+	if err != nil {
+		return 0, err
+	}
+
+	var rowsAffected int64
+	for _, batch := range batchStatements(statements) {
+		if !isDDL(batch[0].SQL) {
+			count, err := e.update(spanner.Statement{SQL: batch[0].SQL})
+			if err != nil {
+				return 0, statementError(statements, batch[0], err)
+			}
+			rowsAffected += count
+			continue
+		}
+
+		if e.tx != nil {
+			return 0, ErrDDLInTransaction
+		}
+		sqls := make([]string, len(batch))
+		for i, statement := range batch {
+			sqls[i] = statement.SQL
+		}
+		if failed, err := e.session.updateDDL(e.ctx, sqls); err != nil {
+			return 0, statementError(statements, batch[failed], err)
+		}
+	}
+
+	return rowsAffected, nil
+}
+
+// update executes a DML statement, in a transaction of its own if the executor is not in one
+func (e *executor) update(statement spanner.Statement) (int64, error) {
+	if e.tx != nil {
+		return e.tx.Update(e.ctx, statement)
+	}
+
+	var count int64
+	_, err := e.session.client.ReadWriteTransaction(e.ctx,
+		func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+			var err error
+			count, err = tx.Update(ctx, statement)
+			return err
+		})
+
+	return count, err
+}
+
+// InsertMigration adds a new migration record
+func (e *executor) InsertMigration(table, version string) error {
+	_, err := e.update(spanner.Statement{
+		SQL:    fmt.Sprintf("insert into %s (version) values (@version)", quotedTableName(table)),
+		Params: map[string]interface{}{"version": version},
+	})
+
+	return err
+}
+
+// DeleteMigration removes a migration record
+func (e *executor) DeleteMigration(table, version string) error {
+	_, err := e.update(spanner.Statement{
+		SQL:    fmt.Sprintf("delete from %s where version = @version", quotedTableName(table)),
+		Params: map[string]interface{}{"version": version},
+	})
+
+	return err
+}
+
+// result is the result of Exec
+type result int64
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("spanner: LastInsertId is not supported")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+// statementError adds the line of the statement which failed to an error, if the script
+// contains more than one statement
+func statementError(statements []dbutil.Statement, statement dbutil.Statement, err error) error {
+	if len(statements) == 1 {
+		return err
+	}
+
+	return fmt.Errorf("statement at line %d: %w", statement.Line, err)
+}
+
+// splitStatements splits SQL into statements, since Spanner only executes one statement at a time
+func splitStatements(query string) ([]dbutil.Statement, error) {
+	statements := []dbutil.Statement{}
+	scanner := dbutil.NewStatementScanner(strings.NewReader(query), statementSyntax)
+	for scanner.Scan() {
+		statements = append(statements, scanner.Statement())
+	}
+
+	return statements, scanner.Err()
+}
+
+// batchStatements groups statements into batches which are executed together: consecutive
+// DDL statements form one batch, and each other statement is a batch of its own
+func batchStatements(statements []dbutil.Statement) [][]dbutil.Statement {
+	batches := [][]dbutil.Statement{}
+	for i := 0; i < len(statements); i++ {
+		end := i + 1
+		if isDDL(statements[i].SQL) {
+			for end < len(statements) && isDDL(statements[end].SQL) {
+				end++
+			}
+		}
+		batches = append(batches, statements[i:end])
+		i = end - 1
+	}
+
+	return batches
+}
+
+// isDDL returns true if a statement changes the schema
+func isDDL(query string) bool {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '('
+	})
+
+	return len(words) > 0 && slices.ContainsFunc(ddlKeywords, func(keyword string) bool {
+		return strings.EqualFold(words[0], keyword)
+	})
+}
+
+// columnValue converts a Spanner value to a Go value. Scalar values which Go does not have a
+// type for (such as DATE and NUMERIC) are returned as strings, and arrays and structs are
+// returned as JSON.
+func columnValue(value spanner.GenericColumnValue) (interface{}, error) {
+	if _, ok := value.Value.GetKind().(*structpb.Value_NullValue); ok {
+		return nil, nil
+	}
+
+	switch value.Type.GetCode() {
+	case spannerpb.TypeCode_BOOL:
+		return value.Value.GetBoolValue(), nil
+	case spannerpb.TypeCode_INT64, spannerpb.TypeCode_ENUM:
+		return strconv.ParseInt(value.Value.GetStringValue(), 10, 64)
+	case spannerpb.TypeCode_FLOAT64, spannerpb.TypeCode_FLOAT32:
+		// NaN and infinity are encoded as strings
+		if s, ok := value.Value.GetKind().(*structpb.Value_StringValue); ok {
+			switch s.StringValue {
+			case "Infinity":
+				return math.Inf(1), nil
+			case "-Infinity":
+				return math.Inf(-1), nil
+			default:
+				return math.NaN(), nil
+			}
+		}
+		return value.Value.GetNumberValue(), nil
+	case spannerpb.TypeCode_BYTES, spannerpb.TypeCode_PROTO:
+		return base64.StdEncoding.DecodeString(value.Value.GetStringValue())
+	case spannerpb.TypeCode_ARRAY, spannerpb.TypeCode_STRUCT:
+		b, err := json.Marshal(value.Value.AsInterface())
+		return string(b), err
+	default:
+		return value.Value.GetStringValue(), nil
+	}
+}
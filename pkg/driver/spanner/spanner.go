@@ -0,0 +1,295 @@
+package spanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"cloud.google.com/go/spanner/admin/instance/apiv1/instancepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func init() {
+	dbmate.RegisterSessionDriver(NewDriver, "spanner")
+}
+
+// ErrInvalidURL is returned when the database URL does not name a Spanner database
+var ErrInvalidURL = errors.New("invalid spanner url " +
+	"(expected spanner://projects/<project>/instances/<instance>/databases/<database>)")
+
+// databaseNameRegexp matches the full name of a database
+var databaseNameRegexp = regexp.MustCompile(`^(projects/[^/]+)/instances/([^/]+)/databases/([^/]+)$`)
+
+// Driver provides top level database functions. Spanner is accessed through its own client
+// libraries, so the driver is a dbmate.SessionDriver rather than using database/sql.
+type Driver struct {
+	migrationsTableName string
+	databaseURL         *url.URL
+	log                 io.Writer
+	dumpOptions         dbmate.DumpOptions
+}
+
+// NewDriver initializes the driver
+func NewDriver(config dbmate.DriverConfig) dbmate.SessionDriver {
+	return &Driver{
+		migrationsTableName: config.MigrationsTableName,
+		databaseURL:         config.DatabaseURL,
+		log:                 config.Log,
+		dumpOptions:         config.DumpOptions,
+	}
+}
+
+// databaseName returns the full name of the database, e.g. projects/p/instances/i/databases/d.
+// The first part of the name is parsed as the URL host.
+func databaseName(u *url.URL) (string, error) {
+	name := strings.Trim(u.Host+u.Path, "/")
+	if !databaseNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidURL, u.Redacted())
+	}
+
+	return name, nil
+}
+
+// instanceName returns the full name of the instance which contains a database
+func instanceName(name string) string {
+	match := databaseNameRegexp.FindStringSubmatch(name)
+	return match[1] + "/instances/" + match[2]
+}
+
+// OpenSession opens the database
+func (drv *Driver) OpenSession() (dbmate.Session, error) {
+	return drv.openSession()
+}
+
+func (drv *Driver) openSession() (*session, error) {
+	ctx := context.Background()
+	admin, name, err := drv.adminClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := spanner.NewClient(ctx, name)
+	if err != nil {
+		dbutil.MustClose(admin)
+		return nil, err
+	}
+
+	return &session{drv: drv, name: name, client: client, admin: admin}, nil
+}
+
+// adminClient returns a client for the database admin API, along with the name of the database
+func (drv *Driver) adminClient(ctx context.Context) (*database.DatabaseAdminClient, string, error) {
+	name, err := databaseName(drv.databaseURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client, err := database.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, name, nil
+}
+
+// CreateDatabase creates the specified database. When connected to the Spanner emulator, the
+// instance is also created if it does not exist.
+func (drv *Driver) CreateDatabase() error {
+	ctx := context.Background()
+	client, name, err := drv.adminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(client)
+
+	fmt.Fprintf(drv.log, "Creating: %s\n", name)
+
+	if os.Getenv("SPANNER_EMULATOR_HOST") != "" {
+		if err := drv.createEmulatorInstance(ctx, instanceName(name)); err != nil {
+			return err
+		}
+	}
+
+	match := databaseNameRegexp.FindStringSubmatch(name)
+	op, err := client.CreateDatabase(ctx, &databasepb.CreateDatabaseRequest{
+		Parent:          instanceName(name),
+		CreateStatement: "CREATE DATABASE " + quoteIdentifier(match[3]),
+		DatabaseDialect: databasepb.DatabaseDialect_GOOGLE_STANDARD_SQL,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = op.Wait(ctx)
+	return err
+}
+
+// createEmulatorInstance creates an instance in the Spanner emulator (if it does not exist),
+// since the emulator starts without any instances
+func (drv *Driver) createEmulatorInstance(ctx context.Context, name string) error {
+	client, err := instance.NewInstanceAdminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(client)
+
+	_, err = client.GetInstance(ctx, &instancepb.GetInstanceRequest{Name: name})
+	if spanner.ErrCode(err) != codes.NotFound {
+		return err
+	}
+
+	fmt.Fprintf(drv.log, "Creating instance: %s\n", name)
+	project, id, _ := strings.Cut(name, "/instances/")
+	op, err := client.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+		Parent:     project,
+		InstanceId: id,
+		Instance: &instancepb.Instance{
+			Config:      project + "/instanceConfigs/emulator-config",
+			DisplayName: id,
+			NodeCount:   1,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = op.Wait(ctx)
+	return err
+}
+
+// DropDatabase drops the specified database (if it exists)
+func (drv *Driver) DropDatabase() error {
+	ctx := context.Background()
+	client, name, err := drv.adminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(client)
+
+	fmt.Fprintf(drv.log, "Dropping: %s\n", name)
+
+	err = client.DropDatabase(ctx, &databasepb.DropDatabaseRequest{Database: name})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return nil
+	}
+
+	return err
+}
+
+// DatabaseExists determines whether the database exists
+func (drv *Driver) DatabaseExists() (bool, error) {
+	ctx := context.Background()
+	client, name, err := drv.adminClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer dbutil.MustClose(client)
+
+	_, err = client.GetDatabase(ctx, &databasepb.GetDatabaseRequest{Name: name})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Ping verifies a connection to the database server. It does not verify whether the
+// specified database (or instance) exists.
+func (drv *Driver) Ping() error {
+	ctx := context.Background()
+	client, name, err := drv.adminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(client)
+
+	_, err = client.ListDatabases(ctx, &databasepb.ListDatabasesRequest{
+		Parent:   instanceName(name),
+		PageSize: 1,
+	}).Next()
+	if err == iterator.Done || spanner.ErrCode(err) == codes.NotFound {
+		return nil
+	}
+
+	return err
+}
+
+// StatementSyntax returns the syntax used to split schema files into statements
+func (drv *Driver) StatementSyntax() dbutil.StatementSyntax {
+	return statementSyntax
+}
+
+// TransactionalDDL returns false, since Spanner does not allow schema changes in transactions
+func (drv *Driver) TransactionalDDL() bool {
+	return false
+}
+
+// LoadSchema executes a schema file as one script, so that consecutive DDL statements are
+// applied in one schema update. Each schema update takes seconds, so applying a large schema
+// one statement at a time would be very slow.
+func (drv *Driver) LoadSchema(r io.Reader) error {
+	schema, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	session, err := drv.openSession()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(session)
+
+	ex := &executor{session: session, ctx: context.Background()}
+	_, err = ex.exec(string(schema))
+	return err
+}
+
+// splitTableName returns the schema and name of a migrations table. The schema may be
+// specified as part of the table name (e.g. audit.schema_migrations), otherwise the table is
+// created in the default schema, which has an empty name.
+func splitTableName(table string) (string, string) {
+	schema, name, found := strings.Cut(table, ".")
+	if !found {
+		return "", table
+	}
+
+	return schema, name
+}
+
+// quotedTableName quotes the name of a migrations table
+func quotedTableName(table string) string {
+	return qualifiedName(splitTableName(table))
+}
+
+// quoteIdentifier quotes a table or column name with backticks
+func quoteIdentifier(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "\\`") + "`"
+}
+
+// quoteLiteral quotes a string, escaping backslashes and quotes
+func quoteLiteral(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`).Replace(s) + "'"
+}
+
+// qualifiedName quotes the name of an object, prefixed by its schema unless it is in the
+// default schema
+func qualifiedName(schema, name string) string {
+	if schema == "" {
+		return quoteIdentifier(name)
+	}
+
+	return quoteIdentifier(schema) + "." + quoteIdentifier(name)
+}
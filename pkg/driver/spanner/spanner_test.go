@@ -0,0 +1,510 @@
+package spanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	"cloud.google.com/go/spanner/apiv1/spannerpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbtest"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSpannerDriver(t *testing.T) *Driver {
+	u := dbtest.GetenvURLOrSkip(t, "SPANNER_TEST_URL")
+	drv, err := dbmate.New(u).SessionDriver()
+	require.NoError(t, err)
+
+	return drv.(*Driver)
+}
+
+func prepTestSpannerDB(t *testing.T) (*Driver, *session) {
+	drv := testSpannerDriver(t)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// connect database
+	s, err := drv.openSession()
+	require.NoError(t, err)
+
+	return drv, s
+}
+
+// execScript executes a script outside of a transaction
+func execScript(t *testing.T, s *session, script string) {
+	err := s.Run(false, func(ex dbmate.Executor) error {
+		_, err := ex.Exec(script)
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func TestGetDriver(t *testing.T) {
+	db := dbmate.New(dbtest.MustParseURL(t, "spanner://projects/p/instances/i/databases/d"))
+	drvInterface, err := db.SessionDriver()
+	require.NoError(t, err)
+
+	drv, ok := drvInterface.(*Driver)
+	require.True(t, ok)
+	require.Equal(t, db.DatabaseURL.String(), drv.databaseURL.String())
+	require.Equal(t, "schema_migrations", drv.migrationsTableName)
+	require.Implements(t, (*dbmate.StatementSplitter)(nil), drvInterface)
+	require.Implements(t, (*dbmate.SchemaLoader)(nil), drvInterface)
+	require.Implements(t, (*dbmate.SessionMigrationsDumper)(nil), &session{})
+
+	// spanner does not use database/sql
+	_, err = db.Driver()
+	require.ErrorIs(t, err, dbmate.ErrNotSQLDriver)
+}
+
+func TestDatabaseName(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"spanner://projects/p/instances/i/databases/d", "projects/p/instances/i/databases/d"},
+		{"spanner://projects/my-project/instances/test/databases/app_db/", "projects/my-project/instances/test/databases/app_db"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			name, err := databaseName(dbtest.MustParseURL(t, c.input))
+			require.NoError(t, err)
+			require.Equal(t, c.expected, name)
+		})
+	}
+
+	for _, input := range []string{
+		"spanner://",
+		"spanner://projects/p/instances/i",
+		"spanner://projects/p/instances/i/databases/d/tables/t",
+		"spanner://localhost/d",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := databaseName(dbtest.MustParseURL(t, input))
+			require.ErrorIs(t, err, ErrInvalidURL)
+		})
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	require.Equal(t, "projects/p/instances/i", instanceName("projects/p/instances/i/databases/d"))
+}
+
+func TestQuotedTableName(t *testing.T) {
+	require.Equal(t, "`schema_migrations`", quotedTableName("schema_migrations"))
+	require.Equal(t, "`audit`.`schema_migrations`", quotedTableName("audit.schema_migrations"))
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	require.Equal(t, `'abc'`, quoteLiteral("abc"))
+	require.Equal(t, `'it\'s a \\ test\n'`, quoteLiteral("it's a \\ test\n"))
+}
+
+func TestIsDDL(t *testing.T) {
+	require.True(t, isDDL("CREATE TABLE users (id INT64) PRIMARY KEY (id)"))
+	require.True(t, isDDL("  alter table users add column name STRING(MAX)"))
+	require.True(t, isDDL("drop index users_by_name"))
+	require.False(t, isDDL("insert into users (id) values (1)"))
+	require.False(t, isDDL("select 1"))
+	require.False(t, isDDL(""))
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements, err := splitStatements("CREATE TABLE a (s STRING(MAX)) PRIMARY KEY (s);\n" +
+		"# comment with a ;\n" +
+		"INSERT INTO a (s) VALUES ('x;y'), (\"\"\"a\n;b\"\"\");\n")
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+	require.Equal(t, 1, statements[0].Line)
+	require.Equal(t, 3, statements[1].Line)
+	require.Contains(t, statements[1].SQL, "('x;y')")
+}
+
+func TestBatchStatements(t *testing.T) {
+	statements, err := splitStatements("CREATE TABLE a (s STRING(MAX)) PRIMARY KEY (s);\n" +
+		"CREATE INDEX a_by_s ON a(s);\n" +
+		"INSERT INTO a (s) VALUES ('x');\n" +
+		"UPDATE a SET s = 'y' WHERE true;\n" +
+		"DROP INDEX a_by_s;\n")
+	require.NoError(t, err)
+
+	lines := [][]int{}
+	for _, batch := range batchStatements(statements) {
+		batchLines := []int{}
+		for _, statement := range batch {
+			batchLines = append(batchLines, statement.Line)
+		}
+		lines = append(lines, batchLines)
+	}
+	require.Equal(t, [][]int{{1, 2}, {3}, {4}, {5}}, lines)
+
+	require.Empty(t, batchStatements(nil))
+}
+
+func TestStatementError(t *testing.T) {
+	statements := []dbutil.Statement{{SQL: "select 1", Line: 1}, {SQL: "select 2", Line: 3}}
+	errFailed := errors.New("failed")
+
+	err := statementError(statements, statements[1], errFailed)
+	require.ErrorIs(t, err, errFailed)
+	require.EqualError(t, err, "statement at line 3: failed")
+
+	// the line is not reported for scripts with one statement
+	err = statementError(statements[:1], statements[0], errFailed)
+	require.Equal(t, errFailed, err)
+}
+
+func TestColumnValue(t *testing.T) {
+	value := func(code spannerpb.TypeCode, v *structpb.Value) spanner.GenericColumnValue {
+		return spanner.GenericColumnValue{Type: &spannerpb.Type{Code: code}, Value: v}
+	}
+	cases := []struct {
+		name     string
+		input    spanner.GenericColumnValue
+		expected interface{}
+	}{
+		{"null", value(spannerpb.TypeCode_STRING, structpb.NewNullValue()), nil},
+		{"bool", value(spannerpb.TypeCode_BOOL, structpb.NewBoolValue(true)), true},
+		{"int64", value(spannerpb.TypeCode_INT64, structpb.NewStringValue("42")), int64(42)},
+		{"float64", value(spannerpb.TypeCode_FLOAT64, structpb.NewNumberValue(1.5)), 1.5},
+		{"infinity", value(spannerpb.TypeCode_FLOAT64, structpb.NewStringValue("-Infinity")), math.Inf(-1)},
+		{"bytes", value(spannerpb.TypeCode_BYTES, structpb.NewStringValue("YWJj")), []byte("abc")},
+		{"date", value(spannerpb.TypeCode_DATE, structpb.NewStringValue("2024-01-02")), "2024-01-02"},
+		{"array", value(spannerpb.TypeCode_ARRAY, structpb.NewListValue(&structpb.ListValue{
+			Values: []*structpb.Value{structpb.NewStringValue("1"), structpb.NewStringValue("2")},
+		})), `["1","2"]`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v, err := columnValue(c.input)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, v)
+		})
+	}
+
+	_, err := columnValue(value(spannerpb.TypeCode_INT64, structpb.NewStringValue("x")))
+	require.Error(t, err)
+}
+
+func TestParseDDLObject(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected ddlObject
+	}{
+		{"CREATE TABLE users (\n  id INT64 NOT NULL,\n) PRIMARY KEY(id)", ddlObject{Type: "TABLE", Name: "users", Table: "users"}},
+		{"CREATE TABLE audit.events (id INT64) PRIMARY KEY(id)", ddlObject{Type: "TABLE", Schema: "audit", Name: "events", Table: "events"}},
+		{"CREATE UNIQUE NULL_FILTERED INDEX users_by_email ON users(email)", ddlObject{Type: "INDEX", Name: "users_by_email", Table: "users"}},
+		{"CREATE INDEX `order` ON `select`(id)", ddlObject{Type: "INDEX", Name: "order", Table: "select"}},
+		{"CREATE VIEW user_ids SQL SECURITY INVOKER AS SELECT id FROM users", ddlObject{Type: "VIEW", Name: "user_ids", Table: "user_ids"}},
+		{"CREATE SEQUENCE seq OPTIONS (sequence_kind = 'bit_reversed_positive')", ddlObject{Type: "SEQUENCE", Name: "seq"}},
+		{"ALTER TABLE orders ADD CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id)", ddlObject{Type: "TABLE", Name: "orders", Table: "orders"}},
+		{"CREATE SCHEMA audit", ddlObject{Type: "SCHEMA", Schema: "audit"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			object, ok := parseDDLObject(c.input)
+			require.True(t, ok)
+			require.Equal(t, c.expected, object)
+		})
+	}
+
+	_, ok := parseDDLObject("GRANT SELECT ON TABLE users TO ROLE reader")
+	require.False(t, ok)
+}
+
+func TestFormatDDL(t *testing.T) {
+	statements := []string{
+		"CREATE SCHEMA audit",
+		"CREATE TABLE users (\n  id INT64 NOT NULL,\n) PRIMARY KEY(id)",
+		"CREATE INDEX users_by_id ON users(id)",
+		"CREATE TABLE audit_log (id INT64) PRIMARY KEY(id)",
+		"CREATE TABLE audit.events (id INT64) PRIMARY KEY(id)",
+		"CREATE VIEW user_ids SQL SECURITY INVOKER AS SELECT id FROM users",
+		"CREATE ROLE reader",
+	}
+
+	t.Run("all objects", func(t *testing.T) {
+		drv := &Driver{}
+		require.Equal(t, "CREATE SCHEMA audit;\n\n"+
+			"CREATE TABLE users (\n  id INT64 NOT NULL,\n) PRIMARY KEY(id);\n\n"+
+			"CREATE INDEX users_by_id ON users(id);\n\n"+
+			"CREATE TABLE audit_log (id INT64) PRIMARY KEY(id);\n\n"+
+			"CREATE TABLE audit.events (id INT64) PRIMARY KEY(id);\n\n"+
+			"CREATE VIEW user_ids SQL SECURITY INVOKER AS SELECT id FROM users;\n\n"+
+			"CREATE ROLE reader;\n\n",
+			string(drv.formatDDL(statements)))
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		drv := &Driver{dumpOptions: dbmate.DumpOptions{
+			ExcludeTables:  []string{"audit_*"},
+			IncludeSchemas: []string{""},
+			Skip:           []string{dbmate.DumpSkipViews, dbmate.DumpSkipIndexes},
+		}}
+		require.Equal(t, "CREATE TABLE users (\n  id INT64 NOT NULL,\n) PRIMARY KEY(id);\n\n"+
+			"CREATE ROLE reader;\n\n",
+			string(drv.formatDDL(statements)))
+	})
+}
+
+func TestFormatLiteral(t *testing.T) {
+	cases := []struct {
+		value      interface{}
+		columnType string
+		expected   string
+	}{
+		{nil, "STRING(MAX)", "NULL"},
+		{true, "BOOL", "TRUE"},
+		{int64(-3), "INT64", "-3"},
+		{1.25, "FLOAT64", "1.25"},
+		{math.NaN(), "FLOAT64", "CAST('NaN' AS FLOAT64)"},
+		{[]byte("abc"), "BYTES(MAX)", "FROM_BASE64('YWJj')"},
+		{"it's", "STRING(10)", `'it\'s'`},
+		{"2024-01-02", "DATE", "DATE '2024-01-02'"},
+		{"1.50", "NUMERIC", "NUMERIC '1.50'"},
+		{`{"a":1}`, "JSON", `JSON '{"a":1}'`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.columnType, func(t *testing.T) {
+			literal, err := formatLiteral(c.value, c.columnType)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, literal)
+		})
+	}
+
+	_, err := formatLiteral(`["a"]`, "ARRAY<STRING(MAX)>")
+	require.Error(t, err)
+}
+
+func TestCreateDropDatabase(t *testing.T) {
+	drv := testSpannerDriver(t)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	exists, err := drv.DatabaseExists()
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// drop the database
+	err = drv.DropDatabase()
+	require.NoError(t, err)
+
+	exists, err = drv.DatabaseExists()
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestCreateMigrationsTable(t *testing.T) {
+	t.Run("default schema", func(t *testing.T) {
+		_, s := prepTestSpannerDB(t)
+		defer dbutil.MustClose(s)
+
+		exists, err := s.MigrationsTableExists("schema_migrations")
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		err = s.CreateMigrationsTable("schema_migrations")
+		require.NoError(t, err)
+
+		exists, err = s.MigrationsTableExists("schema_migrations")
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		// create table should be idempotent
+		err = s.CreateMigrationsTable("schema_migrations")
+		require.NoError(t, err)
+	})
+
+	t.Run("custom schema", func(t *testing.T) {
+		_, s := prepTestSpannerDB(t)
+		defer dbutil.MustClose(s)
+
+		err := s.CreateMigrationsTable("audit.testMigrations")
+		require.NoError(t, err)
+
+		exists, err := s.MigrationsTableExists("audit.testMigrations")
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		migrations, err := s.SelectMigrations("audit.testMigrations", -1)
+		require.NoError(t, err)
+		require.Empty(t, migrations)
+	})
+}
+
+func TestDumpSchema(t *testing.T) {
+	drv, s := prepTestSpannerDB(t)
+	drv.dumpOptions = dbmate.DumpOptions{DataTables: []string{"countries"}}
+
+	execScript(t, s, `CREATE TABLE users (id INT64 NOT NULL, name STRING(MAX)) PRIMARY KEY (id);
+		CREATE INDEX users_by_name ON users(name);
+		CREATE TABLE countries (code STRING(2) NOT NULL, founded DATE) PRIMARY KEY (code)`)
+	execScript(t, s, `INSERT INTO countries (code, founded) VALUES ('NZ', '1907-09-26'), ('AU', NULL)`)
+
+	err := s.CreateMigrationsTable("schema_migrations")
+	require.NoError(t, err)
+	err = s.Run(false, func(ex dbmate.Executor) error {
+		return ex.InsertMigration("schema_migrations", "abc1")
+	})
+	require.NoError(t, err)
+
+	schema, err := s.DumpSchema()
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "CREATE TABLE users (\n")
+	require.Contains(t, string(schema), "CREATE INDEX users_by_name ON users(name);\n")
+	require.Contains(t, string(schema), "-- Data for table countries\n"+
+		"INSERT INTO `countries` (`code`, `founded`) VALUES\n"+
+		"  ('AU', NULL),\n"+
+		"  ('NZ', DATE '1907-09-26');\n")
+	require.Contains(t, string(schema), "-- Dbmate schema migrations\n"+
+		"INSERT INTO `schema_migrations` (version) VALUES\n"+
+		"  ('abc1');\n")
+	dbutil.MustClose(s)
+
+	// the dump should recreate the schema
+	drv.dumpOptions = dbmate.DumpOptions{}
+	err = drv.DropDatabase()
+	require.NoError(t, err)
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	err = drv.LoadSchema(bytes.NewReader(schema))
+	require.NoError(t, err)
+
+	s, err = drv.openSession()
+	require.NoError(t, err)
+	defer dbutil.MustClose(s)
+	migrations, err := s.SelectMigrations("schema_migrations", -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true}, migrations)
+}
+
+func TestRun(t *testing.T) {
+	t.Run("commits", func(t *testing.T) {
+		_, s := prepTestSpannerDB(t)
+		defer dbutil.MustClose(s)
+
+		err := s.CreateMigrationsTable("schema_migrations")
+		require.NoError(t, err)
+
+		err = s.Run(true, func(ex dbmate.Executor) error {
+			return ex.InsertMigration("schema_migrations", "abc1")
+		})
+		require.NoError(t, err)
+
+		migrations, err := s.SelectMigrations("schema_migrations", -1)
+		require.NoError(t, err)
+		require.Equal(t, map[string]bool{"abc1": true}, migrations)
+	})
+
+	t.Run("rolls back", func(t *testing.T) {
+		_, s := prepTestSpannerDB(t)
+		defer dbutil.MustClose(s)
+
+		err := s.CreateMigrationsTable("schema_migrations")
+		require.NoError(t, err)
+
+		errFailed := errors.New("migration failed")
+		err = s.Run(true, func(ex dbmate.Executor) error {
+			if err := ex.InsertMigration("schema_migrations", "abc1"); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		require.ErrorIs(t, err, errFailed)
+
+		migrations, err := s.SelectMigrations("schema_migrations", -1)
+		require.NoError(t, err)
+		require.Empty(t, migrations)
+	})
+
+	t.Run("rejects ddl", func(t *testing.T) {
+		_, s := prepTestSpannerDB(t)
+		defer dbutil.MustClose(s)
+
+		err := s.Run(true, func(ex dbmate.Executor) error {
+			_, err := ex.Exec("CREATE TABLE users (id INT64) PRIMARY KEY (id)")
+			return err
+		})
+		require.ErrorIs(t, err, ErrDDLInTransaction)
+	})
+
+	t.Run("reports failed statement", func(t *testing.T) {
+		_, s := prepTestSpannerDB(t)
+		defer dbutil.MustClose(s)
+
+		err := s.Run(false, func(ex dbmate.Executor) error {
+			_, err := ex.Exec("CREATE TABLE users (id INT64) PRIMARY KEY (id);\n" +
+				"INSERT INTO users (id) VALUES (1);\n" +
+				"INSERT INTO missing (id) VALUES (1);\n")
+			return err
+		})
+		require.ErrorContains(t, err, "statement at line 3")
+
+		// the statements before the failed statement remain applied
+		var count int64
+		count, err = s.queryCount(context.Background(), spanner.Statement{SQL: "select count(*) from users"})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count)
+	})
+}
+
+func TestSelectInsertDeleteMigrations(t *testing.T) {
+	_, s := prepTestSpannerDB(t)
+	defer dbutil.MustClose(s)
+
+	err := s.CreateMigrationsTable("schema_migrations")
+	require.NoError(t, err)
+
+	err = s.Run(false, func(ex dbmate.Executor) error {
+		if err := ex.InsertMigration("schema_migrations", "abc2"); err != nil {
+			return err
+		}
+		return ex.InsertMigration("schema_migrations", "abc1")
+	})
+	require.NoError(t, err)
+
+	migrations, err := s.SelectMigrations("schema_migrations", -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true, "abc2": true}, migrations)
+
+	err = s.Run(false, func(ex dbmate.Executor) error {
+		return ex.DeleteMigration("schema_migrations", "abc2")
+	})
+	require.NoError(t, err)
+
+	migrations, err = s.SelectMigrations("schema_migrations", 1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true}, migrations)
+}
+
+func TestPing(t *testing.T) {
+	drv := testSpannerDriver(t)
+
+	err := drv.Ping()
+	require.NoError(t, err)
+}
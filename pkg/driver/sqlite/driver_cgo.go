@@ -0,0 +1,18 @@
+//go:build cgo && !sqlite_purego
+// +build cgo,!sqlite_purego
+
+package sqlite
+
+import (
+	"net/url"
+
+	_ "github.com/mattn/go-sqlite3" // database/sql driver
+)
+
+// sqlDriverName is the database/sql driver registered by mattn/go-sqlite3
+const sqlDriverName = "sqlite3"
+
+// dataSourceName returns the connection string passed to sql.Open
+func dataSourceName(u *url.URL) string {
+	return ConnectionString(u)
+}
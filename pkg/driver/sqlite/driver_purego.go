@@ -0,0 +1,69 @@
+//go:build !cgo || sqlite_purego
+// +build !cgo sqlite_purego
+
+package sqlite
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	_ "modernc.org/sqlite" // database/sql driver
+)
+
+// sqlDriverName is the database/sql driver registered by modernc.org/sqlite
+const sqlDriverName = "sqlite"
+
+// pragmaParams maps the connection parameters supported by mattn/go-sqlite3 to pragmas,
+// so that the same database URLs work with or without cgo
+var pragmaParams = []struct {
+	Pragma string
+	Params []string
+}{
+	{"auto_vacuum", []string{"_auto_vacuum", "_vacuum"}},
+	{"busy_timeout", []string{"_busy_timeout", "_timeout"}},
+	{"cache_size", []string{"_cache_size"}},
+	{"case_sensitive_like", []string{"_case_sensitive_like", "_cslike"}},
+	{"defer_foreign_keys", []string{"_defer_foreign_keys", "_defer_fk"}},
+	{"foreign_keys", []string{"_foreign_keys", "_fk"}},
+	{"ignore_check_constraints", []string{"_ignore_check_constraints"}},
+	{"journal_mode", []string{"_journal_mode", "_journal"}},
+	{"locking_mode", []string{"_locking_mode", "_locking"}},
+	{"query_only", []string{"_query_only"}},
+	{"recursive_triggers", []string{"_recursive_triggers", "_rt"}},
+	{"secure_delete", []string{"_secure_delete"}},
+	{"synchronous", []string{"_synchronous", "_sync"}},
+	{"writable_schema", []string{"_writable_schema"}},
+}
+
+// defaultBusyTimeout matches the busy timeout (in milliseconds) used by mattn/go-sqlite3
+const defaultBusyTimeout = "5000"
+
+// dataSourceName returns the connection string passed to sql.Open, with connection
+// parameters converted to the _pragma parameters understood by modernc.org/sqlite
+func dataSourceName(u *url.URL) string {
+	dsn := ConnectionString(u)
+	path, rawQuery, _ := strings.Cut(dsn, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// leave invalid parameters for the driver to report
+		return dsn
+	}
+
+	if query.Get("_busy_timeout") == "" && query.Get("_timeout") == "" {
+		query.Set("_busy_timeout", defaultBusyTimeout)
+	}
+	for _, p := range pragmaParams {
+		for _, param := range p.Params {
+			if value := query.Get(param); value != "" {
+				query.Add("_pragma", fmt.Sprintf("%s(%s)", p.Pragma, value))
+				break
+			}
+		}
+		for _, param := range p.Params {
+			query.Del(param)
+		}
+	}
+
+	return path + "?" + query.Encode()
+}
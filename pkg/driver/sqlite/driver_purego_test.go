@@ -0,0 +1,47 @@
+//go:build !cgo || sqlite_purego
+// +build !cgo sqlite_purego
+
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbtest"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataSourceName(t *testing.T) {
+	tests := []struct {
+		name, url, want string
+	}{
+		{"defaults", "sqlite:foo.sqlite3", "foo.sqlite3?_pragma=busy_timeout%285000%29"},
+		{"custom timeout", "sqlite:foo.sqlite3?_timeout=100", "foo.sqlite3?_pragma=busy_timeout%28100%29"},
+		{"pragma params", "sqlite:/tmp/foo.sqlite3?_fk=1&_journal_mode=WAL&mode=ro",
+			"/tmp/foo.sqlite3?_pragma=busy_timeout%285000%29&_pragma=foreign_keys%281%29&_pragma=journal_mode%28WAL%29&mode=ro"},
+		{"native pragma", "sqlite:foo.sqlite3?_pragma=foreign_keys(1)&_txlock=immediate",
+			"foo.sqlite3?_pragma=foreign_keys%281%29&_pragma=busy_timeout%285000%29&_txlock=immediate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, dataSourceName(dbtest.MustParseURL(t, tt.url)))
+		})
+	}
+}
+
+func TestSQLiteForeignKeysParam(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	u := *drv.databaseURL
+	u.RawQuery = "_foreign_keys=on"
+	drv.databaseURL = &u
+
+	db := prepTestSQLiteDB(t, drv)
+	defer dbutil.MustClose(db)
+
+	enabled := false
+	err := db.QueryRow("pragma foreign_keys").Scan(&enabled)
+	require.NoError(t, err)
+	require.True(t, enabled)
+}
@@ -0,0 +1,191 @@
+package sqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// dumpMethod returns the configured schema dump method. The --dump-method flag takes
+// precedence over the dump_method URL parameter. By default the schema is dumped natively,
+// since SQLite is linked into dbmate, and the schema file should not depend on whether the
+// sqlite3 command is installed (its output is ordered and formatted differently).
+func (drv *Driver) dumpMethod() (string, error) {
+	method := drv.dumpMethodName
+	if method == "" {
+		method = drv.databaseURL.Query().Get("dump_method")
+	}
+
+	switch method {
+	case "sqlite3", dbmate.DumpMethodTool:
+		return dbmate.DumpMethodTool, nil
+	case "", dbmate.DumpMethodNative:
+		return dbmate.DumpMethodNative, nil
+	case dbmate.DumpMethodAuto:
+		if _, err := exec.LookPath("sqlite3"); err != nil {
+			return dbmate.DumpMethodNative, nil
+		}
+		return dbmate.DumpMethodTool, nil
+	default:
+		return "", fmt.Errorf("%w: %s", dbmate.ErrUnsupportedDumpMethod, method)
+	}
+}
+
+// nativeDumpQuery lists the schema in the same format as `.schema --nosys`, ordered by type
+// and name. SQLite does not resolve the tables used by views and triggers until they are
+// used, so only indexes need to follow the tables they belong to. Internal tables, and the
+// shadow tables of virtual tables (which are created along with the virtual table) are skipped.
+const nativeDumpQuery = `select type, tbl_name, sql from sqlite_master
+	where sql is not null and name not like 'sqlite_%'
+	and name not in (select name from pragma_table_list where schema = 'main' and type = 'shadow')
+	order by case type when 'table' then 0 when 'index' then 1 when 'view' then 2 else 3 end,
+		tbl_name, name`
+
+// dumpSkipTypes maps sqlite_master object types to DumpOptions.Skip object types
+var dumpSkipTypes = map[string]string{
+	"index":   dbmate.DumpSkipIndexes,
+	"view":    dbmate.DumpSkipViews,
+	"trigger": dbmate.DumpSkipTriggers,
+}
+
+// excludesObject returns true if DumpOptions leave an object out of the schema
+func (drv *Driver) excludesObject(objectType, table string) bool {
+	return drv.dumpOptions.Skips(dumpSkipTypes[objectType]) || drv.dumpOptions.ExcludesTable("main", table)
+}
+
+// nativeDumpSchema dumps the schema from sqlite_master, without the sqlite3 command
+func (drv *Driver) nativeDumpSchema(db *sql.DB) ([]byte, error) {
+	rows, err := db.Query(nativeDumpQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	var buf bytes.Buffer
+	for rows.Next() {
+		var objectType, table, statement string
+		if err := rows.Scan(&objectType, &table, &statement); err != nil {
+			return nil, err
+		}
+		if drv.excludesObject(objectType, table) {
+			continue
+		}
+		buf.WriteString(statement + ";\n")
+	}
+
+	return buf.Bytes(), rows.Err()
+}
+
+// toolDumpObjectsQuery lists the objects printed by `.schema --nosys`, in the same order. The
+// shadow tables of a virtual table belong to the virtual table, whose name they start with.
+const toolDumpObjectsQuery = `select m.type, coalesce(
+		(select v.name from pragma_table_list v
+			where v.schema = 'main' and v.type = 'virtual'
+			and m.name in (select s.name from pragma_table_list s where s.schema = 'main' and s.type = 'shadow')
+			and substr(m.name, 1, length(v.name) + 1) = v.name || '_'
+			order by length(v.name) desc limit 1),
+		m.tbl_name)
+	from sqlite_master m
+	where m.sql is not null and m.name not like 'sqlite_%'
+	order by m.rowid`
+
+// ErrToolSchemaMismatch is returned when the output of the sqlite3 command cannot be matched
+// with the objects in the database, so that it cannot be filtered
+var ErrToolSchemaMismatch = errors.New("unable to filter sqlite3 schema output " +
+	"(use --dump-method native to filter the schema)")
+
+// toolDumpSchema dumps the schema with `sqlite3 .schema --nosys`. The command cannot filter
+// its output, so DumpOptions are applied to the statements which it prints.
+func (drv *Driver) toolDumpSchema(db *sql.DB) ([]byte, error) {
+	schema, err := dbutil.RunCommand("sqlite3", filePathFromURL(drv.databaseURL), ".schema --nosys")
+	if err != nil || !drv.dumpOptions.Filtered() {
+		return schema, err
+	}
+
+	rows, err := db.Query(toolDumpObjectsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	statements := splitToolSchema(schema)
+	var buf bytes.Buffer
+	i := 0
+	for ; rows.Next(); i++ {
+		var objectType, table string
+		if err := rows.Scan(&objectType, &table); err != nil {
+			return nil, err
+		}
+		if i >= len(statements) {
+			return nil, ErrToolSchemaMismatch
+		}
+		if !drv.excludesObject(objectType, table) {
+			buf.WriteString(statements[i])
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if i != len(statements) {
+		return nil, ErrToolSchemaMismatch
+	}
+
+	return buf.Bytes(), nil
+}
+
+// splitToolSchema splits the output of `.schema`, which prints each object as one statement.
+// Trigger bodies contain semicolons, but cannot contain CREATE statements, so statements are
+// split where a line starting with CREATE follows a semicolon.
+func splitToolSchema(schema []byte) []string {
+	statements := []string{}
+	s := string(schema)
+	for s != "" {
+		end := strings.Index(s, ";\nCREATE ")
+		if end < 0 {
+			statements = append(statements, s)
+			break
+		}
+		statements = append(statements, s[:end+2])
+		s = s[end+2:]
+	}
+
+	return statements
+}
+
+// dataDumpSyntax formats rows as lists of quoted values
+var dataDumpSyntax = dbutil.DataDumpSyntax{
+	RowSQL: func(values []string) string {
+		return strings.Join(values, " || ', ' || ")
+	},
+	Indent: "  ",
+}
+
+// tableDataDump returns INSERT statements for the rows of each table in DumpOptions.DataTables
+func (drv *Driver) tableDataDump(db *sql.DB) ([]byte, error) {
+	return dataDumpSyntax.DumpTableData(db, drv.dumpOptions.DataTables, func(table string) (dbutil.DataTable, error) {
+		// generated columns are hidden, and cannot be inserted
+		columns, err := dbutil.QueryColumn(db,
+			"select name from pragma_table_xinfo(?) where hidden = 0 order by cid", table)
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+
+		data := dbutil.DataTable{
+			Name:   drv.quoteIdentifier(table),
+			Header: fmt.Sprintf("-- Data for table %s\n", table),
+		}
+		for _, column := range columns {
+			column = drv.quoteIdentifier(column)
+			data.Columns = append(data.Columns, column)
+			data.Values = append(data.Values, "quote("+column+")")
+		}
+
+		return data, nil
+	})
+}
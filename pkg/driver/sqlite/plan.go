@@ -0,0 +1,208 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// InspectSchema returns the tables in the database
+func (drv *Driver) InspectSchema(db *sql.DB) (*dbmate.Schema, error) {
+	rows, err := db.Query("select name, sql from sqlite_master " +
+		"where type = 'table' and name not like 'sqlite_%' order by name")
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	schema := &dbmate.Schema{}
+	for rows.Next() {
+		var table dbmate.SchemaTable
+		if err := rows.Scan(&table.Name, &table.Definition); err != nil {
+			return nil, err
+		}
+		schema.Tables = append(schema.Tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range schema.Tables {
+		if err := drv.inspectTable(db, &schema.Tables[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// views and triggers are not compared
+	schema.Unplanned, err = dbutil.QueryColumn(db, "select type || ' ' || name from sqlite_master "+
+		"where type in ('view', 'trigger') order by type, name")
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+func (drv *Driver) inspectTable(db *sql.DB, table *dbmate.SchemaTable) error {
+	name := drv.quoteIdentifier(table.Name)
+
+	// columns
+	rows, err := db.Query(fmt.Sprintf("pragma table_info(%s)", name))
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	primaryKey := []string{}
+	for rows.Next() {
+		var cid, pk int
+		var column dbmate.SchemaColumn
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &column.Name, &column.Type, &column.NotNull, &dflt, &pk); err != nil {
+			return err
+		}
+		column.Default = dflt.String
+		column.Definition = drv.columnDefinition(column)
+		table.Columns = append(table.Columns, column)
+		if pk > 0 {
+			primaryKey = append(primaryKey, column.Name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(primaryKey) > 0 {
+		table.Constraints = append(table.Constraints, dbmate.SchemaConstraint{
+			Name:       "primary key",
+			Definition: fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(primaryKey, ", ")),
+		})
+	}
+
+	// foreign keys
+	fkRows, err := db.Query(fmt.Sprintf("pragma foreign_key_list(%s)", name))
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(fkRows)
+
+	for fkRows.Next() {
+		var id, seq int
+		var refTable, from, onUpdate, onDelete, match string
+		var to sql.NullString
+		if err := fkRows.Scan(&id, &seq, &refTable, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return err
+		}
+		table.Constraints = append(table.Constraints, dbmate.SchemaConstraint{
+			Name: fmt.Sprintf("foreign key %d.%d", id, seq),
+			Definition: fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s(%s) ON UPDATE %s ON DELETE %s",
+				from, refTable, to.String, onUpdate, onDelete),
+		})
+		table.References = append(table.References, refTable)
+	}
+	if err := fkRows.Err(); err != nil {
+		return err
+	}
+
+	// indexes, excluding those created automatically for constraints
+	indexRows, err := db.Query("select name, sql from sqlite_master "+
+		"where type = 'index' and tbl_name = ? and sql is not null order by name", table.Name)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(indexRows)
+
+	for indexRows.Next() {
+		var index dbmate.SchemaIndex
+		if err := indexRows.Scan(&index.Name, &index.Definition); err != nil {
+			return err
+		}
+		table.Indexes = append(table.Indexes, index)
+	}
+
+	return indexRows.Err()
+}
+
+func (drv *Driver) columnDefinition(column dbmate.SchemaColumn) string {
+	def := drv.quoteIdentifier(column.Name)
+	if column.Type != "" {
+		def += " " + column.Type
+	}
+	if column.NotNull {
+		def += " NOT NULL"
+	}
+	if column.Default != "" {
+		def += " DEFAULT " + column.Default
+	}
+
+	return def
+}
+
+// ScratchDatabaseURL returns the URL of a temporary database file
+func (drv *Driver) ScratchDatabaseURL() (*url.URL, error) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("dbmate_scratch_%d.sqlite3", time.Now().UnixNano()))
+
+	return normalizeSQLiteURL(&url.URL{Scheme: drv.databaseURL.Scheme, Opaque: filepath.ToSlash(path)}), nil
+}
+
+// AlterTableSQL returns the statements which change a table from one definition to another.
+// SQLite can only add and drop columns in place, so other changes (such as changing a column
+// type or constraint) return dbmate.ErrPlanUnsupportedChange.
+func (drv *Driver) AlterTableSQL(from, to dbmate.SchemaTable) ([]string, error) {
+	if !equalConstraints(from.Constraints, to.Constraints) {
+		return nil, fmt.Errorf("%w: sqlite cannot alter constraints on table %s",
+			dbmate.ErrPlanUnsupportedChange, from.Name)
+	}
+
+	name := drv.quoteIdentifier(to.Name)
+	fromColumns := map[string]dbmate.SchemaColumn{}
+	for _, column := range from.Columns {
+		fromColumns[column.Name] = column
+	}
+	toColumns := map[string]dbmate.SchemaColumn{}
+	for _, column := range to.Columns {
+		toColumns[column.Name] = column
+	}
+
+	statements := []string{}
+	for _, column := range from.Columns {
+		if _, ok := toColumns[column.Name]; !ok {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s",
+				name, drv.quoteIdentifier(column.Name)))
+		}
+	}
+	for _, column := range to.Columns {
+		fromColumn, ok := fromColumns[column.Name]
+		if !ok {
+			if column.NotNull && column.Default == "" {
+				return nil, fmt.Errorf("%w: sqlite cannot add column %s.%s without a default value",
+					dbmate.ErrPlanUnsupportedChange, to.Name, column.Name)
+			}
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", name, column.Definition))
+		} else if fromColumn.Definition != column.Definition {
+			return nil, fmt.Errorf("%w: sqlite cannot alter column %s.%s",
+				dbmate.ErrPlanUnsupportedChange, to.Name, column.Name)
+		}
+	}
+
+	return statements, nil
+}
+
+func equalConstraints(a, b []dbmate.SchemaConstraint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,6 +1,3 @@
-//go:build cgo
-// +build cgo
-
 package sqlite
 
 import (
@@ -11,13 +8,13 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 
 	"github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3" // database/sql driver
 )
 
 func init() {
@@ -30,6 +27,8 @@ type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
 	log                 io.Writer
+	dumpMethodName      string
+	dumpOptions         dbmate.DumpOptions
 }
 
 // NewDriver initializes the driver
@@ -38,6 +37,8 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
 		log:                 config.Log,
+		dumpMethodName:      config.DumpMethod,
+		dumpOptions:         config.DumpOptions,
 	}
 }
 
@@ -92,6 +93,12 @@ func filePathFromURL(u *url.URL) string {
 func ConnectionString(u *url.URL) string {
 	u = normalizeSQLiteURL(u)
 	p := filePathFromURL(u)
+
+	// dump_method is used by dbmate, not the sqlite driver
+	if query := u.Query(); query.Has("dump_method") {
+		query.Del("dump_method")
+		u.RawQuery = query.Encode()
+	}
 	if u.RawQuery != "" {
 		p += "?" + u.RawQuery
 	}
@@ -103,7 +110,7 @@ func ConnectionString(u *url.URL) string {
 
 // Open creates a new database connection
 func (drv *Driver) Open() (*sql.DB, error) {
-	return sql.Open("sqlite3", ConnectionString(drv.databaseURL))
+	return sql.Open(sqlDriverName, dataSourceName(drv.databaseURL))
 }
 
 // CreateDatabase creates the specified database
@@ -159,20 +166,42 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// DumpMigrations returns the versions recorded in the migrations table, as in DumpSchema
+func (drv *Driver) DumpMigrations(db *sql.DB) ([]byte, error) {
+	return drv.schemaMigrationsDump(db)
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB, _ ...string) ([]byte, error) {
-	path := filePathFromURL(drv.databaseURL)
-	schema, err := dbutil.RunCommand("sqlite3", path, ".schema --nosys")
+	method, err := drv.dumpMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	var schema []byte
+	if method == dbmate.DumpMethodNative {
+		schema, err = drv.nativeDumpSchema(db)
+	} else {
+		schema, err = drv.toolDumpSchema(db)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	migrations, err := drv.schemaMigrationsDump(db)
+	data, err := drv.tableDataDump(db)
 	if err != nil {
 		return nil, err
 	}
+	schema = append(schema, data...)
+
+	if !drv.dumpOptions.NoMigrationsData {
+		migrations, err := drv.schemaMigrationsDump(db)
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, migrations...)
+	}
 
-	schema = append(schema, migrations...)
 	return dbutil.TrimLeadingSQLComments(schema)
 }
 
@@ -274,6 +303,39 @@ func (drv *Driver) Ping() error {
 	return db.Ping()
 }
 
+// ServerVersion returns the version of the SQLite library. It uses an in-memory database,
+// since opening the database file would create it.
+func (drv *Driver) ServerVersion() (string, error) {
+	db, err := sql.Open(sqlDriverName, ":memory:")
+	if err != nil {
+		return "", err
+	}
+	defer dbutil.MustClose(db)
+
+	return dbutil.QueryValue(db, "select sqlite_version()")
+}
+
+var (
+	vacuumRegexp      = regexp.MustCompile(`(?is)^vacuum\b`)
+	foreignKeysRegexp = regexp.MustCompile(`(?is)^pragma\s+([\w"]+\.)?foreign_keys\s*=`)
+	journalModeRegexp = regexp.MustCompile(`(?is)^pragma\s+([\w"]+\.)?journal_mode\s*=`)
+)
+
+// NonTransactionalReason returns why a statement cannot run in a transaction, or an empty
+// string if it can
+func (drv *Driver) NonTransactionalReason(statement string) string {
+	switch {
+	case vacuumRegexp.MatchString(statement):
+		return "VACUUM cannot run in a transaction"
+	case foreignKeysRegexp.MatchString(statement):
+		return "PRAGMA foreign_keys has no effect in a transaction"
+	case journalModeRegexp.MatchString(statement):
+		return "PRAGMA journal_mode cannot switch to or from WAL in a transaction"
+	default:
+		return ""
+	}
+}
+
 // Return a normalized version of the driver-specific error type.
 func (drv *Driver) QueryError(query string, err error) error {
 	return &dbmate.QueryError{Err: err, Query: query}
@@ -285,7 +347,7 @@ func (drv *Driver) quotedMigrationsTableName() string {
 
 // quoteIdentifier quotes a table or column name
 // we fall back to lib/pq implementation since both use ansi standard (double quotes)
-// and mattn/go-sqlite3 doesn't provide a sqlite-specific equivalent
+// and the sqlite database/sql drivers don't provide a sqlite-specific equivalent
 func (drv *Driver) quoteIdentifier(s string) string {
 	return pq.QuoteIdentifier(s)
 }
@@ -1,11 +1,9 @@
-//go:build cgo
-// +build cgo
-
 package sqlite
 
 import (
 	"database/sql"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"testing"
@@ -107,6 +105,9 @@ func TestConnectionString(t *testing.T) {
 		{"three slashes with space in path (1st dir)", "sqlite:///tm p/foo bar.sqlite3?mode=ro", "/tm p/foo bar.sqlite3?mode=ro"},
 		// supported for backwards compatibility
 		{"four slashes with space", "sqlite:////tmp/foo bar.sqlite3?mode=ro", "/tmp/foo bar.sqlite3?mode=ro"},
+		// dump_method is not passed to the sqlite driver
+		{"dump method", "sqlite:foo.sqlite3?dump_method=native&mode=ro", "foo.sqlite3?mode=ro"},
+		{"only dump method", "sqlite:foo.sqlite3?dump_method=native", "foo.sqlite3"},
 	}
 
 	for _, tt := range tests {
@@ -145,6 +146,7 @@ func TestSQLiteCreateDropDatabase(t *testing.T) {
 func TestSQLiteDumpSchema(t *testing.T) {
 	drv := testSQLiteDriver(t)
 	drv.migrationsTableName = "test_migrations"
+	drv.dumpMethodName = dbmate.DumpMethodTool
 
 	// prepare database
 	db := prepTestSQLiteDB(t, drv)
@@ -183,6 +185,270 @@ func TestSQLiteDumpSchema(t *testing.T) {
 	require.EqualError(t, err, "Error: unable to open database \".\": unable to open database file")
 }
 
+func TestSQLiteNativeDumpSchema(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+	drv.dumpMethodName = dbmate.DumpMethodNative
+
+	// prepare database
+	db := prepTestSQLiteDB(t, drv)
+	defer dbutil.MustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT);
+		CREATE TRIGGER users_email AFTER INSERT ON users BEGIN SELECT 1; END;
+		CREATE VIEW user_emails AS SELECT email FROM users;
+		CREATE INDEX users_email_idx ON users (email);
+		CREATE TABLE posts (id INTEGER, user_id INTEGER REFERENCES users (id))`)
+	require.NoError(t, err)
+
+	// DumpSchema should return schema ordered by type and name, without sqlite_* tables
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE posts (id INTEGER, user_id INTEGER REFERENCES users (id));\n"+
+		"CREATE TABLE \"test_migrations\" (version varchar(128) primary key);\n"+
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT);\n"+
+		"CREATE INDEX users_email_idx ON users (email);\n"+
+		"CREATE VIEW user_emails AS SELECT email FROM users;\n"+
+		"CREATE TRIGGER users_email AFTER INSERT ON users BEGIN SELECT 1; END;\n"+
+		"-- Dbmate schema migrations\n"+
+		"INSERT INTO \"test_migrations\" (version) VALUES\n"+
+		"  ('abc1');\n", string(schema))
+
+	// the dump should be loadable into an empty database
+	db2, err := sql.Open(sqlDriverName, filepath.Join(t.TempDir(), "load.sqlite3"))
+	require.NoError(t, err)
+	defer dbutil.MustClose(db2)
+	_, err = db2.Exec(string(schema))
+	require.NoError(t, err)
+}
+
+func TestSQLiteDumpSchemaFilters(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+	drv.dumpOptions = dbmate.DumpOptions{
+		ExcludeTables:    []string{"audit_*"},
+		Skip:             []string{dbmate.DumpSkipViews, dbmate.DumpSkipIndexes},
+		NoMigrationsData: true,
+	}
+
+	// prepare database
+	db := prepTestSQLiteDB(t, drv)
+	defer dbutil.MustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE TABLE audit_log (id INTEGER, user_id INTEGER);
+		CREATE TRIGGER audit_log_insert AFTER INSERT ON audit_log BEGIN SELECT 1; END;
+		CREATE TRIGGER users_insert AFTER INSERT ON users BEGIN SELECT 1; END;
+		CREATE VIEW user_emails AS SELECT email FROM users;
+		CREATE INDEX users_email_idx ON users (email)`)
+	require.NoError(t, err)
+
+	// filters always use the native dump, since the sqlite3 command cannot apply them
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE \"test_migrations\" (version varchar(128) primary key);\n"+
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);\n"+
+		"CREATE TRIGGER users_insert AFTER INSERT ON users BEGIN SELECT 1; END;\n", string(schema))
+}
+
+func TestSQLiteDumpSchemaDataTables(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+	drv.dumpMethodName = dbmate.DumpMethodNative
+	drv.dumpOptions = dbmate.DumpOptions{DataTables: []string{"countries", "plan types"}}
+
+	// prepare database
+	db := prepTestSQLiteDB(t, drv)
+	defer dbutil.MustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT, upper_code TEXT AS (upper(code)));
+		INSERT INTO countries (code, name) VALUES ('nz', 'New Zealand'), ('au', 'Australia'), ('xx', NULL);
+		CREATE TABLE "plan types" (id INTEGER, data BLOB, price REAL);
+		INSERT INTO "plan types" VALUES (2, x'00ff', 9.5), (1, NULL, 0)`)
+	require.NoError(t, err)
+
+	// rows are sorted, and generated columns are left out
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "-- Data for table countries\n"+
+		"INSERT INTO \"countries\" (\"code\", \"name\") VALUES\n"+
+		"  ('au', 'Australia'),\n"+
+		"  ('nz', 'New Zealand'),\n"+
+		"  ('xx', NULL);\n"+
+		"-- Data for table plan types\n"+
+		"INSERT INTO \"plan types\" (\"id\", \"data\", \"price\") VALUES\n"+
+		"  (1, NULL, 0.0),\n"+
+		"  (2, X'00FF', 9.5);\n"+
+		"-- Dbmate schema migrations\n")
+
+	// the dump should recreate the data in an empty database
+	db2, err := sql.Open(sqlDriverName, filepath.Join(t.TempDir(), "load.sqlite3"))
+	require.NoError(t, err)
+	defer dbutil.MustClose(db2)
+	_, err = db2.Exec(string(schema))
+	require.NoError(t, err)
+	names, err := dbutil.QueryColumn(db2, "select upper_code || ':' || name from countries where name is not null order by code")
+	require.NoError(t, err)
+	require.Equal(t, []string{"AU:Australia", "NZ:New Zealand"}, names)
+
+	// missing tables are reported
+	drv.dumpOptions.DataTables = []string{"missing"}
+	_, err = drv.DumpSchema(db)
+	require.EqualError(t, err, "unable to dump data for table missing: table does not exist")
+}
+
+func TestSplitToolSchema(t *testing.T) {
+	require.Empty(t, splitToolSchema(nil))
+	require.Equal(t, []string{
+		"CREATE TABLE a (id int);\n",
+		"CREATE VIEW v as select id from a\n/* v(id) */;\n",
+		"CREATE TRIGGER tr after insert on a begin select 1;\nselect 2; end;\n",
+	}, splitToolSchema([]byte("CREATE TABLE a (id int);\n"+
+		"CREATE VIEW v as select id from a\n/* v(id) */;\n"+
+		"CREATE TRIGGER tr after insert on a begin select 1;\nselect 2; end;\n")))
+}
+
+func TestSQLiteDumpSchemaToolFilters(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 command not found")
+	}
+
+	drv := testSQLiteDriver(t)
+	drv.dumpMethodName = dbmate.DumpMethodTool
+	drv.dumpOptions = dbmate.DumpOptions{NoMigrationsData: true}
+	db := prepTestSQLiteDB(t, drv)
+	defer dbutil.MustClose(db)
+
+	_, err := db.Exec(`create table b (id integer primary key, x text);
+		create table audit_log (id int, y text);
+		create index audit_log_y on audit_log(y);
+		create table a (id int);
+		create view v as select id from a;
+		create trigger audit_b after insert on b begin insert into audit_log (id) values (new.id); end;
+		create trigger b_insert after insert on audit_log begin select 1; select 2; end;`)
+	require.NoError(t, err)
+
+	// filters leave out objects, but keep the order (b before a) and format of the sqlite3 command
+	drv.dumpOptions.ExcludeTables = []string{"audit_*"}
+	drv.dumpOptions.Skip = []string{dbmate.DumpSkipViews}
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE b (id integer primary key, x text);\n"+
+		"CREATE TABLE a (id int);\n"+
+		"CREATE TRIGGER audit_b after insert on b begin insert into audit_log (id) values (new.id); end;\n",
+		string(schema))
+}
+
+func TestSQLiteDumpMethod(t *testing.T) {
+	cases := []struct {
+		url      string
+		config   string
+		expected string
+	}{
+		{"sqlite:foo.sqlite3?dump_method=native", "", dbmate.DumpMethodNative},
+		{"sqlite:foo.sqlite3?dump_method=sqlite3", "", dbmate.DumpMethodTool},
+		{"sqlite:foo.sqlite3?dump_method=native", dbmate.DumpMethodTool, dbmate.DumpMethodTool},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url+" "+c.config, func(t *testing.T) {
+			drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, c.url), DumpMethod: c.config}).(*Driver)
+
+			method, err := drv.dumpMethod()
+			require.NoError(t, err)
+			require.Equal(t, c.expected, method)
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		// the default does not depend on whether the sqlite3 command is installed
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "sqlite3"), []byte("#!/bin/sh\n"), 0o755)
+		require.NoError(t, err)
+
+		for _, path := range []string{dir, t.TempDir()} {
+			t.Setenv("PATH", path)
+			drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "sqlite:foo.sqlite3")}).(*Driver)
+
+			method, err := drv.dumpMethod()
+			require.NoError(t, err)
+			require.Equal(t, dbmate.DumpMethodNative, method)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		drv := NewDriver(dbmate.DriverConfig{DatabaseURL: dbtest.MustParseURL(t, "sqlite:foo.sqlite3?dump_method=sql")}).(*Driver)
+
+		_, err := drv.dumpMethod()
+		require.ErrorIs(t, err, dbmate.ErrUnsupportedDumpMethod)
+	})
+}
+
+func TestSQLiteInspectSchema(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	db := prepTestSQLiteDB(t, drv)
+	defer dbutil.MustClose(db)
+
+	_, err := db.Exec(`create table users (id integer primary key, name text not null default '');
+		create table posts (id integer, user_id integer references users (id));
+		create index posts_user_id on posts (user_id);
+		create view named_users as select * from users where name != '';
+		create trigger users_insert after insert on users begin select 1; end;`)
+	require.NoError(t, err)
+
+	schema, err := drv.InspectSchema(db)
+	require.NoError(t, err)
+	require.Len(t, schema.Tables, 2)
+	require.Equal(t, []string{"trigger users_insert", "view named_users"}, schema.Unplanned)
+
+	posts := schema.Tables[0]
+	require.Equal(t, "posts", posts.Name)
+	require.Equal(t, []string{"users"}, posts.References)
+	require.Equal(t, []dbmate.SchemaIndex{{
+		Name:       "posts_user_id",
+		Definition: "CREATE INDEX posts_user_id on posts (user_id)",
+	}}, posts.Indexes)
+
+	users := schema.Tables[1]
+	require.Equal(t, "users", users.Name)
+	require.Equal(t, `"name" TEXT NOT NULL DEFAULT ''`, users.Columns[1].Definition)
+	require.Equal(t, []dbmate.SchemaConstraint{{Name: "primary key", Definition: "PRIMARY KEY (id)"}}, users.Constraints)
+}
+
+func TestSQLiteAlterTableSQL(t *testing.T) {
+	drv := testSQLiteDriver(t)
+
+	id := dbmate.SchemaColumn{Name: "id", Type: "integer", Definition: `"id" integer`}
+	name := dbmate.SchemaColumn{Name: "name", Type: "text", Definition: `"name" text`}
+	from := dbmate.SchemaTable{Name: "users", Columns: []dbmate.SchemaColumn{id}}
+	to := dbmate.SchemaTable{Name: "users", Columns: []dbmate.SchemaColumn{id, name}}
+
+	statements, err := drv.AlterTableSQL(from, to)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "users" ADD COLUMN "name" text`}, statements)
+
+	statements, err = drv.AlterTableSQL(to, from)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "users" DROP COLUMN "name"`}, statements)
+
+	// columns cannot be altered in place
+	changed := name
+	changed.Type = "integer"
+	changed.Definition = `"name" integer`
+	_, err = drv.AlterTableSQL(to, dbmate.SchemaTable{Name: "users", Columns: []dbmate.SchemaColumn{id, changed}})
+	require.ErrorIs(t, err, dbmate.ErrPlanUnsupportedChange)
+}
+
 func TestSQLiteDatabaseExists(t *testing.T) {
 	drv := testSQLiteDriver(t)
 
@@ -342,6 +608,34 @@ func TestSQLiteDeleteMigration(t *testing.T) {
 	require.Equal(t, 1, count)
 }
 
+func TestSQLiteServerVersion(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	path := ConnectionString(drv.databaseURL)
+
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	version, err := drv.ServerVersion()
+	require.NoError(t, err)
+	require.Regexp(t, `^3\.\d+\.\d+$`, version)
+
+	// the database is not created
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSQLiteNonTransactionalReason(t *testing.T) {
+	drv := testSQLiteDriver(t)
+
+	require.Equal(t, "VACUUM cannot run in a transaction", drv.NonTransactionalReason("vacuum"))
+	require.Equal(t, "PRAGMA foreign_keys has no effect in a transaction",
+		drv.NonTransactionalReason("PRAGMA foreign_keys = off"))
+	require.Equal(t, "PRAGMA journal_mode cannot switch to or from WAL in a transaction",
+		drv.NonTransactionalReason("pragma journal_mode=WAL"))
+	require.Equal(t, "", drv.NonTransactionalReason("create table vacuums (id integer)"))
+	require.Equal(t, "", drv.NonTransactionalReason("pragma foreign_keys"))
+}
+
 func TestSQLitePing(t *testing.T) {
 	drv := testSQLiteDriver(t)
 	path := ConnectionString(drv.databaseURL)
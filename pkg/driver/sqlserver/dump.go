@@ -0,0 +1,692 @@
+package sqlserver
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// nativeDumpSchema dumps the schema from the sys catalog views, in an order which can be
+// loaded: schemas, types, sequences, tables, indexes, and then views, functions, procedures,
+// and triggers. Each statement is followed by GO, because SQL Server requires views,
+// functions, procedures, and triggers to be created in their own batch.
+func (drv *Driver) nativeDumpSchema(db *sql.DB) ([]byte, error) {
+	var buf bytes.Buffer
+	dumps := []func(*sql.DB, *bytes.Buffer) error{
+		drv.dumpSchemas,
+		drv.dumpTypes,
+		drv.dumpSequences,
+		drv.dumpTables,
+		drv.dumpIndexes,
+		drv.dumpModules,
+	}
+	for _, dump := range dumps {
+		if err := dump(db, &buf); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeBatch writes a statement followed by the batch separator
+func writeBatch(buf *bytes.Buffer, statement string) {
+	buf.WriteString(statement + "\nGO\n\n")
+}
+
+// dumpSchemas writes CREATE SCHEMA statements, except for dbo and the system schemas
+func (drv *Driver) dumpSchemas(db *sql.DB, buf *bytes.Buffer) error {
+	// schema ids 1-4 are dbo, guest, INFORMATION_SCHEMA, and sys, and ids from 16384 are
+	// fixed database roles
+	schemas, err := dbutil.QueryColumn(db, `select name from sys.schemas
+		where schema_id between 5 and 16383
+		order by name`)
+	if err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		if drv.dumpOptions.IncludesSchema(schema) {
+			writeBatch(buf, fmt.Sprintf("CREATE SCHEMA %s;", quoteIdentifier(schema)))
+		}
+	}
+
+	return nil
+}
+
+// dumpTypes writes CREATE TYPE statements for alias types and table types
+func (drv *Driver) dumpTypes(db *sql.DB, buf *bytes.Buffer) error {
+	if drv.dumpOptions.Skips(dbmate.DumpSkipTypes) {
+		return nil
+	}
+
+	// alias types are written first, since table types may use them
+	rows, err := db.Query(`select schema_name(t.schema_id), t.name, type_name(t.system_type_id),
+			t.max_length, t.precision, t.scale, t.is_nullable, coalesce(tt.type_table_object_id, 0)
+		from sys.types t
+		left join sys.table_types tt on tt.user_type_id = t.user_type_id
+		where t.is_user_defined = 1 and t.is_assembly_type = 0
+		order by t.is_table_type, 1, 2`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	type tableType struct {
+		name     string
+		objectID int64
+	}
+	tableTypes := []tableType{}
+	for rows.Next() {
+		var schema, name, baseType string
+		var maxLength, precision, scale int
+		var nullable bool
+		var objectID int64
+		if err := rows.Scan(&schema, &name, &baseType, &maxLength, &precision, &scale, &nullable,
+			&objectID); err != nil {
+			return err
+		}
+		if !drv.dumpOptions.IncludesSchema(schema) {
+			continue
+		}
+		if objectID != 0 {
+			tableTypes = append(tableTypes, tableType{name: qualifiedName(schema, name), objectID: objectID})
+			continue
+		}
+
+		null := "NULL"
+		if !nullable {
+			null = "NOT NULL"
+		}
+		writeBatch(buf, fmt.Sprintf("CREATE TYPE %s FROM %s %s;", qualifiedName(schema, name),
+			formatType(baseType, maxLength, precision, scale), null))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range tableTypes {
+		body, err := tableBody(db, t.objectID)
+		if err != nil {
+			return err
+		}
+		writeBatch(buf, fmt.Sprintf("CREATE TYPE %s AS TABLE (\n    %s\n);", t.name, strings.Join(body, ",\n    ")))
+	}
+
+	return nil
+}
+
+// formatType returns the definition of a built in data type
+func formatType(name string, maxLength, precision, scale int) string {
+	switch name {
+	case "varchar", "char", "varbinary", "binary", "nvarchar", "nchar":
+		if maxLength == -1 {
+			return name + "(max)"
+		}
+		// max_length is in bytes, and unicode characters use two bytes
+		if strings.HasPrefix(name, "n") {
+			maxLength /= 2
+		}
+		return fmt.Sprintf("%s(%d)", name, maxLength)
+	case "decimal", "numeric":
+		return fmt.Sprintf("%s(%d, %d)", name, precision, scale)
+	case "datetime2", "datetimeoffset", "time":
+		return fmt.Sprintf("%s(%d)", name, scale)
+	}
+
+	return name
+}
+
+// dumpSequences writes CREATE SEQUENCE statements
+func (drv *Driver) dumpSequences(db *sql.DB, buf *bytes.Buffer) error {
+	if drv.dumpOptions.Skips(dbmate.DumpSkipSequences) {
+		return nil
+	}
+
+	rows, err := db.Query(`select schema_name(schema_id), name, type_name(user_type_id),
+			cast(start_value as nvarchar(40)), cast(increment as nvarchar(40)),
+			cast(minimum_value as nvarchar(40)), cast(maximum_value as nvarchar(40)),
+			is_cycling, is_cached, cache_size
+		from sys.sequences
+		order by 1, 2`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var schema, name, dataType, start, increment, minValue, maxValue string
+		var cycle, cached bool
+		var cacheSize sql.NullInt64
+		if err := rows.Scan(&schema, &name, &dataType, &start, &increment, &minValue, &maxValue,
+			&cycle, &cached, &cacheSize); err != nil {
+			return err
+		}
+		if !drv.dumpOptions.IncludesSchema(schema) {
+			continue
+		}
+
+		options := []string{"AS " + dataType, "START WITH " + start, "INCREMENT BY " + increment,
+			"MINVALUE " + minValue, "MAXVALUE " + maxValue}
+		if cycle {
+			options = append(options, "CYCLE")
+		} else {
+			options = append(options, "NO CYCLE")
+		}
+		switch {
+		case !cached:
+			options = append(options, "NO CACHE")
+		case cacheSize.Valid:
+			options = append(options, "CACHE "+strconv.FormatInt(cacheSize.Int64, 10))
+		}
+		writeBatch(buf, fmt.Sprintf("CREATE SEQUENCE %s %s;", qualifiedName(schema, name), strings.Join(options, " ")))
+	}
+
+	return rows.Err()
+}
+
+// dumpObject is a table or module in the schema dump
+type dumpObject struct {
+	id  string
+	sql string
+}
+
+// dumpTables writes CREATE TABLE statements, sorted by name, except that tables are moved
+// after the tables their foreign keys refer to
+func (drv *Driver) dumpTables(db *sql.DB, buf *bytes.Buffer) error {
+	rows, err := db.Query(`select object_id, schema_name(schema_id), name from sys.tables
+		where is_ms_shipped = 0
+		order by 2, 3`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	type table struct {
+		objectID int64
+		name     string
+	}
+	tables := []table{}
+	for rows.Next() {
+		var objectID int64
+		var schema, name string
+		if err := rows.Scan(&objectID, &schema, &name); err != nil {
+			return err
+		}
+		if drv.dumpOptions.IncludesSchema(schema) && !drv.dumpOptions.ExcludesTable(schema, name) {
+			tables = append(tables, table{objectID: objectID, name: qualifiedName(schema, name)})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	objects := make([]dumpObject, 0, len(tables))
+	for _, t := range tables {
+		body, err := tableBody(db, t.objectID)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, dumpObject{
+			id:  strconv.FormatInt(t.objectID, 10),
+			sql: fmt.Sprintf("CREATE TABLE %s (\n    %s\n);", t.name, strings.Join(body, ",\n    ")),
+		})
+	}
+
+	references, err := queryReferences(db, `select distinct parent_object_id, referenced_object_id
+		from sys.foreign_keys`)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range sortObjects(objects, references) {
+		writeBatch(buf, object.sql)
+	}
+
+	return nil
+}
+
+// indexColumnsSQL selects the key columns (or included columns) of index i
+const indexColumnsSQL = `(select string_agg(quotename(c.name) +
+		case when ic.is_descending_key = 1 then ' DESC' else '' end, ', ')
+		within group (order by ic.key_ordinal, ic.index_column_id)
+	from sys.index_columns ic
+	join sys.columns c on c.object_id = ic.object_id and c.column_id = ic.column_id
+	where ic.object_id = i.object_id and ic.index_id = i.index_id and ic.is_included_column = %d)`
+
+// tableBody returns the column and constraint definitions of a table or table type
+func tableBody(db *sql.DB, objectID int64) ([]string, error) {
+	body, err := columnDefinitions(db, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// primary key and unique constraints, in the order they were created
+	rows, err := db.Query(`select kc.name, kc.is_system_named,
+			case kc.type when 'PK' then 'PRIMARY KEY' else 'UNIQUE' end, i.type_desc, `+
+		fmt.Sprintf(indexColumnsSQL, 0)+`
+		from sys.key_constraints kc
+		join sys.indexes i on i.object_id = kc.parent_object_id and i.index_id = kc.unique_index_id
+		where kc.parent_object_id = @p1
+		order by kc.type, i.index_id`, objectID)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var c tableConstraint
+		var constraintType, indexType, columns string
+		if err := rows.Scan(&c.name, &c.systemNamed, &constraintType, &indexType, &columns); err != nil {
+			return nil, err
+		}
+		c.definition = fmt.Sprintf("%s %s (%s)", constraintType, indexType, columns)
+		body = append(body, c.String())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	constraints := []tableConstraint{}
+	rows, err = db.Query(`select fk.name, fk.is_system_named,
+			'FOREIGN KEY (' + (select string_agg(quotename(c.name), ', ')
+					within group (order by fkc.constraint_column_id)
+				from sys.foreign_key_columns fkc
+				join sys.columns c on c.object_id = fkc.parent_object_id and c.column_id = fkc.parent_column_id
+				where fkc.constraint_object_id = fk.object_id) +
+			') REFERENCES ' + quotename(schema_name(r.schema_id)) + '.' + quotename(r.name) +
+			' (' + (select string_agg(quotename(c.name), ', ')
+					within group (order by fkc.constraint_column_id)
+				from sys.foreign_key_columns fkc
+				join sys.columns c on c.object_id = fkc.referenced_object_id and c.column_id = fkc.referenced_column_id
+				where fkc.constraint_object_id = fk.object_id) + ')' +
+			case when fk.delete_referential_action > 0
+				then ' ON DELETE ' + replace(fk.delete_referential_action_desc, '_', ' ') else '' end +
+			case when fk.update_referential_action > 0
+				then ' ON UPDATE ' + replace(fk.update_referential_action_desc, '_', ' ') else '' end
+		from sys.foreign_keys fk
+		join sys.objects r on r.object_id = fk.referenced_object_id
+		where fk.parent_object_id = @p1
+		union all
+		select name, is_system_named, 'CHECK ' + definition
+		from sys.check_constraints
+		where parent_object_id = @p1`, objectID)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var c tableConstraint
+		if err := rows.Scan(&c.name, &c.systemNamed, &c.definition); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortConstraints(constraints)
+	for _, c := range constraints {
+		body = append(body, c.String())
+	}
+
+	return body, nil
+}
+
+// columnDefinitions returns the definition of each column of a table or table type
+func columnDefinitions(db *sql.DB, objectID int64) ([]string, error) {
+	rows, err := db.Query(`select c.name, type_name(c.user_type_id), schema_name(t.schema_id),
+			t.is_user_defined, c.max_length, c.precision, c.scale, c.is_nullable,
+			case when c.collation_name <> cast(databasepropertyex(db_name(), 'Collation') as sysname)
+				then c.collation_name end,
+			cast(ic.seed_value as nvarchar(40)), cast(ic.increment_value as nvarchar(40)),
+			cc.definition, coalesce(cc.is_persisted, 0),
+			dc.name, coalesce(dc.is_system_named, 1), dc.definition
+		from sys.columns c
+		join sys.types t on t.user_type_id = c.user_type_id
+		left join sys.identity_columns ic on ic.object_id = c.object_id and ic.column_id = c.column_id
+		left join sys.computed_columns cc on cc.object_id = c.object_id and cc.column_id = c.column_id
+		left join sys.default_constraints dc
+			on dc.parent_object_id = c.object_id and dc.parent_column_id = c.column_id
+		where c.object_id = @p1
+		order by c.column_id`, objectID)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	columns := []string{}
+	for rows.Next() {
+		var name, typeName, typeSchema string
+		var userDefined, nullable, persisted, defaultSystemNamed bool
+		var maxLength, precision, scale int
+		var collation, seed, increment, computed, defaultName, defaultDefinition sql.NullString
+		if err := rows.Scan(&name, &typeName, &typeSchema, &userDefined, &maxLength, &precision, &scale,
+			&nullable, &collation, &seed, &increment, &computed, &persisted, &defaultName,
+			&defaultSystemNamed, &defaultDefinition); err != nil {
+			return nil, err
+		}
+
+		if computed.Valid {
+			column := quoteIdentifier(name) + " AS " + computed.String
+			if persisted {
+				column += " PERSISTED"
+			}
+			columns = append(columns, column)
+			continue
+		}
+
+		column := quoteIdentifier(name) + " "
+		if userDefined {
+			column += qualifiedName(typeSchema, typeName)
+		} else {
+			column += formatType(typeName, maxLength, precision, scale)
+		}
+		if collation.Valid {
+			column += " COLLATE " + collation.String
+		}
+		if seed.Valid {
+			column += fmt.Sprintf(" IDENTITY(%s, %s)", seed.String, increment.String)
+		}
+		if nullable {
+			column += " NULL"
+		} else {
+			column += " NOT NULL"
+		}
+		if defaultDefinition.Valid {
+			d := tableConstraint{name: defaultName.String, systemNamed: defaultSystemNamed,
+				definition: "DEFAULT " + defaultDefinition.String}
+			column += " " + d.String()
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
+// tableConstraint is a constraint in a CREATE TABLE statement
+type tableConstraint struct {
+	name        string
+	systemNamed bool
+	definition  string
+}
+
+// String returns the constraint definition. Names generated by SQL Server contain random
+// characters, so they are left out of the schema.
+func (c tableConstraint) String() string {
+	if c.systemNamed {
+		return c.definition
+	}
+
+	return "CONSTRAINT " + quoteIdentifier(c.name) + " " + c.definition
+}
+
+// sortConstraints sorts named constraints by name, followed by constraints with generated
+// names, which are sorted by their definition
+func sortConstraints(constraints []tableConstraint) {
+	sort.SliceStable(constraints, func(i, j int) bool {
+		a, b := constraints[i], constraints[j]
+		if a.systemNamed != b.systemNamed {
+			return !a.systemNamed
+		}
+		if !a.systemNamed {
+			return a.name < b.name
+		}
+		return a.definition < b.definition
+	})
+}
+
+// dumpIndexes writes CREATE INDEX statements, except for indexes created by PRIMARY KEY and
+// UNIQUE constraints. Clustered indexes are created first, to avoid rebuilding the other
+// indexes of each table.
+func (drv *Driver) dumpIndexes(db *sql.DB, buf *bytes.Buffer) error {
+	if drv.dumpOptions.Skips(dbmate.DumpSkipIndexes) {
+		return nil
+	}
+
+	rows, err := db.Query(`select schema_name(t.schema_id), t.name, i.name, i.is_unique, i.type_desc, ` +
+		fmt.Sprintf(indexColumnsSQL, 0) + `, ` + fmt.Sprintf(indexColumnsSQL, 1) + `, i.filter_definition
+		from sys.indexes i
+		join sys.tables t on t.object_id = i.object_id
+		where t.is_ms_shipped = 0 and i.type in (1, 2) and i.is_hypothetical = 0
+			and i.is_primary_key = 0 and i.is_unique_constraint = 0
+		order by 1, 2, i.type, 3`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var schema, table, name, indexType, columns string
+		var unique bool
+		var included, filter sql.NullString
+		if err := rows.Scan(&schema, &table, &name, &unique, &indexType, &columns, &included, &filter); err != nil {
+			return err
+		}
+		if !drv.dumpOptions.IncludesSchema(schema) || drv.dumpOptions.ExcludesTable(schema, table) {
+			continue
+		}
+
+		statement := "CREATE "
+		if unique {
+			statement += "UNIQUE "
+		}
+		statement += fmt.Sprintf("%s INDEX %s ON %s (%s)", indexType, quoteIdentifier(name),
+			qualifiedName(schema, table), columns)
+		if included.Valid {
+			statement += " INCLUDE (" + included.String + ")"
+		}
+		if filter.Valid {
+			statement += " WHERE " + filter.String
+		}
+		writeBatch(buf, statement+";")
+	}
+
+	return rows.Err()
+}
+
+// dumpModules writes the definitions of views, functions, procedures, and triggers, sorted
+// by name, except that each object is moved after the objects it refers to. Encrypted
+// modules have no definition, and are skipped.
+func (drv *Driver) dumpModules(db *sql.DB, buf *bytes.Buffer) error {
+	rows, err := db.Query(`select o.object_id, schema_name(o.schema_id), o.name, rtrim(o.type),
+			coalesce(object_schema_name(o.parent_object_id), ''), coalesce(object_name(o.parent_object_id), ''),
+			m.definition
+		from sys.sql_modules m
+		join sys.objects o on o.object_id = m.object_id
+		where o.is_ms_shipped = 0 and o.type in ('V', 'P', 'FN', 'IF', 'TF', 'TR') and m.definition is not null
+		order by 2, 3`)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	modules := []dumpObject{}
+	for rows.Next() {
+		var objectID int64
+		var schema, name, objectType, parentSchema, parent, definition string
+		if err := rows.Scan(&objectID, &schema, &name, &objectType, &parentSchema, &parent, &definition); err != nil {
+			return err
+		}
+		if !drv.includesModule(schema, name, objectType, parentSchema, parent) {
+			continue
+		}
+		modules = append(modules, dumpObject{
+			id:  strconv.FormatInt(objectID, 10),
+			sql: strings.TrimSpace(definition),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	references, err := queryReferences(db, `select distinct referencing_id, referenced_id
+		from sys.sql_expression_dependencies
+		where referenced_id is not null`)
+	if err != nil {
+		return err
+	}
+
+	for _, module := range sortObjects(modules, references) {
+		writeBatch(buf, module.sql)
+	}
+
+	return nil
+}
+
+// includesModule returns true if a view, function, procedure, or trigger should be dumped
+func (drv *Driver) includesModule(schema, name, objectType, parentSchema, parent string) bool {
+	if !drv.dumpOptions.IncludesSchema(schema) {
+		return false
+	}
+
+	switch objectType {
+	case "V":
+		return !drv.dumpOptions.Skips(dbmate.DumpSkipViews) && !drv.dumpOptions.ExcludesTable(schema, name)
+	case "TR":
+		return !drv.dumpOptions.Skips(dbmate.DumpSkipTriggers) &&
+			!drv.dumpOptions.ExcludesTable(parentSchema, parent)
+	default:
+		return !drv.dumpOptions.Skips(dbmate.DumpSkipFunctions)
+	}
+}
+
+// queryReferences returns a map of object ids to the object ids they refer to, from a query
+// which returns pairs of object ids
+func queryReferences(db *sql.DB, query string) (map[string][]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	references := map[string][]string{}
+	for rows.Next() {
+		var id, referenced int64
+		if err := rows.Scan(&id, &referenced); err != nil {
+			return nil, err
+		}
+		key := strconv.FormatInt(id, 10)
+		references[key] = append(references[key], strconv.FormatInt(referenced, 10))
+	}
+
+	return references, rows.Err()
+}
+
+// sortObjects returns objects in their original order, except that each object is moved
+// after the objects it refers to. References to objects which are not in the list are ignored.
+func sortObjects(objects []dumpObject, references map[string][]string) []dumpObject {
+	byID := map[string]dumpObject{}
+	for _, object := range objects {
+		byID[object.id] = object
+	}
+
+	sorted := make([]dumpObject, 0, len(objects))
+	visited := map[string]bool{}
+	var visit func(object dumpObject)
+	visit = func(object dumpObject) {
+		if visited[object.id] {
+			return
+		}
+		// mark objects before visiting references, so that cycles do not recurse forever
+		visited[object.id] = true
+		for _, id := range references[object.id] {
+			if referenced, ok := byID[id]; ok {
+				visit(referenced)
+			}
+		}
+		sorted = append(sorted, object)
+	}
+	for _, object := range objects {
+		visit(object)
+	}
+
+	return sorted
+}
+
+// literalSQL returns an expression which formats a column value as a literal, or NULL.
+// Values are converted with styles which do not lose precision.
+func literalSQL(column, dataType string) string {
+	var value string
+	switch dataType {
+	case "binary", "varbinary", "image":
+		// hexadecimal literals are not quoted
+		return fmt.Sprintf("coalesce(convert(varchar(max), convert(varbinary(max), %s), 1), 'NULL')", column)
+	case "datetime", "smalldatetime", "datetime2", "datetimeoffset":
+		value = fmt.Sprintf("convert(nvarchar(max), %s, 126)", column)
+	case "float", "real":
+		value = fmt.Sprintf("convert(nvarchar(max), %s, 3)", column)
+	case "money", "smallmoney":
+		value = fmt.Sprintf("convert(nvarchar(max), %s, 2)", column)
+	default:
+		value = fmt.Sprintf("convert(nvarchar(max), %s)", column)
+	}
+
+	return fmt.Sprintf(`coalesce('N''' + replace(%s, '''', '''''') + '''', 'NULL')`, value)
+}
+
+// dataDumpSyntax formats rows as lists of literals. SQL Server allows at most 1000 rows in each
+// INSERT statement.
+var dataDumpSyntax = dbutil.DataDumpSyntax{
+	RowSQL: func(values []string) string {
+		return strings.Join(values, " + ', ' + ")
+	},
+	Indent:    "  ",
+	BatchSize: 1000,
+}
+
+// tableDataDump returns INSERT statements for the rows of each table in DumpOptions.DataTables
+func (drv *Driver) tableDataDump(db *sql.DB) ([]byte, error) {
+	return dataDumpSyntax.DumpTableData(db, drv.dumpOptions.DataTables, func(table string) (dbutil.DataTable, error) {
+		var objectID int64
+		var schema, name string
+		err := db.QueryRow(`select object_id, schema_name(schema_id), name from sys.tables
+			where object_id = object_id(@p1)`, table).Scan(&objectID, &schema, &name)
+		if err == sql.ErrNoRows {
+			return dbutil.DataTable{}, dbutil.ErrTableNotFound
+		}
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+
+		// computed and rowversion columns cannot be inserted
+		rows, err := db.Query(`select name, type_name(system_type_id), is_identity from sys.columns
+			where object_id = @p1 and is_computed = 0 and type_name(system_type_id) <> 'timestamp'
+			order by column_id`, objectID)
+		if err != nil {
+			return dbutil.DataTable{}, err
+		}
+		defer dbutil.MustClose(rows)
+
+		qualified := qualifiedName(schema, name)
+		data := dbutil.DataTable{
+			Name:   qualified,
+			Header: fmt.Sprintf("-- Data for table %s\n", table),
+			After:  "GO\n\n",
+		}
+		for rows.Next() {
+			var column, dataType string
+			var isIdentity bool
+			if err := rows.Scan(&column, &dataType, &isIdentity); err != nil {
+				return dbutil.DataTable{}, err
+			}
+			if isIdentity {
+				data.Before = fmt.Sprintf("SET IDENTITY_INSERT %s ON;\n", qualified)
+				data.After = fmt.Sprintf("SET IDENTITY_INSERT %s OFF;\nGO\n\n", qualified)
+			}
+			column = quoteIdentifier(column)
+			data.Columns = append(data.Columns, column)
+			data.Values = append(data.Values, literalSQL(column, dataType))
+		}
+
+		return data, rows.Err()
+	})
+}
@@ -0,0 +1,411 @@
+package sqlserver
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	mssql "github.com/microsoft/go-mssqldb" // database/sql driver
+)
+
+func init() {
+	dbmate.RegisterDriver(NewDriver, "sqlserver")
+}
+
+// Driver provides top level database functions
+type Driver struct {
+	migrationsTableName string
+	databaseURL         *url.URL
+	log                 io.Writer
+	dumpOptions         dbmate.DumpOptions
+}
+
+// NewDriver initializes the driver
+func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
+	return &Driver{
+		migrationsTableName: config.MigrationsTableName,
+		databaseURL:         config.DatabaseURL,
+		log:                 config.Log,
+		dumpOptions:         config.DumpOptions,
+	}
+}
+
+// databaseName returns the database from the URL path, or the database query parameter
+// used by go-mssqldb connection strings
+func databaseName(u *url.URL) string {
+	if name := dbutil.DatabaseName(u); name != "" {
+		return name
+	}
+
+	return u.Query().Get("database")
+}
+
+// connectionString returns a go-mssqldb connection string for a database. go-mssqldb uses the
+// URL path for the instance name, so dbmate moves the database from the path to the database
+// query parameter, and reads the instance name from the instance query parameter.
+func connectionString(u *url.URL, database string) string {
+	query := u.Query()
+	instance := query.Get("instance")
+	query.Del("instance")
+	if database != "" {
+		query.Set("database", database)
+	}
+
+	out := url.URL{
+		Scheme:   "sqlserver",
+		User:     u.User,
+		Host:     u.Host,
+		RawQuery: query.Encode(),
+	}
+	if instance != "" {
+		out.Path = "/" + instance
+	}
+
+	return out.String()
+}
+
+// Open creates a new database connection
+func (drv *Driver) Open() (*sql.DB, error) {
+	return sql.Open("sqlserver", connectionString(drv.databaseURL, databaseName(drv.databaseURL)))
+}
+
+func (drv *Driver) openMasterDB() (*sql.DB, error) {
+	// connect to master database to create or drop databases
+	return sql.Open("sqlserver", connectionString(drv.databaseURL, "master"))
+}
+
+// CreateDatabase creates the specified database
+func (drv *Driver) CreateDatabase() error {
+	name := databaseName(drv.databaseURL)
+	fmt.Fprintf(drv.log, "Creating: %s\n", name)
+
+	db, err := drv.openMasterDB()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	_, err = db.Exec(fmt.Sprintf("create database %s", quoteIdentifier(name)))
+
+	return err
+}
+
+// DropDatabase drops the specified database (if it exists), closing any open connections
+func (drv *Driver) DropDatabase() error {
+	name := databaseName(drv.databaseURL)
+	fmt.Fprintf(drv.log, "Dropping: %s\n", name)
+
+	db, err := drv.openMasterDB()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	_, err = db.Exec(fmt.Sprintf("if db_id(%s) is not null\nbegin\n"+
+		"  alter database %s set single_user with rollback immediate;\n"+
+		"  drop database %s;\nend",
+		quoteLiteral(name), quoteIdentifier(name), quoteIdentifier(name)))
+
+	return err
+}
+
+func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// load applied migrations
+	migrations, err := dbutil.QueryColumn(db,
+		fmt.Sprintf("select version from %s order by version asc", migrationsTable))
+	if err != nil {
+		return nil, err
+	}
+	for i := range migrations {
+		migrations[i] = quoteLiteral(migrations[i])
+	}
+
+	// build schema migrations table data
+	var buf bytes.Buffer
+	buf.WriteString("-- Dbmate schema migrations\n")
+	if len(migrations) > 0 {
+		dataDumpSyntax.WriteInserts(&buf, migrationsTable, []string{"version"}, migrations)
+		buf.WriteString("GO\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DumpMigrations returns the versions recorded in the migrations table, as in DumpSchema
+func (drv *Driver) DumpMigrations(db *sql.DB) ([]byte, error) {
+	return drv.schemaMigrationsDump(db)
+}
+
+// DumpSchema returns the current database schema. SQL Server has no command line tool which
+// scripts a schema, so the schema is always read from the sys catalog views.
+func (drv *Driver) DumpSchema(db *sql.DB, _ ...string) ([]byte, error) {
+	schema, err := drv.nativeDumpSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := drv.tableDataDump(db)
+	if err != nil {
+		return nil, err
+	}
+	schema = append(schema, data...)
+
+	if !drv.dumpOptions.NoMigrationsData {
+		migrations, err := drv.schemaMigrationsDump(db)
+		if err != nil {
+			return nil, err
+		}
+		schema = append(schema, migrations...)
+	}
+
+	return schema, nil
+}
+
+// DatabaseExists determines whether the database exists
+func (drv *Driver) DatabaseExists() (bool, error) {
+	name := databaseName(drv.databaseURL)
+
+	db, err := drv.openMasterDB()
+	if err != nil {
+		return false, err
+	}
+	defer dbutil.MustClose(db)
+
+	exists := false
+	err = db.QueryRow("select cast(1 as bit) from sys.databases where name = @p1", name).
+		Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return exists, err
+}
+
+// MigrationsTableExists checks if the schema_migrations table exists
+func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
+	schema, name, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return false, err
+	}
+
+	exists := false
+	err = db.QueryRow("select cast(1 as bit) from sys.tables "+
+		"where schema_id = schema_id(@p1) and name = @p2", schema, name).
+		Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return exists, err
+}
+
+// CreateMigrationsTable creates the schema_migrations table, along with its schema
+func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
+	schema, name, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return err
+	}
+
+	// only attempt to create the schema if it is missing, in case the user doesn't have
+	// permission to create schemas
+	exists, err := dbutil.QueryValue(db, "select schema_id(@p1)", schema)
+	if err != nil {
+		return err
+	}
+	if exists == "" {
+		fmt.Fprintf(drv.log, "Creating schema: %s\n", schema)
+		// CREATE SCHEMA must be the only statement in its batch
+		if _, err := db.Exec(fmt.Sprintf("create schema %s", quoteIdentifier(schema))); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(fmt.Sprintf(
+		"if object_id(%s, 'U') is null create table %s (version nvarchar(128) not null primary key)",
+		quoteLiteral(qualifiedName(schema, name)), qualifiedName(schema, name)))
+
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return nil, err
+	}
+
+	top := ""
+	if limit >= 0 {
+		top = fmt.Sprintf("top (%d) ", limit)
+	}
+	rows, err := db.Query(fmt.Sprintf("select %sversion from %s order by version desc", top, migrationsTable))
+	if err != nil {
+		return nil, err
+	}
+
+	defer dbutil.MustClose(rows)
+
+	migrations := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		migrations[version] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// InsertMigration adds a new migration record
+func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("insert into %s (version) values (@p1)", migrationsTable), version)
+
+	return err
+}
+
+// DeleteMigration removes a migration record
+func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("delete from %s where version = @p1", migrationsTable), version)
+
+	return err
+}
+
+// Ping verifies a connection to the database server. It does not verify whether the
+// specified database exists.
+func (drv *Driver) Ping() error {
+	db, err := drv.openMasterDB()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	return db.Ping()
+}
+
+// ServerVersion returns the version of the database server
+func (drv *Driver) ServerVersion() (string, error) {
+	db, err := drv.openMasterDB()
+	if err != nil {
+		return "", err
+	}
+	defer dbutil.MustClose(db)
+
+	return dbutil.QueryValue(db, "select cast(serverproperty('ProductVersion') as nvarchar(128))")
+}
+
+// Return a normalized version of the driver-specific error type. SQL Server reports the
+// line of the batch which failed, which is converted to the position of the start of the line.
+func (drv *Driver) QueryError(query string, err error) error {
+	position := 0
+
+	var msErr mssql.Error
+	if errors.As(err, &msErr) && msErr.LineNo > 0 && msErr.ProcName == "" {
+		position = linePosition(query, int(msErr.LineNo))
+	}
+
+	return &dbmate.QueryError{Err: err, Query: query, Position: position}
+}
+
+// linePosition returns the position (in characters, starting at 1) of the start of a line
+// in query, or 0 if the query has fewer lines
+func linePosition(query string, line int) int {
+	if line == 1 {
+		return 1
+	}
+
+	position := 1
+	for _, ch := range query {
+		position++
+		if ch == '\n' {
+			line--
+			if line == 1 {
+				return position
+			}
+		}
+	}
+
+	return 0
+}
+
+// StatementSyntax returns the syntax used to split schema files and migrations into
+// batches. Like sqlcmd and SQL Server Management Studio, batches are separated by GO.
+func (drv *Driver) StatementSyntax() dbutil.StatementSyntax {
+	return dbutil.StatementSyntax{
+		BatchSeparator: "GO",
+	}
+}
+
+// migrationsTableNameParts returns the schema and name of the migrations table. The schema
+// may be specified as part of the table name (e.g. audit.schema_migrations), otherwise the
+// default schema of the current user is used.
+func (drv *Driver) migrationsTableNameParts(db dbutil.Transaction) (string, string, error) {
+	schema, name, found := strings.Cut(drv.migrationsTableName, ".")
+	if found {
+		return schema, name, nil
+	}
+
+	schema, err := dbutil.QueryValue(db, "select schema_name()")
+	if err != nil {
+		return "", "", err
+	}
+
+	// fall back to dbo schema as last resort
+	if schema == "" {
+		schema = "dbo"
+	}
+
+	return schema, drv.migrationsTableName, nil
+}
+
+func (drv *Driver) quotedMigrationsTableName(db dbutil.Transaction) (string, error) {
+	schema, name, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return "", err
+	}
+
+	return qualifiedName(schema, name), nil
+}
+
+// quoteIdentifier quotes a table or column name with square brackets
+func quoteIdentifier(s string) string {
+	return "[" + strings.ReplaceAll(s, "]", "]]") + "]"
+}
+
+// quoteLiteral quotes a unicode string
+func quoteLiteral(s string) string {
+	return "N'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// qualifiedName quotes the name of an object, prefixed by its schema
+func qualifiedName(schema, name string) string {
+	return quoteIdentifier(schema) + "." + quoteIdentifier(name)
+}